@@ -0,0 +1,180 @@
+package entcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ARC provides an in-process cache with the adaptive replacement cache (ARC)
+// eviction policy, and implements the AddGetDeleter interface.
+//
+// ARC splits the cache between entries that were used once (recency) and entries
+// that were used at least twice (frequency), and tracks the keys that were evicted
+// recently from each part (ghost entries) for adapting the split to the workload.
+// Hence, unlike LRU, one-off scans (e.g. paginating over a table) do not flush the
+// hot entries from the cache.
+type ARC struct {
+	mu   sync.Mutex
+	size int
+	// p is the target size of t1, adapted by the hits of the ghost lists.
+	p int
+	// t1 and t2 hold the entries that were used once, and at least twice.
+	t1, t2 *lruCache[Key, *Entry]
+	// b1 and b2 hold the keys that were evicted recently from t1 and t2.
+	b1, b2 *lruCache[Key, struct{}]
+}
+
+// NewARC creates a new ARC cache that holds up to size entries.
+//
+//	entcache.NewDriver(drv, entcache.Levels(entcache.NewARC(1024)))
+func NewARC(size int) *ARC {
+	if size < 1 {
+		size = 1
+	}
+	return &ARC{
+		size: size,
+		t1:   newLRUCache[Key, *Entry](nil),
+		t2:   newLRUCache[Key, *Entry](nil),
+		b1:   newLRUCache[Key, struct{}](nil),
+		b2:   newLRUCache[Key, struct{}](nil),
+	}
+}
+
+// Add adds the entry to the cache.
+func (a *ARC) Add(_ context.Context, k Key, e *Entry, ttl time.Duration) error {
+	ne, err := copyEntry(e)
+	if err != nil {
+		return err
+	}
+	expiry := ne.deadline(ttl)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	// Entries that are used again are promoted to the frequency list.
+	case a.t1.contains(k):
+		a.t1.remove(k)
+		a.t2.add(k, ne, expiry)
+	case a.t2.contains(k):
+		a.t2.add(k, ne, expiry)
+	// A hit in the ghost list of recency indicates that
+	// it is too small, and its target size is increased.
+	case a.b1.contains(k):
+		a.p += ghostDelta(a.b2.len(), a.b1.len())
+		if a.p > a.size {
+			a.p = a.size
+		}
+		if a.full() {
+			a.replace(false)
+		}
+		a.b1.remove(k)
+		a.t2.add(k, ne, expiry)
+	case a.b2.contains(k):
+		a.p -= ghostDelta(a.b1.len(), a.b2.len())
+		if a.p < 0 {
+			a.p = 0
+		}
+		if a.full() {
+			a.replace(true)
+		}
+		a.b2.remove(k)
+		a.t2.add(k, ne, expiry)
+	default:
+		if a.full() {
+			a.replace(false)
+		}
+		// Keep the ghost lists bounded by the target sizes.
+		if a.b1.len() > a.size-a.p {
+			a.b1.removeOldest()
+		}
+		if a.b2.len() > a.p {
+			a.b2.removeOldest()
+		}
+		a.t1.add(k, ne, expiry)
+	}
+	return nil
+}
+
+// Get gets an entry from the cache.
+func (a *ARC) Get(_ context.Context, k Key) (*Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if e, ok := a.t1.get(k, now); ok {
+		_, expiry, _ := a.t1.peek(k)
+		a.t1.remove(k)
+		a.t2.add(k, e, expiry)
+		return e, nil
+	}
+	if e, ok := a.t2.get(k, now); ok {
+		return e, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Del deletes an entry from the cache.
+func (a *ARC) Del(_ context.Context, k Key) error {
+	a.mu.Lock()
+	a.t1.remove(k)
+	a.t2.remove(k)
+	a.b1.remove(k)
+	a.b2.remove(k)
+	a.mu.Unlock()
+	return nil
+}
+
+// Sweep implements the Sweeper interface.
+func (a *ARC) Sweep(context.Context) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	return a.t1.sweep(now) + a.t2.sweep(now), nil
+}
+
+// Len returns the number of entries in the cache.
+func (a *ARC) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.t1.len() + a.t2.len()
+}
+
+// Clear purges all entries from the cache.
+func (a *ARC) Clear() {
+	a.mu.Lock()
+	a.t1.clear()
+	a.t2.clear()
+	a.b1.clear()
+	a.b2.clear()
+	a.p = 0
+	a.mu.Unlock()
+}
+
+// replace evicts an entry from t1 or t2 to its ghost list, according
+// to the target size of t1. b2 reports if the key was found in b2.
+func (a *ARC) replace(b2 bool) {
+	if n := a.t1.len(); n > 0 && (n > a.p || (b2 && n == a.p)) {
+		if k, _, ok := a.t1.oldest(); ok {
+			a.t1.remove(k)
+			a.b1.add(k, struct{}{}, time.Time{})
+		}
+		return
+	}
+	if k, _, ok := a.t2.oldest(); ok {
+		a.t2.remove(k)
+		a.b2.add(k, struct{}{}, time.Time{})
+	}
+}
+
+// full reports if the cache holds the maximum number of entries.
+func (a *ARC) full() bool {
+	return a.t1.len()+a.t2.len() >= a.size
+}
+
+// ghostDelta returns the adaptation step of the target size of t1
+// on a hit in a ghost list, relative to the size of the other one.
+func ghostDelta(other, hit int) int {
+	if d := other / hit; d > 1 {
+		return d
+	}
+	return 1
+}
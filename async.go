@@ -0,0 +1,216 @@
+package entcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// asyncWorkers is the number of background workers of an async level.
+const asyncWorkers = 4
+
+// errAsyncFull is reported when an operation is dropped, as the queue is full.
+var errAsyncFull = errors.New("entcache: async level queue is full")
+
+type (
+	// asyncLevel wraps a cache level, and performs its adds and deletes on
+	// background workers (see AsyncLevel). Operations of the same key are
+	// handled by the same worker, and therefore, are applied in order.
+	asyncLevel struct {
+		AddGetDeleter
+		queues []chan asyncOp
+		wg     sync.WaitGroup
+		once   sync.Once
+		// mu guards the queues from being closed while operations are sent.
+		mu     sync.RWMutex
+		closed bool
+		// report is called with the errors of failed operations.
+		report atomic.Value // func(error)
+		// tagMu and tagGen order tagged adds with tag evictions. Tagged
+		// adds that were queued before an eviction are dropped, and the
+		// ones in progress are waited for by the eviction.
+		tagMu  sync.RWMutex
+		tagGen atomic.Uint64
+	}
+
+	// asyncOp is an operation that is executed by a worker.
+	asyncOp struct {
+		ctx context.Context
+		k   Key
+		// do executes the operation on the wrapped level.
+		do func(context.Context) error
+	}
+)
+
+// AsyncLevel wraps the given cache level (usually, a slow remote one like Redis
+// over WAN or DynamoDB), and performs its adds and deletes on a pool of background
+// workers. Hence, the level never adds latency to the query path. Lookups are sent
+// to the level as-is.
+//
+//	entcache.Levels(
+//		entcache.NewLRU(256),
+//		entcache.AsyncLevel(entdynamodb.New(client, "entcache"), 1024),
+//	)
+//
+// Operations that fail, or that are dropped because the queue (of the given size)
+// is full, are counted as AsyncErrors in the driver stats, and are passed to its Log
+// function. Note that, a deleted entry may be served by the level until its delete
+// is executed. The queue is flushed when the driver is closed.
+func AsyncLevel(level AddGetDeleter, queueSize int) AddGetDeleter {
+	a := &asyncLevel{AddGetDeleter: level, queues: make([]chan asyncOp, asyncWorkers)}
+	size := queueSize / asyncWorkers
+	if size < 1 {
+		size = 1
+	}
+	for i := range a.queues {
+		a.queues[i] = make(chan asyncOp, size)
+		a.wg.Add(1)
+		go a.work(a.queues[i])
+	}
+	return a
+}
+
+// Add adds the entry to the wrapped level in the background.
+func (a *asyncLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	a.enqueue(ctx, k, func(ctx context.Context) error {
+		return a.AddGetDeleter.Add(ctx, k, e, ttl)
+	})
+	return nil
+}
+
+// Del deletes the entry from the wrapped level in the background.
+func (a *asyncLevel) Del(ctx context.Context, k Key) error {
+	a.enqueue(ctx, k, func(ctx context.Context) error {
+		if err := a.AddGetDeleter.Del(ctx, k); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+// AddTagged implements the Tagger interface.
+func (a *asyncLevel) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	gen := a.tagGen.Load()
+	a.enqueue(ctx, k, func(ctx context.Context) error {
+		a.tagMu.RLock()
+		defer a.tagMu.RUnlock()
+		// Tags were evicted after the entry was queued, and it may be stale.
+		if a.tagGen.Load() != gen {
+			return nil
+		}
+		return addTagged(ctx, a.AddGetDeleter, k, e, ttl, tags)
+	})
+	return nil
+}
+
+// EvictTag implements the Tagger interface. Tags are evicted synchronously,
+// as they are evicted on the write path to prevent serving stale entries.
+// Pending tagged adds are not tracked by their tags, and therefore, all of
+// the queued ones are dropped, and the ones in progress are waited for.
+func (a *asyncLevel) EvictTag(ctx context.Context, tag string) error {
+	a.tagMu.Lock()
+	defer a.tagMu.Unlock()
+	a.tagGen.Add(1)
+	return evictTag(ctx, a.AddGetDeleter, tag)
+}
+
+// enqueue sends the operation to the worker of the key,
+// or reports it as failed if the queue is full.
+func (a *asyncLevel) enqueue(ctx context.Context, k Key, do func(context.Context) error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.fail(k, errors.New("entcache: async level is closed"))
+		return
+	}
+	// Operations outlive the query that triggered them.
+	op := asyncOp{ctx: context.WithoutCancel(ctx), k: k, do: do}
+	select {
+	case a.queues[keyHash(k)%uint64(len(a.queues))] <- op:
+	default:
+		a.fail(k, errAsyncFull)
+	}
+}
+
+// work executes the operations of the queue until it is closed.
+func (a *asyncLevel) work(q <-chan asyncOp) {
+	defer a.wg.Done()
+	for op := range q {
+		if err := op.do(op.ctx); err != nil {
+			a.fail(op.k, err)
+		}
+	}
+}
+
+// fail reports the failure of an operation.
+func (a *asyncLevel) fail(k Key, err error) {
+	if report, ok := a.report.Load().(func(error)); ok {
+		report(fmt.Errorf("entcache: async operation on key %v: %w", k, err))
+	}
+}
+
+// close stops accepting operations, and waits for the queued ones.
+func (a *asyncLevel) close() {
+	a.once.Do(func() {
+		a.mu.Lock()
+		a.closed = true
+		for _, q := range a.queues {
+			close(q)
+		}
+		a.mu.Unlock()
+		a.wg.Wait()
+	})
+}
+
+// asyncLevels returns the async levels of the given cache.
+func asyncLevels(c AddGetDeleter) []*asyncLevel {
+	var levels []*asyncLevel
+	walkLevels(c, func(l AddGetDeleter) {
+		if a, ok := l.(*asyncLevel); ok {
+			levels = append(levels, a)
+		}
+	})
+	return levels
+}
+
+// walkLevels calls fn for the given cache level, and for all levels it wraps.
+func walkLevels(c AddGetDeleter, fn func(AddGetDeleter)) {
+	if c == nil {
+		return
+	}
+	fn(c)
+	switch c := c.(type) {
+	case *multiLevel:
+		for _, l := range c.levels {
+			walkLevels(l, fn)
+		}
+	case *contextLevel:
+		walkLevels(c.shared, fn)
+	case *budgetLevel:
+		walkLevels(c.AddGetDeleter, fn)
+	case *required:
+		walkLevels(c.AddGetDeleter, fn)
+	case *asyncLevel:
+		walkLevels(c.AddGetDeleter, fn)
+	case *retryLevel:
+		walkLevels(c.AddGetDeleter, fn)
+	case *readOnly:
+		walkLevels(c.AddGetDeleter, fn)
+	}
+}
+
+// startAsync reports the failures of the async levels of the driver cache to its stats and log.
+func (d *Driver) startAsync() {
+	for _, a := range asyncLevels(d.Cache) {
+		a.report.Store(func(err error) {
+			atomic.AddUint64(&d.stats.AsyncErrors, 1)
+			if d.Log != nil {
+				d.Log(err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Package badger provides a persistent on-disk cache level for entcache,
+// that is backed by Badger.
+package badger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"ariga.io/entcache"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+type (
+	// Level provides a persistent on-disk cache backed by Badger
+	// and implements the entcache.AddGetDeleter interface.
+	Level struct {
+		db       *badger.DB
+		interval time.Duration
+		done     chan struct{}
+		once     sync.Once
+		wg       sync.WaitGroup
+	}
+
+	// Option allows configuring the Badger
+	// cache level using functional options.
+	Option func(*config)
+
+	config struct {
+		opts     badger.Options
+		interval time.Duration
+	}
+)
+
+// New opens (or creates) a Badger database in the given directory, and returns
+// a cache level that stores its entries there. Entries survive process restarts,
+// which is useful for CLIs and batch jobs that re-run the same queries.
+//
+//	l, err := badger.New("/var/cache/myapp")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer l.Close()
+//	entcache.NewDriver(drv, entcache.Levels(entcache.NewLRU(256), l))
+//
+// Entries are stored with their TTL, and expired values are garbage
+// collected by the level in the background (see GCInterval).
+func New(path string, opts ...Option) (*Level, error) {
+	cfg := &config{
+		opts:     badger.DefaultOptions(path).WithLogger(nil),
+		interval: 5 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	db, err := badger.Open(cfg.opts)
+	if err != nil {
+		return nil, err
+	}
+	b := &Level{db: db, interval: cfg.interval, done: make(chan struct{})}
+	if b.interval > 0 {
+		b.wg.Add(1)
+		go b.gc()
+	}
+	return b, nil
+}
+
+// Config allows modifying the options that are used for opening
+// the Badger database. For example, for running it in memory:
+//
+//	badger.New("", badger.Config(func(o badgerdb.Options) badgerdb.Options {
+//		return o.WithInMemory(true)
+//	}))
+func Config(f func(badger.Options) badger.Options) Option {
+	return func(c *config) {
+		c.opts = f(c.opts)
+	}
+}
+
+// GCInterval configures the interval of the background garbage
+// collection of expired values. The default is 5 minutes, and zero
+// disables it.
+func GCInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.interval = d
+	}
+}
+
+// Add adds the entry to the cache.
+func (b *Level) Add(_ context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return err
+	}
+	// Badger expires entries in a resolution of seconds. Therefore, the
+	// entry carries its exact expiration time, and it is stored a second
+	// longer to avoid expiring it too early.
+	e = e.WithExpiry(ttl)
+	buf, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	be := badger.NewEntry([]byte(key), buf)
+	if !e.Expiry.IsZero() {
+		be.ExpiresAt = uint64(e.Expiry.Unix()) + 1
+	}
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(be)
+	})
+}
+
+// Get gets an entry from the cache.
+func (b *Level) Get(_ context.Context, k entcache.Key) (*entcache.Entry, error) {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	err = b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		buf, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, entcache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := &entcache.Entry{}
+	if err := e.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	if e.Expired() {
+		return nil, entcache.ErrNotFound
+	}
+	return e, nil
+}
+
+// Del deletes an entry from the cache.
+func (b *Level) Del(_ context.Context, k entcache.Key) error {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+// Close stops the background garbage collection and closes the database.
+func (b *Level) Close() error {
+	b.once.Do(func() { close(b.done) })
+	b.wg.Wait()
+	return b.db.Close()
+}
+
+// gc runs the value log garbage collection periodically, until the level is closed.
+func (b *Level) gc() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			// Each run rewrites at most one file. Hence, it is repeated
+			// as long as files with enough garbage are found.
+			for b.db.RunValueLogGC(0.5) == nil {
+			}
+		}
+	}
+}
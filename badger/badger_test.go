@@ -0,0 +1,20 @@
+package badger_test
+
+import (
+	"testing"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/badger"
+	"ariga.io/entcache/leveltest"
+)
+
+func TestLevel(t *testing.T) {
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		l, err := badger.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	})
+}
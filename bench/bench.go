@@ -0,0 +1,196 @@
+// Package bench provides a workload simulator for entcache levels. It can be
+// used for comparing different cache levels (e.g. an in-memory LRU and Redis)
+// using the access pattern of the application, before deploying them.
+//
+//	func BenchmarkLevels(b *testing.B) {
+//		w := bench.Workload{Keys: 10_000, HitRatio: 0.9, EntrySize: 512, Concurrency: 16}
+//		b.Run("LRU", func(b *testing.B) {
+//			bench.Benchmark(b, entcache.NewLRU(10_000), w)
+//		})
+//		b.Run("Redis", func(b *testing.B) {
+//			bench.Benchmark(b, entcache.NewRedis(rdb), w)
+//		})
+//	}
+package bench
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+)
+
+type (
+	// Workload describes the shape of a simulated cache workload.
+	Workload struct {
+		// Keys is the number of distinct keys that are stored
+		// in the cache before the workload starts (i.e. hot keys).
+		Keys int
+		// HitRatio is the ratio of lookups of stored keys. The rest of
+		// the lookups are for new keys, and are followed by an Add.
+		HitRatio float64
+		// EntrySize is the size in bytes of the value stored in each entry.
+		EntrySize int
+		// Concurrency is the number of concurrent workers.
+		Concurrency int
+		// TTL is the TTL of the added entries.
+		TTL time.Duration
+	}
+
+	// Result holds the results of a simulated workload.
+	Result struct {
+		Ops      uint64        // total number of operations.
+		Gets     uint64        // number of Get calls.
+		Hits     uint64        // number of successful Get calls.
+		Adds     uint64        // number of Add calls.
+		Errors   uint64        // number of failed calls.
+		Duration time.Duration // total duration of the workload.
+	}
+)
+
+// defaults sets the default values of unset fields.
+func (w *Workload) defaults() {
+	if w.Keys <= 0 {
+		w.Keys = 1000
+	}
+	if w.EntrySize <= 0 {
+		w.EntrySize = 128
+	}
+	if w.Concurrency <= 0 {
+		w.Concurrency = 1
+	}
+	if w.HitRatio < 0 {
+		w.HitRatio = 0
+	}
+	if w.HitRatio > 1 {
+		w.HitRatio = 1
+	}
+}
+
+// String implements the fmt.Stringer interface.
+func (r Result) String() string {
+	var hit, ops float64
+	if r.Gets > 0 {
+		hit = float64(r.Hits) / float64(r.Gets)
+	}
+	if r.Duration > 0 {
+		ops = float64(r.Ops) / r.Duration.Seconds()
+	}
+	return fmt.Sprintf("ops=%d gets=%d hits=%d adds=%d errors=%d hit_ratio=%.2f ops/s=%.0f", r.Ops, r.Gets, r.Hits, r.Adds, r.Errors, hit, ops)
+}
+
+// Run runs n operations of the given workload against the cache level.
+func Run(ctx context.Context, level entcache.AddGetDeleter, w Workload, n int) (Result, error) {
+	w.defaults()
+	s := newSim(level, w)
+	if err := s.populate(ctx); err != nil {
+		return Result{}, err
+	}
+	start := time.Now()
+	s.run(ctx, n)
+	s.res.Duration = time.Since(start)
+	return s.res, nil
+}
+
+// Benchmark runs b.N operations of the given workload against the
+// cache level, and reports the observed hit ratio as a custom metric.
+func Benchmark(b *testing.B, level entcache.AddGetDeleter, w Workload) {
+	w.defaults()
+	s := newSim(level, w)
+	if err := s.populate(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(w.EntrySize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	s.run(context.Background(), b.N)
+	b.StopTimer()
+	if s.res.Gets > 0 {
+		b.ReportMetric(float64(s.res.Hits)/float64(s.res.Gets), "hits/get")
+	}
+	if s.res.Errors > 0 {
+		b.Errorf("%d cache operations failed", s.res.Errors)
+	}
+}
+
+// sim simulates a workload against a cache level.
+type sim struct {
+	w     Workload
+	level entcache.AddGetDeleter
+	entry *entcache.Entry
+	cold  uint64 // next cold key.
+	res   Result
+}
+
+func newSim(level entcache.AddGetDeleter, w Workload) *sim {
+	return &sim{
+		w:     w,
+		level: level,
+		entry: &entcache.Entry{
+			Columns: []string{"value"},
+			Values:  [][]driver.Value{{make([]byte, w.EntrySize)}},
+		},
+		cold: uint64(w.Keys),
+	}
+}
+
+// populate stores the hot keys in the cache.
+func (s *sim) populate(ctx context.Context) error {
+	for i := 0; i < s.w.Keys; i++ {
+		if err := s.level.Add(ctx, uint64(i), s.entry, s.w.TTL); err != nil {
+			return fmt.Errorf("bench: populate cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// run runs n operations split between the workers.
+func (s *sim) run(ctx context.Context, n int) {
+	var (
+		wg   sync.WaitGroup
+		next int64
+	)
+	for i := 0; i < s.w.Concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for atomic.AddInt64(&next, 1) <= int64(n) {
+				s.op(ctx, rnd)
+			}
+		}(int64(i) + 1)
+	}
+	wg.Wait()
+}
+
+// op executes a single operation. Lookups of cold keys are followed by an
+// Add, similar to the way the driver records query results on a cache miss.
+func (s *sim) op(ctx context.Context, rnd *rand.Rand) {
+	atomic.AddUint64(&s.res.Ops, 1)
+	var key uint64
+	if rnd.Float64() < s.w.HitRatio {
+		key = uint64(rnd.Intn(s.w.Keys))
+	} else {
+		key = atomic.AddUint64(&s.cold, 1)
+	}
+	atomic.AddUint64(&s.res.Gets, 1)
+	switch _, err := s.level.Get(ctx, key); {
+	case err == nil:
+		atomic.AddUint64(&s.res.Hits, 1)
+		return
+	case !errors.Is(err, entcache.ErrNotFound):
+		atomic.AddUint64(&s.res.Errors, 1)
+		return
+	}
+	atomic.AddUint64(&s.res.Adds, 1)
+	if err := s.level.Add(ctx, key, s.entry, s.w.TTL); err != nil {
+		atomic.AddUint64(&s.res.Errors, 1)
+	}
+}
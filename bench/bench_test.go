@@ -0,0 +1,56 @@
+package bench_test
+
+import (
+	"context"
+	"testing"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/bench"
+)
+
+func TestRun(t *testing.T) {
+	w := bench.Workload{Keys: 100, HitRatio: 1, Concurrency: 4}
+	res, err := bench.Run(context.Background(), entcache.NewLRU(0), w, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Ops != 1000 || res.Gets != 1000 || res.Hits != 1000 || res.Adds != 0 || res.Errors != 0 {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	w.HitRatio = 0
+	res, err = bench.Run(context.Background(), entcache.NewLRU(0), w, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Hits != 0 || res.Adds != 1000 {
+		t.Fatalf("unexpected result: %v", res)
+	}
+}
+
+func BenchmarkLRU(b *testing.B) {
+	for _, w := range []struct {
+		name string
+		bench.Workload
+	}{
+		{name: "Hot", Workload: bench.Workload{Keys: 1000, HitRatio: 1, EntrySize: 128, Concurrency: 8}},
+		{name: "Mixed", Workload: bench.Workload{Keys: 1000, HitRatio: 0.8, EntrySize: 128, Concurrency: 8}},
+		{name: "Large", Workload: bench.Workload{Keys: 1000, HitRatio: 0.8, EntrySize: 16 << 10, Concurrency: 8}},
+	} {
+		b.Run(w.name, func(b *testing.B) {
+			bench.Benchmark(b, entcache.NewLRU(10_000), w.Workload)
+		})
+	}
+}
+
+func BenchmarkProcLRU(b *testing.B) {
+	w := bench.Workload{Keys: 100, HitRatio: 1, EntrySize: 128, Concurrency: 64}
+	b.Run("LRU", func(b *testing.B) {
+		bench.Benchmark(b, entcache.NewLRU(0), w)
+	})
+	b.Run("ProcLRU", func(b *testing.B) {
+		bench.Benchmark(b, entcache.NewProcLRU(0), w)
+	})
+	b.Run("ShardedLRU", func(b *testing.B) {
+		bench.Benchmark(b, entcache.NewShardedLRU(32, 0), w)
+	})
+}
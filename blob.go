@@ -0,0 +1,141 @@
+package entcache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+type (
+	// BlobStore is the interface of object storages that are used by the Blob
+	// cache level. Get returns ErrNotFound if the object does not exist.
+	BlobStore interface {
+		Get(ctx context.Context, name string) ([]byte, error)
+		Put(ctx context.Context, name string, data []byte) error
+		Delete(ctx context.Context, name string) error
+	}
+
+	// Blob provides a remote cache backed by an object storage (e.g. S3 or GCS),
+	// and implements the AddGetDeleter interface. It is intended to be used as
+	// the last level of a multi-level cache for very large entries, that do not
+	// fit comfortably in the other levels (e.g. Redis).
+	Blob struct {
+		s        BlobStore
+		prefix   string
+		minSize  int
+		compress Compression
+	}
+
+	// BlobOption allows configuring the Blob
+	// cache level using functional options.
+	BlobOption func(*Blob)
+)
+
+// NewBlob returns a new Blob cache level that stores its entries in the given
+// object storage. See NewGCS, and the New function of the ariga.io/entcache/s3
+// package, for the levels of the common storages.
+//
+// Object storages do not expire objects by a TTL. Therefore, entries carry their
+// expiration time and expired entries are filtered out on reads. A lifecycle rule
+// should be configured on the bucket (or the prefix) to delete old objects.
+func NewBlob(s BlobStore, opts ...BlobOption) *Blob {
+	b := &Blob{s: s, prefix: "entcache/", minSize: 1 << 20}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// BlobMinSize configures the minimum size in bytes of (encoded) entries that are
+// stored by the level. Smaller entries are not added (and an older object of their
+// key is deleted), as they are expected to be stored in the other levels. The
+// default is 1MB, and zero stores all entries.
+func BlobMinSize(n int) BlobOption {
+	return func(b *Blob) {
+		b.minSize = n
+	}
+}
+
+// BlobPrefix configures the prefix of the object names. The default is "entcache/".
+func BlobPrefix(prefix string) BlobOption {
+	return func(b *Blob) {
+		b.prefix = prefix
+	}
+}
+
+// BlobCompression configures the compression of the stored entries.
+// The minimum size (see BlobMinSize) applies to the compressed entries.
+func BlobCompression(c Compression) BlobOption {
+	return func(b *Blob) {
+		b.compress = c
+	}
+}
+
+// Add adds the entry to the cache, if it is not smaller than the minimum size.
+// Otherwise, the object of a previous (larger) entry of the key is deleted, to
+// not serve it after the entry is dropped from the other levels.
+func (b *Blob) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	name, err := b.name(k)
+	if err != nil {
+		return err
+	}
+	buf, err := e.WithExpiry(ttl).Encode(b.compress)
+	if err != nil {
+		return err
+	}
+	if len(buf) < b.minSize {
+		if err := b.s.Delete(ctx, name); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	return b.s.Put(ctx, name, buf)
+}
+
+// Get gets an entry from the cache.
+func (b *Blob) Get(ctx context.Context, k Key) (*Entry, error) {
+	name, err := b.name(k)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := b.s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err := e.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	if e.Expired() {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// Del deletes an entry from the cache.
+func (b *Blob) Del(ctx context.Context, k Key) error {
+	name, err := b.name(k)
+	if err != nil {
+		return err
+	}
+	return b.s.Delete(ctx, name)
+}
+
+// blobKeyLimit is the maximum length of keys that are used as is.
+// S3 and GCS limit object names to 1024 bytes.
+const blobKeyLimit = 512
+
+// name returns the object name of the given Key. Keys that are too long are hashed.
+func (b *Blob) name(k Key) (string, error) {
+	key, err := MarshalKey(k)
+	if err != nil {
+		return "", err
+	}
+	if len(key) > blobKeyLimit {
+		h := sha1.Sum([]byte(key))
+		key = hex.EncodeToString(h[:])
+	}
+	return b.prefix + key, nil
+}
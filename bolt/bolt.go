@@ -0,0 +1,134 @@
+// Package bolt provides a persistent cache level for entcache, that is
+// stored in a single memory-mapped file using bbolt.
+package bolt
+
+import (
+	"context"
+	"time"
+
+	"ariga.io/entcache"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Level provides a persistent cache that is stored in a single memory-mapped
+// file using bbolt, and implements the entcache.AddGetDeleter interface.
+type Level struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// New opens (or creates) a bbolt database in the given path, and returns a cache
+// level that stores its entries in the given bucket. Entries survive process
+// restarts, which is useful for desktop and edge applications (e.g. ent + SQLite)
+// that re-run the same queries.
+//
+//	l, err := bolt.New("/var/cache/myapp.db", "entcache")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer l.Close()
+//	entcache.NewDriver(drv, entcache.Levels(entcache.NewLRU(256), l))
+//
+// Note that bbolt locks the file, and therefore, it can be opened only by a single
+// process at a time. Expired entries are dropped when they are read, or by sweeping
+// (see entcache.SweepInterval).
+func New(path, bucket string) (*Level, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	b := &Level{db: db, bucket: []byte(bucket)}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(b.bucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Add adds the entry to the cache.
+func (b *Level) Add(_ context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return err
+	}
+	buf, err := e.WithExpiry(ttl).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Put([]byte(key), buf)
+	})
+}
+
+// Get gets an entry from the cache.
+func (b *Level) Get(_ context.Context, k entcache.Key) (*entcache.Entry, error) {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return nil, err
+	}
+	e := &entcache.Entry{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		// Values are valid only during the transaction,
+		// and UnmarshalBinary does not retain the buffer.
+		buf := tx.Bucket(b.bucket).Get([]byte(key))
+		if buf == nil {
+			return entcache.ErrNotFound
+		}
+		return e.UnmarshalBinary(buf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if e.Expired() {
+		return nil, entcache.ErrNotFound
+	}
+	return e, nil
+}
+
+// Del deletes an entry from the cache.
+func (b *Level) Del(_ context.Context, k entcache.Key) error {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Delete([]byte(key))
+	})
+}
+
+// Sweep implements the entcache.Sweeper interface.
+func (b *Level) Sweep(context.Context) (int, error) {
+	var n int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(b.bucket)
+		var expired [][]byte
+		if err := bk.ForEach(func(k, v []byte) error {
+			// Entries that cannot be decoded are left as-is.
+			if e := (&entcache.Entry{}); e.UnmarshalBinary(v) == nil && e.Expired() {
+				// Keys are valid only during the transaction, and the
+				// bucket must not be modified while iterating over it.
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bk.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
+
+// Close closes the database.
+func (b *Level) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,63 @@
+package bolt_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/bolt"
+	"ariga.io/entcache/leveltest"
+)
+
+func TestLevel(t *testing.T) {
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		l, err := bolt.New(filepath.Join(t.TempDir(), "cache.db"), "entcache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	})
+}
+
+func TestLevel_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	l, err := bolt.New(path, "entcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	if err := l.Add(ctx, "k1", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add(ctx, "k2", e, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Entries survive reopening the file.
+	l, err = bolt.New(path, "entcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got, err := l.Get(ctx, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Values[0][0] != "a8m" {
+		t.Fatalf("unexpected entry: %v", got.Values)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if n, err := l.Sweep(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 swept entry: %d, %v", n, err)
+	}
+	if _, err := l.Get(ctx, "k1"); err != nil {
+		t.Fatal("expected entry to survive sweeping", err)
+	}
+}
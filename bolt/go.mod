@@ -0,0 +1,25 @@
+module ariga.io/entcache/bolt
+
+go 1.22
+
+require (
+	ariga.io/entcache v0.0.0-00010101000000-000000000000
+	go.etcd.io/bbolt v1.3.10
+)
+
+require (
+	entgo.io/ent v0.11.2-0.20220805114204-0066eb986dd3 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.3 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/mitchellh/hashstructure/v2 v2.0.2 // indirect
+	github.com/redis/go-redis/v9 v9.0.5 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
+)
+
+replace ariga.io/entcache => ../
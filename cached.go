@@ -0,0 +1,41 @@
+package entcache
+
+import (
+	"context"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+)
+
+// Cached executes the given SELECT query through the driver cache, and scans its
+// rows into dest, a pointer to a slice of structs or basic types (see sql.ScanSlice).
+// It allows caching hand-written SQL (e.g. reporting queries) with the same cache
+// levels, statistics and invalidation as ent queries.
+//
+//	var reports []struct {
+//		Owner string `sql:"owner"`
+//		Total int    `sql:"total"`
+//	}
+//	err := drv.Cached(ctx, nil, time.Minute, "SELECT owner, COUNT(*) AS total FROM todos WHERE done = ? GROUP BY owner", []any{true}, &reports)
+//
+// A nil key means the key is computed from the query and its arguments, and a zero
+// TTL means the TTL of the driver. Options that are set on the context (e.g. Evict or
+// WithTags) apply as well. Note that statements that do not start with SELECT (e.g.
+// CTEs) bypass the cache.
+func (d *Driver) Cached(ctx context.Context, key Key, ttl time.Duration, query string, args []any, dest any) error {
+	if key != nil {
+		ctx = WithKey(ctx, key)
+	}
+	if ttl != 0 {
+		ctx = WithTTL(ctx, ttl)
+	}
+	if args == nil {
+		args = []any{}
+	}
+	rows := &sql.Rows{}
+	if err := d.Query(ctx, query, args, rows); err != nil {
+		return err
+	}
+	defer rows.Close()
+	return sql.ScanSlice(rows, dest)
+}
@@ -0,0 +1,33 @@
+// Package chi provides a chi middleware that attaches a context-level
+// cache of entcache to the requests of the routes it is registered on.
+package chi
+
+import (
+	"net/http"
+
+	"ariga.io/entcache"
+)
+
+// Middleware returns a chi middleware that wraps the request context with a
+// context-level cache (see entcache.Middleware), and accepts the same options.
+// Register it on the router or on a group for all of their routes, or inline
+// on a single route to opt it in:
+//
+//	r := chi.NewRouter()
+//	r.With(entchi.Middleware()).Get("/users", listUsers)
+func Middleware(opts ...entcache.MiddlewareOption) func(http.Handler) http.Handler {
+	return entcache.Middleware(opts...)
+}
+
+// Skip returns a chi middleware that opts a route out of the context-level
+// cache that is attached by a Middleware registered on the router or its group.
+//
+//	r.Use(entchi.Middleware())
+//	r.With(entchi.Skip()).Get("/admin/users", listUsers)
+func Skip() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(entcache.Detach(r.Context())))
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package chi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ariga.io/entcache"
+	entchi "ariga.io/entcache/chi"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMiddleware(t *testing.T) {
+	var cached bool
+	h := func(_ http.ResponseWriter, r *http.Request) {
+		_, cached = entcache.FromContext(r.Context())
+	}
+	// Opt-out of a router-level middleware.
+	r := chi.NewRouter()
+	r.Use(entchi.Middleware())
+	r.Get("/users", h)
+	r.With(entchi.Skip()).Get("/admin", h)
+	// Opt-in of a single route.
+	r2 := chi.NewRouter()
+	r2.With(entchi.Middleware()).Get("/users", h)
+	r2.Get("/admin", h)
+	for _, srv := range []*chi.Mux{r, r2} {
+		for path, want := range map[string]bool{"/users": true, "/admin": false} {
+			cached = false
+			srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			if cached != want {
+				t.Errorf("%s: cached = %t, want %t", path, cached, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module ariga.io/entcache/chi
+
+go 1.22
+
+require (
+	ariga.io/entcache v0.0.0-00010101000000-000000000000
+	github.com/go-chi/chi/v5 v5.0.12
+)
+
+replace ariga.io/entcache => ../
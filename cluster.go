@@ -0,0 +1,108 @@
+package entcache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisHashTag configures the Redis level to wrap its keys (and the keys of its
+// tag sets) with the given hash tag. For example, the key "k" is stored as "{tag}:k".
+// In Redis Cluster, keys that share a hash tag are stored in the same slot, and
+// therefore, multi-key operations like tagging (see Tagger) are supported.
+//
+//	entcache.NewRedis(redis.NewClusterClient(&redis.ClusterOptions{
+//		Addrs: []string{":7000", ":7001", ":7002"},
+//	}), entcache.RedisHashTag("entcache"))
+//
+// Note that all keys are stored on a single shard. Hence, for large caches, it is
+// recommended to use a dedicated cluster for tagged entries, or to give up tagging,
+// and batch multi-key operations by slot (see RedisSlot and Redis.DelMulti).
+func RedisHashTag(tag string) RedisOption {
+	return func(r *Redis) {
+		r.hashTag = "{" + tag + "}:"
+	}
+}
+
+// key returns the Redis key of the given Key.
+func (r *Redis) key(k Key) (string, error) {
+	key, err := MarshalKey(k)
+	if err != nil || key == "" {
+		return "", err
+	}
+	return r.hashTag + key, nil
+}
+
+// tagKey returns the Redis key of the set holding the members of the tag.
+func (r *Redis) tagKey(tag string) string {
+	return r.hashTag + redisTagKey(tag)
+}
+
+// DelMulti deletes the given entries from the cache. Keys are batched by their
+// Redis Cluster slot, and each batch is deleted using a single DEL command. All
+// commands are sent in one pipeline.
+func (r *Redis) DelMulti(ctx context.Context, keys ...Key) error {
+	slots := make(map[int][]string)
+	for _, k := range keys {
+		key, err := r.key(k)
+		if err != nil {
+			return err
+		}
+		if key != "" {
+			slot := RedisSlot(key)
+			slots[slot] = append(slots[slot], key)
+		}
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+	_, err := r.c.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, keys := range slots {
+			p.Del(ctx, keys...)
+		}
+		return nil
+	})
+	return err
+}
+
+// redisSlots is the number of hash slots in Redis Cluster.
+const redisSlots = 16384
+
+// RedisSlot returns the Redis Cluster hash slot of the given key. If the key
+// contains a hash tag (e.g. "{user}:1"), only the tag is hashed. It can be used
+// for batching multi-key operations by slot.
+func RedisSlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s != -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) % redisSlots)
+}
+
+// crc16 implements the CRC16-XMODEM checksum that is used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// stripHashTag returns the name of the key without its hash tag prefix, if any.
+func stripHashTag(name string) string {
+	if strings.HasPrefix(name, "{") {
+		if i := strings.Index(name, "}:"); i != -1 {
+			return name[i+2:]
+		}
+	}
+	return name
+}
@@ -0,0 +1,268 @@
+// Command entcachectl inspects and manages the Redis cache used by entcache.
+//
+// Usage:
+//
+//	entcachectl [-redis addr] <command> [flags] [args]
+//
+// The commands are:
+//
+//	keys   [-prefix p]                       list the cache keys, optionally by namespace (i.e. prefix).
+//	get    <key>...                          show the decoded entries of the given keys.
+//	evict  [-key k] [-prefix p] [-tag t]     evict entries by key, prefix or tag.
+//	stats  -url <endpoint>                   dump the stats served by entcache.StatsHandler.
+//
+// The Redis address defaults to the ENTCACHE_REDIS environment variable, or ":6379".
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ariga.io/entcache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "entcachectl:", err)
+		os.Exit(1)
+	}
+}
+
+// tagPrefix is the prefix of the Redis sets that hold the members of tags.
+const tagPrefix = "entcache:tag:"
+
+// run executes the command line with the given arguments, and writes its output to w.
+func run(ctx context.Context, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("entcachectl", flag.ContinueOnError)
+	addr := fs.String("redis", envOr("ENTCACHE_REDIS", ":6379"), "Redis address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("missing command: keys, get, evict or stats")
+	}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	if cmd == "stats" {
+		return stats(ctx, args, w)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: *addr})
+	defer rdb.Close()
+	switch cmd {
+	case "keys":
+		return keys(ctx, rdb, args, w)
+	case "get":
+		return get(ctx, rdb, args, w)
+	case "evict":
+		return evict(ctx, rdb, args, w)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// keys lists the cache keys and their TTL.
+func keys(ctx context.Context, rdb *redis.Client, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("keys", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "list only keys with the given prefix (e.g. a tenant namespace)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTTL")
+	err := scan(ctx, rdb, *prefix, func(key string) error {
+		ttl, err := rdb.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", key, formatTTL(ttl))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// get prints the decoded entries of the given keys.
+func get(ctx context.Context, rdb *redis.Client, keys []string, w io.Writer) error {
+	if len(keys) == 0 {
+		return errors.New("get: missing keys")
+	}
+	for _, key := range keys {
+		buf, err := rdb.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return fmt.Errorf("get: key %q was not found", key)
+		}
+		if err != nil {
+			return err
+		}
+		e := &entcache.Entry{}
+		if err := e.UnmarshalBinary(buf); err != nil {
+			return fmt.Errorf("get: decoding key %q: %w", key, err)
+		}
+		fmt.Fprintf(w, "key: %s\n", key)
+		if !e.Expiry.IsZero() {
+			fmt.Fprintf(w, "expiry: %s\n", e.Expiry.Format(time.RFC3339))
+		}
+		if p := e.Provenance; p != nil {
+			fmt.Fprintf(w, "recorded: %s by %s\nquery: %s\n", p.Time.Format(time.RFC3339), p.Node, p.Query)
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		if len(e.Columns) > 0 {
+			fmt.Fprintln(tw, strings.Join(e.Columns, "\t"))
+		}
+		for _, row := range e.Values {
+			for i, v := range row {
+				if i > 0 {
+					fmt.Fprint(tw, "\t")
+				}
+				fmt.Fprint(tw, formatValue(v))
+			}
+			fmt.Fprintln(tw)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "(%d rows)\n", len(e.Values))
+	}
+	return nil
+}
+
+// evict evicts entries by key, prefix or tag.
+func evict(ctx context.Context, rdb *redis.Client, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("evict", flag.ContinueOnError)
+	var (
+		key    = fs.String("key", "", "evict the entry of the given key")
+		prefix = fs.String("prefix", "", "evict all entries with the given key prefix")
+		tag    = fs.String("tag", "", "evict all entries with the given tag")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *key == "" && *prefix == "" && *tag == "" {
+		return errors.New("evict: one of -key, -prefix or -tag is required")
+	}
+	var n int
+	if *key != "" {
+		deleted, err := rdb.Del(ctx, *key).Result()
+		if err != nil {
+			return err
+		}
+		n += int(deleted)
+	}
+	if *prefix != "" {
+		err := scan(ctx, rdb, *prefix, func(key string) error {
+			deleted, err := rdb.Del(ctx, key).Result()
+			n += int(deleted)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if *tag != "" {
+		members, err := rdb.SCard(ctx, tagPrefix+*tag).Result()
+		if err != nil {
+			return err
+		}
+		if err := entcache.NewRedis(rdb).EvictTag(ctx, *tag); err != nil {
+			return err
+		}
+		n += int(members)
+	}
+	fmt.Fprintf(w, "evicted %d entries\n", n)
+	return nil
+}
+
+// stats dumps the stats that are served by the given endpoint.
+func stats(ctx context.Context, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	url := fs.String("url", "", "the URL of the stats endpoint (see entcache.StatsHandler)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *url == "" {
+		return errors.New("stats: missing -url")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stats: unexpected status: %s", resp.Status)
+	}
+	var v map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return fmt.Errorf("stats: decoding response: %w", err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// scan calls f for each cache key with the given prefix. Tag sets are skipped.
+func scan(ctx context.Context, rdb *redis.Client, prefix string, f func(string) error) error {
+	iter := rdb.Scan(ctx, 0, escapePattern(prefix)+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		if key := iter.Val(); !strings.HasPrefix(key, tagPrefix) {
+			if err := f(key); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Err()
+}
+
+// escapePattern escapes the glob characters of the given string.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl < 0 {
+		return "-"
+	}
+	return ttl.Round(time.Millisecond).String()
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return fmt.Sprintf("%q", v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
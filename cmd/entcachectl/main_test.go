@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	r := entcache.NewRedis(rdb)
+	e := &entcache.Entry{Columns: []string{"id", "name"}, Values: [][]driver.Value{{int64(1), "a8m"}}}
+	for _, k := range []string{"ariga:1", "ariga:2", "other:1"} {
+		if err := r.Add(ctx, k, e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.AddTagged(ctx, "tagged", e, 0, []string{"todos"}); err != nil {
+		t.Fatal(err)
+	}
+	exec := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := run(ctx, append([]string{"-redis", m.Addr()}, args...), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	out := exec("keys", "-prefix", "ariga:")
+	if !strings.Contains(out, "ariga:1") || !strings.Contains(out, "ariga:2") || strings.Contains(out, "other:1") {
+		t.Fatalf("unexpected keys output:\n%s", out)
+	}
+	if out := exec("keys"); strings.Contains(out, "entcache:tag:") {
+		t.Fatalf("expected tag sets to be skipped:\n%s", out)
+	}
+	if out := exec("get", "ariga:1"); !strings.Contains(out, "name") || !strings.Contains(out, "a8m") || !strings.Contains(out, "(1 rows)") {
+		t.Fatalf("unexpected get output:\n%s", out)
+	}
+	if out := exec("evict", "-prefix", "ariga:"); out != "evicted 2 entries\n" {
+		t.Fatalf("unexpected evict output: %q", out)
+	}
+	if out := exec("evict", "-tag", "todos", "-key", "other:1"); out != "evicted 2 entries\n" {
+		t.Fatalf("unexpected evict output: %q", out)
+	}
+	if keys := m.Keys(); len(keys) != 0 {
+		t.Fatalf("expected all keys to be evicted: %v", keys)
+	}
+
+	srv := httptest.NewServer(entcache.StatsHandler(entcache.NewDriver(nil)))
+	defer srv.Close()
+	if out := exec("stats", "-url", srv.URL); !strings.Contains(out, `"gets": 0`) {
+		t.Fatalf("unexpected stats output:\n%s", out)
+	}
+	if err := run(ctx, []string{"unknown"}, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected unknown command to fail")
+	}
+}
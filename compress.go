@@ -0,0 +1,163 @@
+package entcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression defines the compression algorithm of entries
+// that are stored in remote levels (see RedisCompression).
+type Compression uint8
+
+// List of compression algorithms.
+const (
+	// CompressionNone stores entries uncompressed, and without an envelope.
+	// Hence, they can be read by all versions of the package.
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionSnappy
+	CompressionZstd
+)
+
+// String implements the fmt.Stringer interface.
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", c)
+	}
+}
+
+// Compressed entries are wrapped with an envelope that records the codec
+// and the compression algorithm of their payload. The envelope starts with
+// a zero byte, that can not start a gob stream, and therefore, readers can
+// tell enveloped entries apart from entries that were stored without it.
+const (
+	envelopeMagic = 0x00
+	// codecGob is the codec of MarshalBinary.
+	codecGob = 0x01
+)
+
+// maxDecompressedSize caps the size of decompressed payloads, as entries
+// that are read from shared levels (e.g. Redis) may be corrupted or crafted
+// to exhaust the memory of the process (i.e. decompression bombs).
+const maxDecompressedSize = 64 << 20
+
+// errDecompressedSize is returned for payloads that exceed maxDecompressedSize.
+var errDecompressedSize = fmt.Errorf("entcache: decompressed entry exceeds %d bytes", maxDecompressedSize)
+
+// Encode encodes the entry with the given compression algorithm. Compressed
+// entries are decoded by UnmarshalBinary, like entries that are encoded by
+// MarshalBinary.
+func (e Entry) Encode(c Compression) ([]byte, error) {
+	buf, err := e.MarshalBinary()
+	if err != nil || c == CompressionNone {
+		return buf, err
+	}
+	var out bytes.Buffer
+	out.Write([]byte{envelopeMagic, codecGob, byte(c)})
+	switch c {
+	case CompressionGzip:
+		w := gzip.NewWriter(&out)
+		if _, err := w.Write(buf); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionSnappy:
+		out.Write(snappy.Encode(nil, buf))
+	case CompressionZstd:
+		out.Write(zstdEncoder().EncodeAll(buf, nil))
+	default:
+		return nil, fmt.Errorf("entcache: unsupported compression: %s", c)
+	}
+	return out.Bytes(), nil
+}
+
+// unwrap returns the decompressed payload of an enveloped entry.
+// Entries that were stored without an envelope are returned as-is.
+func unwrap(buf []byte) ([]byte, error) {
+	if len(buf) == 0 || buf[0] != envelopeMagic {
+		return buf, nil
+	}
+	if len(buf) < 3 {
+		return nil, fmt.Errorf("entcache: invalid entry envelope")
+	}
+	if buf[1] != codecGob {
+		return nil, fmt.Errorf("entcache: unsupported entry codec: %d", buf[1])
+	}
+	switch c, payload := Compression(buf[2]), buf[3:]; c {
+	case CompressionNone:
+		return payload, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxDecompressedSize {
+			return nil, errDecompressedSize
+		}
+		return out, nil
+	case CompressionSnappy:
+		n, err := snappy.DecodedLen(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxDecompressedSize {
+			return nil, errDecompressedSize
+		}
+		return snappy.Decode(nil, payload)
+	case CompressionZstd:
+		out, err := zstdDecoder().DecodeAll(payload, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, errDecompressedSize
+		}
+		return out, err
+	default:
+		return nil, fmt.Errorf("entcache: unsupported compression: %s", c)
+	}
+}
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+)
+
+// zstdEncoder returns the shared zstd encoder. EncodeAll is safe for concurrent use.
+func zstdEncoder() *zstd.Encoder {
+	zstdOnce.Do(initZstd)
+	return zstdEnc
+}
+
+// zstdDecoder returns the shared zstd decoder. DecodeAll is safe for concurrent use.
+func zstdDecoder() *zstd.Decoder {
+	zstdOnce.Do(initZstd)
+	return zstdDec
+}
+
+func initZstd() {
+	// Errors are returned only for invalid options.
+	zstdEnc, _ = zstd.NewWriter(nil)
+	zstdDec, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+}
@@ -2,12 +2,14 @@ package entcache
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
 type ctxKey struct{}
 
-// NewContext returns a new Context that carries a cache.
+// NewContext returns a new Context that carries a cache. The entries
+// that are added to it can be listed using ContextEntries.
 func NewContext(ctx context.Context, levels ...AddGetDeleter) context.Context {
 	var cache AddGetDeleter
 	switch len(levels) {
@@ -16,8 +18,9 @@ func NewContext(ctx context.Context, levels ...AddGetDeleter) context.Context {
 	case 1:
 		cache = levels[0]
 	default:
-		cache = &multiLevel{levels: levels}
+		cache = newMultiLevel(levels...)
 	}
+	ctx = context.WithValue(ctx, ctxIndexKey{}, &ctxIndex{})
 	return context.WithValue(ctx, ctxKey{}, cache)
 }
 
@@ -27,15 +30,73 @@ func FromContext(ctx context.Context) (AddGetDeleter, bool) {
 	return c, ok
 }
 
+// Reuse returns a new Context that carries the cache stored in from (if any).
+// It is used to share the context-level cache of an operation with its retries,
+// which usually run with a fresh context, so previously fetched reads are reused.
+//
+//	for i := 0; i < 3; i++ {
+//		ctx, cancel := context.WithTimeout(entcache.Reuse(context.Background(), reqCtx), time.Second)
+//		err = op(ctx)
+//		cancel()
+//	}
+func Reuse(ctx, from context.Context) context.Context {
+	c, ok := FromContext(from)
+	if !ok {
+		return ctx
+	}
+	if idx := indexFromContext(from); idx != nil {
+		ctx = context.WithValue(ctx, ctxIndexKey{}, idx)
+	}
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// Detach returns a new Context that does not carry the cache stored in ctx (if any).
+// It allows routes to opt-out of the context-level cache that is attached by a
+// router-level middleware (see Middleware).
+func Detach(ctx context.Context) context.Context {
+	if _, ok := FromContext(ctx); !ok {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, ctxIndexKey{}, nil)
+	return context.WithValue(ctx, ctxKey{}, nil)
+}
+
 // ctxOptions allows injecting runtime options.
 type ctxOptions struct {
-	skip  bool          // i.e. skip entry.
-	evict bool          // i.e. skip and invalidate entry.
-	key   Key           // entry key.
-	ttl   time.Duration // entry duration.
+	skip      bool          // i.e. skip entry.
+	evict     bool          // i.e. skip and invalidate entry.
+	refresh   bool          // i.e. skip lookup and store entry.
+	batch     bool          // i.e. store entry only in context.
+	snapshot  bool          // i.e. pin entries in context.
+	key       Key           // entry key.
+	claim     *keyClaim     // claim of explicit keys.
+	ttl       time.Duration // entry duration.
+	hedge     time.Duration // lookup hedging delay.
+	rows      int           // recorded rows limit.
+	bytes     int           // recorded bytes limit.
+	tags      []string      // entry tags.
+	name      string        // operation name.
+	session   string        // session identifier.
+	level     AddGetDeleter // cache level override.
+	aggregate aggregate     // cached aggregate queries (e.g. CachedCount).
+	cache     AddGetDeleter // resolved cache of the query.
 }
 
-var ctxOptionsKey ctxOptions
+// ctxOptionsKey is the context key of the ctxOptions.
+type ctxOptionsKey struct{}
+
+// withOptions returns a new Context that carries a copy of the options stored in
+// ctx (if any), modified by the given function. Options are never modified in place,
+// because a context may be shared by queries that are executed concurrently (e.g.
+// eager-loading), or by sibling contexts that were derived from it.
+func withOptions(ctx context.Context, f func(*ctxOptions)) context.Context {
+	var c ctxOptions
+	if o, ok := ctx.Value(ctxOptionsKey{}).(*ctxOptions); ok {
+		c = *o
+	}
+	f(&c)
+	return context.WithValue(ctx, ctxOptionsKey{}, &c)
+}
 
 // Skip returns a new Context that tells the Driver
 // to skip the cache entry on Query.
@@ -43,12 +104,9 @@ var ctxOptionsKey ctxOptions
 //	client.T.Query().All(entcache.Skip(ctx))
 //
 func Skip(ctx context.Context) context.Context {
-	c, ok := ctx.Value(ctxOptionsKey).(*ctxOptions)
-	if !ok {
-		return context.WithValue(ctx, ctxOptionsKey, &ctxOptions{skip: true})
-	}
-	c.skip = true
-	return ctx
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.skip = true
+	})
 }
 
 // Evict returns a new Context that tells the Driver
@@ -57,28 +115,52 @@ func Skip(ctx context.Context) context.Context {
 //	client.T.Query().All(entcache.Evict(ctx))
 //
 func Evict(ctx context.Context) context.Context {
-	c, ok := ctx.Value(ctxOptionsKey).(*ctxOptions)
-	if !ok {
-		return context.WithValue(ctx, ctxOptionsKey, &ctxOptions{skip: true, evict: true})
-	}
-	c.skip = true
-	c.evict = true
-	return ctx
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.skip = true
+		c.evict = true
+	})
+}
+
+// Refresh returns a new Context that tells the Driver
+// to skip the cache lookup, and store (or replace) the
+// query results in the cache.
+//
+//	client.T.Query().All(entcache.Refresh(ctx))
+func Refresh(ctx context.Context) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.refresh = true
+	})
 }
 
 // WithKey returns a new Context that carries the Key for the cache entry.
-// Note that, this option should not be used if the ent.Client query involves
-// more than 1 SQL query (e.g. eager loading).
+// Note that, if the ent.Client query involves more than 1 SQL query (e.g.
+// eager loading), only the first statement is stored under the given key,
+// and the others fall back to the key computed by the Hash function.
 //
 //	client.T.Query().All(entcache.WithKey(ctx, "key"))
 //
 func WithKey(ctx context.Context, key Key) context.Context {
-	c, ok := ctx.Value(ctxOptionsKey).(*ctxOptions)
-	if !ok {
-		return context.WithValue(ctx, ctxOptionsKey, &ctxOptions{key: key})
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.key = key
+		c.claim = &keyClaim{}
+	})
+}
+
+// keyClaim tracks the statement that uses an explicit key. Operations that
+// execute multiple statements (e.g. eager-loading) share the same claim.
+type keyClaim struct {
+	mu    sync.Mutex
+	owner Key
+}
+
+// claim reports if the statement with the given hash owns the key.
+func (c *keyClaim) claim(h Key) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owner == nil {
+		c.owner = h
 	}
-	c.key = key
-	return ctx
+	return c.owner == h
 }
 
 // WithTTL returns a new Context that carries the TTL for the cache entry.
@@ -86,10 +168,85 @@ func WithKey(ctx context.Context, key Key) context.Context {
 //	client.T.Query().All(entcache.WithTTL(ctx, time.Second))
 //
 func WithTTL(ctx context.Context, ttl time.Duration) context.Context {
-	c, ok := ctx.Value(ctxOptionsKey).(*ctxOptions)
-	if !ok {
-		return context.WithValue(ctx, ctxOptionsKey, &ctxOptions{ttl: ttl})
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.ttl = ttl
+	})
+}
+
+// WithCache returns a new Context that carries a cache level to be used by
+// its queries instead of the driver cache. For example, using only a local LRU
+// cache for an internal health endpoint, while other requests use Redis.
+//
+//	client.T.Query().All(entcache.WithCache(ctx, lru))
+//
+// Context options, like Batch and Snapshot, are applied on top of the given level.
+func WithCache(ctx context.Context, level AddGetDeleter) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.level = level
+	})
+}
+
+// WithLimits returns a new Context that carries the maximum number of rows and
+// the maximum size in bytes of query results that are stored in the cache. Zero
+// values fall back to the driver limits, and negative values disable them.
+//
+//	client.T.Query().All(entcache.WithLimits(ctx, 100_000, 64<<20))
+func WithLimits(ctx context.Context, rows, bytes int) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.rows, c.bytes = rows, bytes
+	})
+}
+
+// WithHedge returns a new Context that carries the delay for hedging the
+// cache lookup with a database query. A negative value disables hedging.
+//
+//	client.T.Query().All(entcache.WithHedge(ctx, 5*time.Millisecond))
+func WithHedge(ctx context.Context, delay time.Duration) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.hedge = delay
+	})
+}
+
+// Batch returns a new Context for executing batch jobs (e.g. nightly jobs that scan
+// large tables). Queries that are executed with this context are served from the
+// Driver cache if possible, but their results are stored only in a fresh context-level
+// cache that is discarded with the job. Hence, batch jobs do not evict the hot entries
+// of the interactive workload.
+//
+//	entcache.Batch(ctx)
+func Batch(ctx context.Context) context.Context {
+	ctx = NewContext(ctx)
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.batch = true
+	})
+}
+
+// Snapshot returns a new Context that pins the result of the first read of each
+// query in its context-level cache. Subsequent reads of the same query in the
+// context (e.g. the same request) return the pinned result, even if the driver
+// cache was refreshed in the meantime. This avoids rendering a page with data
+// that changed in the middle of the request, while entries still age out
+// across requests.
+//
+//	ctx = entcache.Snapshot(r.Context())
+//
+// If ctx already carries a cache (see NewContext), it is used for pinning.
+func Snapshot(ctx context.Context) context.Context {
+	if _, ok := FromContext(ctx); !ok {
+		ctx = NewContext(ctx)
+	}
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.snapshot = true
+	})
+}
+
+// BatchJob wraps the given job function to be executed with a Batch context.
+//
+//	scheduler.Every(24*time.Hour, entcache.BatchJob(func(ctx context.Context) error {
+//		return scan(ctx, client)
+//	}))
+func BatchJob(job func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return job(Batch(ctx))
 	}
-	c.ttl = ttl
-	return ctx
 }
@@ -0,0 +1,69 @@
+package entcache
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// aggregate defines the kind of queries that are
+// cached by a context (see CachedCount and CachedExist).
+type aggregate uint8
+
+const (
+	aggregateCount aggregate = iota + 1
+	aggregateExist
+)
+
+// CachedCount returns a new Context that tells the Driver to cache the results
+// of count queries (e.g. UserQuery.Count) for the given TTL, and to skip the cache
+// for other queries. A zero TTL means the TTL of the driver.
+//
+//	n, err := client.User.Query().Where(user.Active(true)).Count(entcache.CachedCount(ctx, 10*time.Second))
+//
+// Counts are prone to staleness, and therefore, once they are cached, the driver
+// evicts the entries of tables that are modified by its statements, even if it was
+// not configured with the EvictWrites option. The cache must support tagging (see
+// Tagger), and otherwise, count queries bypass the cache.
+func CachedCount(ctx context.Context, ttl time.Duration) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.aggregate, c.ttl = aggregateCount, ttl
+	})
+}
+
+// CachedExist returns a new Context that tells the Driver to cache the results
+// of existence queries (e.g. UserQuery.Exist) for the given TTL, and to skip the
+// cache for other queries. See CachedCount for more info.
+//
+//	ok, err := client.User.Query().Where(user.Name("a8m")).Exist(entcache.CachedExist(ctx, time.Minute))
+//
+// Note that ent checks existence using a count query, and therefore, count
+// queries are cached with this context.
+func CachedExist(ctx context.Context, ttl time.Duration) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.aggregate, c.ttl = aggregateExist, ttl
+	})
+}
+
+// match reports if the query is of the aggregate kind.
+func (a aggregate) match(query string) bool {
+	switch a {
+	case aggregateCount, aggregateExist:
+		return isCount(query)
+	default:
+		return true
+	}
+}
+
+// isCount reports if the query is a count query (e.g. SELECT COUNT(*) FROM ...).
+func isCount(query string) bool {
+	const prefix = "SELECT COUNT("
+	return len(query) > len(prefix) && strings.EqualFold(query[:len(prefix)], prefix)
+}
+
+// evictAfterWrite reports if the entries of tables that are modified
+// by statements are evicted after they are executed.
+func (d *Driver) evictAfterWrite() bool {
+	return d.Writes&EvictAfterWrite != 0 || atomic.LoadUint32(&d.counts) != 0
+}
@@ -0,0 +1,146 @@
+package entcache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ContextEntry describes an entry that was added to the
+// context-level cache of a request (see ContextEntries).
+type ContextEntry struct {
+	Key Key
+	// Rows and Size hold the number of rows of the entry, and the
+	// estimated size in bytes of its values (see Limits).
+	Rows int
+	Size int
+	// Added holds the time the entry was added, and Age
+	// holds the time that has passed since then.
+	Added time.Time
+	Age   time.Duration
+	// TTL holds the remaining time until the entry expires.
+	// Zero means the entry does not expire.
+	TTL time.Duration
+	// Hits holds the number of lookups that were served by the entry.
+	Hits int
+	// Cached reports if the entry is still stored in the cache. Entries
+	// that expired, or were evicted (e.g. due to the LRU size limit, or
+	// by a statement that modified their tables) are not cached.
+	Cached bool
+}
+
+// ContextEntries returns the entries that were added to the cache of the given
+// request context (see NewContext), ordered by the time they were added. It helps
+// debugging resolvers that unexpectedly miss the cache within the same request.
+//
+//	for _, e := range entcache.ContextEntries(ctx) {
+//		log.Printf("key=%v rows=%d size=%d age=%s hits=%d cached=%t", e.Key, e.Rows, e.Size, e.Age, e.Hits, e.Cached)
+//	}
+//
+// Note that only entries that were added by the driver are
+// listed, and that evicted entries are kept in the list.
+func ContextEntries(ctx context.Context) []ContextEntry {
+	idx, ok := ctx.Value(ctxIndexKey{}).(*ctxIndex)
+	if !ok {
+		return nil
+	}
+	c, _ := FromContext(ctx)
+	now := time.Now()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	entries := make([]ContextEntry, 0, len(idx.entries))
+	for k, ie := range idx.entries {
+		e := ContextEntry{
+			Key:    k,
+			Rows:   ie.rows,
+			Size:   ie.size,
+			Added:  ie.added,
+			Age:    now.Sub(ie.added),
+			Hits:   ie.hits,
+			Cached: !ie.deleted && (ie.expiry.IsZero() || now.Before(ie.expiry)),
+		}
+		if p, ok := c.(interface {
+			peek(Key) (*Entry, time.Time, bool)
+		}); ok && e.Cached {
+			_, _, e.Cached = p.peek(k)
+		}
+		if !ie.expiry.IsZero() && e.Cached {
+			e.TTL = ie.expiry.Sub(now)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Added.Before(entries[j].Added)
+	})
+	return entries
+}
+
+type (
+	// ctxIndexKey is the context key of the ctxIndex.
+	ctxIndexKey struct{}
+
+	// ctxIndex records the entries that were added to the cache of a request.
+	ctxIndex struct {
+		mu      sync.Mutex
+		entries map[Key]*indexEntry
+	}
+
+	// indexEntry describes an entry of the ctxIndex.
+	indexEntry struct {
+		rows, size int
+		hits       int
+		added      time.Time
+		expiry     time.Time
+		deleted    bool
+	}
+)
+
+// indexFromContext returns the index of the request cache, if any.
+func indexFromContext(ctx context.Context) *ctxIndex {
+	idx, _ := ctx.Value(ctxIndexKey{}).(*ctxIndex)
+	return idx
+}
+
+// add records an entry that was added to the cache.
+func (x *ctxIndex) add(k Key, e *Entry, ttl time.Duration) {
+	if x == nil {
+		return
+	}
+	ie := &indexEntry{rows: len(e.Values), added: time.Now(), expiry: e.deadline(ttl)}
+	for _, row := range e.Values {
+		for _, v := range row {
+			ie.size += valueSize(v)
+		}
+	}
+	x.mu.Lock()
+	if x.entries == nil {
+		x.entries = make(map[Key]*indexEntry)
+	}
+	x.entries[k] = ie
+	x.mu.Unlock()
+}
+
+// hit records a lookup that was served by the entry.
+func (x *ctxIndex) hit(k Key) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	if ie, ok := x.entries[k]; ok {
+		ie.hits++
+	}
+	x.mu.Unlock()
+}
+
+// del records an entry that was deleted from the cache.
+func (x *ctxIndex) del(k Key) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	if ie, ok := x.entries[k]; ok {
+		ie.deleted = true
+	}
+	x.mu.Unlock()
+}
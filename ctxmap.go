@@ -0,0 +1,79 @@
+package entcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// ContextMap is a lightweight cache for the lifetime of a single request, and
+	// implements the AddGetDeleter interface. Unlike LRU, it has no size limit and
+	// no eviction policy, and it stores entries as they are, without copying them.
+	// Hence, it should be used only as the cache of a request context, that dies
+	// with the request.
+	//
+	// Statements that modify data clear the entire map, as it does not index
+	// the tables of its entries.
+	ContextMap struct {
+		m sync.Map // key to its *mapEntry.
+	}
+
+	// mapEntry is an entry of the ContextMap. A zero
+	// expiry means the entry does not expire.
+	mapEntry struct {
+		e      *Entry
+		expiry time.Time
+	}
+)
+
+// NewContextMap returns a new ContextMap.
+//
+//	ctx = entcache.NewContext(ctx, entcache.NewContextMap())
+func NewContextMap() *ContextMap {
+	return &ContextMap{}
+}
+
+// Add adds the entry to the cache.
+func (c *ContextMap) Add(_ context.Context, k Key, e *Entry, ttl time.Duration) error {
+	c.m.Store(k, &mapEntry{e: e, expiry: e.deadline(ttl)})
+	return nil
+}
+
+// Get gets an entry from the cache.
+func (c *ContextMap) Get(_ context.Context, k Key) (*Entry, error) {
+	v, ok := c.m.Load(k)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	me := v.(*mapEntry)
+	if !me.expiry.IsZero() && !time.Now().Before(me.expiry) {
+		c.m.CompareAndDelete(k, me)
+		return nil, ErrNotFound
+	}
+	return me.e, nil
+}
+
+// Del deletes an entry from the cache.
+func (c *ContextMap) Del(_ context.Context, k Key) error {
+	c.m.Delete(k)
+	return nil
+}
+
+// Clear removes all entries from the cache.
+func (c *ContextMap) Clear() {
+	c.m.Range(func(k, _ any) bool {
+		c.m.Delete(k)
+		return true
+	})
+}
+
+// peek returns the stored entry of the key and its expiration time as-is.
+func (c *ContextMap) peek(k Key) (*Entry, time.Time, bool) {
+	v, ok := c.m.Load(k)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	me := v.(*mapEntry)
+	return me.e, me.expiry, true
+}
@@ -0,0 +1,51 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+)
+
+// DatabaseKey configures the driver to scope its cache keys by the given
+// database name. It is used when a process works with multiple databases
+// that share the same schema (e.g. shards or per-tenant databases), and
+// therefore, identical queries must not share cache entries.
+//
+//	entcache.NewDriver(shard07, entcache.DatabaseKey("shard-07"), entcache.Levels(lru, rdb))
+func DatabaseKey(name string) Option {
+	return DatabaseKeyFunc(func(context.Context) string {
+		return name
+	})
+}
+
+// DatabaseKeyFunc configures the driver to scope its cache keys by the
+// database name that is returned by the given function. It is used when a
+// single driver routes queries to multiple databases according to their
+// context. An empty name leaves the keys of the context unscoped.
+//
+//	entcache.NewDriver(drv, entcache.DatabaseKeyFunc(func(ctx context.Context) string {
+//		return tenant.FromContext(ctx).Database
+//	}))
+//
+// Note that the table tags (see EvictWrites) are not scoped. Hence, a write to a
+// table evicts the entries of the table in all databases.
+func DatabaseKeyFunc(f func(context.Context) string) Option {
+	return func(o *Options) {
+		o.Database = f
+	}
+}
+
+// DBKey is the cache key of a query that is scoped by its database.
+// In remote levels (e.g. Redis), it is stored as "<DB>:<Key>".
+type DBKey struct {
+	DB  string
+	Key Key
+}
+
+// MarshalKey implements the KeyMarshaler interface.
+func (k DBKey) MarshalKey() (string, error) {
+	key, err := MarshalKey(k.Key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s", k.DB, key), nil
+}
@@ -0,0 +1,142 @@
+package entcache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrDegraded is returned by cache levels that bypass their backend
+// during an outage or a failover (see RedisDegraded).
+var ErrDegraded = errors.New("entcache: cache level is degraded")
+
+// RedisDegraded configures the Redis level to detect outages and failovers, and
+// to degrade to pass-through instead of adding latency to every query by timing
+// out on lookups. The level is degraded after the given number of consecutive
+// connection errors (or immediately on a failover error, like READONLY), and for
+// the given cooldown. Then, a single probe is sent to Redis, and on success, the
+// level recovers.
+//
+//	entcache.NewRedis(redis.NewFailoverClient(&redis.FailoverOptions{
+//		MasterName:    "master",
+//		SentinelAddrs: []string{":26379"},
+//	}), entcache.RedisDegraded(3, 5*time.Second))
+//
+// While degraded, lookups fail with ErrDegraded, and queries are executed on the
+// database and counted as SkipDegraded in the driver stats. Adds are dropped, and
+// deletes fail fast with ErrDegraded, and therefore, they are counted as EvictErrors.
+func RedisDegraded(failures int, cooldown time.Duration) RedisOption {
+	return func(r *Redis) {
+		if failures < 1 {
+			failures = 1
+		}
+		r.degrader = &degrader{threshold: int32(failures), cooldown: cooldown}
+	}
+}
+
+// Degraded reports if the level is currently degraded. See RedisDegraded for more info.
+func (r *Redis) Degraded() bool {
+	return r.degrader.degraded()
+}
+
+// Degradations returns the number of times the level was degraded.
+func (r *Redis) Degradations() uint64 {
+	if r.degrader == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&r.degrader.events)
+}
+
+// degrader tracks the health of a remote level. A nil degrader is always healthy.
+type degrader struct {
+	threshold int32
+	cooldown  time.Duration
+	failures  int32
+	until     int64 // unix nanoseconds, zero when healthy.
+	events    uint64
+}
+
+// allow reports if an operation can be sent to the backend. Once the cooldown
+// is elapsed, a single caller is allowed to probe the backend, and the cooldown
+// is extended for the others.
+func (g *degrader) allow() bool {
+	if g == nil {
+		return true
+	}
+	u := atomic.LoadInt64(&g.until)
+	if u == 0 {
+		return true
+	}
+	now := time.Now().UnixNano()
+	return now >= u && atomic.CompareAndSwapInt64(&g.until, u, now+int64(g.cooldown))
+}
+
+// done records the result of an operation that was sent to
+// the backend with the given context of the caller.
+func (g *degrader) done(ctx context.Context, err error) {
+	if g == nil {
+		return
+	}
+	if !isOutage(ctx, err) {
+		atomic.StoreInt32(&g.failures, 0)
+		atomic.StoreInt64(&g.until, 0)
+		return
+	}
+	if n := atomic.AddInt32(&g.failures, 1); n >= g.threshold || isFailover(err) {
+		if atomic.SwapInt64(&g.until, time.Now().Add(g.cooldown).UnixNano()) == 0 {
+			atomic.AddUint64(&g.events, 1)
+		}
+	}
+}
+
+// degraded reports if the backend is considered unavailable.
+func (g *degrader) degraded() bool {
+	return g != nil && atomic.LoadInt64(&g.until) != 0
+}
+
+// isOutage reports if the error indicates that Redis is unavailable, rather than
+// a missing key or a command error (e.g. a wrong type). Deadlines of the caller
+// context (e.g. a short request deadline) are not outages, unlike the timeouts of
+// the level (see RedisWriteTimeout).
+func isOutage(ctx context.Context, err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+		return false
+	}
+	var rerr redis.Error
+	if errors.As(err, &rerr) {
+		return isFailover(err)
+	}
+	return true
+}
+
+// failoverErrors are the prefixes of Redis errors that
+// are returned by nodes during failovers or resharding.
+var failoverErrors = []string{"READONLY", "LOADING", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN"}
+
+// isFailover reports if the error is returned by Redis during a failover.
+func isFailover(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, p := range failoverErrors {
+		if strings.HasPrefix(err.Error(), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheErrorReason returns the skip reason of a failed cache lookup.
+func cacheErrorReason(err error) SkipReason {
+	if errors.Is(err, ErrDegraded) {
+		return SkipDegraded
+	}
+	return SkipCacheError
+}
@@ -6,7 +6,9 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	_ "unsafe"
@@ -14,6 +16,7 @@ import (
 	"entgo.io/ent/dialect"
 	"entgo.io/ent/dialect/sql"
 	"github.com/mitchellh/hashstructure/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 type (
@@ -23,6 +26,16 @@ type (
 		// is valid in the cache.
 		TTL time.Duration
 
+		// ZeroTTL defines the interpretation of queries that have no
+		// TTL, and must be NoExpiry or NoCache (see ZeroTTL). Zero
+		// means NoExpiry, and Verify warns about it.
+		ZeroTTL time.Duration
+
+		// MinTTL and MaxTTL define the bounds of the entry TTLs
+		// (see MinTTL and MaxTTL). Zero means no bound.
+		MinTTL time.Duration
+		MaxTTL time.Duration
+
 		// Cache defines the GetAddDeleter (cache implementation)
 		// for holding the cache entries. If no cache implementation
 		// was provided, an LRU cache with no limit is used.
@@ -33,9 +46,117 @@ type (
 		// function was provided, the DefaultHash is used.
 		Hash func(query string, args []any) (Key, error)
 
+		// Database defines an optional function that returns the name of
+		// the database that executes the queries of the context. If set,
+		// cache keys are scoped by it (see DatabaseKey).
+		Database func(context.Context) string
+
+		// Invalidators defines the strategies for invalidating
+		// cache entries on writes (see Invalidate).
+		Invalidators []Invalidator
+
+		// Provenance indicates if the origin of entries is recorded
+		// alongside them. Node identifies the process in the recorded
+		// provenance (see RecordProvenance).
+		Provenance bool
+		Node       string
+
+		// ProfileLabels indicates if goroutines that query the
+		// database are labeled with pprof labels (see ProfileLabels).
+		ProfileLabels bool
+
+		// KeyEvents defines an optional Redis client for subscribing
+		// to key events (see KeyEvents).
+		KeyEvents redis.UniversalClient
+
+		// SweepInterval defines the interval of sweeping the
+		// cache levels in the background (see SweepInterval).
+		// Zero means sweeping is disabled.
+		SweepInterval time.Duration
+
+		// Policy defines an optional provider of per-query
+		// caching policies (see Policies).
+		Policy PolicyProvider
+
+		// TTLFunc defines an optional function that resolves the TTL
+		// of a query by its arguments (see TTLPolicy).
+		TTLFunc func(query string, args []any) (time.Duration, bool)
+
+		// SkipUnfiltered indicates if queries without a WHERE or a LIMIT
+		// clause bypass the cache, unless they read only from the tables
+		// in UnfilteredTables (see SkipUnfiltered).
+		SkipUnfiltered   bool
+		UnfilteredTables []string
+
+		// StatsStore defines an optional store that the statistics are
+		// added to every StatsInterval, and when the driver is closed
+		// (see PersistStats).
+		StatsStore    StatsStore
+		StatsInterval time.Duration
+
+		// SQLComments indicates if the sqlcommenter tags of queries are
+		// stripped before hashing, and CommentNames defines the tags that
+		// name their operation (see SQLComments).
+		SQLComments  bool
+		CommentNames []string
+
 		// Logf function. If provided, the Driver will call it with
 		// errors that can not be handled.
 		Log func(...any)
+
+		// PromoteOnHit indicates if entries that were found in a lower
+		// cache level are added to the levels above it (see PromoteOnHit).
+		PromoteOnHit bool
+
+		// TolerateLevelErrors indicates if failing cache levels are
+		// skipped and logged (see TolerateLevelErrors).
+		TolerateLevelErrors bool
+
+		// Hedge defines an optional delay for hedging cache lookups. If
+		// a lookup was not answered within this period, the query is
+		// executed on the database in parallel, and the first result to
+		// arrive is used.
+		Hedge time.Duration
+
+		// HotKeys defines the number of most frequently used
+		// keys to track. Zero means tracking is disabled.
+		HotKeys int
+
+		// Writes defines when the entries of tables that are modified by
+		// statements (e.g. UPDATE) are evicted from the cache. Zero means
+		// entries are not evicted on writes.
+		Writes WriteEviction
+
+		// MaxRows and MaxBytes limit the number of rows and the (estimated)
+		// size in bytes of recorded query results. Results that exceed one
+		// of the limits are not stored in the cache. Zero means no limit.
+		MaxRows  int
+		MaxBytes int
+
+		// Prefetch defines the number of next pages of paginated queries
+		// to prefetch on cache misses. Zero means prefetching is disabled.
+		Prefetch int
+
+		// Stampede configures an optional guard that bounds the number
+		// of callers that add the same entry to the cache on misses.
+		Stampede *StampedeGuard
+
+		// SessionWindow defines the period in which queries of a session
+		// bypass the cache for the tables it modified (see ReadYourWrites).
+		SessionWindow time.Duration
+
+		// ColumnMetadata defines the number of query fingerprints to cache
+		// the column metadata of (see ColumnMetadata). Zero means disabled.
+		ColumnMetadata int
+
+		// Fingerprints indicates if entries are tagged with
+		// the fingerprint of their query (see TagFingerprints).
+		Fingerprints bool
+
+		// Debug enables recording the caching decision of each query.
+		// Decisions are passed to Log, and are collected by contexts
+		// that were created using WithTrace.
+		Debug bool
 	}
 
 	// Option allows configuring the cache
@@ -47,7 +168,29 @@ type (
 	Driver struct {
 		dialect.Driver
 		*Options
-		stats Stats
+		stats  Stats
+		db     DBStats
+		hot    *hotKeys
+		misses *missCounter
+		meta   *metadataCache
+		// sessions holds the recent writes of sessions.
+		sessions *sessionWrites
+		names    sync.Map // operation name to its *Stats.
+		// prefetching holds the keys of pages that are being prefetched.
+		prefetching sync.Map
+		// mu guards the fields below.
+		mu         sync.Mutex
+		refreshers map[*refresher]struct{}
+		stopSweep  context.CancelFunc
+		stopEvents context.CancelFunc
+		stopStats  context.CancelFunc
+		// persistMu guards persisted, the snapshot
+		// of the stats that was last persisted.
+		persistMu sync.Mutex
+		persisted Stats
+		// counts is set once an aggregate query is cached
+		// with a CachedCount or a CachedExist context.
+		counts uint32
 	}
 )
 
@@ -69,10 +212,44 @@ func NewDriver(drv dialect.Driver, opts ...Option) *Driver {
 	for _, opt := range opts {
 		opt(options)
 	}
-	return &Driver{
+	d := &Driver{
 		Driver:  drv,
 		Options: options,
 	}
+	if options.HotKeys > 0 {
+		d.hot = newHotKeys(options.HotKeys)
+	}
+	if options.Stampede != nil {
+		d.misses = newMissCounter(options.Stampede)
+	}
+	if options.SessionWindow > 0 {
+		d.sessions = newSessionWrites(options.SessionWindow)
+	}
+	if options.ColumnMetadata > 0 {
+		d.meta = newMetadataCache(options.ColumnMetadata)
+	}
+	if options.SweepInterval > 0 {
+		var ctx context.Context
+		ctx, d.stopSweep = context.WithCancel(context.Background())
+		go d.runSweeper(ctx)
+	}
+	if options.StatsStore != nil && options.StatsInterval > 0 {
+		var ctx context.Context
+		ctx, d.stopStats = context.WithCancel(context.Background())
+		go d.runStatsPersister(ctx)
+	}
+	if m, ok := options.Cache.(*multiLevel); ok {
+		m.promote = options.PromoteOnHit
+		m.tolerant = options.TolerateLevelErrors
+		m.report = func(i int, err error) {
+			if d.Log != nil {
+				d.Log(fmt.Sprintf("entcache: skipping failed cache level %d (%T): %v", i, m.levels[i], err))
+			}
+		}
+	}
+	d.startKeyEvents()
+	d.startAsync()
+	return d
 }
 
 // TTL configures the period of time that an Entry
@@ -83,6 +260,61 @@ func TTL(ttl time.Duration) Option {
 	}
 }
 
+// Explicit TTLs that can be set on the driver (see TTL and ZeroTTL), on contexts
+// (see WithTTL) and on policies, instead of the ambiguous zero TTL.
+const (
+	// NoExpiry stores entries without expiration. They are
+	// kept in the cache until they are evicted or deleted.
+	NoExpiry time.Duration = math.MaxInt64
+	// NoCache bypasses the cache. Queries are executed on the database,
+	// and counted as SkipNoCache in the driver stats.
+	NoCache time.Duration = math.MinInt64
+)
+
+// ZeroTTL configures the interpretation of queries that have no TTL, i.e. a TTL was
+// not configured for them by the driver, their context, or their policy. The given
+// ttl must be NoExpiry (the default), or NoCache. For example, caching only queries
+// that were configured explicitly:
+//
+//	entcache.NewDriver(drv, entcache.ZeroTTL(entcache.NoCache))
+//
+// If it is not configured, and the driver has neither a TTL nor a MaxTTL, Verify passes
+// a warning to the Log function, as a zero TTL stores entries without expiration.
+func ZeroTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.ZeroTTL = ttl
+	}
+}
+
+// MinTTL configures the lower bound of the TTLs of entries. TTLs that are set by
+// the driver or by contexts (see WithTTL) and are shorter than d are clamped to d.
+// It protects from code paths that accidentally configure near-zero TTLs, which
+// effectively disable the cache, and hide capacity problems until later.
+//
+//	entcache.NewDriver(drv, entcache.TTL(time.Minute), entcache.MinTTL(time.Second))
+//
+// Note that zero TTLs (i.e. no expiration) and negative TTLs (i.e. entries that
+// expire immediately, as set explicitly using WithTTL) are not affected.
+func MinTTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.MinTTL = d
+	}
+}
+
+// MaxTTL configures the upper bound of the TTLs of entries. TTLs that are set by
+// the driver or by contexts (see WithTTL) and are longer than d are clamped to d,
+// so individual call sites cannot cache volatile data for longer than the policy
+// allows. Entries without expiration (i.e. zero TTL) are clamped as well.
+//
+//	entcache.NewDriver(drv, entcache.TTL(time.Minute), entcache.MaxTTL(10*time.Minute))
+//
+// Clamped TTLs (of both MinTTL and MaxTTL) are counted by Stats.ClampedTTLs.
+func MaxTTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.MaxTTL = d
+	}
+}
+
 // Hash configures an optional Hash function for
 // converting a query and its arguments to a cache key.
 func Hash(hash func(query string, args []any) (Key, error)) Option {
@@ -98,11 +330,117 @@ func Levels(levels ...AddGetDeleter) Option {
 		if len(levels) == 1 {
 			o.Cache = levels[0]
 		} else {
-			o.Cache = &multiLevel{levels: levels}
+			o.Cache = newMultiLevel(levels...)
 		}
 	}
 }
 
+// PromoteOnHit configures the driver to add entries that were found only in a lower
+// cache level (e.g. Redis) to the levels above it (e.g. the in-process LRU). Hence,
+// the next lookups of the entry on the same process are not sent to the lower level.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(entcache.NewLRU(256), entcache.NewRedis(rdb)),
+//		entcache.PromoteOnHit(true),
+//	)
+//
+// Promoted entries keep their remaining TTL, and are associated with the tags of
+// their query (see WithTags), so they are evicted along with the original entries.
+func PromoteOnHit(enabled bool) Option {
+	return func(o *Options) {
+		o.PromoteOnHit = enabled
+	}
+}
+
+// TolerateLevelErrors configures the driver to skip the levels of a multi-level cache
+// that fail, instead of failing the whole lookup. For example, during a Redis outage,
+// entries are still looked up in (and added to) the in-process LRU, and missing ones
+// are fetched from the database, as if the failing level missed them.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(entcache.NewLRU(256), entcache.NewRedis(rdb)),
+//		entcache.TolerateLevelErrors(true),
+//	)
+//
+// Failures of the skipped levels are passed to the Log function, and are counted
+// by LevelStats. Failures of required levels (see Required) are never skipped.
+func TolerateLevelErrors(enabled bool) Option {
+	return func(o *Options) {
+		o.TolerateLevelErrors = enabled
+	}
+}
+
+// Hedge configures the driver to hedge slow cache lookups (e.g. a remote Redis
+// level) with the database. If the cache did not answer within the given delay,
+// the query is also executed on the database, and the first result to arrive is
+// used. Results that were fetched from the database are stored in the cache.
+//
+// Use WithHedge for configuring the delay of a specific query.
+func Hedge(delay time.Duration) Option {
+	return func(o *Options) {
+		o.Hedge = delay
+	}
+}
+
+// Debug configures the driver to record the caching decision made for
+// each query. Decisions are logged using the Log function (if provided),
+// and can be retrieved from a context that was wrapped with WithTrace:
+//
+//	ctx = entcache.WithTrace(ctx)
+//	client.T.Query().All(ctx)
+//	for _, d := range entcache.TraceFromContext(ctx) {
+//		fmt.Println(d)
+//	}
+func Debug() Option {
+	return func(o *Options) {
+		o.Debug = true
+	}
+}
+
+// Limits configures the maximum number of rows and the maximum size in bytes of
+// query results that are stored in the cache. Zero means no limit. Use WithLimits
+// for overriding the limits of specific queries (e.g. large exports).
+//
+//	entcache.NewDriver(drv, entcache.Limits(1000, 1<<20))
+func Limits(rows, bytes int) Option {
+	return func(o *Options) {
+		o.MaxRows, o.MaxBytes = rows, bytes
+	}
+}
+
+// WriteEviction defines when the entries of tables that
+// are modified by a statement are evicted from the cache.
+type WriteEviction uint
+
+// List of write eviction modes. Modes can be combined.
+const (
+	// EvictAfterWrite evicts the entries after the statement was executed.
+	EvictAfterWrite WriteEviction = 1 << iota
+	// EvictBeforeWrite evicts the entries before the statement is executed.
+	// When combined with EvictAfterWrite, entries that were recorded by
+	// readers while the statement was executed are evicted as well.
+	EvictBeforeWrite
+)
+
+// EvictWrites configures the driver to evict the entries of tables that are
+// modified by statements executed through it (e.g. UPDATE and DELETE). Entries
+// are tagged with the tables they read, and therefore, the cache must support
+// tagging (see Tagger).
+//
+//	entcache.NewDriver(drv, entcache.EvictWrites(entcache.EvictBeforeWrite|entcache.EvictAfterWrite))
+//
+// Note that evicting entries only after writes leaves a window between the
+// write and the eviction, in which readers are served stale entries. Evicting
+// before writes shrinks this window, but readers that run during the statement
+// execution may record stale entries. Hence, both modes are usually combined.
+func EvictWrites(w WriteEviction) Option {
+	return func(o *Options) {
+		o.Writes = w
+	}
+}
+
 // ContextLevel configures the driver to work with context/request level cache.
 // Users that use this option, should wraps the *http.Request context with the
 // cache value as follows:
@@ -110,9 +448,34 @@ func Levels(levels ...AddGetDeleter) Option {
 //	ctx = entcache.NewContext(ctx)
 //
 //	ctx = entcache.NewContext(ctx, entcache.NewLRU(128))
-func ContextLevel() Option {
+//
+// Use AlsoShared for populating a longer-lived shared level as well.
+func ContextLevel(opts ...ContextLevelOption) Option {
 	return func(o *Options) {
-		o.Cache = &contextLevel{}
+		l := &contextLevel{}
+		for _, opt := range opts {
+			opt(l)
+		}
+		o.Cache = l
+	}
+}
+
+// ContextLevelOption allows configuring the
+// context level cache using functional options.
+type ContextLevelOption func(*contextLevel)
+
+// AlsoShared configures the context level cache to add its entries to the given
+// shared level (e.g. an LRU or Redis) with the given TTL, and to look up entries
+// that are missing in the request context in it. Hence, queries that run again in
+// the next requests are served from the shared level. If ttl is zero, the TTL of
+// the driver (or the query) is used.
+//
+//	entcache.NewDriver(drv, entcache.ContextLevel(entcache.AlsoShared(entcache.NewLRU(1024), time.Minute)))
+//
+// Entries of tables that are modified by the driver are evicted from both levels.
+func AlsoShared(level AddGetDeleter, ttl time.Duration) ContextLevelOption {
+	return func(l *contextLevel) {
+		l.shared, l.ttl = level, ttl
 	}
 }
 
@@ -122,14 +485,33 @@ func ContextLevel() Option {
 // Note that, the driver does not synchronize identical queries that are executed
 // concurrently. Hence, if 2 identical queries are executed at the ~same time, and
 // there is no cache entry for them, the driver will execute both of them and the
-// last successful one will be stored in the cache.
+// last successful one will be stored in the cache. Use GuardStampede for bounding
+// the number of callers that store the same entry.
 func (d *Driver) Query(ctx context.Context, query string, args, v any) error {
+	if d.Debug {
+		return d.debugQuery(ctx, d.Driver, query, args, v)
+	}
+	return d.query(ctx, d.Driver, query, args, v)
+}
+
+// query executes the query using the cache, and falls back
+// to the given driver (or transaction) on cache misses.
+func (d *Driver) query(ctx context.Context, drv dialect.ExecQuerier, query string, args, v any) error {
 	// Check if the given statement looks like a standard Ent query (e.g. SELECT).
 	// Custom queries (e.g. CTE) or statements that are prefixed with comments are
 	// not supported. This check is mainly necessary, because PostgreSQL and SQLite
 	// may execute insert statement like "INSERT ... RETURNING" using Driver.Query.
 	if !strings.HasPrefix(query, "SELECT") && !strings.HasPrefix(query, "select") {
-		return d.Driver.Query(ctx, query, args, v)
+		d.skip(ctx, SkipNotSelect)
+		if !isWrite(query) {
+			return drv.Query(ctx, query, args, v)
+		}
+		return d.write(ctx, query, func() error {
+			return drv.Query(ctx, query, args, v)
+		})
+	}
+	if d.Debug {
+		decisionFromContext(ctx).Select = true
 	}
 	vr, ok := v.(*sql.Rows)
 	if !ok {
@@ -141,39 +523,253 @@ func (d *Driver) Query(ctx context.Context, query string, args, v any) error {
 	}
 	opts, err := d.optionsFromContext(ctx, query, argv)
 	if err != nil {
-		return d.Driver.Query(ctx, query, args, v)
+		return d.execQuery(ctx, drv, query, args, v)
+	}
+	if opts.refresh {
+		if err := d.execQuery(ctx, drv, query, args, vr); err != nil {
+			return err
+		}
+		d.record(ctx, opts, query, vr)
+		return nil
 	}
 	atomic.AddUint64(&d.stats.Gets, 1)
-	switch e, err := d.Cache.Get(ctx, opts.key); {
+	if opts.name != "" {
+		atomic.AddUint64(&d.nameStats(opts.name).Gets, 1)
+	}
+	if d.hot != nil {
+		d.hot.touch(opts.key, query, argv)
+	}
+	if l, ok := d.Cache.(*contextLevel); d.PromoteOnHit || ok && l.shared != nil {
+		// Tags are computed only if the entry is promoted
+		// (or copied from the shared level to the context).
+		ctx = context.WithValue(ctx, promoteTagsKey{}, func() []string {
+			return d.entryTags(query, opts)
+		})
+	}
+	// Transactions execute their statements on a single
+	// connection, and therefore, their queries are not hedged.
+	if _, ok := drv.(dialect.Tx); !ok && opts.hedge > 0 {
+		return d.hedgedQuery(ctx, drv, opts, query, args, vr)
+	}
+	var start time.Time
+	if d.Debug {
+		start = time.Now()
+	}
+	e, err := d.get(ctx, opts)
+	if d.Debug {
+		decisionFromContext(ctx).Lookup = time.Since(start)
+	}
+	return d.lookup(ctx, drv, opts, query, args, vr, e, err)
+}
+
+// lookup handles the result of a cache lookup. On hit, the rows are replayed from the
+// cache entry. On miss, the query is executed using the underlying driver, and its rows
+// are recorded for the cache.
+func (d *Driver) lookup(ctx context.Context, drv dialect.ExecQuerier, opts ctxOptions, query string, args any, vr *sql.Rows, e *Entry, err error) error {
+	switch {
 	case err == nil:
-		atomic.AddUint64(&d.stats.Hits, 1)
-		vr.ColumnScanner = &repeater{columns: e.Columns, values: e.Values}
+		d.hit(ctx, opts, query, vr, e)
+	case err == errInFlight, err == ErrNotFound && d.guard(ctx, opts):
+		// Another caller records the entry.
+		d.skip(ctx, SkipInFlight)
+		return d.dbQuery(ctx, drv, query, args, vr)
 	case err == ErrNotFound:
-		if err := d.Driver.Query(ctx, query, args, vr); err != nil {
+		if err := d.dbQuery(ctx, drv, query, args, vr); err != nil {
 			return err
 		}
-		vr.ColumnScanner = &recorder{
-			ColumnScanner: vr.ColumnScanner,
-			onClose: func(columns []string, values [][]driver.Value) {
-				err := d.Cache.Add(ctx, opts.key, &Entry{Columns: columns, Values: values}, opts.ttl)
-				if err != nil && d.Log != nil {
-					atomic.AddUint64(&d.stats.Errors, 1)
-					d.Log(fmt.Sprintf("entcache: failed storing entry %v in cache: %v", opts.key, err))
-				}
-			},
-		}
+		d.missed(ctx, drv, opts, query, args, vr)
 	default:
-		return d.Driver.Query(ctx, query, args, v)
+		d.skip(ctx, cacheErrorReason(err))
+		return d.dbQuery(ctx, drv, query, args, vr)
 	}
 	return nil
 }
 
+// missed records the rows of a query that missed the cache, and
+// prefetches its related queries, if it is not executed in a transaction.
+func (d *Driver) missed(ctx context.Context, drv dialect.ExecQuerier, opts ctxOptions, query string, args any, vr *sql.Rows) {
+	d.record(ctx, opts, query, vr)
+	if _, ok := drv.(dialect.Tx); !ok {
+		d.prefetch(ctx, opts, query, args)
+	}
+}
+
+// dbQuery executes the query using the given driver after the cache was
+// missed, and records the time it took separately from the cache lookup.
+func (d *Driver) dbQuery(ctx context.Context, drv dialect.ExecQuerier, query string, args, v any) error {
+	start := time.Now()
+	err := d.execQuery(ctx, drv, query, args, v)
+	took := time.Since(start)
+	atomic.AddUint64(&d.db.Queries, 1)
+	atomic.AddInt64((*int64)(&d.db.Time), int64(took))
+	if d.Debug {
+		if dec := decisionFromContext(ctx); dec != nil {
+			dec.DB = took
+		}
+	}
+	return err
+}
+
+// hit sets the rows to repeat the given cache entry of the query.
+func (d *Driver) hit(ctx context.Context, opts ctxOptions, query string, vr *sql.Rows, e *Entry) {
+	atomic.AddUint64(&d.stats.Hits, 1)
+	if opts.name != "" {
+		atomic.AddUint64(&d.nameStats(opts.name).Hits, 1)
+	}
+	if d.Debug {
+		decisionFromContext(ctx).hit()
+	}
+	var recorded time.Time
+	if e.Provenance != nil {
+		recorded = e.Provenance.Time
+	}
+	validate(ctx, e, recorded)
+	r := repeaters.Get().(*repeater)
+	r.rows, r.columns, r.values = vr, e.Columns, e.Values
+	r.meta, r.query = d.meta, query
+	vr.ColumnScanner = r
+}
+
+// record wraps the rows with a recorder that stores them in the cache on close.
+func (d *Driver) record(ctx context.Context, opts ctxOptions, query string, vr *sql.Rows) {
+	tags := d.entryTags(query, opts)
+	vr.ColumnScanner = &recorder{
+		ColumnScanner: vr.ColumnScanner,
+		query:         query,
+		meta:          d.meta,
+		maxRows:       opts.rows,
+		maxBytes:      opts.bytes,
+		onLimit: func(rows bool) {
+			if v := validatorFromContext(ctx); v != nil {
+				v.bypass()
+			}
+			if rows {
+				atomic.AddUint64(&d.stats.RowsLimited, 1)
+			} else {
+				atomic.AddUint64(&d.stats.BytesLimited, 1)
+			}
+		},
+		onClose: func(columns []string, values [][]driver.Value) {
+			e := &Entry{Columns: columns, Values: values, Provenance: d.provenance(query)}
+			validate(ctx, e, time.Now())
+			err := addTagged(ctx, opts.cache, opts.key, e, opts.ttl, tags)
+			if err == nil {
+				return
+			}
+			if column, v, ok := unencodable(columns, values); ok {
+				atomic.AddUint64(&d.stats.EncodeErrors, 1)
+				if d.Log != nil {
+					d.Log(fmt.Sprintf("entcache: entry %v is not cacheable due to value type %T in column %s of query: %s", opts.key, v, column, query))
+				}
+				return
+			}
+			if d.Log != nil {
+				atomic.AddUint64(&d.stats.Errors, 1)
+				d.Log(fmt.Sprintf("entcache: failed storing entry %v in cache: %v", opts.key, err))
+			}
+		},
+	}
+}
+
 // Stats returns a copy of the cache statistics.
 func (d *Driver) Stats() Stats {
-	return Stats{
-		Gets:   atomic.LoadUint64(&d.stats.Gets),
-		Hits:   atomic.LoadUint64(&d.stats.Hits),
-		Errors: atomic.LoadUint64(&d.stats.Errors),
+	s := Stats{
+		Gets:         atomic.LoadUint64(&d.stats.Gets),
+		Hits:         atomic.LoadUint64(&d.stats.Hits),
+		Errors:       atomic.LoadUint64(&d.stats.Errors),
+		Hedges:       atomic.LoadUint64(&d.stats.Hedges),
+		EvictErrors:  atomic.LoadUint64(&d.stats.EvictErrors),
+		RowsLimited:  atomic.LoadUint64(&d.stats.RowsLimited),
+		BytesLimited: atomic.LoadUint64(&d.stats.BytesLimited),
+		EncodeErrors: atomic.LoadUint64(&d.stats.EncodeErrors),
+		ClampedTTLs:  atomic.LoadUint64(&d.stats.ClampedTTLs),
+		Swept:        atomic.LoadUint64(&d.stats.Swept),
+		AsyncErrors:  atomic.LoadUint64(&d.stats.AsyncErrors),
+	}
+	for i := range s.Skips {
+		s.Skips[i] = atomic.LoadUint64(&d.stats.Skips[i])
+	}
+	return s
+}
+
+// ResetStats resets the cache statistics of the driver, including the statistics
+// of named operations, the database fallbacks (except InFlight) and the levels,
+// and returns the cache statistics that were collected until the reset. Counters
+// are reset one by one, and therefore, a concurrent operation may be recorded
+// partially before the reset and partially after it.
+func (d *Driver) ResetStats() Stats {
+	s := Stats{
+		Gets:         atomic.SwapUint64(&d.stats.Gets, 0),
+		Hits:         atomic.SwapUint64(&d.stats.Hits, 0),
+		Errors:       atomic.SwapUint64(&d.stats.Errors, 0),
+		Hedges:       atomic.SwapUint64(&d.stats.Hedges, 0),
+		EvictErrors:  atomic.SwapUint64(&d.stats.EvictErrors, 0),
+		RowsLimited:  atomic.SwapUint64(&d.stats.RowsLimited, 0),
+		BytesLimited: atomic.SwapUint64(&d.stats.BytesLimited, 0),
+		EncodeErrors: atomic.SwapUint64(&d.stats.EncodeErrors, 0),
+		ClampedTTLs:  atomic.SwapUint64(&d.stats.ClampedTTLs, 0),
+		Swept:        atomic.SwapUint64(&d.stats.Swept, 0),
+		AsyncErrors:  atomic.SwapUint64(&d.stats.AsyncErrors, 0),
+	}
+	for i := range s.Skips {
+		s.Skips[i] = atomic.SwapUint64(&d.stats.Skips[i], 0)
+	}
+	d.names.Range(func(_, v any) bool {
+		ns := v.(*Stats)
+		atomic.StoreUint64(&ns.Gets, 0)
+		atomic.StoreUint64(&ns.Hits, 0)
+		return true
+	})
+	atomic.StoreUint64(&d.db.Queries, 0)
+	atomic.StoreInt64((*int64)(&d.db.Time), 0)
+	if m, ok := d.Cache.(*multiLevel); ok {
+		for i := range m.errs {
+			atomic.StoreUint64(&m.errs[i], 0)
+			atomic.StoreUint64(&m.getErrs[i], 0)
+		}
+	}
+	return s
+}
+
+// entryTTL returns the TTL of an entry that was requested with the given TTL.
+// A zero ttl means the driver TTL (or the ZeroTTL, if it is zero as well), and
+// non-negative TTLs are clamped to the MinTTL and MaxTTL bounds. NoExpiry is
+// returned as zero, and NoCache is returned as-is.
+func (d *Driver) entryTTL(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		ttl = d.TTL
+	}
+	if ttl == 0 {
+		ttl = d.ZeroTTL
+	}
+	switch ttl {
+	case NoCache:
+		return ttl
+	case NoExpiry:
+		ttl = 0
+	}
+	switch {
+	case ttl > 0 && ttl < d.MinTTL:
+		ttl = d.MinTTL
+	case ttl >= 0 && d.MaxTTL > 0 && (ttl == 0 || ttl > d.MaxTTL):
+		ttl = d.MaxTTL
+	default:
+		return ttl
+	}
+	atomic.AddUint64(&d.stats.ClampedTTLs, 1)
+	return ttl
+}
+
+// skip records that a query bypassed the cache for the given reason.
+func (d *Driver) skip(ctx context.Context, r SkipReason) {
+	atomic.AddUint64(&d.stats.Skips[r], 1)
+	if v := validatorFromContext(ctx); v != nil {
+		v.bypass()
+	}
+	if d.Debug {
+		if dec := decisionFromContext(ctx); dec != nil {
+			dec.Skipped, dec.Skip = true, r
+		}
 	}
 }
 
@@ -206,33 +802,145 @@ var errSkip = errors.New("entcache: skip cache")
 // optionsFromContext returns the injected options from the context, or its default value.
 func (d *Driver) optionsFromContext(ctx context.Context, query string, args []any) (ctxOptions, error) {
 	var opts ctxOptions
-	if c, ok := ctx.Value(ctxOptionsKey).(*ctxOptions); ok {
+	if c, ok := ctx.Value(ctxOptionsKey{}).(*ctxOptions); ok {
 		opts = *c
 	}
-	if opts.key == nil {
+	if d.SQLComments {
+		var tags map[string]string
+		query, tags = splitComment(query)
+		if opts.name == "" {
+			opts.name = d.commentName(tags)
+		}
+		if d.Debug && tags != nil {
+			decisionFromContext(ctx).Comments = tags
+		}
+	}
+	if opts.aggregate != 0 {
+		// Cached aggregates rely on the eviction of their tables on writes, and
+		// therefore, they are not cached if the cache does not support tagging.
+		if _, ok := d.Cache.(Tagger); !ok || !opts.aggregate.match(query) {
+			d.skip(ctx, SkipOption)
+			return opts, errSkip
+		}
+		if atomic.LoadUint32(&d.counts) == 0 {
+			atomic.StoreUint32(&d.counts, 1)
+		}
+	}
+	if opts.key == nil || opts.claim != nil {
 		key, err := d.Hash(query, args)
 		if err != nil {
+			d.skip(ctx, SkipHashError)
 			return opts, errSkip
 		}
-		opts.key = key
+		switch {
+		case opts.key == nil:
+			opts.key = key
+		case !opts.claim.claim(key):
+			// The explicit key is already used by another statement of the same
+			// operation (e.g. eager-loading). Fall back to the statement hash to
+			// avoid overriding its entry.
+			if d.Log != nil {
+				d.Log(fmt.Sprintf("entcache: key %v is used by multiple statements, falling back to %v for: %s", opts.key, key, query))
+			}
+			opts.key = key
+		}
+	}
+	for _, inv := range d.Invalidators {
+		opts.key = inv.Key(query, opts.key)
+	}
+	if d.Database != nil {
+		if db := d.Database(ctx); db != "" {
+			opts.key = DBKey{DB: db, Key: opts.key}
+		}
+	}
+	if d.Debug {
+		decisionFromContext(ctx).Key = opts.key
+	}
+	policy, hasPolicy := d.policy(ctx, query, opts)
+	if hasPolicy && opts.ttl == 0 {
+		opts.ttl = policy.TTL
+	}
+	if opts.ttl == 0 && d.TTLFunc != nil {
+		if ttl, ok := d.TTLFunc(query, args); ok {
+			opts.ttl = ttl
+		}
+	}
+	opts.ttl = d.entryTTL(opts.ttl)
+	if opts.hedge == 0 {
+		opts.hedge = d.Hedge
 	}
-	if opts.ttl == 0 {
-		opts.ttl = d.TTL
+	if opts.rows == 0 {
+		opts.rows = d.MaxRows
+	}
+	if opts.bytes == 0 {
+		opts.bytes = d.MaxBytes
+	}
+	base := d.Cache
+	if opts.level != nil {
+		base = opts.level
+	}
+	opts.cache = base
+	if _, ok := base.(*contextLevel); !ok {
+		switch {
+		case opts.batch:
+			// Batch queries are served from the driver cache,
+			// but stored only in their context-level cache.
+			opts.cache = newMultiLevel(&contextLevel{}, &readOnly{base})
+		case opts.snapshot:
+			opts.cache = &snapshotLevel{AddGetDeleter: base}
+		}
 	}
 	if opts.evict {
-		if err := d.Cache.Del(ctx, opts.key); err != nil {
+		if err := d.evict(ctx, base, opts.key); err != nil {
+			d.skip(ctx, SkipCacheError)
 			return opts, err
 		}
+		if opts.snapshot {
+			(&contextLevel{}).Del(ctx, opts.key)
+		}
 	}
 	if opts.skip {
+		d.skip(ctx, SkipOption)
+		return opts, errSkip
+	}
+	if opts.ttl == NoCache {
+		d.skip(ctx, SkipNoCache)
+		return opts, errSkip
+	}
+	if hasPolicy && policy.bypass() {
+		d.skip(ctx, SkipPolicy)
+		return opts, errSkip
+	}
+	if d.unfiltered(query) {
+		d.skip(ctx, SkipNoFilter)
+		return opts, errSkip
+	}
+	if opts.session != "" && d.sessions != nil && d.sessions.reads(opts.session, query) {
+		d.skip(ctx, SkipSessionWrite)
 		return opts, errSkip
 	}
 	return opts, nil
 }
 
 // DefaultHash provides the default implementation for converting
-// a query and its argument to a cache key.
+// a query and its arguments to a cache key. Queries that their arguments
+// are all of basic types (e.g. numbers, strings or time.Time) are streamed
+// into the hash function without intermediate allocations. Other queries
+// are hashed using StructHash.
 func DefaultHash(query string, args []any) (Key, error) {
+	if key, ok := basicHash(query, args); ok {
+		return key, nil
+	}
+	return StructHash(query, args)
+}
+
+// StructHash converts a query and its arguments to a cache key using the
+// hashstructure package. It was the DefaultHash of previous versions, and
+// can be used to keep the keys of entries that were already stored in a
+// shared cache (e.g. Redis) stable. For example:
+//
+//	entcache.NewDriver(drv, entcache.Hash(entcache.StructHash))
+func StructHash(query string, args []any) (Key, error) {
 	key, err := hashstructure.Hash(struct {
 		Q string
 		A []any
@@ -251,6 +959,170 @@ type Stats struct {
 	Gets   uint64
 	Hits   uint64
 	Errors uint64
+	// Hedges holds the number of cache lookups that were
+	// hedged with a database query.
+	Hedges uint64
+	// EvictErrors holds the number of cache levels that
+	// failed to evict an entry after all retries.
+	EvictErrors uint64
+	// RowsLimited and BytesLimited hold the number of query results that
+	// were not stored in the cache, because they exceeded the row or byte
+	// limits (see Limits).
+	RowsLimited  uint64
+	BytesLimited uint64
+	// EncodeErrors holds the number of query results that were not
+	// stored in the cache, because one of their values could not be
+	// encoded (e.g. a custom type that was not registered with gob).
+	// These failures are not counted as Errors.
+	EncodeErrors uint64
+	// ClampedTTLs holds the number of queries (and values) that
+	// their TTL was clamped to the MinTTL or MaxTTL bounds.
+	ClampedTTLs uint64
+	// Swept holds the number of expired entries and stale index
+	// references that were dropped by sweeping (see SweepInterval).
+	Swept uint64
+	// AsyncErrors holds the number of background adds and deletes of
+	// async levels that failed, or were dropped as their queue was full
+	// (see AsyncLevel).
+	AsyncErrors uint64
+	// Skips holds the number of queries that bypassed
+	// the cache, indexed by their SkipReason.
+	Skips [numSkipReasons]uint64
+}
+
+// Delta returns the difference between the statistics and a previous snapshot
+// of them, i.e. the counts of the interval between the two. Counters that are
+// lower than their previous value (e.g. after Driver.ResetStats) are considered
+// to be restarted, and are returned as-is.
+//
+//	prev := drv.Stats()
+//	for range time.Tick(time.Minute) {
+//		curr := drv.Stats()
+//		report(curr.Delta(prev))
+//		prev = curr
+//	}
+func (s Stats) Delta(prev Stats) Stats {
+	d := Stats{
+		Gets:         delta(s.Gets, prev.Gets),
+		Hits:         delta(s.Hits, prev.Hits),
+		Errors:       delta(s.Errors, prev.Errors),
+		Hedges:       delta(s.Hedges, prev.Hedges),
+		EvictErrors:  delta(s.EvictErrors, prev.EvictErrors),
+		RowsLimited:  delta(s.RowsLimited, prev.RowsLimited),
+		BytesLimited: delta(s.BytesLimited, prev.BytesLimited),
+		EncodeErrors: delta(s.EncodeErrors, prev.EncodeErrors),
+		ClampedTTLs:  delta(s.ClampedTTLs, prev.ClampedTTLs),
+		Swept:        delta(s.Swept, prev.Swept),
+		AsyncErrors:  delta(s.AsyncErrors, prev.AsyncErrors),
+	}
+	for i := range d.Skips {
+		d.Skips[i] = delta(s.Skips[i], prev.Skips[i])
+	}
+	return d
+}
+
+// delta returns the difference between a counter and its previous value.
+func delta(curr, prev uint64) uint64 {
+	if curr < prev {
+		return curr
+	}
+	return curr - prev
+}
+
+// DBStats represents the statistics of queries that were executed on the
+// underlying driver after the cache was missed (or failed). It does not
+// include the time of the cache lookups.
+type DBStats struct {
+	// Queries holds the number of executed queries,
+	// and Time holds the total time they took.
+	Queries uint64
+	Time    time.Duration
+	// InFlight holds the number of queries
+	// that are currently being executed.
+	InFlight int64
+}
+
+// DBStats returns a copy of the database fallback statistics.
+func (d *Driver) DBStats() DBStats {
+	return DBStats{
+		Queries:  atomic.LoadUint64(&d.db.Queries),
+		Time:     time.Duration(atomic.LoadInt64((*int64)(&d.db.Time))),
+		InFlight: atomic.LoadInt64(&d.db.InFlight),
+	}
+}
+
+// LevelStats represents the statistics of a cache level.
+type LevelStats struct {
+	// AddErrors holds the number of entries
+	// that failed to be added to the level.
+	AddErrors uint64
+	// GetErrors holds the number of lookups that failed
+	// on the level, and were skipped (see TolerateLevelErrors).
+	GetErrors uint64
+}
+
+// LevelStats returns the statistics of the cache levels in the order they were
+// configured, or nil if the driver was not configured with multiple levels.
+func (d *Driver) LevelStats() []LevelStats {
+	m, ok := d.Cache.(*multiLevel)
+	if !ok {
+		return nil
+	}
+	s := make([]LevelStats, len(m.levels))
+	for i := range s {
+		s[i].AddErrors = atomic.LoadUint64(&m.errs[i])
+		s[i].GetErrors = atomic.LoadUint64(&m.getErrs[i])
+	}
+	return s
+}
+
+// SkipReason describes why a query bypassed the cache.
+type SkipReason uint
+
+// List of reasons for skipping the cache.
+const (
+	SkipNotSelect    SkipReason = iota // statement is not a SELECT query.
+	SkipHashError                      // failed computing the cache key.
+	SkipOption                         // Skip, Evict or an aggregate (e.g. CachedCount) was set on the context.
+	SkipCacheError                     // cache returned an unexpected error.
+	SkipInFlight                       // entry is recorded by another caller.
+	SkipTxWrite                        // transaction modified data before the query.
+	SkipSessionWrite                   // session recently modified the data (see ReadYourWrites).
+	SkipPolicy                         // query policy disabled the cache (see Policies).
+	SkipNoFilter                       // query has no WHERE or LIMIT clause (see SkipUnfiltered).
+	SkipDegraded                       // cache level is degraded due to an outage (see RedisDegraded).
+	SkipNoCache                        // query TTL is NoCache (see ZeroTTL).
+	numSkipReasons
+)
+
+// String returns the reason label, to be used by metric collectors.
+func (r SkipReason) String() string {
+	switch r {
+	case SkipNotSelect:
+		return "not_select"
+	case SkipHashError:
+		return "hash_error"
+	case SkipOption:
+		return "option"
+	case SkipCacheError:
+		return "cache_error"
+	case SkipInFlight:
+		return "in_flight"
+	case SkipTxWrite:
+		return "tx_write"
+	case SkipSessionWrite:
+		return "session_write"
+	case SkipPolicy:
+		return "policy"
+	case SkipNoFilter:
+		return "no_filter"
+	case SkipDegraded:
+		return "degraded"
+	case SkipNoCache:
+		return "no_cache"
+	default:
+		return fmt.Sprintf("SkipReason(%d)", r)
+	}
 }
 
 // rawCopy copies the driver values by implementing
@@ -278,6 +1150,15 @@ type recorder struct {
 	columns []string
 	done    bool
 	onClose func([]string, [][]driver.Value)
+	// query and meta are used for sharing the
+	// column metadata of the query (see ColumnMetadata).
+	query string
+	meta  *metadataCache
+	// Recording limits. Non-positive values mean no limit.
+	maxRows, maxBytes int
+	size              int
+	limited           bool
+	onLimit           func(rows bool)
 }
 
 // Next wraps the underlying Next method
@@ -305,10 +1186,50 @@ func (r *recorder) Scan(dest ...any) error {
 			return err
 		}
 	}
+	if r.limited {
+		return nil
+	}
+	if r.maxRows > 0 && len(r.values) >= r.maxRows {
+		r.limit(true)
+		return nil
+	}
+	if r.maxBytes > 0 {
+		for _, v := range values {
+			r.size += valueSize(v)
+		}
+		if r.size > r.maxBytes {
+			r.limit(false)
+			return nil
+		}
+	}
 	r.values = append(r.values, values)
 	return nil
 }
 
+// limit stops recording the rows, as the given limit was exceeded.
+func (r *recorder) limit(rows bool) {
+	r.limited, r.values = true, nil
+	if r.onLimit != nil {
+		r.onLimit(rows)
+	}
+}
+
+// valueSize returns the estimated size in bytes of a driver value.
+func valueSize(v driver.Value) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case time.Time:
+		return 24
+	default:
+		return 8
+	}
+}
+
 // Columns wraps the underlying Column method and stores it in the recorder state.
 // The repeater.Columns cannot be called if the recorder method was not called before.
 // That means, raw scanning should be identical for identical queries.
@@ -317,6 +1238,9 @@ func (r *recorder) Columns() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	if r.meta != nil {
+		columns = r.meta.record(r.query, columns, r.ColumnScanner)
+	}
 	r.columns = columns
 	return columns, nil
 }
@@ -327,7 +1251,7 @@ func (r *recorder) Close() error {
 	}
 	// If we did not encounter any error during iteration,
 	// and we scanned all rows, we store it on cache.
-	if err := r.ColumnScanner.Err(); err == nil || r.done {
+	if err := r.ColumnScanner.Err(); !r.limited && (err == nil || r.done) {
 		r.onClose(r.columns, r.values)
 	}
 	return nil
@@ -335,14 +1259,31 @@ func (r *recorder) Close() error {
 
 // repeater repeats columns scanning from cache history.
 type repeater struct {
+	rows    *sql.Rows
 	columns []string
 	values  [][]driver.Value
+	// query and meta are used for resolving the
+	// column types of the query (see ColumnMetadata).
+	query string
+	meta  *metadataCache
 }
 
-func (*repeater) Close() error {
+// repeaters pools the repeaters used by cache hits.
+var repeaters = sync.Pool{New: func() any { return &repeater{} }}
+
+// Close detaches the repeater from its rows before returning it to the
+// pool. Hence, calling Close twice (or using the rows after they were
+// closed) never reaches a repeater that was reused by another query.
+func (r *repeater) Close() error {
+	r.rows.ColumnScanner = closedRows{}
+	*r = repeater{}
+	repeaters.Put(r)
 	return nil
 }
-func (*repeater) ColumnTypes() ([]*stdsql.ColumnType, error) {
+func (r *repeater) ColumnTypes() ([]*stdsql.ColumnType, error) {
+	if types, ok := r.meta.types(r.query, r.columns); ok {
+		return types, nil
+	}
 	return nil, fmt.Errorf("entcache.ColumnTypes is not supported")
 }
 func (r *repeater) Columns() ([]string, error) {
@@ -362,6 +1303,9 @@ func (r *repeater) Scan(dest ...any) error {
 	if !r.Next() {
 		return stdsql.ErrNoRows
 	}
+	if len(dest) != len(r.values[0]) {
+		return fmt.Errorf("entcache: expected %d destination arguments in Scan, not %d", len(r.values[0]), len(dest))
+	}
 	for i, src := range r.values[0] {
 		if err := convertAssign(dest[i], src); err != nil {
 			return err
@@ -373,3 +1317,30 @@ func (r *repeater) Scan(dest ...any) error {
 
 //go:linkname convertAssign database/sql.convertAssign
 func convertAssign(dest, src any) error
+
+// closedRows is set on rows that their repeater was closed.
+type closedRows struct{}
+
+func (closedRows) Close() error {
+	return nil
+}
+func (closedRows) ColumnTypes() ([]*stdsql.ColumnType, error) {
+	return nil, errRowsClosed
+}
+func (closedRows) Columns() ([]string, error) {
+	return nil, errRowsClosed
+}
+func (closedRows) Err() error {
+	return nil
+}
+func (closedRows) Next() bool {
+	return false
+}
+func (closedRows) NextResultSet() bool {
+	return false
+}
+func (closedRows) Scan(...any) error {
+	return errRowsClosed
+}
+
+var errRowsClosed = errors.New("entcache: rows are closed")
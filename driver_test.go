@@ -1,8 +1,17 @@
 package entcache_test
 
 import (
+	"bytes"
 	"context"
+	stdsql "database/sql"
 	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,7 +20,9 @@ import (
 	"entgo.io/ent/dialect"
 	"entgo.io/ent/dialect/sql"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestDriver_ContextLevel(t *testing.T) {
@@ -53,6 +64,41 @@ func TestDriver_ContextLevel(t *testing.T) {
 		}
 	})
 
+	t.Run("Reuse", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.ContextLevel())
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		ctx1, cancel := context.WithCancel(entcache.NewContext(context.Background()))
+		expectQuery(ctx1, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		cancel()
+		ctx2 := entcache.Reuse(context.Background(), ctx1)
+		expectQuery(ctx2, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.ContextLevel())
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		ctx := entcache.NewContext(context.Background(), entcache.NewContextMap())
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		// Writes clear the map.
+		mock.ExpectExec("UPDATE users SET name = ?").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := drv.Exec(ctx, "UPDATE users SET name = ?", []interface{}{"a8m"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("TTL", func(t *testing.T) {
 		drv := entcache.NewDriver(drv, entcache.ContextLevel(), entcache.TTL(-1))
 		mock.ExpectQuery("SELECT name FROM users").
@@ -68,6 +114,83 @@ func TestDriver_ContextLevel(t *testing.T) {
 	})
 }
 
+func TestContextEntries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ContextLevel(), entcache.TTL(time.Minute))
+	if entries := entcache.ContextEntries(context.Background()); entries != nil {
+		t.Fatalf("unexpected entries without a request cache: %v", entries)
+	}
+	ctx := entcache.NewContext(context.Background())
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m").AddRow("nati"))
+	mock.ExpectQuery("SELECT title FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("entcache"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m", "nati"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m", "nati"})
+	expectQuery(ctx, t, drv, "SELECT title FROM todos", []interface{}{"entcache"})
+	mock.ExpectExec("UPDATE `todos`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := drv.Exec(ctx, "UPDATE `todos` SET `title` = ?", []interface{}{"ent"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	entries := entcache.ContextEntries(ctx)
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if e := entries[0]; e.Rows != 2 || e.Size != len("a8m")+len("nati") || e.Hits != 1 || !e.Cached || e.TTL <= 0 || e.TTL > time.Minute {
+		t.Errorf("unexpected users entry: %+v", e)
+	}
+	// Entries of modified tables are evicted from the request cache.
+	if e := entries[1]; e.Rows != 1 || e.Hits != 0 || e.Cached || e.TTL != 0 || e.Added.Before(entries[0].Added) {
+		t.Errorf("unexpected todos entry: %+v", e)
+	}
+	if entries := entcache.ContextEntries(entcache.Reuse(context.Background(), ctx)); len(entries) != 2 {
+		t.Errorf("expected reused contexts to share the entries: %+v", entries)
+	}
+}
+
+func TestDriver_ContextLevelShared(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := entcache.NewLRU(0)
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ContextLevel(entcache.AlsoShared(shared, time.Minute)))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.NewContext(context.Background()), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// The next request is served from the shared level.
+	ctx := entcache.NewContext(context.Background())
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// Writes evict the entries of their tables from both levels.
+	mock.ExpectExec("UPDATE `users`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := drv.Exec(ctx, "UPDATE `users` SET `name` = ?", []interface{}{"Ariel"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if shared.Len() != 0 {
+		t.Fatal("expected entry to be evicted from the shared level")
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	// Shared hits are copied to the context, and therefore,
+	// the next lookups of the request are served from it.
+	ctx = entcache.NewContext(context.Background())
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	shared.Clear()
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDriver_Levels(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -202,6 +325,59 @@ func TestDriver_ContextOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("MinTTL", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.MinTTL(time.Minute))
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		ttlCtx := entcache.WithTTL(context.Background(), time.Nanosecond)
+		expectQuery(ttlCtx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		time.Sleep(time.Millisecond)
+		expectQuery(ttlCtx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if n := drv.Stats().ClampedTTLs; n != 2 {
+			t.Fatalf("unexpected clamped TTLs: %d != 2", n)
+		}
+	})
+
+	t.Run("MaxTTL", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.MaxTTL(time.Millisecond))
+		for _, ctx := range []context.Context{context.Background(), entcache.WithTTL(context.Background(), time.Hour)} {
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+			time.Sleep(2 * time.Millisecond)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if n := drv.Stats().ClampedTTLs; n != 2 {
+			t.Fatalf("unexpected clamped TTLs: %d != 2", n)
+		}
+	})
+
+	t.Run("ZeroTTL", func(t *testing.T) {
+		// Queries without a TTL bypass the cache, unless they set one explicitly.
+		drv := entcache.NewDriver(drv, entcache.ZeroTTL(entcache.NoCache))
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+			expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		}
+		if n := drv.Stats().Skips[entcache.SkipNoCache]; n != 2 {
+			t.Fatalf("unexpected %s skips: %d != 2", entcache.SkipNoCache, n)
+		}
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		ttlCtx := entcache.WithTTL(context.Background(), entcache.NoExpiry)
+		expectQuery(ttlCtx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		expectQuery(ttlCtx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("WithKey", func(t *testing.T) {
 		drv := entcache.NewDriver(drv)
 		mock.ExpectQuery("SELECT name FROM users").
@@ -245,12 +421,214 @@ func TestDriver_SkipInsert(t *testing.T) {
 		t.Fatal(err)
 	}
 	var expected entcache.Stats
+	expected.Skips[entcache.SkipNotSelect] = 1
 	if s := drv.Stats(); s != expected {
 		t.Errorf("unexpected stats: %v != %v", s, expected)
 	}
 }
 
-func expectQuery(ctx context.Context, t *testing.T, drv dialect.Driver, query string, args []interface{}) {
+func TestDriver_SkipStats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Hash(func(q string, _ []interface{}) (entcache.Key, error) {
+		if q == "SELECT age FROM users" {
+			return nil, errors.New("unsupported")
+		}
+		return q, nil
+	}))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Skip(context.Background()), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Evict(context.Background()), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT age FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"age"}).AddRow(30))
+	expectQuery(context.Background(), t, drv, "SELECT age FROM users", []interface{}{int64(30)})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	s := drv.Stats()
+	if n := s.Skips[entcache.SkipOption]; n != 2 {
+		t.Errorf("unexpected %s skips: %d != 2", entcache.SkipOption, n)
+	}
+	if n := s.Skips[entcache.SkipHashError]; n != 1 {
+		t.Errorf("unexpected %s skips: %d != 1", entcache.SkipHashError, n)
+	}
+	if s.Gets != 0 {
+		t.Errorf("unexpected gets: %d != 0", s.Gets)
+	}
+}
+
+func TestDriver_ResetStats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := entcache.WithName(context.Background(), "users")
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	prev := drv.Stats()
+	if prev.Gets != 2 || prev.Hits != 1 {
+		t.Fatalf("unexpected stats: %+v", prev)
+	}
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if d := drv.Stats().Delta(prev); d.Gets != 1 || d.Hits != 1 {
+		t.Fatalf("unexpected delta: %+v", d)
+	}
+	if prev = drv.ResetStats(); prev.Gets != 3 || prev.Hits != 2 {
+		t.Fatalf("unexpected stats before reset: %+v", prev)
+	}
+	if s := drv.Stats(); s != (entcache.Stats{}) {
+		t.Fatalf("expected stats to be reset: %+v", s)
+	}
+	if s := drv.NameStats()["users"]; s.Gets != 0 || s.Hits != 0 {
+		t.Fatalf("expected name stats to be reset: %+v", s)
+	}
+	if s := drv.DBStats(); s.Queries != 0 || s.Time != 0 {
+		t.Fatalf("expected db stats to be reset: %+v", s)
+	}
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// Counters that were reset since the previous snapshot are returned as-is.
+	if d := drv.Stats().Delta(prev); d.Gets != 1 || d.Hits != 1 {
+		t.Fatalf("unexpected delta after reset: %+v", d)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_SkipUnfiltered(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.SkipUnfiltered("countries"))
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT `name` FROM `users`").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT `name` FROM `users`", []interface{}{"a8m"})
+	}
+	for _, query := range []string{"SELECT `name` FROM `users` WHERE `id` = ?", "SELECT `name` FROM `users` LIMIT 1", "SELECT `name` FROM `countries`"} {
+		mock.ExpectQuery(query).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, query, []interface{}{"a8m"})
+		expectQuery(ctx, t, drv, query, []interface{}{"a8m"})
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if n := drv.Stats().Skips[entcache.SkipNoFilter]; n != 2 {
+		t.Fatalf("unexpected %s skips: %d != 2", entcache.SkipNoFilter, n)
+	}
+}
+
+func TestDriver_TTLPolicy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.TTL(time.Hour),
+		entcache.TTLPolicy(func(_ string, args []any) (time.Duration, bool) {
+			if len(args) == 1 && args[0] == "today" {
+				return time.Millisecond, true
+			}
+			return 0, false
+		}),
+	)
+	query := func(day string) {
+		t.Helper()
+		rows := &sql.Rows{}
+		if err := drv.Query(context.Background(), "SELECT `id` FROM `orders` WHERE `day` = ?", []any{day}, rows); err != nil {
+			t.Fatal(err)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Entries of today expire after the policy TTL.
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT `id` FROM `orders`").
+			WithArgs("today").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		query("today")
+		time.Sleep(2 * time.Millisecond)
+	}
+	// Other entries use the driver TTL.
+	mock.ExpectQuery("SELECT `id` FROM `orders`").
+		WithArgs("2020-01-01").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	query("2020-01-01")
+	time.Sleep(2 * time.Millisecond)
+	query("2020-01-01")
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_CachedCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	ctx := entcache.CachedCount(context.Background(), time.Minute)
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM `users`").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	expectQuery(ctx, t, drv, "SELECT COUNT(*) FROM `users`", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT COUNT(*) FROM `users`", []interface{}{int64(1)})
+	// Other queries bypass the cache.
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT `name` FROM `users`").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT `name` FROM `users`", []interface{}{"a8m"})
+	}
+	// Writes evict the counts of their tables.
+	mock.ExpectExec("INSERT INTO `users`").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	if err := drv.Exec(context.Background(), "INSERT INTO `users` (`name`) VALUES (?)", []interface{}{"a8m"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM `users`").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	expectQuery(ctx, t, drv, "SELECT COUNT(*) FROM `users`", []interface{}{int64(2)})
+	expectQuery(ctx, t, drv, "SELECT COUNT(*) FROM `users`", []interface{}{int64(2)})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Caches without tagging support do not cache counts,
+	// and do not fail writes on evictions.
+	cache := &countingLevel{AddGetDeleter: entcache.NewLRU(0)}
+	drv = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(cache))
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM `users`").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		expectQuery(ctx, t, drv, "SELECT COUNT(*) FROM `users`", []interface{}{int64(2)})
+	}
+	mock.ExpectExec("INSERT INTO `users`").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	if err := drv.Exec(context.Background(), "INSERT INTO `users` (`name`) VALUES (?)", []interface{}{"a8m"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); cache.adds != 0 || s.EvictErrors != 0 {
+		t.Fatalf("unexpected adds or eviction errors: %d, %d", cache.adds, s.EvictErrors)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectQuery(ctx context.Context, t *testing.T, drv dialect.ExecQuerier, query string, args []interface{}) {
+	t.Helper()
 	rows := &sql.Rows{}
 	if err := drv.Query(ctx, query, []interface{}{}, rows); err != nil {
 		t.Fatalf("unexpected query failure: %q: %v", query, err)
@@ -275,3 +653,1777 @@ func expectQuery(ctx context.Context, t *testing.T, drv dialect.Driver, query st
 		t.Fatal(err)
 	}
 }
+
+// labelDriver records the pprof labels and the
+// in-flight queries of the driver on query.
+type labelDriver struct {
+	dialect.Driver
+	drv      *entcache.Driver
+	labels   map[string]string
+	inFlight int64
+}
+
+func (d *labelDriver) Query(ctx context.Context, query string, args, v any) error {
+	d.labels = make(map[string]string)
+	pprof.ForLabels(ctx, func(k, v string) bool {
+		d.labels[k] = v
+		return true
+	})
+	d.inFlight = d.drv.DBStats().InFlight
+	return d.Driver.Query(ctx, query, args, v)
+}
+
+func TestDriver_ProfileLabels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld := &labelDriver{Driver: sql.OpenDB(dialect.MySQL, db)}
+	ld.drv = entcache.NewDriver(ld, entcache.ProfileLabels())
+	query := "SELECT `users`.`name` FROM `users` JOIN `pets` ON `users`.`id` = `pets`.`owner_id`"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(context.Background(), t, ld.drv, query, []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if ld.labels["entcache.fingerprint"] != entcache.Fingerprint(query) || ld.labels["entcache.tables"] != "users,pets" {
+		t.Fatalf("unexpected labels: %v", ld.labels)
+	}
+	if ld.inFlight != 1 {
+		t.Fatalf("unexpected in-flight queries during query: %d != 1", ld.inFlight)
+	}
+	if s := ld.drv.DBStats(); s.InFlight != 0 || s.Queries != 1 {
+		t.Fatalf("unexpected database stats: %+v", s)
+	}
+}
+
+func TestDriver_Debug(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Debug(),
+		entcache.Levels(
+			entcache.NewLRU(-1),
+			entcache.NewLRU(0),
+		),
+		entcache.Hash(func(q string, _ []interface{}) (entcache.Key, error) {
+			return q, nil
+		}),
+	)
+	drv.Log = func(v ...any) { logs = append(logs, fmt.Sprint(v...)) }
+	mock.ExpectQuery("SELECT name FROM users").
+		WillDelayFor(time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := entcache.WithTrace(context.Background())
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Skip(ctx), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	decisions := entcache.TraceFromContext(ctx)
+	if len(decisions) != 3 {
+		t.Fatalf("unexpected decisions: %v", decisions)
+	}
+	if d := decisions[0]; !d.Select || d.Hit || d.Skipped || d.Key != "SELECT name FROM users" || d.DB < time.Millisecond {
+		t.Errorf("unexpected miss decision: %+v", d)
+	}
+	if d := decisions[1]; !d.Hit || d.Level != 1 || d.DB != 0 {
+		t.Errorf("unexpected hit decision: %+v", d)
+	}
+	if s := drv.DBStats(); s.Queries != 1 || s.Time < time.Millisecond {
+		t.Errorf("unexpected database stats: %d queries in %s", s.Queries, s.Time)
+	}
+	if d := decisions[2]; !d.Skipped || d.Skip != entcache.SkipOption {
+		t.Errorf("unexpected skip decision: %+v", d)
+	}
+	if len(logs) != 3 || logs[1] != `entcache: "SELECT name FROM users" hit key SELECT name FROM users at level 1` {
+		t.Errorf("unexpected logs: %q", logs)
+	}
+}
+
+func TestDriver_LatencyBudget(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		rdb, rmock = redismock.NewClientMock()
+		drv        = entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(
+				entcache.NewLRU(-1),
+				entcache.LatencyBudget(entcache.NewRedis(rdb), time.Minute),
+			),
+			entcache.Hash(func(string, []interface{}) (entcache.Key, error) {
+				return 1, nil
+			}),
+		)
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// Redis level was not used.
+	if err := rmock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	rmock.ExpectGet("1").RedisNil()
+	buf, _ := entcache.Entry{Values: [][]driver.Value{{"a8m"}}}.MarshalBinary()
+	rmock.ExpectSet("1", buf, 0).RedisNil()
+	expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := rmock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_Hedge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(&slowLevel{AddGetDeleter: entcache.NewLRU(0), delay: 100 * time.Millisecond}),
+		entcache.Hedge(time.Millisecond),
+	)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// Entry was stored by the database result, but hedging is disabled for this query.
+	expectQuery(entcache.WithHedge(context.Background(), -1), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	expected := entcache.Stats{Gets: 2, Hits: 1, Hedges: 1}
+	if s := drv.Stats(); s != expected {
+		t.Errorf("unexpected stats: %v != %v", s, expected)
+	}
+}
+
+func TestDriver_HedgeMiss(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &countingLevel{AddGetDeleter: entcache.NewLRU(0)}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(&slowLevel{AddGetDeleter: cache, delay: 100 * time.Millisecond}),
+		entcache.Hedge(time.Millisecond),
+		entcache.GuardStampede(entcache.StampedeGuard{Misses: 1, Window: time.Minute}),
+	)
+	// Database wins, and its result is handled as a miss, i.e. the
+	// stampede guard inserts a placeholder before the entry is recorded.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if n := atomic.LoadInt32(&cache.adds); n != 2 {
+		t.Fatalf("unexpected number of adds: %d != 2", n)
+	}
+	expectQuery(entcache.WithHedge(context.Background(), -1), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cache wins, and the database query is canceled.
+	canceled := make(chan struct{})
+	drv = entcache.NewDriver(
+		&blockingDriver{Driver: sql.OpenDB(dialect.MySQL, db), canceled: canceled},
+		entcache.Levels(&slowLevel{AddGetDeleter: cache, delay: 10 * time.Millisecond}),
+		entcache.Hedge(time.Millisecond),
+	)
+	expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the database query to be canceled")
+	}
+}
+
+// blockingDriver blocks queries until their context is canceled.
+type blockingDriver struct {
+	dialect.Driver
+	canceled chan struct{}
+}
+
+func (d *blockingDriver) Query(ctx context.Context, _ string, _, _ any) error {
+	<-ctx.Done()
+	close(d.canceled)
+	return ctx.Err()
+}
+
+// slowLevel delays the lookups of the underlying level.
+type slowLevel struct {
+	entcache.AddGetDeleter
+	delay time.Duration
+}
+
+func (s *slowLevel) Get(ctx context.Context, k entcache.Key) (*entcache.Entry, error) {
+	select {
+	case <-time.After(s.delay):
+		return s.AddGetDeleter.Get(ctx, k)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDriver_HotKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.HotKeys(2))
+	for _, q := range []string{"SELECT name FROM users", "SELECT age FROM users", "SELECT id FROM users"} {
+		mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"v"}).AddRow(1))
+	}
+	ctx := context.Background()
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT age FROM users", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	keys := drv.HotKeys()
+	if len(keys) != 2 || keys[0].Query != "SELECT name FROM users" || keys[0].Hits != 3 || keys[1].Query != "SELECT id FROM users" {
+		t.Fatalf("unexpected hot keys: %v", keys)
+	}
+	var buf bytes.Buffer
+	if err := drv.ExportHotKeys(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	drv = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.ExpectQuery("SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"v"}).AddRow(1))
+	mock.ExpectQuery("SELECT id FROM users").WillReturnRows(sqlmock.NewRows([]string{"v"}).AddRow(1))
+	if err := drv.WarmFromExport(ctx, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+	expected := entcache.Stats{Gets: 4, Hits: 2}
+	if s := drv.Stats(); s != expected {
+		t.Errorf("unexpected stats: %v != %v", s, expected)
+	}
+}
+
+func TestDriver_RegisterRefresher(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Hash(func(string, []interface{}) (entcache.Key, error) {
+			return "count", nil
+		}),
+	)
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	stop := drv.RegisterRefresher("SELECT COUNT(*) FROM users", []any{}, 10*time.Millisecond)
+	for i := 0; ; i++ {
+		if e, err := drv.Cache.Get(context.Background(), "count"); err == nil && e.Values[0][0] == int64(2) {
+			break
+		}
+		if i == 100 {
+			t.Fatal("refresher was not executed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	expectQuery(context.Background(), t, drv, "SELECT COUNT(*) FROM users", []interface{}{int64(2)})
+	expected := entcache.Stats{Gets: 1, Hits: 1}
+	if s := drv.Stats(); s != expected {
+		t.Errorf("unexpected stats: %v != %v", s, expected)
+	}
+}
+
+func TestDriver_RegisterRefresherInterval(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	// Non-positive intervals are clamped, instead of
+	// re-executing the query in a tight loop.
+	stop := drv.RegisterRefresher("SELECT COUNT(*) FROM users", []any{}, 0)
+	time.Sleep(3 * time.Millisecond)
+	stop()
+	if n := drv.DBStats().Queries; n != 0 {
+		t.Fatalf("unexpected refreshes: %d", n)
+	}
+}
+
+func TestDriver_RefreshTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Hash(func(q string, _ []interface{}) (entcache.Key, error) {
+			return q, nil
+		}),
+	)
+	const query = "SELECT COUNT(*) FROM `todos`"
+	stop := drv.RegisterRefresher(query, []any{}, time.Hour)
+	defer stop()
+	stop2 := drv.RegisterRefresher("SELECT COUNT(*) FROM `users`", []any{}, time.Hour)
+	defer stop2()
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	drv.RefreshTables("todos", "groups")
+	for i := 0; ; i++ {
+		if _, err := drv.Cache.Get(context.Background(), query); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("refresher was not triggered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_Batch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	job := entcache.BatchJob(func(ctx context.Context) error {
+		// Served from the driver cache.
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		// Stored in the job cache.
+		expectQuery(ctx, t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+		expectQuery(ctx, t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+		return nil
+	})
+	if err := job(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Not stored in the driver cache.
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectQuery(context.Background(), t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_ConcurrentOptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	// Simulate eager-loading of multiple edges that
+	// is executed concurrently using the same context.
+	ctx := entcache.WithTTL(context.Background(), time.Minute)
+	edges := []string{"SELECT id FROM todos", "SELECT id FROM groups", "SELECT id FROM pets", "SELECT id FROM cars"}
+	for _, q := range edges {
+		mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	}
+	var wg sync.WaitGroup
+	for i, q := range edges {
+		wg.Add(2)
+		go func(i int, q string) {
+			defer wg.Done()
+			expectQuery(entcache.WithKey(ctx, i), t, drv, q, []interface{}{int64(1)})
+		}(i, q)
+		go func(q string) {
+			defer wg.Done()
+			expectQuery(entcache.Skip(ctx), t, drv, q, []interface{}{int64(1)})
+		}(q)
+	}
+	wg.Wait()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// The shared context was not affected by its derived contexts.
+	for i, q := range edges {
+		expectQuery(entcache.WithKey(ctx, i), t, drv, q, []interface{}{int64(1)})
+	}
+	expected := entcache.Stats{Gets: 8, Hits: 4}
+	expected.Skips[entcache.SkipOption] = 4
+	if s := drv.Stats(); s != expected {
+		t.Errorf("unexpected stats: %v != %v", s, expected)
+	}
+}
+
+func TestDriver_EvictTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	ctx := context.Background()
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectQuery(entcache.WithTags(ctx, "users"), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+	if err := drv.EvictTags(ctx, "users"); err != nil {
+		t.Fatal(err)
+	}
+	// Only the tagged entry was evicted.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_EvictTagsLevels(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		arc    = entcache.NewARC(8)
+		remote = &countingLevel{AddGetDeleter: entcache.NewLRU(0)}
+		drv    = entcache.NewDriver(nil, entcache.Levels(remote, arc, entcache.NewLRU(0)))
+		e      = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	)
+	if err := drv.Cache.Add(ctx, "k", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	// All levels are evicted, even if one of them fails. Levels that do not support
+	// tags are cleared, or fail if they cannot be cleared (e.g. remote levels).
+	err := drv.EvictTags(ctx, "users")
+	if err == nil || !strings.Contains(err.Error(), "level 0: entcache: cache level *entcache_test.countingLevel does not support evicting tags") {
+		t.Fatalf("expected eviction of level 0 to fail: %v", err)
+	}
+	if _, err := arc.Get(ctx, "k"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected ARC level to be cleared: %v", err)
+	}
+}
+
+func TestDriver_CloseTwice(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := context.Background()
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	rows := &sql.Rows{}
+	if err := drv.Query(ctx, "SELECT name FROM users", []interface{}{}, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closed rows are detached from the pooled repeater.
+	other := &sql.Rows{}
+	if err := drv.Query(ctx, "SELECT name FROM users", []interface{}{}, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Fatal("expected closed rows to be empty")
+	}
+	if !other.Next() {
+		t.Fatal("expected rows to be unaffected by closing other rows")
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkDriverHit(b *testing.B) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var key entcache.Key = uint64(1 << 32)
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Hash(func(string, []any) (entcache.Key, error) {
+			return key, nil
+		}),
+	)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := context.Background()
+	args := any([]any{})
+	rows := &sql.Rows{}
+	if err := drv.Query(ctx, "SELECT name FROM users", args, rows); err != nil {
+		b.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		b.Fatal(err)
+	}
+	var name string
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := drv.Query(ctx, "SELECT name FROM users", args, rows); err != nil {
+			b.Fatal(err)
+		}
+		for rows.Next() {
+			if err := rows.Scan(&name); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDriver_Scope(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ContextLevel())
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	mock.ExpectQuery("SELECT id FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	ctx := entcache.NewContext(context.Background())
+	scope := entcache.NewScope(ctx, "todos")
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(scope, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	if err := entcache.DropScope(ctx, "todos"); err != nil {
+		t.Fatal(err)
+	}
+	// Only the scope entries were dropped.
+	mock.ExpectQuery("SELECT id FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(2)})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_ContextMutation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ContextLevel())
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	mock.ExpectQuery("SELECT id FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	ctx := entcache.NewContext(context.Background())
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+
+	mock.ExpectExec("UPDATE users SET name = ?").
+		WithArgs("Ariel").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := drv.Exec(ctx, "UPDATE users SET name = ?", []interface{}{"Ariel"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Entries of the modified table were evicted.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+
+	// Statements with unknown tables clear the entire context cache.
+	mock.ExpectExec("CALL cleanup()").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := drv.Exec(ctx, "CALL cleanup()", []interface{}{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT id FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_EvictLevels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ctx    = context.Background()
+		lru    = entcache.NewLRU(0)
+		remote = &failingLevel{AddGetDeleter: entcache.NewLRU(0), n: 2}
+		drv    = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(lru, remote))
+	)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// Remote level succeeds on the third attempt.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Evict(ctx), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if s := drv.Stats(); s.EvictErrors != 0 {
+		t.Fatalf("unexpected eviction errors: %d", s.EvictErrors)
+	}
+
+	// Remote level keeps failing, but the entry is still evicted from the LRU.
+	atomic.StoreInt32(&remote.n, 3)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Evict(ctx), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if s := drv.Stats(); s.EvictErrors != 1 || s.Skips[entcache.SkipCacheError] != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	key, _ := entcache.DefaultHash("SELECT name FROM users", []interface{}{})
+	if _, err := lru.Get(ctx, key); err != entcache.ErrNotFound {
+		t.Fatalf("expected entry to be evicted from the first level, got: %v", err)
+	}
+
+	// Levels that report missing keys on Del are not considered failing.
+	drv = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(lru, missingLevel{lru}))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Evict(ctx), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if s := drv.Stats(); s.EvictErrors != 0 || s.Skips[entcache.SkipCacheError] != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_AddLevels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	t.Run("BestEffort", func(t *testing.T) {
+		remote := &failingLevel{AddGetDeleter: entcache.NewLRU(0), add: true}
+		drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(entcache.NewLRU(0), remote))
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		// Served from the first level.
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if s := drv.LevelStats(); len(s) != 2 || s[0].AddErrors != 0 || s[1].AddErrors != 1 {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+	})
+	t.Run("Required", func(t *testing.T) {
+		var logs []string
+		remote := &failingLevel{AddGetDeleter: entcache.NewLRU(0), add: true}
+		drv := entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(entcache.NewLRU(0), entcache.Required(remote)),
+		)
+		drv.Log = func(v ...any) { logs = append(logs, fmt.Sprint(v...)) }
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if s := drv.Stats(); s.Errors != 1 || len(logs) != 1 {
+			t.Fatalf("expected failure of required level to be reported: %v %v", s, logs)
+		}
+		if s := drv.LevelStats(); s[1].AddErrors != 1 {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+	})
+	t.Run("Tolerant", func(t *testing.T) {
+		var logs []string
+		remote := &failingLevel{AddGetDeleter: entcache.NewLRU(0), add: true, get: true}
+		drv := entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(entcache.NewLRU(0), remote),
+			entcache.TolerateLevelErrors(true),
+		)
+		drv.Log = func(v ...any) { logs = append(logs, fmt.Sprint(v...)) }
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		// Served from the first level, although the second one is failing.
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if s := drv.Stats(); s.Hits != 1 || s.Skips[entcache.SkipCacheError] != 0 {
+			t.Fatalf("unexpected stats: %+v", s)
+		}
+		if s := drv.LevelStats(); s[1].GetErrors != 1 || s[1].AddErrors != 1 {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+		if len(logs) != 2 || !strings.Contains(logs[0], "skipping failed cache level 1") {
+			t.Fatalf("expected failures to be logged: %q", logs)
+		}
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type stats struct {
+		Users int
+		Names []string
+	}
+	var (
+		calls int
+		ctx   = context.Background()
+		drv   = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+		fn    = func(context.Context) (*stats, error) {
+			calls++
+			return &stats{Users: calls, Names: []string{"a8m"}}, nil
+		}
+	)
+	for i := 0; i < 2; i++ {
+		v, err := entcache.Query(ctx, drv, "stats", time.Minute, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Users != 1 || len(v.Names) != 1 || v.Names[0] != "a8m" {
+			t.Fatalf("unexpected value: %+v", v)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected value to be computed once, got: %d", calls)
+	}
+	if s := drv.Stats(); s.Gets != 2 || s.Hits != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	// Values of other types are stored under the same key namespace.
+	n, err := entcache.Query(ctx, drv, "count", 0, func(context.Context) (int, error) { return 42, nil })
+	if err != nil || n != 42 {
+		t.Fatalf("unexpected value: %d, %v", n, err)
+	}
+	v, err := entcache.Query(entcache.Evict(ctx), drv, "stats", time.Minute, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Users != 2 {
+		t.Fatalf("expected evicted value to be recomputed: %+v", v)
+	}
+	// Errors are not cached.
+	_, err = entcache.Query(ctx, drv, "failing", 0, func(context.Context) (int, error) { return 0, errors.New("failed") })
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if n, err = entcache.Query(ctx, drv, "failing", 0, func(context.Context) (int, error) { return 1, nil }); err != nil || n != 1 {
+		t.Fatalf("unexpected value: %d, %v", n, err)
+	}
+}
+
+func TestQuery_Keys(t *testing.T) {
+	ctx := context.Background()
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	type dbKey struct{}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(entcache.NewRedis(rdb)), entcache.DatabaseKeyFunc(func(ctx context.Context) string {
+		name, _ := ctx.Value(dbKey{}).(string)
+		return name
+	}))
+	// Values are scoped by their database, and keys are marshaled like the keys of queries.
+	key := entcache.GenKey{Key: "stats", Gen: 1}
+	for _, name := range []string{"shard-1", "shard-2"} {
+		v, err := entcache.Query(context.WithValue(ctx, dbKey{}, name), drv, key, time.Minute, func(context.Context) (string, error) { return name, nil })
+		if err != nil || v != name {
+			t.Fatalf("unexpected value: %q, %v", v, err)
+		}
+	}
+	if keys := m.Keys(); len(keys) != 2 || keys[0] != "shard-1:entcache:value:stats:g1" || keys[1] != "shard-2:entcache:value:stats:g1" {
+		t.Fatalf("unexpected keys: %q", keys)
+	}
+}
+
+func TestDriver_WithKeyCollision(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	drv.Log = func(v ...any) { logs = append(logs, fmt.Sprint(v...)) }
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	mock.ExpectQuery("SELECT id FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	// Two statements of the same operation.
+	ctx := entcache.WithKey(context.Background(), "users")
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	if len(logs) != 1 {
+		t.Fatalf("expected key collision to be logged: %v", logs)
+	}
+	// Both were cached, and none overrode the other.
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	expectQuery(entcache.WithKey(context.Background(), "users"), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_WithName(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.ExpectQuery("SELECT id FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := context.Background()
+	named := entcache.WithName(ctx, "dashboard.todos")
+	expectQuery(named, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	expectQuery(named, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	stats := drv.NameStats()
+	if len(stats) != 1 || stats["dashboard.todos"] != (entcache.Stats{Gets: 2, Hits: 1}) {
+		t.Fatalf("unexpected name stats: %v", stats)
+	}
+	if err := drv.EvictName(ctx, "dashboard.todos"); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT id FROM todos").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectQuery(named, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_EvictWrites(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, w := range []entcache.WriteEviction{entcache.EvictAfterWrite, entcache.EvictBeforeWrite, entcache.EvictBeforeWrite | entcache.EvictAfterWrite} {
+		drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.EvictWrites(w))
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		mock.ExpectQuery("SELECT id FROM todos").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+		mock.ExpectExec("DELETE FROM `users`").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := drv.Exec(ctx, "DELETE FROM `users` WHERE `id` = ?", []interface{}{1}, nil); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", nil)
+		expectQuery(ctx, t, drv, "SELECT id FROM todos", []interface{}{int64(1)})
+	}
+
+	// Entries are evicted before the write, even if it failed.
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.EvictWrites(entcache.EvictBeforeWrite))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectExec("UPDATE `users`").
+		WillReturnError(errors.New("lock wait timeout"))
+	if err := drv.Exec(ctx, "UPDATE `users` SET `name` = ?", []interface{}{"Ariel"}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_Tx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.EvictWrites(entcache.EvictAfterWrite))
+	mock.ExpectBegin()
+	tx, err := drv.Tx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, tx, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, tx, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Skip(ctx), t, tx, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Evict(ctx), t, tx, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, tx, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectExec("UPDATE `users`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := tx.Exec(ctx, "UPDATE `users` SET `name` = ?", []interface{}{"Ariel"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Reads after a write in the transaction bypass the cache.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	expectQuery(ctx, t, tx, "SELECT name FROM users", []interface{}{"Ariel"})
+	// Not committed yet.
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectCommit()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if n := drv.Stats().Skips[entcache.SkipTxWrite]; n != 1 {
+		t.Fatalf("unexpected %s skips: %d != 1", entcache.SkipTxWrite, n)
+	}
+}
+
+func TestDriver_TxContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.ExpectBegin()
+	tx, err := drv.Tx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ent code that uses the methods of the database/sql transaction.
+	etx, ok := tx.(interface {
+		ExecContext(context.Context, string, ...any) (stdsql.Result, error)
+		QueryContext(context.Context, string, ...any) (*stdsql.Rows, error)
+	})
+	if !ok {
+		t.Fatalf("expected %T to implement ExecContext and QueryContext", tx)
+	}
+	mock.ExpectExec("UPDATE `users`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := etx.ExecContext(ctx, "UPDATE `users` SET `name` = ?", "Ariel"); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	rows, err := etx.QueryContext(ctx, "SELECT name FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if !rows.Next() || rows.Scan(&name) != nil || name != "Ariel" {
+		t.Fatalf("unexpected rows: %q", name)
+	}
+	rows.Close()
+	mock.ExpectCommit()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_ReadYourWrites(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ReadYourWrites(time.Minute))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	mock.ExpectQuery("SELECT name FROM pets").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("pedro"))
+	expectQuery(ctx, t, drv, "SELECT name FROM pets", []interface{}{"pedro"})
+
+	a8m, nati := entcache.Session(ctx, "a8m"), entcache.Session(ctx, "nati")
+	mock.ExpectExec("UPDATE `users`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := drv.Exec(a8m, "UPDATE `users` SET `name` = ?", []interface{}{"Ariel"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The writer session reads its own writes.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	expectQuery(a8m, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	// Tables that were not modified by the session are served from the cache.
+	expectQuery(a8m, t, drv, "SELECT name FROM pets", []interface{}{"pedro"})
+	// Other sessions are not affected.
+	expectQuery(nati, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+
+	// Writes of transactions are recorded on commit.
+	mock.ExpectBegin()
+	tx, err := drv.Tx(nati)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectExec("DELETE FROM `pets`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := tx.Exec(nati, "DELETE FROM `pets`", []interface{}{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectQuery(nati, t, drv, "SELECT name FROM pets", []interface{}{"pedro"})
+	mock.ExpectCommit()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT name FROM pets").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	expectQuery(nati, t, drv, "SELECT name FROM pets", nil)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if n := drv.Stats().Skips[entcache.SkipSessionWrite]; n != 2 {
+		t.Fatalf("unexpected %s skips: %d != 2", entcache.SkipSessionWrite, n)
+	}
+}
+
+func TestDriver_Policies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ctx      = context.Background()
+		disabled atomic.Value
+		infos    []entcache.QueryInfo
+	)
+	disabled.Store("")
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Policies(entcache.PolicyFunc(func(_ context.Context, q entcache.QueryInfo) (entcache.Policy, bool) {
+			infos = append(infos, q)
+			for _, t := range q.Tables {
+				if t == disabled.Load().(string) {
+					return entcache.Policy{Disable: true}, true
+				}
+			}
+			if len(q.Tables) == 1 && q.Tables[0] == "pets" {
+				return entcache.Policy{TTL: time.Millisecond}, true
+			}
+			return entcache.Policy{}, false
+		})),
+	)
+	mock.ExpectQuery("SELECT `name` FROM `users`").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT `name` FROM `users`", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT `name` FROM `users`", []interface{}{"a8m"})
+	if len(infos) != 2 || infos[0].Fingerprint != entcache.Fingerprint("SELECT `name` FROM `users`") || len(infos[0].Tables) != 1 || infos[0].Tables[0] != "users" {
+		t.Fatalf("unexpected query info: %+v", infos)
+	}
+
+	// Policies are changed in real time.
+	disabled.Store("users")
+	mock.ExpectQuery("SELECT `name` FROM `users`").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	expectQuery(ctx, t, drv, "SELECT `name` FROM `users`", []interface{}{"Ariel"})
+	disabled.Store("")
+	expectQuery(ctx, t, drv, "SELECT `name` FROM `users`", []interface{}{"a8m"})
+
+	// The policy TTL overrides the driver TTL.
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT `name` FROM `pets`").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("pedro"))
+		expectQuery(ctx, t, drv, "SELECT `name` FROM `pets`", []interface{}{"pedro"})
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if n := drv.Stats().Skips[entcache.SkipPolicy]; n != 1 {
+		t.Fatalf("unexpected %s skips: %d != 1", entcache.SkipPolicy, n)
+	}
+}
+
+type (
+	// coord is a custom type that is not registered with gob.
+	coord struct{ X, Y int }
+	// passConverter passes custom types to the driver as is.
+	passConverter struct{}
+)
+
+func (passConverter) ConvertValue(v any) (driver.Value, error) { return v, nil }
+
+func TestDriver_EncodeErrors(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	drv.Log = func(v ...any) { logs = append(logs, fmt.Sprint(v...)) }
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT location FROM users").
+			WillReturnRows(mock.NewRows([]string{"location"}).AddRow(coord{1, 2}))
+		rows := &sql.Rows{}
+		if err := drv.Query(context.Background(), "SELECT location FROM users", []any{}, rows); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rows.Columns(); err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var v any
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.EncodeErrors != 2 || s.Errors != 0 {
+		t.Fatalf("unexpected stats: encode errors=%d errors=%d", s.EncodeErrors, s.Errors)
+	}
+	if len(logs) != 2 || !strings.Contains(logs[0], `value type entcache_test.coord in column "location" of query: SELECT location FROM users`) {
+		t.Fatalf("unexpected logs: %q", logs)
+	}
+}
+
+func TestDriver_Provenance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := entcache.WithKey(context.Background(), "users")
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.RecordProvenance("node-1"))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	start := time.Now()
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	stats := drv.Stats()
+	e, err := drv.Peek(ctx, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := e.Provenance
+	if p == nil || p.Node != "node-1" || p.Query != "SELECT name FROM users" || p.Time.Before(start) {
+		t.Fatalf("unexpected provenance: %+v", p)
+	}
+	if drv.Stats() != stats {
+		t.Fatal("expected peek to not affect stats")
+	}
+	// Provenance is not recorded by default.
+	drv = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if e, err := drv.Peek(ctx, "users"); err != nil || e.Provenance != nil {
+		t.Fatalf("unexpected provenance: %v, %v", e, err)
+	}
+}
+
+func TestDriver_DatabaseKey(t *testing.T) {
+	ctx := context.Background()
+	cache := entcache.NewLRU(0)
+	for _, name := range []string{"shard-1", "shard-2"} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(cache), entcache.DatabaseKey(name))
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(name))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{name})
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{name})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("expected an entry per database, got: %d", n)
+	}
+
+	type dbKey struct{}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(cache), entcache.DatabaseKeyFunc(func(ctx context.Context) string {
+		name, _ := ctx.Value(dbKey{}).(string)
+		return name
+	}))
+	expectQuery(context.WithValue(ctx, dbKey{}, "shard-1"), t, drv, "SELECT name FROM users", []interface{}{"shard-1"})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("default"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"default"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := entcache.MarshalKey(entcache.DBKey{DB: "shard-1", Key: uint64(1)}); err != nil || key != "shard-1:1" {
+		t.Fatalf("unexpected key: %q, %v", key, err)
+	}
+}
+
+func TestDriver_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	for name, inv := range map[string]entcache.Invalidator{
+		"Tables":      entcache.InvalidateTables(),
+		"Generations": entcache.InvalidateGenerations(),
+		"Tags": entcache.InvalidateTags(func(query string) []string {
+			if strings.Contains(query, "users") {
+				return []string{"users"}
+			}
+			return nil
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Invalidate(inv))
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+			mock.ExpectQuery("SELECT name FROM pets").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("pedro"))
+			expectQuery(ctx, t, drv, "SELECT name FROM pets", []interface{}{"pedro"})
+
+			mock.ExpectExec("UPDATE `users`").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			if err := drv.Exec(ctx, "UPDATE `users` SET `name` = ?", []interface{}{"Ariel"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+			expectQuery(ctx, t, drv, "SELECT name FROM pets", []interface{}{"pedro"})
+
+			// Writes of transactions are invalidated on commit.
+			mock.ExpectBegin()
+			tx, err := drv.Tx(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mock.ExpectExec("UPDATE `users`").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			if err := tx.Exec(ctx, "UPDATE `users` SET `name` = ?", []interface{}{"Ariel Mashraki"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+			mock.ExpectCommit()
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel Mashraki"))
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel Mashraki"})
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDriver_Limits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Limits(1, 4))
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m").AddRow("nati"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m", "nati"})
+		mock.ExpectQuery("SELECT name FROM groups").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("ariga"))
+		expectQuery(ctx, t, drv, "SELECT name FROM groups", []interface{}{"ariga"})
+	}
+	mock.ExpectQuery("SELECT name FROM pets").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("pedro"))
+	expectQuery(ctx, t, drv, "SELECT name FROM pets", []interface{}{"pedro"})
+	if s := drv.Stats(); s.RowsLimited != 2 || s.BytesLimited != 3 {
+		t.Fatalf("unexpected limited stats: rows=%d bytes=%d", s.RowsLimited, s.BytesLimited)
+	}
+	// Limits can be overridden by the context.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m").AddRow("nati"))
+	expectQuery(entcache.WithLimits(ctx, -1, 16), t, drv, "SELECT name FROM users", []interface{}{"a8m", "nati"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m", "nati"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_Prefetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Prefetch(2), entcache.Hash(func(q string, _ []interface{}) (entcache.Key, error) {
+		return q, nil
+	}))
+	mock.ExpectQuery("SELECT id FROM users LIMIT 2 OFFSET 0").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery("SELECT id FROM users LIMIT 2 OFFSET 2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4))
+	mock.ExpectQuery("SELECT id FROM users LIMIT 2 OFFSET 4").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	expectQuery(ctx, t, drv, "SELECT id FROM users LIMIT 2 OFFSET 0", []interface{}{int64(1), int64(2)})
+	// Pages are prefetched in order.
+	for i := 0; ; i++ {
+		if _, err := drv.Cache.Get(ctx, "SELECT id FROM users LIMIT 2 OFFSET 4"); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected next pages to be prefetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectQuery(ctx, t, drv, "SELECT id FROM users LIMIT 2 OFFSET 2", []interface{}{int64(3), int64(4)})
+	expectQuery(ctx, t, drv, "SELECT id FROM users LIMIT 2 OFFSET 4", []interface{}{int64(5)})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_WithCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	lru := entcache.NewLRU(0)
+	ctx := entcache.WithCache(context.Background(), lru)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if lru.Len() != 1 || drv.Cache.(*entcache.LRU).Len() != 0 {
+		t.Fatal("expected entry to be stored only in the context cache level")
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_Snapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	ctx := context.Background()
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// The first read of the request pins the shared entry.
+	req := entcache.Snapshot(ctx)
+	expectQuery(req, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// Driver cache is refreshed in the middle of the request.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ariel"))
+	expectQuery(entcache.Refresh(ctx), t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	expectQuery(req, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// Other requests see the refreshed entry.
+	expectQuery(entcache.Snapshot(ctx), t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"Ariel"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_GuardStampede(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &countingLevel{AddGetDeleter: entcache.NewLRU(0)}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(cache),
+		entcache.GuardStampede(entcache.StampedeGuard{Misses: 2, Window: time.Minute}),
+	)
+	// Simulate a stampede by executing the query
+	// before any of its results was recorded.
+	rows := make([]*sql.Rows, 4)
+	for i := range rows {
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		rows[i] = &sql.Rows{}
+		if err := drv.Query(context.Background(), "SELECT name FROM users", []interface{}{}, rows[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range rows {
+		for r.Next() {
+			var name string
+			if err := r.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 1 entry that was recorded by the first caller, and 1 placeholder
+	// that was replaced by the entry of the caller that inserted it.
+	if n := atomic.LoadInt32(&cache.adds); n != 3 {
+		t.Fatalf("unexpected number of adds: %d != 3", n)
+	}
+	if n := drv.Stats().Skips[entcache.SkipInFlight]; n != 2 {
+		t.Fatalf("unexpected %s skips: %d != 2", entcache.SkipInFlight, n)
+	}
+	// The recorded entry replaced the placeholder.
+	expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_GuardStampedeConcurrent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	cache := &countingLevel{AddGetDeleter: entcache.NewLRU(0)}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(cache),
+		entcache.GuardStampede(entcache.StampedeGuard{Misses: 1, Window: time.Minute}),
+	)
+	const n = 8
+	for i := 0; i < n; i++ {
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	}
+	// All callers miss the cache together, and close their
+	// rows only after all queries were sent to the database.
+	var (
+		wg   sync.WaitGroup
+		rows = make([]*sql.Rows, n)
+		errs = make([]error, n)
+	)
+	for i := range rows {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rows[i] = &sql.Rows{}
+			errs[i] = drv.Query(context.Background(), "SELECT name FROM users", []interface{}{}, rows[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, r := range rows {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		for r.Next() {
+			var name string
+			if err := r.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 1 placeholder, and 1 entry that was recorded by the caller that inserted it.
+	if n := atomic.LoadInt32(&cache.adds); n != 2 {
+		t.Fatalf("unexpected number of adds: %d != 2", n)
+	}
+	if s := drv.Stats().Skips[entcache.SkipInFlight]; s != n-1 {
+		t.Fatalf("unexpected %s skips: %d != %d", entcache.SkipInFlight, s, n-1)
+	}
+	// Later calls are served from the cache.
+	for i := 0; i < 3; i++ {
+		expectQuery(context.Background(), t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	}
+	if s := drv.Stats(); s.Hits != 3 {
+		t.Fatalf("unexpected hits: %d != 3", s.Hits)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTestHash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb, rmock := redismock.NewClientMock()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(entcache.NewRedis(rdb)), entcache.TestHash())
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	rmock.ExpectGet("users:SELECT id FROM users:[]").RedisNil()
+	buf, _ := entcache.Entry{Values: [][]driver.Value{{int64(1)}}}.MarshalBinary()
+	rmock.ExpectSet("users:SELECT id FROM users:[]", buf, 0).SetVal("OK")
+	expectQuery(context.Background(), t, drv, "SELECT id FROM users", []interface{}{int64(1)})
+	if err := rmock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := drv.Hash("SELECT `users`.`name` FROM `users` JOIN `groups` ON `users`.`gid` = `groups`.`id` WHERE `users`.`id` = ?", []any{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "users,groups:SELECT `users`.`name` FROM `users` JOIN `groups` ON `users`.`gid` = `groups`.`id` WHERE `users`.`id` = ?:[1]"; key != expected {
+		t.Fatalf("unexpected key: %v != %v", key, expected)
+	}
+}
+
+func TestDefaultHash(t *testing.T) {
+	now := time.Now()
+	for _, args := range [][]any{
+		{1, "a8m", true, 1.5, []byte("a8m"), now, nil},
+		{struct{ ID int }{ID: 1}},
+	} {
+		k1, err := entcache.DefaultHash("SELECT * FROM users WHERE id = ?", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, err := entcache.DefaultHash("SELECT * FROM users WHERE id = ?", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k1 != k2 {
+			t.Fatalf("expected stable keys: %v != %v", k1, k2)
+		}
+	}
+	k1, _ := entcache.DefaultHash("SELECT * FROM users WHERE id = ?", []any{1})
+	k2, _ := entcache.DefaultHash("SELECT * FROM users WHERE id = ?", []any{"1"})
+	k3, _ := entcache.DefaultHash("SELECT * FROM users WHERE id = ?", []any{2})
+	if k1 == k2 || k1 == k3 {
+		t.Fatal("expected different keys for different arguments")
+	}
+	k1, _ = entcache.DefaultHash("SELECT * FROM users WHERE name IN (?, ?)", []any{"ab", "c"})
+	k2, _ = entcache.DefaultHash("SELECT * FROM users WHERE name IN (?, ?)", []any{"a", "bc"})
+	if k1 == k2 {
+		t.Fatal("expected different keys for different arguments")
+	}
+	// Times outside the range of UnixNano.
+	k1, _ = entcache.DefaultHash("SELECT * FROM users WHERE created_at > ?", []any{time.Time{}})
+	k2, _ = entcache.DefaultHash("SELECT * FROM users WHERE created_at > ?", []any{time.Date(1, 1, 1, 0, 0, 1, 0, time.UTC)})
+	k3, _ = entcache.DefaultHash("SELECT * FROM users WHERE created_at > ?", []any{time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if k1 == k2 || k1 == k3 || k2 == k3 {
+		t.Fatal("expected different keys for different times")
+	}
+}
+
+func BenchmarkDefaultHash(b *testing.B) {
+	args := []any{1, "a8m", time.Now()}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := entcache.DefaultHash("SELECT * FROM users WHERE id = ? AND name = ? AND created_at < ?", args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// failingLevel is a cache level that its Del fails n times,
+// its Add fails if add is set, and its Get fails if get is set.
+type failingLevel struct {
+	entcache.AddGetDeleter
+	n        int32
+	add, get bool
+}
+
+func (l *failingLevel) Get(ctx context.Context, k entcache.Key) (*entcache.Entry, error) {
+	if l.get {
+		return nil, errors.New("connection refused")
+	}
+	return l.AddGetDeleter.Get(ctx, k)
+}
+
+func (l *failingLevel) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	if l.add {
+		return errors.New("connection refused")
+	}
+	return l.AddGetDeleter.Add(ctx, k, e, ttl)
+}
+
+func (l *failingLevel) Del(ctx context.Context, k entcache.Key) error {
+	if atomic.AddInt32(&l.n, -1) >= 0 {
+		return errors.New("connection refused")
+	}
+	return l.AddGetDeleter.Del(ctx, k)
+}
+
+// countingLevel is a cache level that counts its Add calls.
+type countingLevel struct {
+	entcache.AddGetDeleter
+	adds int32
+}
+
+func (l *countingLevel) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	atomic.AddInt32(&l.adds, 1)
+	return l.AddGetDeleter.Add(ctx, k, e, ttl)
+}
+
+// missingLevel is a cache level that reports deleting missing keys as ErrNotFound.
+type missingLevel struct {
+	entcache.AddGetDeleter
+}
+
+func (missingLevel) Del(context.Context, entcache.Key) error {
+	return entcache.ErrNotFound
+}
+
+func TestDriver_SQLComments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.SQLComments("controller", "route"),
+		entcache.Debug(),
+	)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := entcache.WithTrace(context.Background())
+	expectQuery(ctx, t, drv, "SELECT name FROM users /*db_driver='ent',route='%2Fusers'*/", []interface{}{"a8m"})
+	// Same query from another route is served from the cache.
+	expectQuery(ctx, t, drv, "SELECT name FROM users /*db_driver='ent',route='%2Fadmin'*/", []interface{}{"a8m"})
+	expectQuery(entcache.WithName(ctx, "named"), t, drv, "SELECT name FROM users /*route='%2Fadmin'*/", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	stats := drv.NameStats()
+	if s := stats["/users"]; s.Gets != 1 || s.Hits != 0 {
+		t.Errorf("unexpected /users stats: %+v", s)
+	}
+	if s := stats["/admin"]; s.Gets != 1 || s.Hits != 1 {
+		t.Errorf("unexpected /admin stats: %+v", s)
+	}
+	if s := stats["named"]; s.Gets != 1 || s.Hits != 1 {
+		t.Errorf("expected explicit names to take precedence: %+v", s)
+	}
+	decisions := entcache.TraceFromContext(ctx)
+	if len(decisions) != 3 {
+		t.Fatalf("unexpected decisions: %v", decisions)
+	}
+	if d := decisions[1]; !d.Hit || d.Comments["route"] != "/admin" || d.Comments["db_driver"] != "ent" {
+		t.Errorf("unexpected hit decision: %+v", d)
+	}
+}
+
+func TestDriver_Cached(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	const query = "SELECT owner, COUNT(*) AS total FROM todos WHERE done = ? GROUP BY owner"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "total"}).AddRow("a8m", 2).AddRow("nati", 1))
+	for i := 0; i < 2; i++ {
+		var reports []struct {
+			Owner string `sql:"owner"`
+			Total int    `sql:"total"`
+		}
+		if err := drv.Cached(ctx, "reports", time.Minute, query, []any{true}, &reports); err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 2 || reports[0].Owner != "a8m" || reports[0].Total != 2 || reports[1].Owner != "nati" {
+			t.Fatalf("unexpected reports: %+v", reports)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Gets != 2 || s.Hits != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if _, err := drv.Cache.Get(ctx, "reports"); err != nil {
+		t.Fatalf("expected entry to be stored with the given key: %v", err)
+	}
+}
+
+func TestDriver_ColumnMetadata(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ColumnMetadata(16))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRowsWithColumnDefinition(sqlmock.NewColumn("name").OfType("VARCHAR", "")).AddRow("a8m"))
+	// The second query is served from the cache, and shares the
+	// metadata of the first one, as they have the same fingerprint.
+	for _, query := range []string{"SELECT name FROM users LIMIT 1", "SELECT name FROM users LIMIT 2"} {
+		rows := &sql.Rows{}
+		if err := drv.Query(entcache.WithKey(context.Background(), "users"), query, []any{}, rows); err != nil {
+			t.Fatal(err)
+		}
+		if columns, err := rows.Columns(); err != nil || len(columns) != 1 || columns[0] != "name" {
+			t.Fatalf("unexpected columns: %v, %v", columns, err)
+		}
+		types, err := rows.ColumnTypes()
+		if err != nil || len(types) != 1 || types[0].DatabaseTypeName() != "VARCHAR" {
+			t.Fatalf("unexpected column types: %v", err)
+		}
+		var name string
+		if !rows.Next() || rows.Scan(&name) != nil || name != "a8m" {
+			t.Fatalf("unexpected rows: %q", name)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Hits != 1 {
+		t.Fatalf("expected the second query to hit the cache: %+v", s)
+	}
+}
@@ -0,0 +1,268 @@
+// Package dynamodb provides a remote cache level for entcache,
+// that is backed by a DynamoDB table.
+package dynamodb
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"ariga.io/entcache"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type (
+	// Client is the subset of the DynamoDB client API that is used
+	// by the DynamoDB cache level. It is implemented by *dynamodb.Client.
+	Client interface {
+		GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+		PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+		DeleteItem(context.Context, *dynamodb.DeleteItemInput, ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	}
+
+	// Level provides a remote cache backed by a DynamoDB table
+	// and implements the entcache.AddGetDeleter interface.
+	Level struct {
+		c     Client
+		table *string
+	}
+)
+
+// New returns a new DynamoDB cache level that stores its entries in the given
+// table. It is useful for serverless deployments (e.g. AWS Lambda), where Redis
+// is not available.
+//
+//	cfg, err := config.LoadDefaultConfig(ctx)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(
+//			entcache.NewLRU(256),
+//			dynamodb.New(awsdynamodb.NewFromConfig(cfg), "entcache"),
+//		),
+//	)
+//
+// The table is expected to have a partition key named "key" of type string,
+// and its TTL attribute should be configured to "ttl", in order for DynamoDB
+// to delete expired items. Since DynamoDB deletes them lazily, expired items
+// are also filtered out on reads.
+func New(c Client, table string) *Level {
+	return &Level{c: c, table: aws.String(table)}
+}
+
+// List of attributes of the items that are stored by the level.
+const (
+	dynamoKey    = "key"    // partition key.
+	dynamoValue  = "value"  // encoded entry, or one of its chunks.
+	dynamoTTL    = "ttl"    // expiration time in seconds, used by the DynamoDB TTL.
+	dynamoExpiry = "exp"    // expiration time in milliseconds, used by conditional writes.
+	dynamoChunks = "chunks" // header of a chunked entry.
+)
+
+// dynamoChunkSize is the maximum size of values that are stored in a single
+// item. DynamoDB limits items to 400KB, including the attribute names.
+const dynamoChunkSize = 350 << 10
+
+// Add adds the entry to the cache. Concurrent writes of the same key are
+// resolved using a conditional write, that keeps the entry that expires
+// last. Hence, an entry is never replaced by an entry that was recorded
+// (and stamped) before it.
+func (d *Level) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	key, err := dynamoDBKey(k)
+	if err != nil {
+		return err
+	}
+	e = e.WithExpiry(ttl)
+	buf, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	exp := int64(math.MaxInt64)
+	if !e.Expiry.IsZero() {
+		exp = e.Expiry.UnixMilli()
+	}
+	item := map[string]types.AttributeValue{
+		dynamoKey:    &types.AttributeValueMemberS{Value: key},
+		dynamoExpiry: &types.AttributeValueMemberN{Value: strconv.FormatInt(exp, 10)},
+	}
+	if !e.Expiry.IsZero() {
+		item[dynamoTTL] = &types.AttributeValueMemberN{Value: strconv.FormatInt(e.Expiry.Unix()+1, 10)}
+	}
+	if len(buf) <= dynamoChunkSize {
+		item[dynamoValue] = &types.AttributeValueMemberB{Value: buf}
+	} else {
+		header, err := d.addChunks(ctx, key, buf, item[dynamoTTL])
+		if err != nil {
+			return err
+		}
+		item[dynamoChunks] = &types.AttributeValueMemberS{Value: header}
+	}
+	_, err = d.c.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           d.table,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#k) OR #e <= :e"),
+		ExpressionAttributeNames: map[string]string{
+			"#k": dynamoKey,
+			"#e": dynamoExpiry,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":e": item[dynamoExpiry],
+		},
+	})
+	var cerr *types.ConditionalCheckFailedException
+	if errors.As(err, &cerr) {
+		// A fresher entry was stored concurrently.
+		return nil
+	}
+	return err
+}
+
+// addChunks stores the chunks of a large entry, and returns its header.
+func (d *Level) addChunks(ctx context.Context, key string, buf []byte, ttl types.AttributeValue) (string, error) {
+	// Chunks are stored under a random prefix, to avoid mixing
+	// them with the chunks of entries that are added concurrently.
+	prefix := fmt.Sprintf("%s:%x", key, rand.Uint64())
+	n := (len(buf) + dynamoChunkSize - 1) / dynamoChunkSize
+	for i := 0; i < n; i++ {
+		chunk := buf[i*dynamoChunkSize:]
+		if len(chunk) > dynamoChunkSize {
+			chunk = chunk[:dynamoChunkSize]
+		}
+		item := map[string]types.AttributeValue{
+			dynamoKey:   &types.AttributeValueMemberS{Value: chunkKey(prefix, i)},
+			dynamoValue: &types.AttributeValueMemberB{Value: chunk},
+		}
+		if ttl != nil {
+			item[dynamoTTL] = ttl
+		}
+		if _, err := d.c.PutItem(ctx, &dynamodb.PutItemInput{TableName: d.table, Item: item}); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s %d", prefix, n), nil
+}
+
+// Get gets an entry from the cache.
+func (d *Level) Get(ctx context.Context, k entcache.Key) (*entcache.Entry, error) {
+	key, err := dynamoDBKey(k)
+	if err != nil {
+		return nil, err
+	}
+	item, err := d.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	switch v := item[dynamoChunks].(type) {
+	case *types.AttributeValueMemberS:
+		if buf, err = d.chunks(ctx, v.Value); err != nil {
+			return nil, err
+		}
+	default:
+		v1, ok := item[dynamoValue].(*types.AttributeValueMemberB)
+		if !ok {
+			return nil, fmt.Errorf("entcache: invalid dynamodb item of key %q", key)
+		}
+		buf = v1.Value
+	}
+	e := &entcache.Entry{}
+	if err := e.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	if e.Expired() {
+		return nil, entcache.ErrNotFound
+	}
+	return e, nil
+}
+
+// get gets an item from the table, or returns entcache.ErrNotFound if it does not exist.
+func (d *Level) get(ctx context.Context, key string) (map[string]types.AttributeValue, error) {
+	out, err := d.c.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      d.table,
+		Key:            map[string]types.AttributeValue{dynamoKey: &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, entcache.ErrNotFound
+	}
+	return out.Item, nil
+}
+
+// chunks reads the chunks of an entry using its header.
+func (d *Level) chunks(ctx context.Context, header string) ([]byte, error) {
+	// Keys may contain spaces, unlike the chunks count.
+	i := strings.LastIndexByte(header, ' ')
+	if i == -1 {
+		return nil, fmt.Errorf("entcache: invalid dynamodb chunks header: %q", header)
+	}
+	prefix := header[:i]
+	n, err := strconv.Atoi(header[i+1:])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("entcache: invalid dynamodb chunks header: %q", header)
+	}
+	var buf []byte
+	for i := 0; i < n; i++ {
+		item, err := d.get(ctx, chunkKey(prefix, i))
+		if err != nil {
+			// entcache.ErrNotFound is returned if one of the chunks has expired.
+			return nil, err
+		}
+		v, ok := item[dynamoValue].(*types.AttributeValueMemberB)
+		if !ok {
+			return nil, fmt.Errorf("entcache: invalid dynamodb chunk %d of %q", i, prefix)
+		}
+		buf = append(buf, v.Value...)
+	}
+	return buf, nil
+}
+
+// Del deletes an entry from the cache. The chunks of large
+// entries are not deleted, and expire with the entry TTL.
+func (d *Level) Del(ctx context.Context, k entcache.Key) error {
+	key, err := dynamoDBKey(k)
+	if err != nil {
+		return err
+	}
+	_, err = d.c.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: d.table,
+		Key:       map[string]types.AttributeValue{dynamoKey: &types.AttributeValueMemberS{Value: key}},
+	})
+	return err
+}
+
+// dynamoKeyLimit is the maximum length of keys that are used as is. DynamoDB
+// limits partition keys to 2048 bytes, leaving room for the suffix of chunks.
+const dynamoKeyLimit = 1024
+
+// dynamoDBKey returns the partition key of the given Key. Keys that are too long are hashed.
+func dynamoDBKey(k entcache.Key) (string, error) {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return "", err
+	}
+	if len(key) <= dynamoKeyLimit {
+		return key, nil
+	}
+	h := sha1.Sum([]byte(key))
+	return "entcache:" + hex.EncodeToString(h[:]), nil
+}
+
+// chunkKey returns the key of the i-th chunk of an entry.
+func chunkKey(prefix string, i int) string {
+	return prefix + ":" + strconv.Itoa(i)
+}
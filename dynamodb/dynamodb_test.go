@@ -0,0 +1,40 @@
+package dynamodb_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/dynamodb"
+	"ariga.io/entcache/leveltest"
+)
+
+func TestLevel(t *testing.T) {
+	c := newDynamoDB()
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return dynamodb.New(c, "entcache")
+	})
+}
+
+func TestLevel_ConditionalWrite(t *testing.T) {
+	ctx := context.Background()
+	l := dynamodb.New(newDynamoDB(), "entcache")
+	fresh := &entcache.Entry{Values: [][]driver.Value{{"Ariel"}}, Expiry: time.Now().Add(time.Hour)}
+	if err := l.Add(ctx, "k", fresh, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Entries that expire before the stored one do not replace it.
+	stale := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}, Expiry: time.Now().Add(time.Minute)}
+	if err := l.Add(ctx, "k", stale, 0); err != nil {
+		t.Fatal(err)
+	}
+	e, err := l.Get(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Values[0][0] != "Ariel" {
+		t.Fatalf("unexpected entry: %v", e.Values)
+	}
+}
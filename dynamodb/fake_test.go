@@ -0,0 +1,78 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dynamoDB is a minimal in-memory DynamoDB table that supports the operations
+// used by the DynamoDB level. Conditional writes support only the condition
+// that is used by the level (attribute_not_exists(#k) OR #e <= :e).
+type dynamoDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func newDynamoDB() *dynamoDB {
+	return &dynamoDB{items: make(map[string]map[string]types.AttributeValue)}
+}
+
+func (d *dynamoDB) GetItem(_ context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: d.items[dynamoKey(in.Key)]}, nil
+}
+
+func (d *dynamoDB) PutItem(_ context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	size := 0
+	for name, v := range in.Item {
+		size += len(name)
+		switch v := v.(type) {
+		case *types.AttributeValueMemberB:
+			size += len(v.Value)
+		case *types.AttributeValueMemberS:
+			size += len(v.Value)
+		case *types.AttributeValueMemberN:
+			size += len(v.Value)
+		}
+	}
+	if size > 400<<10 {
+		return nil, errors.New("ValidationException: Item size has exceeded the maximum allowed size")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := dynamoKey(in.Item)
+	if in.ConditionExpression != nil {
+		if cur, ok := d.items[key]; ok && number(cur[in.ExpressionAttributeNames["#e"]]) > number(in.ExpressionAttributeValues[":e"]) {
+			return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+		}
+	}
+	d.items[key] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (d *dynamoDB) DeleteItem(_ context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.items, dynamoKey(in.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func dynamoKey(item map[string]types.AttributeValue) string {
+	return item["key"].(*types.AttributeValueMemberS).Value
+}
+
+func number(v types.AttributeValue) int64 {
+	n, ok := v.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	i, _ := strconv.ParseInt(n.Value, 10, 64)
+	return i
+}
@@ -0,0 +1,41 @@
+// Package echo provides an Echo middleware that attaches a context-level
+// cache of entcache to the requests of the routes it is registered on.
+package echo
+
+import (
+	"ariga.io/entcache"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware returns an Echo middleware that wraps the request context with a
+// context-level cache (see entcache.Middleware), and accepts the same options.
+// Register it on the server or on a group for all of their routes, or on a
+// single route to opt it in:
+//
+//	e := echo.New()
+//	e.GET("/users", listUsers, entecho.Middleware())
+func Middleware(opts ...entcache.MiddlewareOption) echo.MiddlewareFunc {
+	wrap := entcache.RequestWrapper(opts...)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(wrap(c.Request()))
+			return next(c)
+		}
+	}
+}
+
+// Skip returns an Echo middleware that opts a route out of the context-level
+// cache that is attached by a Middleware registered on the server or its group.
+//
+//	e.Use(entecho.Middleware())
+//	e.GET("/admin/users", listUsers, entecho.Skip())
+func Skip() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			c.SetRequest(r.WithContext(entcache.Detach(r.Context())))
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package echo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ariga.io/entcache"
+	entecho "ariga.io/entcache/echo"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddleware(t *testing.T) {
+	var cached bool
+	h := func(c echo.Context) error {
+		_, cached = entcache.FromContext(c.Request().Context())
+		return nil
+	}
+	// Opt-out of a server-level middleware.
+	e := echo.New()
+	e.Use(entecho.Middleware())
+	e.GET("/users", h)
+	e.GET("/admin", h, entecho.Skip())
+	// Opt-in of a single route.
+	e2 := echo.New()
+	e2.GET("/users", h, entecho.Middleware())
+	e2.GET("/admin", h)
+	for _, srv := range []*echo.Echo{e, e2} {
+		for path, want := range map[string]bool{"/users": true, "/admin": false} {
+			cached = false
+			srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			if cached != want {
+				t.Errorf("%s: cached = %t, want %t", path, cached, want)
+			}
+		}
+	}
+}
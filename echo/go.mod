@@ -0,0 +1,10 @@
+module ariga.io/entcache/echo
+
+go 1.22
+
+require (
+	ariga.io/entcache v0.0.0-00010101000000-000000000000
+	github.com/labstack/echo/v4 v4.11.4
+)
+
+replace ariga.io/entcache => ../
@@ -0,0 +1,30 @@
+// Package entcachegen provides an entc template that generates typed caching
+// helpers for ent entities. For each entity, the entity client is extended with
+// the following methods:
+//
+//	// QueryCached returns a query builder, and a context that caches its results.
+//	func (c *UserClient) QueryCached(ctx context.Context, ttl time.Duration) (context.Context, *UserQuery)
+//
+//	// GetCached returns an entity by its id, and caches it.
+//	func (c *UserClient) GetCached(ctx context.Context, id int, ttl time.Duration) (*User, error)
+//
+// The helpers apply the entcache.WithTTL and entcache.WithName context options,
+// where the operation name is the name of the entity. The template is registered
+// in the entc configuration (e.g. ent/entc.go):
+//
+//	err := entc.Generate("./schema", &gen.Config{
+//		Templates: []*gen.Template{
+//			gen.MustParse(gen.NewTemplate("entcache").Parse(entcachegen.Template)),
+//		},
+//	})
+//
+// Note that the helpers are generated on the entity clients, as the entity
+// packages (e.g. ent/user) cannot import the client package.
+package entcachegen
+
+import _ "embed"
+
+// Template holds the entc template that generates the caching helpers.
+//
+//go:embed template/entcache.tmpl
+var Template string
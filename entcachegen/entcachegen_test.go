@@ -0,0 +1,54 @@
+package entcachegen_test
+
+import (
+	"bytes"
+	"go/format"
+	"path"
+	"strings"
+	"testing"
+	"text/template"
+
+	"ariga.io/entcache/entcachegen"
+)
+
+type (
+	graph struct {
+		Config struct{ Package string }
+		Nodes  []node
+	}
+	node struct {
+		Name string
+		ID   struct{ Type string }
+	}
+)
+
+func (n node) QueryName() string  { return n.Name + "Query" }
+func (n node) Package() string    { return strings.ToLower(n.Name) }
+func (n node) PackageDir() string { return n.Package() }
+
+func TestTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{"base": path.Base}).Parse(`{{ define "header" }}package {{ base $.Config.Package }}{{ end }}`))
+	tmpl = template.Must(tmpl.Parse(entcachegen.Template))
+	g := graph{Nodes: []node{{Name: "User"}, {Name: "Todo"}}}
+	g.Config.Package = "example.com/app/ent"
+	g.Nodes[0].ID.Type, g.Nodes[1].ID.Type = "int", "string"
+	var b bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&b, "entcache", g); err != nil {
+		t.Fatal(err)
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		t.Fatalf("invalid generated code: %v\n%s", err, b.String())
+	}
+	for _, s := range []string{
+		`"example.com/app/ent/user"`,
+		`func (c *UserClient) QueryCached(ctx context.Context, ttl time.Duration) (context.Context, *UserQuery) {`,
+		`return entcache.WithName(entcache.WithTTL(ctx, ttl), "User"), c.Query()`,
+		`func (c *TodoClient) GetCached(ctx context.Context, id string, ttl time.Duration) (*Todo, error) {`,
+		`return q.Where(todo.ID(id)).Only(ctx)`,
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Errorf("expected generated code to contain %q:\n%s", s, src)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package entcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Eviction retry policy for failing cache levels (e.g. a remote Redis level).
+const (
+	evictAttempts = 3
+	evictBackoff  = 10 * time.Millisecond
+)
+
+// evict deletes the key from all levels of the given cache. A failure in one level
+// does not prevent the eviction from the others. Failing levels are retried with
+// backoff, and the returned error holds the levels that failed after all attempts.
+func (d *Driver) evict(ctx context.Context, c AddGetDeleter, k Key) error {
+	levels := []AddGetDeleter{c}
+	if m, ok := c.(*multiLevel); ok {
+		levels = m.levels
+	}
+	var errs levelErrors
+	for i, l := range levels {
+		// Levels that report deleting missing keys as ErrNotFound
+		// are tolerated, since the entry does not exist after all.
+		err := retry(ctx, func() error {
+			if err := l.Del(ctx, k); !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			atomic.AddUint64(&d.stats.EvictErrors, 1)
+			if d.Log != nil {
+				d.Log(fmt.Sprintf("entcache: failed evicting entry %v from level %d: %v", k, i, err))
+			}
+			errs = append(errs, &LevelError{Level: i, Err: err})
+		}
+	}
+	return errs.err()
+}
+
+// retry calls f until it succeeds, up to evictAttempts times
+// with exponential backoff, or until the context is done.
+func retry(ctx context.Context, f func() error) error {
+	var (
+		err     error
+		backoff = evictBackoff
+	)
+	for i := 0; i < evictAttempts; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		// Degraded levels fail fast.
+		if i == evictAttempts-1 || errors.Is(err, ErrDegraded) {
+			break
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// LevelError is returned when an operation on a specific cache level fails.
+type LevelError struct {
+	Level int // index of the level.
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *LevelError) Error() string {
+	return fmt.Sprintf("entcache: level %d: %v", e.Level, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LevelError) Unwrap() error {
+	return e.Err
+}
+
+// levelErrors aggregates errors of multiple cache levels.
+type levelErrors []error
+
+// Error implements the error interface.
+func (e levelErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	s := make([]string, len(e))
+	for i := range e {
+		s[i] = e[i].Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// err returns the aggregated errors as an error, or nil if there are none.
+func (e levelErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Unwrap returns the aggregated errors.
+func (e levelErrors) Unwrap() []error {
+	return e
+}
@@ -0,0 +1,148 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Exec executes a statement using the underlying driver. In ContextLevel mode,
+// the entries of the tables that were modified by the statement are evicted from
+// the context cache, so reads that follow a mutation in the same request (e.g. a
+// GraphQL mutation) are not served stale data. See EvictWrites for evicting them
+// from the driver cache.
+func (d *Driver) Exec(ctx context.Context, query string, args, v any) error {
+	return d.write(ctx, query, func() error {
+		return d.Driver.Exec(ctx, query, args, v)
+	})
+}
+
+// write executes a statement that modifies data, and evicts the
+// entries of the tables it modifies according to the driver options.
+func (d *Driver) write(ctx context.Context, query string, exec func() error) error {
+	if d.Writes&EvictBeforeWrite != 0 {
+		d.evictTables(ctx, query)
+	}
+	err := exec()
+	if d.evictAfterWrite() {
+		d.evictTables(ctx, query)
+	}
+	d.invalidate(ctx, query)
+	d.invalidateContext(ctx, query)
+	d.sessionWrite(ctx, query)
+	return err
+}
+
+// evictTables evicts the entries of the tables that are
+// modified by the statement from the driver cache.
+func (d *Driver) evictTables(ctx context.Context, query string) {
+	tables := queryTables(query)
+	if len(tables) == 0 {
+		return
+	}
+	tags := make([]string, len(tables))
+	for i, table := range tables {
+		tags[i] = tableTag(table)
+	}
+	if err := d.EvictTags(ctx, tags...); err != nil {
+		atomic.AddUint64(&d.stats.EvictErrors, 1)
+		if d.Log != nil {
+			d.Log(fmt.Sprintf("entcache: failed evicting tables %v: %v", tables, err))
+		}
+	}
+}
+
+// invalidateContext evicts the entries of the tables that are modified by the
+// statement from the context cache. If the tables cannot be detected or the cache
+// does not support tagging, the entire context cache is cleared (if supported).
+func (d *Driver) invalidateContext(ctx context.Context, query string) {
+	l, ok := d.Cache.(*contextLevel)
+	if !ok {
+		return
+	}
+	tables := queryTables(query)
+	if l.shared != nil {
+		d.invalidateShared(ctx, l.shared, query, tables)
+	}
+	c, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+	t, ok := c.(Tagger)
+	if !ok || len(tables) == 0 {
+		if c, ok := c.(interface{ Clear() }); ok {
+			c.Clear()
+		}
+		return
+	}
+	for _, table := range tables {
+		if err := t.EvictTag(ctx, tableTag(table)); err != nil && d.Log != nil {
+			atomic.AddUint64(&d.stats.Errors, 1)
+			d.Log(fmt.Sprintf("entcache: failed evicting table %q from context cache: %v", table, err))
+		}
+	}
+}
+
+// invalidateShared evicts the entries of the tables that are modified by the
+// statement from the shared level of the context level cache (see AlsoShared).
+func (d *Driver) invalidateShared(ctx context.Context, shared AddGetDeleter, query string, tables []string) {
+	t, ok := shared.(Tagger)
+	if !ok || len(tables) == 0 {
+		if d.Log != nil {
+			d.Log(fmt.Sprintf("entcache: cannot evict the entries of statement from the shared cache: %s", query))
+		}
+		return
+	}
+	for _, table := range tables {
+		if err := t.EvictTag(ctx, tableTag(table)); err != nil {
+			atomic.AddUint64(&d.stats.EvictErrors, 1)
+			if d.Log != nil {
+				d.Log(fmt.Sprintf("entcache: failed evicting table %q from shared cache: %v", table, err))
+			}
+		}
+	}
+}
+
+// entryTags returns the tags of the entry that is recorded for the query. In
+// ContextLevel mode, or if write eviction is enabled, entries are also tagged
+// with the tables they read. If TagFingerprints is set, entries are tagged with
+// the fingerprint of their query. Invalidation strategies add their tags as well.
+func (d *Driver) entryTags(query string, opts ctxOptions) []string {
+	_, tables := d.Cache.(*contextLevel)
+	tables = tables || d.Writes != 0 || opts.aggregate != 0
+	if !tables && !d.Fingerprints && len(d.Invalidators) == 0 {
+		return opts.tags
+	}
+	tags := opts.tags[:len(opts.tags):len(opts.tags)]
+	if tables {
+		for _, table := range queryTables(query) {
+			tags = append(tags, tableTag(table))
+		}
+	}
+	if d.Fingerprints {
+		tags = append(tags, fingerprintTag(Fingerprint(query)))
+	}
+	for _, inv := range d.Invalidators {
+		for _, tag := range inv.Tags(query) {
+			tags = appendTable(tags, tag)
+		}
+	}
+	return tags
+}
+
+// tableTag returns the tag of the entries that read the given table.
+func tableTag(table string) string {
+	return "entcache:table:" + table
+}
+
+// isWrite reports if the statement modifies data.
+func isWrite(query string) bool {
+	query = strings.TrimSpace(query)
+	for _, p := range []string{"INSERT", "UPDATE", "DELETE", "REPLACE"} {
+		if len(query) >= len(p) && strings.EqualFold(query[:len(p)], p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+// Package fiber provides a Fiber middleware that attaches a context-level
+// cache of entcache to the requests of the routes it is registered on.
+package fiber
+
+import (
+	"ariga.io/entcache"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+// Middleware returns a Fiber middleware that wraps the user context of the request
+// with a context-level cache (see entcache.Middleware), and accepts the same options.
+// Since Fiber is not based on net/http, the request is converted to an *http.Request
+// for the options (e.g. entcache.MiddlewareFilter). Register it on the app or on a
+// group for all of their routes, or on a single route to opt it in:
+//
+//	app := fiber.New()
+//	app.Get("/users", entfiber.Middleware(), listUsers)
+//
+// Note that handlers should pass the user context (c.UserContext()) to ent queries.
+func Middleware(opts ...entcache.MiddlewareOption) fiber.Handler {
+	wrap := entcache.RequestWrapper(opts...)
+	return func(c *fiber.Ctx) error {
+		r, err := adaptor.ConvertRequest(c, false)
+		if err != nil {
+			return err
+		}
+		c.SetUserContext(wrap(r.WithContext(c.UserContext())).Context())
+		return c.Next()
+	}
+}
+
+// Skip returns a Fiber middleware that opts a route out of the context-level
+// cache that is attached by a Middleware registered on the app or its group.
+//
+//	app.Use(entfiber.Middleware())
+//	app.Get("/admin/users", entfiber.Skip(), listUsers)
+func Skip() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.SetUserContext(entcache.Detach(c.UserContext()))
+		return c.Next()
+	}
+}
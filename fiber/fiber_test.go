@@ -0,0 +1,62 @@
+package fiber_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ariga.io/entcache"
+	entfiber "ariga.io/entcache/fiber"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMiddleware(t *testing.T) {
+	var cached bool
+	h := func(c *fiber.Ctx) error {
+		_, cached = entcache.FromContext(c.UserContext())
+		return nil
+	}
+	// Opt-out of an app-level middleware.
+	app := fiber.New()
+	app.Use(entfiber.Middleware())
+	app.Get("/users", h)
+	app.Get("/admin", entfiber.Skip(), h)
+	// Opt-in of a single route.
+	app2 := fiber.New()
+	app2.Get("/users", entfiber.Middleware(), h)
+	app2.Get("/admin", h)
+	for _, srv := range []*fiber.App{app, app2} {
+		for path, want := range map[string]bool{"/users": true, "/admin": false} {
+			cached = false
+			if _, err := srv.Test(httptest.NewRequest(http.MethodGet, path, nil)); err != nil {
+				t.Fatal(err)
+			}
+			if cached != want {
+				t.Errorf("%s: cached = %t, want %t", path, cached, want)
+			}
+		}
+	}
+}
+
+func TestMiddlewareFilter(t *testing.T) {
+	var cached bool
+	app := fiber.New()
+	app.Use(entfiber.Middleware(entcache.MiddlewareFilter(func(r *http.Request) bool {
+		return !strings.HasPrefix(r.URL.Path, "/admin")
+	})))
+	app.Get("/*", func(c *fiber.Ctx) error {
+		_, cached = entcache.FromContext(c.UserContext())
+		return nil
+	})
+	for path, want := range map[string]bool{"/users": true, "/admin/users": false} {
+		cached = false
+		if _, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if cached != want {
+			t.Errorf("%s: cached = %t, want %t", path, cached, want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module ariga.io/entcache/fiber
+
+go 1.22
+
+require (
+	ariga.io/entcache v0.0.0-00010101000000-000000000000
+	github.com/gofiber/fiber/v2 v2.52.0
+)
+
+replace ariga.io/entcache => ../
@@ -0,0 +1,71 @@
+package entcache
+
+import "strings"
+
+// SkipUnfiltered configures the driver to bypass the cache for queries without
+// a WHERE or a LIMIT clause. Full-table queries are usually migrations, admin
+// scans or bugs, and caching their results wastes a lot of memory. Queries that
+// read only from the given tables are cached as usual.
+//
+//	entcache.NewDriver(drv, entcache.SkipUnfiltered("countries", "currencies"))
+//
+// Skipped queries are counted in Stats.Skips by SkipNoFilter.
+func SkipUnfiltered(except ...string) Option {
+	return func(o *Options) {
+		o.SkipUnfiltered = true
+		o.UnfilteredTables = append(o.UnfilteredTables, except...)
+	}
+}
+
+// unfiltered reports if the query should bypass the cache, because it
+// has no filter and reads from tables that are not listed as exceptions.
+func (d *Driver) unfiltered(query string) bool {
+	if !d.SkipUnfiltered || hasFilter(query) {
+		return false
+	}
+	for _, t := range queryTables(query) {
+		if !d.unfilteredTable(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// unfilteredTable reports if the table is allowed to be cached without a filter.
+func (d *Driver) unfilteredTable(t string) bool {
+	for _, u := range d.UnfilteredTables {
+		if strings.EqualFold(t, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFilter reports if the statement has a WHERE or a LIMIT clause at its
+// top level. Clauses of subqueries and string literals are ignored.
+func hasFilter(query string) bool {
+	var depth int
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == '\'':
+			for i++; i < len(query) && query[i] != '\''; i++ {
+			}
+			i++
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case c == '"' || c == '`' || isIdent(c):
+			var name string
+			name, i = scanName(query, i)
+			if depth == 0 && !isQuoted(c) && (strings.EqualFold(name, "WHERE") || strings.EqualFold(name, "LIMIT")) {
+				return true
+			}
+		default:
+			i++
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package entcache
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+)
+
+// TagFingerprints configures the driver to tag entries with the fingerprint of
+// their query (see Fingerprint). Hence, all entries of a query, like all cached
+// pages of a list, can be evicted in one call using Driver.EvictFingerprint, or
+// the EvictHandler. Note that the cache must support tagging (see Tagger).
+//
+//	entcache.NewDriver(drv, entcache.TagFingerprints())
+func TagFingerprints() Option {
+	return func(o *Options) {
+		o.Fingerprints = true
+	}
+}
+
+// Fingerprint returns the fingerprint of the given query. Queries that differ
+// only in their arguments, their pagination (i.e. the LIMIT/OFFSET clause at
+// the end of the query) or their sqlcommenter tags share the same fingerprint.
+func Fingerprint(query string) string {
+	query, _ = splitComment(query)
+	if loc := pageRegex.FindStringIndex(query); loc != nil {
+		query = query[:loc[0]]
+	}
+	h := fnv.New64a()
+	h.Write([]byte(query))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// EvictFingerprint deletes all cache entries that were recorded by queries with
+// the given fingerprint. It fails if the configured cache does not support tagging.
+func (d *Driver) EvictFingerprint(ctx context.Context, fingerprint string) error {
+	return d.EvictTags(ctx, fingerprintTag(fingerprint))
+}
+
+// fingerprintTag returns the tag of entries that were recorded by queries with the given fingerprint.
+func fingerprintTag(fingerprint string) string {
+	return "entcache:fingerprint:" + fingerprint
+}
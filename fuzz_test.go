@@ -0,0 +1,106 @@
+package entcache_test
+
+import (
+	"context"
+	stdsql "database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fuzzEntries returns the seed entries of the fuzz tests.
+func fuzzEntries(f *testing.F) [][]byte {
+	var seeds [][]byte
+	for _, e := range []entcache.Entry{
+		{},
+		{Columns: []string{"id", "name"}, Values: [][]driver.Value{{int64(1), "a8m"}, {int64(2), nil}}},
+		{Columns: []string{"id"}, Values: [][]driver.Value{{int64(1), "a8m"}, {}}},
+		{Values: [][]driver.Value{{[]byte("a8m"), 1.5, true, time.Unix(0, 0)}}, Expiry: time.Unix(1, 0)},
+	} {
+		buf, err := e.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, buf, buf[:len(buf)/2])
+	}
+	return seeds
+}
+
+func FuzzEntry_UnmarshalBinary(f *testing.F) {
+	for _, buf := range fuzzEntries(f) {
+		f.Add(buf)
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		e := &entcache.Entry{}
+		if err := e.UnmarshalBinary(buf); err != nil {
+			return
+		}
+		if _, err := e.MarshalBinary(); err != nil {
+			t.Fatalf("unexpected marshal failure of a decoded entry: %v", err)
+		}
+	})
+}
+
+func FuzzRepeater(f *testing.F) {
+	for _, buf := range fuzzEntries(f) {
+		f.Add(buf, uint8(1))
+		f.Add(buf, uint8(2))
+	}
+	db, _, err := sqlmock.New()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, buf []byte, n uint8) {
+		e := &entcache.Entry{}
+		if err := e.UnmarshalBinary(buf); err != nil {
+			return
+		}
+		// Expired entries are never served.
+		e.Expiry = time.Time{}
+		ctx := context.Background()
+		lru := entcache.NewLRU(0)
+		if err := lru.Add(ctx, 1, e, 0); err != nil {
+			return
+		}
+		drv := entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(lru),
+			entcache.Hash(func(string, []any) (entcache.Key, error) {
+				return 1, nil
+			}),
+		)
+		rows := &sql.Rows{}
+		if err := drv.Query(ctx, "SELECT * FROM users", []any{}, rows); err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		dest := make([]any, n%8)
+		for i := range dest {
+			switch i % 6 {
+			case 0:
+				dest[i] = new(any)
+			case 1:
+				dest[i] = new(string)
+			case 2:
+				dest[i] = new(int64)
+			case 3:
+				dest[i] = new([]byte)
+			case 4:
+				dest[i] = new(time.Time)
+			case 5:
+				dest[i] = new(stdsql.NullString)
+			}
+		}
+		_, _ = rows.Columns()
+		for rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				break
+			}
+		}
+	})
+}
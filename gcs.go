@@ -0,0 +1,94 @@
+package entcache
+
+import (
+	"context"
+	"io"
+)
+
+type (
+	// GCSBucket is the subset of the Cloud Storage bucket API that is used by
+	// the GCS cache level. Objects of *storage.BucketHandle are read and written
+	// using object handles, and therefore, the bucket is wrapped as follows:
+	//
+	//	type bucket struct{ *storage.BucketHandle }
+	//
+	//	func (b bucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	//		r, err := b.Object(name).NewReader(ctx)
+	//		if errors.Is(err, storage.ErrObjectNotExist) {
+	//			return nil, entcache.ErrNotFound
+	//		}
+	//		return r, err
+	//	}
+	//
+	//	func (b bucket) NewWriter(ctx context.Context, name string) io.WriteCloser {
+	//		return b.Object(name).NewWriter(ctx)
+	//	}
+	//
+	//	func (b bucket) Delete(ctx context.Context, name string) error {
+	//		if err := b.Object(name).Delete(ctx); !errors.Is(err, storage.ErrObjectNotExist) {
+	//			return err
+	//		}
+	//		return nil
+	//	}
+	GCSBucket interface {
+		// NewReader returns a reader of the object, or
+		// ErrNotFound if the object does not exist.
+		NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+		// NewWriter returns a writer of the object. The object
+		// is stored when the writer is closed successfully.
+		NewWriter(ctx context.Context, name string) io.WriteCloser
+		// Delete deletes the object. Deleting an object
+		// that does not exist is not an error.
+		Delete(ctx context.Context, name string) error
+	}
+
+	// gcsStore implements the BlobStore interface for a GCS bucket.
+	gcsStore struct {
+		b GCSBucket
+	}
+)
+
+// NewGCS returns a new Blob cache level that stores its entries in the given
+// Cloud Storage bucket. Only entries that are larger than the minimum size (1MB
+// by default) are stored, and therefore, it should be the last level of the cache.
+//
+//	c, err := storage.NewClient(ctx)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(
+//			entcache.NewLRU(256),
+//			entcache.NewRedis(rdb),
+//			entcache.NewGCS(bucket{c.Bucket("entcache")}),
+//		),
+//	)
+func NewGCS(b GCSBucket, opts ...BlobOption) *Blob {
+	return NewBlob(&gcsStore{b: b}, opts...)
+}
+
+// Get implements the BlobStore interface.
+func (s *gcsStore) Get(ctx context.Context, name string) ([]byte, error) {
+	r, err := s.b.NewReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Put implements the BlobStore interface.
+func (s *gcsStore) Put(ctx context.Context, name string, data []byte) error {
+	w := s.b.NewWriter(ctx, name)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Delete implements the BlobStore interface.
+func (s *gcsStore) Delete(ctx context.Context, name string) error {
+	return s.b.Delete(ctx, name)
+}
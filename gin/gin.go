@@ -0,0 +1,39 @@
+// Package gin provides a Gin middleware that attaches a context-level
+// cache of entcache to the requests of the routes it is registered on.
+package gin
+
+import (
+	"ariga.io/entcache"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware returns a Gin middleware that wraps the request context with a
+// context-level cache (see entcache.Middleware), and accepts the same options.
+// Register it on the engine or on a group for all of their routes, or on a
+// single route to opt it in:
+//
+//	r := gin.Default()
+//	r.GET("/users", entgin.Middleware(), listUsers)
+//
+// Note that handlers should pass the request context (c.Request.Context())
+// to ent queries, as the cache is not attached to the gin.Context itself.
+func Middleware(opts ...entcache.MiddlewareOption) gin.HandlerFunc {
+	wrap := entcache.RequestWrapper(opts...)
+	return func(c *gin.Context) {
+		c.Request = wrap(c.Request)
+		c.Next()
+	}
+}
+
+// Skip returns a Gin middleware that opts a route out of the context-level
+// cache that is attached by a Middleware registered on the engine or its group.
+//
+//	r.Use(entgin.Middleware())
+//	r.GET("/admin/users", entgin.Skip(), listUsers)
+func Skip() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(entcache.Detach(c.Request.Context()))
+		c.Next()
+	}
+}
@@ -0,0 +1,38 @@
+package gin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ariga.io/entcache"
+	entgin "ariga.io/entcache/gin"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var cached bool
+	h := func(c *gin.Context) {
+		_, cached = entcache.FromContext(c.Request.Context())
+	}
+	// Opt-out of an engine-level middleware.
+	r := gin.New()
+	r.Use(entgin.Middleware())
+	r.GET("/users", h)
+	r.GET("/admin", entgin.Skip(), h)
+	// Opt-in of a single route.
+	r2 := gin.New()
+	r2.GET("/users", entgin.Middleware(), h)
+	r2.GET("/admin", h)
+	for _, srv := range []*gin.Engine{r, r2} {
+		for path, want := range map[string]bool{"/users": true, "/admin": false} {
+			cached = false
+			srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			if cached != want {
+				t.Errorf("%s: cached = %t, want %t", path, cached, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module ariga.io/entcache/gin
+
+go 1.22
+
+require (
+	ariga.io/entcache v0.0.0-00010101000000-000000000000
+	github.com/gin-gonic/gin v1.9.1
+)
+
+replace ariga.io/entcache => ../
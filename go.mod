@@ -1,6 +1,6 @@
 module ariga.io/entcache
 
-go 1.19
+go 1.22
 
 require (
 	entgo.io/ent v0.11.2-0.20220805114204-0066eb986dd3
@@ -11,11 +11,19 @@ require (
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/alicebob/miniredis/v2 v2.30.4
 	github.com/go-redis/redismock/v9 v9.0.3
+	github.com/golang/snappy v0.0.3
+	github.com/klauspost/compress v1.18.0
 	github.com/redis/go-redis/v9 v9.0.5
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
 )
@@ -0,0 +1,124 @@
+package entcache
+
+import (
+	"database/sql/driver"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// RegisterSchemaTypes walks the entity types of the given ent.Client, and
+// registers the types of their fields (e.g. enums, JSON fields and field.Other
+// types) with encoding/gob, so values of these types can be stored in remote
+// levels. It fails if a field type cannot be encoded. Hence, it is recommended
+// to call it at startup, instead of discovering it on the first cache write:
+//
+//	if err := entcache.RegisterSchemaTypes(client); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// Entity types are detected by the Get method of the entity clients (e.g.
+// client.User.Get) that are generated by ent, and their edges are skipped.
+func RegisterSchemaTypes(client any) error {
+	t := reflect.TypeOf(client)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("entcache: unexpected client type %T", client)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		ent, ok := entityType(f.Type)
+		if !ok {
+			continue
+		}
+		for j := 0; j < ent.NumField(); j++ {
+			ef := ent.Field(j)
+			if !ef.IsExported() || ef.Name == "Edges" {
+				continue
+			}
+			if err := registerType(ef.Type); err != nil {
+				return fmt.Errorf("entcache: field %s.%s: %w", ent.Name(), ef.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// entityType returns the entity type of the given entity client
+// type. That is, the type returned by its Get method (e.g. *User).
+func entityType(t reflect.Type) (reflect.Type, bool) {
+	m, ok := t.MethodByName("Get")
+	if !ok || m.Type.NumOut() != 2 {
+		return nil, false
+	}
+	ent := m.Type.Out(0)
+	if ent.Kind() != reflect.Pointer || ent.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	return ent.Elem(), true
+}
+
+// builtinTypes holds the types that are registered by encoding/gob.
+var builtinTypes = map[reflect.Type]bool{
+	reflect.TypeOf(time.Time{}): true,
+	reflect.TypeOf([]byte(nil)): true,
+}
+
+// registerType registers the given field type with encoding/gob,
+// and verifies that its values can be stored in cache entries.
+func registerType(t reflect.Type) (err error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case builtinTypes[t], t.Kind() == reflect.Interface:
+		return nil
+	case t.PkgPath() == "" && (t.Kind() <= reflect.Complex128 || t.Kind() == reflect.String):
+		// Unnamed basic types.
+		return nil
+	}
+	defer func() {
+		// gob.Register panics if the type was registered under another name.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("registering type %s: %v", t, r)
+		}
+	}()
+	v := reflect.New(t).Elem().Interface()
+	gob.Register(v)
+	// Encode a zero value inside an entry, as it is stored in the cache.
+	e := Entry{Values: [][]driver.Value{{v}}}
+	if _, err := e.MarshalBinary(); err != nil {
+		return fmt.Errorf("encoding type %s: %w", t, err)
+	}
+	return nil
+}
+
+// unencodable returns the first value of the given rows that cannot be
+// encoded with encoding/gob, and the name (or the position) of its column.
+func unencodable(columns []string, values [][]driver.Value) (string, any, bool) {
+	checked := make(map[reflect.Type]bool)
+	for _, row := range values {
+		for i, v := range row {
+			t := reflect.TypeOf(v)
+			if t == nil || checked[t] {
+				continue
+			}
+			checked[t] = true
+			if err := gob.NewEncoder(io.Discard).Encode(&v); err != nil {
+				column := fmt.Sprintf("#%d", i)
+				if i < len(columns) {
+					column = fmt.Sprintf("%q", columns[i])
+				}
+				return column, v, true
+			}
+		}
+	}
+	return "", nil, false
+}
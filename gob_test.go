@@ -0,0 +1,53 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"ariga.io/entcache"
+)
+
+type (
+	status   string
+	profile  struct{ Bio string }
+	badField struct{ ch chan int }
+	testUser struct {
+		ID      int
+		Name    string
+		Status  status
+		Profile *profile
+		Tags    []string
+		Edges   struct{ Friends []*testUser }
+	}
+	testPet    struct{ Field badField }
+	userClient struct{}
+	petClient  struct{}
+)
+
+func (userClient) Get(context.Context, int) (*testUser, error) { return nil, nil }
+func (petClient) Get(context.Context, int) (*testPet, error)   { return nil, nil }
+
+func TestRegisterSchemaTypes(t *testing.T) {
+	client := &struct {
+		User *userClient
+		Pet  *petClient
+	}{}
+	err := entcache.RegisterSchemaTypes(client)
+	if err == nil || !strings.Contains(err.Error(), "testPet.Field") {
+		t.Fatalf("expected unsupported field to fail registration, got: %v", err)
+	}
+	e := &entcache.Entry{Values: [][]driver.Value{{status("active"), profile{Bio: "a8m"}}}}
+	buf, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &entcache.Entry{}
+	if err := got.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got.Values[0][0] != status("active") || got.Values[0][1] != (profile{Bio: "a8m"}) {
+		t.Fatalf("unexpected values: %v", got.Values)
+	}
+}
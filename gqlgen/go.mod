@@ -0,0 +1,13 @@
+module ariga.io/entcache/gqlgen
+
+go 1.22
+
+require (
+	ariga.io/entcache v0.0.0-00010101000000-000000000000
+	entgo.io/ent v0.11.2-0.20220805114204-0066eb986dd3
+	github.com/99designs/gqlgen v0.17.45
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/vektah/gqlparser/v2 v2.5.11
+)
+
+replace ariga.io/entcache => ../
@@ -0,0 +1,248 @@
+// Package gqlgen provides a gqlgen handler extension that attaches a context-level
+// cache of entcache to GraphQL operations, and configures the caching of their
+// queries per operation.
+package gqlgen
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"ariga.io/entcache"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type (
+	// Extension is a gqlgen handler extension that wraps the context of GraphQL
+	// queries with a context-level cache (see entcache.NewContext), and applies
+	// the cache policy of their operation. Mutations and subscriptions are not
+	// cached.
+	Extension struct {
+		policies     map[string]Policy
+		fallback     Policy
+		cacheControl bool
+		schema       *ast.Schema
+		persisted    *opCaches
+	}
+
+	// Policy defines the caching of the queries of a GraphQL operation.
+	Policy struct {
+		// TTL defines the TTL of the entries of the operation. Zero
+		// means the TTL of the driver (or the query) is used.
+		TTL time.Duration
+		// Skip bypasses the cache for the operation.
+		Skip bool
+		// RequestOnly configures the operation to use only its request-level
+		// cache, and not the shared level of the driver (see entcache.AlsoShared).
+		RequestOnly bool
+	}
+
+	// Option allows configuring the Extension
+	// using functional options.
+	Option func(*Extension)
+)
+
+var (
+	_ graphql.HandlerExtension    = (*Extension)(nil)
+	_ graphql.ResponseInterceptor = (*Extension)(nil)
+	_ graphql.FieldInterceptor    = (*Extension)(nil)
+)
+
+// New returns a new gqlgen extension for a driver that works in ContextLevel mode.
+//
+//	drv := entcache.NewDriver(db, entcache.ContextLevel(entcache.AlsoShared(entcache.NewRedis(rdb), time.Minute)))
+//	client := ent.NewClient(ent.Driver(drv))
+//	srv := handler.NewDefaultServer(graph.NewSchema(client))
+//	srv.Use(gqlgen.New())
+func New(opts ...Option) *Extension {
+	e := &Extension{policies: make(map[string]Policy)}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Policies maps operation names to cache policies. Operations that are not
+// in the map use the DefaultPolicy. For example, Dashboard queries use the
+// shared (Redis) level, while all other operations use only their request cache:
+//
+//	gqlgen.New(
+//		gqlgen.DefaultPolicy(gqlgen.Policy{RequestOnly: true}),
+//		gqlgen.Policies(map[string]gqlgen.Policy{
+//			"Dashboard": {TTL: time.Minute},
+//			"AuditLog":  {Skip: true},
+//		}),
+//	)
+func Policies(m map[string]Policy) Option {
+	return func(e *Extension) {
+		for name, p := range m {
+			e.policies[name] = p
+		}
+	}
+}
+
+// DefaultPolicy configures the policy of operations without a policy,
+// including anonymous ones. The zero Policy is used by default.
+func DefaultPolicy(p Policy) Option {
+	return func(e *Extension) {
+		e.fallback = p
+	}
+}
+
+// CacheControl configures the extension to honor the @cacheControl(maxAge:) directive
+// of the schema, by translating it to entcache.WithTTL on the context of the resolvers
+// of the annotated fields, or the fields that return the annotated types. A maxAge of
+// zero bypasses the cache. The directive should be declared in the schema, and skipped
+// at runtime by gqlgen (i.e. skip_runtime: true in gqlgen.yml):
+//
+//	directive @cacheControl(maxAge: Int) on FIELD_DEFINITION | OBJECT | INTERFACE | UNION
+//
+//	type Query {
+//		dashboard: Dashboard! @cacheControl(maxAge: 60)
+//	}
+//
+// The cacheControl extension of operations (e.g. {"cacheControl": {"maxAge": 60}}) is
+// honored as well, for operations that their policy does not define a TTL. Directives
+// take precedence over the policies and the operation extensions, as they are more
+// specific.
+func CacheControl() Option {
+	return func(e *Extension) {
+		e.cacheControl = true
+	}
+}
+
+// ExtensionName implements the graphql.HandlerExtension interface.
+func (*Extension) ExtensionName() string {
+	return "EntCache"
+}
+
+// Validate implements the graphql.HandlerExtension interface.
+func (e *Extension) Validate(s graphql.ExecutableSchema) error {
+	e.schema = s.Schema()
+	return nil
+}
+
+// InterceptResponse implements the graphql.ResponseInterceptor interface.
+func (e *Extension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	if oc.Operation == nil || oc.Operation.Operation != ast.Query {
+		return next(ctx)
+	}
+	return next(e.operation(ctx, oc))
+}
+
+// operation returns the context of the given query operation.
+func (e *Extension) operation(ctx context.Context, oc *graphql.OperationContext) context.Context {
+	p, ok := e.policies[oc.OperationName]
+	if !ok {
+		p = e.fallback
+	}
+	if p.Skip {
+		return entcache.Skip(ctx)
+	}
+	if op := e.persisted.get(oc); op != nil {
+		ctx = context.WithValue(entcache.NewContext(ctx, op.lru), opCacheKey{}, op)
+	} else {
+		ctx = entcache.NewContext(ctx)
+	}
+	if p.RequestOnly {
+		c, _ := entcache.FromContext(ctx)
+		ctx = entcache.WithCache(ctx, c)
+	}
+	switch ttl, ok := extensionMaxAge(oc.Extensions); {
+	case p.TTL != 0:
+		ctx = entcache.WithTTL(ctx, p.TTL)
+	case e.cacheControl && ok:
+		ctx = entcache.WithTTL(ctx, ttl)
+	}
+	return ctx
+}
+
+// InterceptField implements the graphql.FieldInterceptor interface.
+func (e *Extension) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || fc.Field.Field == nil || fc.Field.Definition == nil {
+		return next(ctx)
+	}
+	if op, ok := ctx.Value(opCacheKey{}).(*opCache); ok && fc.Field.Definition.Type != nil {
+		op.resolved(fc.Field.Definition.Type.Name())
+	}
+	if e.cacheControl {
+		if ttl, ok := e.fieldMaxAge(fc.Field.Definition); ok {
+			ctx = entcache.WithTTL(ctx, ttl)
+		}
+	}
+	return next(ctx)
+}
+
+// fieldMaxAge returns the TTL of the @cacheControl directive of the
+// field, or of the type it returns, if the directive is defined.
+func (e *Extension) fieldMaxAge(f *ast.FieldDefinition) (time.Duration, bool) {
+	if ttl, ok := directiveMaxAge(f.Directives); ok {
+		return ttl, true
+	}
+	if e.schema == nil || f.Type == nil {
+		return 0, false
+	}
+	if t, ok := e.schema.Types[f.Type.Name()]; ok {
+		return directiveMaxAge(t.Directives)
+	}
+	return 0, false
+}
+
+// directiveMaxAge returns the TTL of the @cacheControl directive in the list, if any.
+func directiveMaxAge(l ast.DirectiveList) (time.Duration, bool) {
+	d := l.ForName("cacheControl")
+	if d == nil {
+		return 0, false
+	}
+	arg := d.Arguments.ForName("maxAge")
+	if arg == nil || arg.Value == nil {
+		return 0, false
+	}
+	v, err := arg.Value.Value(nil)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, false
+	}
+	return maxAgeTTL(float64(n)), true
+}
+
+// extensionMaxAge returns the TTL of the cacheControl extension of the operation, if any.
+func extensionMaxAge(ext map[string]any) (time.Duration, bool) {
+	cc, ok := ext["cacheControl"].(map[string]any)
+	if !ok {
+		return 0, false
+	}
+	// JSON numbers are decoded as float64, or as json.Number
+	// if the transport decodes the request with UseNumber.
+	switch v := cc["maxAge"].(type) {
+	case float64:
+		return maxAgeTTL(v), true
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			return 0, false
+		}
+		return maxAgeTTL(n), true
+	default:
+		return 0, false
+	}
+}
+
+// maxAgeTTL returns the TTL of the given maxAge in seconds.
+// Non-positive values bypass the cache.
+func maxAgeTTL(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return entcache.NoCache
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,204 @@
+package gqlgen_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/gqlgen"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestPolicies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := entcache.NewLRU(0)
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ContextLevel(entcache.AlsoShared(shared, 0)))
+	ext := gqlgen.New(
+		gqlgen.DefaultPolicy(gqlgen.Policy{RequestOnly: true}),
+		gqlgen.Policies(map[string]gqlgen.Policy{
+			"Dashboard": {TTL: time.Minute},
+			"AuditLog":  {Skip: true},
+		}),
+	)
+	// Users uses only its request cache.
+	ctx := execQuery(t, ext, drv, mock, "Users")
+	if c, ok := entcache.FromContext(ctx); !ok || c.(*entcache.LRU).Len() != 1 {
+		t.Fatal("expected the entry to be stored in the request cache")
+	}
+	if n := shared.Len(); n != 0 {
+		t.Fatalf("unexpected shared entries: %d", n)
+	}
+	// Dashboard uses the shared level, with its TTL.
+	ctx = execQuery(t, ext, drv, mock, "Dashboard")
+	if n := shared.Len(); n != 1 {
+		t.Fatalf("expected the entry to be stored in the shared level: %d", n)
+	}
+	if entries := entcache.ContextEntries(ctx); len(entries) != 1 || entries[0].TTL <= 0 || entries[0].TTL > time.Minute {
+		t.Fatalf("unexpected request entries: %+v", entries)
+	}
+	// AuditLog skips the cache.
+	execQuery(t, ext, drv, mock, "AuditLog")
+	if n := drv.Stats().Skips[entcache.SkipOption]; n != 1 {
+		t.Fatalf("unexpected %s skips: %d", entcache.SkipOption, n)
+	}
+	// Mutations are not cached.
+	ctx = graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+		OperationName: "CreateUser",
+		Operation:     &ast.OperationDefinition{Operation: ast.Mutation, Name: "CreateUser"},
+	})
+	ext.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+		if _, ok := entcache.FromContext(ctx); ok {
+			t.Error("unexpected cache in mutation context")
+		}
+		return &graphql.Response{}
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// execQuery executes a GraphQL query operation with the given name that runs
+// a single database query, and returns the context of the operation.
+func execQuery(t *testing.T, ext *gqlgen.Extension, drv *entcache.Driver, mock sqlmock.Sqlmock, name string) (octx context.Context) {
+	t.Helper()
+	ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+		OperationName: name,
+		Operation:     &ast.OperationDefinition{Operation: ast.Query, Name: name},
+	})
+	ext.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+		octx = ctx
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		rows := &sql.Rows{}
+		if err := drv.Query(ctx, "SELECT name FROM users", []any{}, rows); err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return &graphql.Response{}
+	})
+	return octx
+}
+
+func TestCacheControl(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ContextLevel())
+	ext := gqlgen.New(gqlgen.CacheControl())
+	err = ext.Validate(executableSchema{s: &ast.Schema{
+		Types: map[string]*ast.Definition{
+			"Dashboard": {Kind: ast.Object, Name: "Dashboard", Directives: cacheControl("30")},
+			"User":      {Kind: ast.Object, Name: "User"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		ext    map[string]any
+		field  *ast.FieldDefinition
+		maxTTL time.Duration
+		skip   bool
+	}{
+		{
+			name:   "Field",
+			field:  &ast.FieldDefinition{Name: "users", Type: &ast.Type{NamedType: "User"}, Directives: cacheControl("60")},
+			maxTTL: time.Minute,
+		},
+		{
+			name:   "Type",
+			field:  &ast.FieldDefinition{Name: "dashboard", Type: &ast.Type{NamedType: "Dashboard"}},
+			maxTTL: 30 * time.Second,
+		},
+		{
+			name:   "FieldOverType",
+			field:  &ast.FieldDefinition{Name: "dashboard", Type: &ast.Type{NamedType: "Dashboard"}, Directives: cacheControl("10")},
+			maxTTL: 10 * time.Second,
+		},
+		{
+			name:   "Extension",
+			ext:    map[string]any{"cacheControl": map[string]any{"maxAge": float64(5)}},
+			field:  &ast.FieldDefinition{Name: "users", Type: &ast.Type{NamedType: "User"}},
+			maxTTL: 5 * time.Second,
+		},
+		{
+			name:  "NoCache",
+			field: &ast.FieldDefinition{Name: "users", Type: &ast.Type{NamedType: "User"}, Directives: cacheControl("0")},
+			skip:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+				Operation:  &ast.OperationDefinition{Operation: ast.Query},
+				Extensions: tt.ext,
+			})
+			skips := drv.Stats().Skips[entcache.SkipNoCache]
+			ext.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+				ctx = graphql.WithFieldContext(ctx, &graphql.FieldContext{
+					Object: "Query",
+					Field:  graphql.CollectedField{Field: &ast.Field{Name: tt.field.Name, Definition: tt.field}},
+				})
+				if _, err := ext.InterceptField(ctx, func(ctx context.Context) (any, error) {
+					mock.ExpectQuery("SELECT name FROM users").
+						WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+					rows := &sql.Rows{}
+					if err := drv.Query(ctx, "SELECT name FROM users", []any{}, rows); err != nil {
+						return nil, err
+					}
+					for rows.Next() {
+					}
+					return nil, rows.Close()
+				}); err != nil {
+					t.Fatal(err)
+				}
+				entries := entcache.ContextEntries(ctx)
+				switch {
+				case tt.skip:
+					if len(entries) != 0 || drv.Stats().Skips[entcache.SkipNoCache] != skips+1 {
+						t.Fatalf("expected the query to bypass the cache: %+v", entries)
+					}
+				case len(entries) != 1 || entries[0].TTL <= 0 || entries[0].TTL > tt.maxTTL:
+					t.Fatalf("unexpected entries: %+v", entries)
+				}
+				return &graphql.Response{}
+			})
+		})
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// executableSchema is a graphql.ExecutableSchema of the given schema.
+type executableSchema struct {
+	graphql.ExecutableSchema
+	s *ast.Schema
+}
+
+func (e executableSchema) Schema() *ast.Schema {
+	return e.s
+}
+
+// cacheControl returns the @cacheControl directive with the given maxAge.
+func cacheControl(maxAge string) ast.DirectiveList {
+	return ast.DirectiveList{{
+		Name:      "cacheControl",
+		Arguments: ast.ArgumentList{{Name: "maxAge", Value: &ast.Value{Raw: maxAge, Kind: ast.IntValue}}},
+	}}
+}
@@ -0,0 +1,157 @@
+package gqlgen
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"ariga.io/entcache"
+
+	"entgo.io/ent"
+	"github.com/99designs/gqlgen/graphql"
+)
+
+type (
+	// opCaches holds the context-level caches of persisted operations,
+	// keyed by the hash of the operation and its variables.
+	opCaches struct {
+		size  int
+		ttl   time.Duration
+		mu    sync.Mutex
+		ll    *list.List
+		items map[string]*list.Element
+	}
+
+	// opCache is the context-level cache of a persisted operation.
+	opCache struct {
+		key     string
+		lru     *entcache.LRU
+		expires time.Time
+		mu      sync.Mutex
+		types   map[string]struct{} // types resolved by the operation.
+	}
+
+	// opCacheKey is the context key of the opCache.
+	opCacheKey struct{}
+)
+
+// PersistedQueries configures the extension to derive the context-level cache of
+// persisted queries (see Apollo APQ) from the hash of the operation and its variables.
+// Requests that execute the same persisted query with the same variables share their
+// context-level cache, and therefore, the whole set of their resolver queries is
+// served from memory. Up to size operation caches are kept, each for at most ttl. If
+// ttl is zero, caches are kept until they are evicted or invalidated.
+//
+// Writes evict the entries of the request that executed them, and other operation
+// caches are invalidated by the entity types their resolvers returned. Hence, the
+// hook of the extension should be registered on the ent client (see Hook):
+//
+//	ext := gqlgen.New(gqlgen.PersistedQueries(1024, time.Minute))
+//	client.Use(ext.Hook())
+//	srv.Use(ext)
+func PersistedQueries(size int, ttl time.Duration) Option {
+	return func(e *Extension) {
+		e.persisted = &opCaches{size: size, ttl: ttl, ll: list.New(), items: make(map[string]*list.Element)}
+	}
+}
+
+// Invalidate drops the caches of the persisted operations that
+// resolved fields of the given GraphQL types (e.g. "User").
+func (e *Extension) Invalidate(types ...string) {
+	if e.persisted != nil {
+		e.persisted.invalidate(types)
+	}
+}
+
+// Hook returns an ent hook that invalidates the caches of the persisted operations
+// that resolved the mutated entity type (see Invalidate). Note that it assumes the
+// GraphQL types are named after the ent types, as generated by entgql.
+func (e *Extension) Hook() ent.Hook {
+	return func(next ent.Mutator) ent.Mutator {
+		return ent.MutateFunc(func(ctx context.Context, m ent.Mutation) (ent.Value, error) {
+			v, err := next.Mutate(ctx, m)
+			if err == nil {
+				e.Invalidate(m.Type())
+			}
+			return v, err
+		})
+	}
+}
+
+// get returns the cache of the given operation, or nil if it is not a persisted query.
+func (c *opCaches) get(oc *graphql.OperationContext) *opCache {
+	if c == nil {
+		return nil
+	}
+	pq, _ := oc.Extensions["persistedQuery"].(map[string]any)
+	hash, _ := pq["sha256Hash"].(string)
+	if hash == "" {
+		return nil
+	}
+	// Map keys are sorted by the encoder, and therefore,
+	// equal variables are encoded to the same key.
+	vars, err := json.Marshal(oc.Variables)
+	if err != nil {
+		return nil
+	}
+	key := hash + ":" + oc.OperationName + ":" + string(vars)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		op := el.Value.(*opCache)
+		if op.expires.IsZero() || now.Before(op.expires) {
+			c.ll.MoveToFront(el)
+			return op
+		}
+		c.remove(el)
+	}
+	op := &opCache{key: key, lru: entcache.NewLRU(0), types: make(map[string]struct{})}
+	if c.ttl > 0 {
+		op.expires = now.Add(c.ttl)
+	}
+	c.items[key] = c.ll.PushFront(op)
+	if c.size > 0 && c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+	return op
+}
+
+// invalidate drops the caches of the operations that resolved one of the given types.
+func (c *opCaches) invalidate(types []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*opCache).resolves(types) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+func (c *opCaches) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*opCache).key)
+}
+
+// resolved records that the operation resolved a field of the given type.
+func (op *opCache) resolved(t string) {
+	op.mu.Lock()
+	op.types[t] = struct{}{}
+	op.mu.Unlock()
+}
+
+// resolves reports if the operation resolved a field of one of the given types.
+func (op *opCache) resolves(types []string) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	for _, t := range types {
+		if _, ok := op.types[t]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package gqlgen_test
+
+import (
+	"context"
+	"testing"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/gqlgen"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestPersistedQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.ContextLevel())
+	ext := gqlgen.New(gqlgen.PersistedQueries(2, 0))
+	// exec executes a persisted query that resolves a list of users.
+	exec := func(hash string, vars map[string]any, queried bool) {
+		t.Helper()
+		ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+			OperationName: "Users",
+			Operation:     &ast.OperationDefinition{Operation: ast.Query, Name: "Users"},
+			Variables:     vars,
+			Extensions:    map[string]any{"persistedQuery": map[string]any{"version": float64(1), "sha256Hash": hash}},
+		})
+		queries := drv.DBStats().Queries
+		ext.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+			ctx = graphql.WithFieldContext(ctx, &graphql.FieldContext{
+				Object: "Query",
+				Field: graphql.CollectedField{Field: &ast.Field{
+					Name:       "users",
+					Definition: &ast.FieldDefinition{Name: "users", Type: &ast.Type{Elem: &ast.Type{NamedType: "User"}}},
+				}},
+			})
+			if _, err := ext.InterceptField(ctx, func(ctx context.Context) (any, error) {
+				if queried {
+					mock.ExpectQuery("SELECT name FROM users").
+						WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+				}
+				rows := &sql.Rows{}
+				if err := drv.Query(ctx, "SELECT name FROM users", []any{}, rows); err != nil {
+					return nil, err
+				}
+				for rows.Next() {
+				}
+				return nil, rows.Close()
+			}); err != nil {
+				t.Fatal(err)
+			}
+			return &graphql.Response{}
+		})
+		if q := drv.DBStats().Queries - queries; (q > 0) != queried {
+			t.Fatalf("hash %s, vars %v: unexpected database queries: %d", hash, vars, q)
+		}
+	}
+	// Requests of the same operation and variables share their cache.
+	exec("h1", map[string]any{"first": 10}, true)
+	exec("h1", map[string]any{"first": 10}, false)
+	exec("h1", map[string]any{"first": 20}, true)
+	exec("h1", map[string]any{"first": 20}, false)
+	// Mutating users invalidates the operations that resolved them.
+	mutate := ext.Hook()(ent.MutateFunc(func(context.Context, ent.Mutation) (ent.Value, error) {
+		return nil, nil
+	}))
+	if _, err := mutate.Mutate(context.Background(), mutation{typ: "Group"}); err != nil {
+		t.Fatal(err)
+	}
+	exec("h1", map[string]any{"first": 10}, false)
+	if _, err := mutate.Mutate(context.Background(), mutation{typ: "User"}); err != nil {
+		t.Fatal(err)
+	}
+	exec("h1", map[string]any{"first": 10}, true)
+	exec("h1", map[string]any{"first": 20}, true)
+	// The least recently used operation is evicted.
+	exec("h2", nil, true)
+	exec("h1", map[string]any{"first": 20}, false)
+	exec("h1", map[string]any{"first": 10}, true)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mutation is an ent.Mutation of the given type.
+type mutation struct {
+	ent.Mutation
+	typ string
+}
+
+func (m mutation) Type() string {
+	return m.typ
+}
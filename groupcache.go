@@ -0,0 +1,175 @@
+package entcache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache"
+)
+
+type (
+	// Groupcache provides a peer-to-peer cache level backed by groupcache,
+	// and implements the AddGetDeleter interface. Each key is owned by one
+	// of the peers in the pool, and misses on one peer are filled by the
+	// owner of the key, if it holds the entry.
+	Groupcache struct {
+		pool   *groupcache.HTTPPool
+		group  *groupcache.Group
+		window time.Duration
+		// The entries that were added to this peer.
+		mu       sync.Mutex
+		store    *lruCache[string, []byte]
+		size     int64
+		maxBytes int64
+	}
+
+	// GroupcacheOption allows configuring the groupcache
+	// level using functional options.
+	GroupcacheOption func(*Groupcache)
+)
+
+// NewGroupcache returns a cache level that shares its entries with the peers of
+// the given pool, using the groupcache group with the given name. A query that
+// misses on one peer is served by the peer that owns its key, if that peer has
+// recorded the entry, instead of hitting the database again. The size bounds
+// both the entries recorded by this peer, and the entries groupcache caches
+// on behalf of the other peers.
+//
+//	pool := groupcache.NewHTTPPool("http://10.0.0.1:8080")
+//	pool.Set("http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080")
+//	go http.ListenAndServe(":8080", pool)
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(
+//			entcache.NewLRU(256),
+//			entcache.NewGroupcache(pool, "entcache", 64<<20),
+//		),
+//	)
+//
+// Groupcache does not support overriding or removing values. Hence, values are
+// cached by the peers for a limited window (see GroupcacheWindow), after which
+// they are loaded again from their owner. Within the window, peers may serve
+// entries that were deleted from their owner, but never entries that expired.
+//
+// Note that the group name must be unique in the process, as groupcache panics
+// if a group is registered twice.
+func NewGroupcache(pool *groupcache.HTTPPool, groupName string, sizeBytes int64, opts ...GroupcacheOption) *Groupcache {
+	g := &Groupcache{
+		pool:     pool,
+		window:   time.Minute,
+		maxBytes: sizeBytes,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.store = newLRUCache(func(_ string, v []byte) {
+		g.size -= int64(len(v))
+	})
+	g.group = groupcache.NewGroup(groupName, sizeBytes, groupcache.GetterFunc(g.load))
+	return g
+}
+
+// GroupcacheWindow configures the duration values are cached by the peers
+// before they are loaded again from their owner. The default is 1 minute.
+func GroupcacheWindow(d time.Duration) GroupcacheOption {
+	return func(g *Groupcache) {
+		g.window = d
+	}
+}
+
+// Add adds the entry to the cache. The entry is stored on this peer,
+// and is served to the other peers when this peer owns its key.
+func (g *Groupcache) Add(_ context.Context, k Key, e *Entry, ttl time.Duration) error {
+	key, err := MarshalKey(k)
+	if err != nil {
+		return err
+	}
+	buf, err := e.WithExpiry(ttl).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if v, _, ok := g.store.peek(key); ok {
+		g.size -= int64(len(v))
+	}
+	g.store.add(key, buf, time.Time{})
+	g.size += int64(len(buf))
+	for g.maxBytes > 0 && g.size > g.maxBytes && g.store.len() > 0 {
+		g.store.removeOldest()
+	}
+	return nil
+}
+
+// Get gets an entry from the cache. Entries are loaded from the peer
+// that owns the key, or from this peer if the owner does not hold it.
+// Note that a miss on the owner is counted by groupcache as a peer error.
+func (g *Groupcache) Get(ctx context.Context, k Key) (*Entry, error) {
+	key, err := MarshalKey(k)
+	if err != nil {
+		return nil, err
+	}
+	// Keys are versioned by the current window, to load
+	// their values again from their owner when it ends.
+	vkey := key + "@" + strconv.FormatInt(time.Now().UnixNano()/int64(g.window), 36)
+	var buf []byte
+	if _, remote := g.pool.PickPeer(vkey); remote {
+		err = g.group.Get(ctx, vkey, groupcache.AllocatingByteSliceSink(&buf))
+	} else {
+		// The owner reads its own entries directly, as
+		// they are up to date (e.g. deleted or replaced).
+		err = g.load(ctx, vkey, groupcache.AllocatingByteSliceSink(&buf))
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err := e.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	if e.Expired() {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// Del deletes an entry from this peer. Copies of the entry that are cached
+// by the other peers are dropped at the end of the current window.
+func (g *Groupcache) Del(_ context.Context, k Key) error {
+	key, err := MarshalKey(k)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.store.remove(key)
+	g.mu.Unlock()
+	return nil
+}
+
+// load loads the entry of the versioned key from the entries of this peer.
+// It is called by groupcache on the owner of the key, or on the requesting
+// peer if the owner failed loading it.
+func (g *Groupcache) load(_ context.Context, key string, dest groupcache.Sink) error {
+	if i := strings.LastIndexByte(key, '@'); i != -1 {
+		key = key[:i]
+	}
+	g.mu.Lock()
+	v, ok := g.store.get(key, time.Now())
+	g.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	e := &Entry{}
+	if err := e.UnmarshalBinary(v); err != nil {
+		return err
+	}
+	// Expired entries are not shared, as groupcache
+	// keeps them until the end of the window.
+	if e.Expired() {
+		return ErrNotFound
+	}
+	return dest.SetBytes(v)
+}
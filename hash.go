@@ -0,0 +1,123 @@
+package entcache
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TestHash configures the driver to use stable and human-readable cache keys that
+// are composed of the tables, the statement and the arguments of the query. It is
+// intended for tests that use the keys in their expectations (e.g. redismock) or
+// golden files, and should not be used in production.
+//
+//	drv := entcache.NewDriver(drv, entcache.TestHash())
+//	rmock.ExpectGet("users:SELECT name FROM users WHERE id = ?:[1]")
+func TestHash() Option {
+	return Hash(testHash)
+}
+
+// testHash returns the human-readable key of the query.
+func testHash(query string, args []any) (Key, error) {
+	return fmt.Sprintf("%s:%s:%v", strings.Join(queryTables(query), ","), query, args), nil
+}
+
+// basicHash streams the query and its arguments into an FNV-1a hash,
+// without allocating intermediate values. It reports false if one of
+// the arguments is not of a basic type.
+func basicHash(query string, args []any) (Key, bool) {
+	h := newHasher()
+	// Length-prefixed, so the query and the arguments cannot run together.
+	h.uint(5, uint64(len(query)))
+	h.string(query)
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			h.byte(0)
+		case bool:
+			h.byte(1)
+			if v {
+				h.byte(1)
+			} else {
+				h.byte(0)
+			}
+		case int:
+			h.int(2, int64(v))
+		case int8:
+			h.int(2, int64(v))
+		case int16:
+			h.int(2, int64(v))
+		case int32:
+			h.int(2, int64(v))
+		case int64:
+			h.int(2, v)
+		case uint:
+			h.uint(3, uint64(v))
+		case uint8:
+			h.uint(3, uint64(v))
+		case uint16:
+			h.uint(3, uint64(v))
+		case uint32:
+			h.uint(3, uint64(v))
+		case uint64:
+			h.uint(3, v)
+		case float32:
+			h.uint(4, math.Float64bits(float64(v)))
+		case float64:
+			h.uint(4, math.Float64bits(v))
+		case string:
+			h.uint(5, uint64(len(v)))
+			h.string(v)
+		case []byte:
+			h.uint(6, uint64(len(v)))
+			for _, b := range v {
+				h.byte(b)
+			}
+		case time.Time:
+			// UnixNano is undefined outside the years 1678-2262 (e.g. the zero time).
+			_, off := v.Zone()
+			h.int(7, v.Unix())
+			h.int(7, int64(v.Nanosecond()))
+			h.int(7, int64(off))
+		default:
+			return nil, false
+		}
+	}
+	return uint64(h), true
+}
+
+// hasher implements the 64-bit FNV-1a hash function.
+type hasher uint64
+
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+func newHasher() hasher {
+	return offset64
+}
+
+func (h *hasher) byte(b byte) {
+	*h ^= hasher(b)
+	*h *= prime64
+}
+
+func (h *hasher) string(s string) {
+	for i := 0; i < len(s); i++ {
+		h.byte(s[i])
+	}
+}
+
+// uint writes the type tag t, followed by the bytes of v.
+func (h *hasher) uint(t byte, v uint64) {
+	h.byte(t)
+	for i := 0; i < 8; i++ {
+		h.byte(byte(v >> (8 * i)))
+	}
+}
+
+func (h *hasher) int(t byte, v int64) {
+	h.uint(t, uint64(v))
+}
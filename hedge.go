@@ -0,0 +1,117 @@
+package entcache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+)
+
+// hedgedQuery looks up the query in the cache, and if the lookup was not answered
+// within the hedging delay, executes the query on the database in parallel. The
+// first successful result is used, and the other one is discarded.
+func (d *Driver) hedgedQuery(ctx context.Context, drv dialect.ExecQuerier, opts ctxOptions, query string, args any, vr *sql.Rows) error {
+	type result struct {
+		e   *Entry
+		err error
+		dec *Decision
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The lookup may outlive the query. Hence, it records
+	// its decision (i.e. level hit) on a private copy.
+	dec := &Decision{Level: -1}
+	lctx = context.WithValue(lctx, decisionKey{}, dec)
+	lookup := make(chan result, 1)
+	go func() {
+		e, err := d.get(lctx, opts)
+		lookup <- result{e: e, err: err, dec: dec}
+	}()
+	timer := time.NewTimer(opts.hedge)
+	select {
+	case r := <-lookup:
+		timer.Stop()
+		d.hedgeDecision(ctx, r.dec)
+		return d.lookup(ctx, drv, opts, query, args, vr, r.e, r.err)
+	case <-timer.C:
+	}
+	atomic.AddUint64(&d.stats.Hedges, 1)
+	rows := &sql.Rows{}
+	exec := make(chan error, 1)
+	// The query may outlive the lookup, and therefore, its duration is recorded
+	// only in the driver stats. It is canceled if the cache wins, and otherwise,
+	// its context is released when its rows are closed.
+	qctx, qcancel := context.WithCancel(context.WithValue(ctx, decisionKey{}, (*Decision)(nil)))
+	go func() {
+		exec <- d.dbQuery(qctx, drv, query, args, rows)
+	}()
+	// The lookup result is unknown if the database wins,
+	// and it is handled as a miss, like in Driver.lookup.
+	lerr := ErrNotFound
+	select {
+	case r := <-lookup:
+		if r.err == nil {
+			// Cache won, cancel the database query and discard its rows.
+			qcancel()
+			go func() {
+				if err := <-exec; err == nil {
+					rows.Close()
+				}
+			}()
+			d.hedgeDecision(ctx, r.dec)
+			d.hit(ctx, opts, query, vr, r.e)
+			return nil
+		}
+		if err := <-exec; err != nil {
+			qcancel()
+			return err
+		}
+		lerr = r.err
+	case err := <-exec:
+		if err != nil {
+			qcancel()
+			// Database failed, fall back to the cache lookup.
+			if r := <-lookup; r.err == nil {
+				d.hedgeDecision(ctx, r.dec)
+				d.hit(ctx, opts, query, vr, r.e)
+				return nil
+			}
+			return err
+		}
+		// Database won, cancel the lookup.
+		cancel()
+	}
+	vr.ColumnScanner = &hedgedRows{ColumnScanner: rows.ColumnScanner, cancel: qcancel}
+	switch {
+	case lerr == errInFlight, lerr == ErrNotFound && d.guard(ctx, opts):
+		// Another caller records the entry.
+		d.skip(ctx, SkipInFlight)
+	case lerr == ErrNotFound:
+		d.missed(ctx, drv, opts, query, args, vr)
+	default:
+		d.skip(ctx, cacheErrorReason(lerr))
+	}
+	return nil
+}
+
+// hedgedRows releases the context of a hedged
+// database query, when its rows are closed.
+type hedgedRows struct {
+	sql.ColumnScanner
+	cancel context.CancelFunc
+}
+
+// Close closes the rows, and cancels the query context.
+func (r *hedgedRows) Close() error {
+	defer r.cancel()
+	return r.ColumnScanner.Close()
+}
+
+// hedgeDecision copies the decision recorded by the lookup.
+func (d *Driver) hedgeDecision(ctx context.Context, dec *Decision) {
+	if d.Debug {
+		decisionFromContext(ctx).Level = dec.Level
+	}
+}
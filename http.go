@@ -0,0 +1,298 @@
+package entcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type (
+	// MiddlewareOption allows configuring the HTTP middleware
+	// using functional options.
+	MiddlewareOption func(*middleware)
+
+	// middleware holds the configuration of the HTTP middleware.
+	middleware struct {
+		filter    func(*http.Request) bool
+		cache     func() AddGetDeleter
+		authorize func(*http.Request) bool
+	}
+)
+
+// BypassHeader is the request header that is honored by the middleware
+// for bypassing the cache, if authorized. See MiddlewareBypass for more
+// info.
+const BypassHeader = "X-EntCache"
+
+// Middleware returns an HTTP middleware that wraps the request context with a
+// context-level cache (see NewContext and ContextLevel). By default, a new LRU
+// cache is attached to GET and HEAD requests.
+//
+//	srv.Use(entcache.Middleware())
+//
+// The middleware follows the standard net/http signature. Routes opt-in by
+// registering the middleware on them, instead of on the router:
+//
+//	r := chi.NewRouter()
+//	r.With(entcache.Middleware()).Get("/users", listUsers)
+//
+// Adapters for common frameworks (e.g. ariga.io/entcache/echo) are provided by
+// separate modules. Other frameworks can use RequestWrapper.
+func Middleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	wrap := RequestWrapper(opts...)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, wrap(r))
+		})
+	}
+}
+
+// RequestWrapper returns a function that wraps the request context like Middleware
+// does, and accepts the same options. It allows integrating frameworks that expose
+// the *http.Request, but do not support net/http middleware, like gin:
+//
+//	wrap := entcache.RequestWrapper()
+//	r.GET("/users", func(c *gin.Context) {
+//		c.Request = wrap(c.Request)
+//		c.Next()
+//	}, listUsers)
+func RequestWrapper(opts ...MiddlewareOption) func(*http.Request) *http.Request {
+	m := &middleware{
+		filter: func(r *http.Request) bool {
+			return r.Method == http.MethodGet || r.Method == http.MethodHead
+		},
+		cache: func() AddGetDeleter {
+			return NewLRU(0)
+		},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return func(r *http.Request) *http.Request {
+		if m.filter(r) {
+			r = r.WithContext(NewContext(r.Context(), m.cache()))
+		}
+		if m.authorize != nil && r.Header.Get(BypassHeader) != "" {
+			r = m.bypass(r)
+		}
+		return r
+	}
+}
+
+// MiddlewareFilter configures the requests that are wrapped with a context-level
+// cache by the middleware. It can be used for opting-in or out specific routes.
+//
+//	entcache.Middleware(
+//		entcache.MiddlewareFilter(func(r *http.Request) bool {
+//			return r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, "/admin")
+//		}),
+//	)
+func MiddlewareFilter(f func(*http.Request) bool) MiddlewareOption {
+	return func(m *middleware) {
+		m.filter = f
+	}
+}
+
+// MiddlewareCache configures the function that creates the cache attached to each
+// request. For example, for limiting the number of entries cached by a request:
+//
+//	entcache.Middleware(
+//		entcache.MiddlewareCache(func() entcache.AddGetDeleter {
+//			return entcache.NewLRU(128)
+//		}),
+//	)
+func MiddlewareCache(f func() AddGetDeleter) MiddlewareOption {
+	return func(m *middleware) {
+		m.cache = f
+	}
+}
+
+// MiddlewareBypass configures the middleware to honor the BypassHeader of requests
+// that are approved by the given authorization hook. It allows support engineers
+// to verify live data without code changes. The supported values are:
+//
+//	skip     skip the cache for the request (see Skip).
+//	refresh  execute the queries of the request and refresh their entries (see Refresh).
+//	evict    execute the queries of the request and evict their entries (see Evict).
+//
+// For example, for allowing super-users to bypass the cache:
+//
+//	entcache.Middleware(
+//		entcache.MiddlewareBypass(func(r *http.Request) bool {
+//			u, ok := auth.UserFromContext(r.Context())
+//			return ok && u.IsAdmin()
+//		}),
+//	)
+//
+//	curl -H "X-EntCache: skip" localhost:8080/users
+//
+// The header is ignored for unauthorized requests, and for requests with an unknown value.
+func MiddlewareBypass(authorize func(*http.Request) bool) MiddlewareOption {
+	return func(m *middleware) {
+		m.authorize = authorize
+	}
+}
+
+// bypass returns the request with the context that is
+// derived from its bypass header, if it is authorized.
+func (m *middleware) bypass(r *http.Request) *http.Request {
+	var wrap func(context.Context) context.Context
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get(BypassHeader))) {
+	case "skip":
+		wrap = Skip
+	case "refresh":
+		wrap = Refresh
+	case "evict":
+		wrap = Evict
+	default:
+		return r
+	}
+	if !m.authorize(r) {
+		return r
+	}
+	return r.WithContext(wrap(r.Context()))
+}
+
+// EvictHandler returns an HTTP handler for evicting cache entries in bulk. It
+// accepts POST and DELETE requests, and evicts the entries that match the
+// given form values:
+//
+//	fingerprint  entries of queries with the given fingerprint (see TagFingerprints).
+//	query        entries of queries with the fingerprint of the given query.
+//	tag          entries with the given tag (see WithTags).
+//	name         entries of the given operation name (see WithName).
+//
+// For example, evicting every cached page of a list:
+//
+//	http.Handle("/admin/cache/evict", entcache.EvictHandler(drv))
+//
+//	curl -X POST "localhost:8080/admin/cache/evict?fingerprint=af63ad4c86019caf"
+//
+// Note that the handler should be exposed only to trusted (admin) clients.
+func EvictHandler(d *Driver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var tags []string
+		for _, fp := range r.Form["fingerprint"] {
+			tags = append(tags, fingerprintTag(fp))
+		}
+		for _, q := range r.Form["query"] {
+			tags = append(tags, fingerprintTag(Fingerprint(q)))
+		}
+		tags = append(tags, r.Form["tag"]...)
+		for _, name := range r.Form["name"] {
+			tags = append(tags, nameTag(name))
+		}
+		if len(tags) == 0 {
+			http.Error(w, "missing fingerprint, query, tag or name", http.StatusBadRequest)
+			return
+		}
+		if err := d.EvictTags(r.Context(), tags...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// MarshalJSON implements the json.Marshaler interface. Skips are
+// encoded as an object, keyed by the label of their SkipReason.
+//
+//	{"gets": 10, "hits": 8, "errors": 0, ..., "skips": {"not_select": 2, ...}}
+func (s Stats) MarshalJSON() ([]byte, error) {
+	skips := make(map[string]uint64, len(s.Skips))
+	for r, n := range s.Skips {
+		skips[SkipReason(r).String()] = n
+	}
+	return json.Marshal(struct {
+		Gets         uint64            `json:"gets"`
+		Hits         uint64            `json:"hits"`
+		Errors       uint64            `json:"errors"`
+		Hedges       uint64            `json:"hedges"`
+		EvictErrors  uint64            `json:"evict_errors"`
+		RowsLimited  uint64            `json:"rows_limited"`
+		BytesLimited uint64            `json:"bytes_limited"`
+		EncodeErrors uint64            `json:"encode_errors"`
+		ClampedTTLs  uint64            `json:"clamped_ttls"`
+		Swept        uint64            `json:"swept"`
+		AsyncErrors  uint64            `json:"async_errors"`
+		Skips        map[string]uint64 `json:"skips"`
+	}{
+		Gets:         s.Gets,
+		Hits:         s.Hits,
+		Errors:       s.Errors,
+		Hedges:       s.Hedges,
+		EvictErrors:  s.EvictErrors,
+		RowsLimited:  s.RowsLimited,
+		BytesLimited: s.BytesLimited,
+		EncodeErrors: s.EncodeErrors,
+		ClampedTTLs:  s.ClampedTTLs,
+		Swept:        s.Swept,
+		AsyncErrors:  s.AsyncErrors,
+		Skips:        skips,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// It decodes the format that is encoded by MarshalJSON.
+func (s *Stats) UnmarshalJSON(buf []byte) error {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+	fields := make(map[string]uint64, len(v))
+	for k, raw := range v {
+		if k == "skips" {
+			var skips map[string]uint64
+			if err := json.Unmarshal(raw, &skips); err != nil {
+				return err
+			}
+			for r, n := range skips {
+				fields["skips."+r] = n
+			}
+			continue
+		}
+		var n uint64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return err
+		}
+		fields[k] = n
+	}
+	*s = statsFromFields(fields)
+	return nil
+}
+
+// StatsHandler returns an HTTP handler that writes the statistics of
+// the driver as JSON. It allows services to expose a consistent stats
+// endpoint:
+//
+//	http.Handle("/stats", entcache.StatsHandler(drv))
+//
+// If the driver persists its statistics (see PersistStats), requests with
+// the "total" query parameter (e.g. /stats?total) are answered with the
+// statistics across restarts, instead of the statistics since boot.
+func StatsHandler(d *Driver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := d.Stats()
+		if r.URL.Query().Has("total") && d.StatsStore != nil {
+			var err error
+			if s, err = d.TotalStats(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
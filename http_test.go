@@ -0,0 +1,251 @@
+package entcache_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ariga.io/entcache"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMiddleware(t *testing.T) {
+	var cached bool
+	h := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		_, cached = entcache.FromContext(r.Context())
+	})
+	tests := []struct {
+		mw     func(http.Handler) http.Handler
+		method string
+		path   string
+		cached bool
+	}{
+		{mw: entcache.Middleware(), method: http.MethodGet, path: "/", cached: true},
+		{mw: entcache.Middleware(), method: http.MethodPost, path: "/"},
+		{
+			mw: entcache.Middleware(entcache.MiddlewareFilter(func(r *http.Request) bool {
+				return !strings.HasPrefix(r.URL.Path, "/admin")
+			})),
+			method: http.MethodGet,
+			path:   "/admin/users",
+		},
+		{
+			mw: entcache.Middleware(entcache.MiddlewareFilter(func(r *http.Request) bool {
+				return !strings.HasPrefix(r.URL.Path, "/admin")
+			})),
+			method: http.MethodPost,
+			path:   "/graphql",
+			cached: true,
+		},
+	}
+	for _, tt := range tests {
+		cached = false
+		tt.mw(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if cached != tt.cached {
+			t.Errorf("%s %s: cached = %t, want %t", tt.method, tt.path, cached, tt.cached)
+		}
+	}
+}
+
+func TestRequestWrapper(t *testing.T) {
+	wrap := entcache.RequestWrapper(entcache.MiddlewareFilter(func(r *http.Request) bool {
+		return r.URL.Path == "/users"
+	}))
+	for path, cached := range map[string]bool{"/users": true, "/admin": false} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if _, ok := entcache.FromContext(wrap(r).Context()); ok != cached {
+			t.Errorf("%s: cached = %t, want %t", path, ok, cached)
+		}
+		// The original request is not modified.
+		if _, ok := entcache.FromContext(r.Context()); ok {
+			t.Errorf("%s: unexpected cache in original request", path)
+		}
+	}
+}
+
+func TestDetach(t *testing.T) {
+	ctx := entcache.NewContext(context.Background())
+	ctx = entcache.Detach(ctx)
+	if _, ok := entcache.FromContext(ctx); ok {
+		t.Fatal("expected the context cache to be detached")
+	}
+	if entries := entcache.ContextEntries(ctx); entries != nil {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	// Contexts without a cache are returned as is.
+	if bg := context.Background(); entcache.Detach(bg) != bg {
+		t.Fatal("expected the context to be returned as is")
+	}
+}
+
+func TestMiddlewareBypass(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	h := entcache.Middleware(entcache.MiddlewareBypass(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "admin"
+	}))(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		rows := &sql.Rows{}
+		if err := drv.Query(r.Context(), "SELECT name FROM users", []interface{}{}, rows); err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+	}))
+	tests := []struct {
+		header, auth string
+		query        bool
+	}{
+		{query: true},
+		{},
+		{header: "skip", auth: "admin", query: true},
+		{header: "skip", auth: "user"},
+		{header: "unknown", auth: "admin"},
+		{header: "Refresh", auth: "admin", query: true},
+		{},
+	}
+	for _, tt := range tests {
+		if tt.query {
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(entcache.BypassHeader, tt.header)
+		req.Header.Set("Authorization", tt.auth)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("header %q (%s): %v", tt.header, tt.auth, err)
+		}
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	drv := entcache.NewDriver(nil)
+	rec := httptest.NewRecorder()
+	entcache.StatsHandler(drv).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+	var stats struct {
+		Gets  uint64            `json:"gets"`
+		Skips map[string]uint64 `json:"skips"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Gets != 0 || len(stats.Skips) != len(entcache.Stats{}.Skips) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if _, ok := stats.Skips[entcache.SkipNotSelect.String()]; !ok {
+		t.Fatalf("expected skips to be keyed by their reason: %v", stats.Skips)
+	}
+}
+
+func TestEvictHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.TagFingerprints())
+	pages := []string{"SELECT id FROM todos LIMIT 10", "SELECT id FROM todos LIMIT 10 OFFSET 10"}
+	if entcache.Fingerprint(pages[0]) != entcache.Fingerprint(pages[1]) {
+		t.Fatal("expected pages to share the same fingerprint")
+	}
+	for i := 0; i < 2; i++ {
+		for j, page := range pages {
+			mock.ExpectQuery(page).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(j))
+			expectQuery(ctx, t, drv, page, []interface{}{int64(j)})
+			expectQuery(ctx, t, drv, page, []interface{}{int64(j)})
+		}
+		form := url.Values{"fingerprint": {entcache.Fingerprint(pages[0])}}
+		if i > 0 {
+			form = url.Values{"query": {pages[1]}}
+		}
+		rec := httptest.NewRecorder()
+		entcache.EvictHandler(drv).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/evict?"+form.Encode(), nil))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("unexpected status code: %d: %s", rec.Code, rec.Body)
+		}
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		rec := httptest.NewRecorder()
+		entcache.EvictHandler(drv).ServeHTTP(rec, httptest.NewRequest(method, "/evict", nil))
+		if rec.Code != http.StatusMethodNotAllowed && rec.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status code for %s without values: %d", method, rec.Code)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidator(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.RecordProvenance("node-1"))
+	var served int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := entcache.WithValidator(r.Context())
+		if r.URL.Query().Has("skip") {
+			ctx = entcache.Skip(ctx)
+		}
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+		if v, ok := entcache.Validator(ctx); ok && v.NotModified(w, r) {
+			return
+		}
+		served++
+	})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	etag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("unexpected response: %d %v", rec.Code, rec.Header())
+	}
+
+	// Requests that are served from the cache share the same validators.
+	for _, hdr := range [][2]string{{"If-None-Match", etag}, {"If-None-Match", `W/"other", ` + etag}, {"If-Modified-Since", modified}} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(hdr[0], hdr[1])
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != etag {
+			t.Fatalf("unexpected response for %s: %d %v", hdr[0], rec.Code, rec.Header())
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || served != 2 {
+		t.Fatalf("unexpected response: %d", rec.Code)
+	}
+
+	// Queries that bypass the cache are not covered by validators.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	req = httptest.NewRequest(http.MethodGet, "/?skip", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" {
+		t.Fatalf("unexpected response: %d %v", rec.Code, rec.Header())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+package entcache
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxInterned bounds the number of distinct column sets that are interned.
+// Column sets that are added after the limit was reached are stored as-is.
+const maxInterned = 4096
+
+// columns interns the column names of entries that are stored in the in-process
+// levels. Entries of the same query share the same immutable slice, instead of
+// holding a copy of it for each entry.
+var columns = &interner{sets: make(map[string][]string)}
+
+// interner holds shared column slices, keyed by their joined names.
+type interner struct {
+	mu   sync.RWMutex
+	sets map[string][]string
+}
+
+// intern returns the shared slice that is equal to the given columns.
+// The returned slice must not be modified.
+func (i *interner) intern(cols []string) []string {
+	if len(cols) == 0 {
+		return cols
+	}
+	k := strings.Join(cols, "\x00")
+	i.mu.RLock()
+	shared, ok := i.sets[k]
+	i.mu.RUnlock()
+	if ok {
+		return shared
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if shared, ok := i.sets[k]; ok {
+		return shared
+	}
+	if len(i.sets) >= maxInterned {
+		return cols
+	}
+	// Trim the capacity, to prevent appends from writing to the shared array.
+	i.sets[k] = cols[:len(cols):len(cols)]
+	return i.sets[k]
+}
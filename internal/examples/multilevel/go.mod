@@ -18,6 +18,7 @@ require (
 	github.com/99designs/gqlgen v0.14.0
 	github.com/alecthomas/kong v0.2.16
 	github.com/mattn/go-sqlite3 v1.14.8
+	github.com/redis/go-redis/v9 v9.0.5
 	github.com/vektah/gqlparser/v2 v2.2.0
 )
 
@@ -27,7 +28,6 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-openapi/inflect v0.19.0 // indirect
-	github.com/go-redis/redis/v8 v8.11.3 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
@@ -18,8 +18,8 @@ import (
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/alecthomas/kong"
-	"github.com/go-redis/redis/v8"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -62,14 +62,13 @@ func main() {
 	srv.Use(entgql.Transactioner{TxOpener: client})
 	http.Handle("/", playground.Handler("Todo", "/query"))
 	http.Handle("/query", srv)
-	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		if cd, ok := drv.(*entcache.Driver); ok {
-			stat := cd.Stats()
-			fmt.Fprintf(w, "cache stats (gets: %d, hits: %d, errors: %d)\n", stat.Gets, stat.Hits, stat.Errors)
-		} else {
+	if cd, ok := drv.(*entcache.Driver); ok {
+		http.Handle("/stats", entcache.StatsHandler(cd))
+	} else {
+		http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintln(w, "cache mode is not enabled")
-		}
-	})
+		})
+	}
 	log.Println("listening on", cli.Addr)
 	if err := http.ListenAndServe(cli.Addr, nil); err != nil {
 		log.Fatal("http server terminated", err)
@@ -0,0 +1,170 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Invalidator defines a strategy for invalidating the cache entries that are
+// affected by statements that modify data. The driver intercepts both queries
+// and writes, and therefore, invalidation does not require ent hooks.
+type Invalidator interface {
+	// Tags returns the tags of the entry that is recorded for the given query.
+	Tags(query string) []string
+	// Key returns the cache key of the given query, derived from its hash key.
+	Key(query string, k Key) Key
+	// Invalidate invalidates the entries in the given cache that are
+	// affected by the given statement, after it was executed.
+	Invalidate(ctx context.Context, c AddGetDeleter, query string) error
+}
+
+// Invalidate configures the driver to invalidate cache entries on writes using
+// the given strategies. For example:
+//
+//	entcache.NewDriver(drv, entcache.Invalidate(entcache.InvalidateGenerations()))
+//
+// Writes that are executed in transactions are invalidated once committed.
+func Invalidate(strategies ...Invalidator) Option {
+	return func(o *Options) {
+		o.Invalidators = append(o.Invalidators, strategies...)
+	}
+}
+
+// InvalidateTables returns a strategy that tags entries with the tables they
+// read, and evicts the entries of the tables that are modified by statements.
+// The cache must support tagging (see Tagger). It is equivalent to using the
+// EvictWrites option with the EvictAfterWrite mode.
+func InvalidateTables() Invalidator {
+	return InvalidateTags(func(query string) []string {
+		tables := queryTables(query)
+		tags := make([]string, len(tables))
+		for i, t := range tables {
+			tags[i] = tableTag(t)
+		}
+		return tags
+	})
+}
+
+// InvalidateTags returns a strategy that tags entries with the tags that are
+// returned by f for their query, and evicts the tags that are returned by f for
+// statements that modify data. The cache must support tagging (see Tagger).
+//
+//	entcache.InvalidateTags(func(query string) []string {
+//		if strings.Contains(query, "`todos`") {
+//			return []string{"todos"}
+//		}
+//		return nil
+//	})
+func InvalidateTags(f func(query string) []string) Invalidator {
+	return tagInvalidator(f)
+}
+
+// tagInvalidator implements the InvalidateTags strategy.
+type tagInvalidator func(string) []string
+
+func (f tagInvalidator) Tags(query string) []string { return f(query) }
+
+func (tagInvalidator) Key(_ string, k Key) Key { return k }
+
+func (f tagInvalidator) Invalidate(ctx context.Context, c AddGetDeleter, query string) error {
+	tags := f(query)
+	if len(tags) == 0 {
+		return nil
+	}
+	t, ok := c.(Tagger)
+	if !ok {
+		return fmt.Errorf("entcache: cache %T does not support tags", c)
+	}
+	for _, tag := range tags {
+		if err := t.EvictTag(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateGenerations returns a strategy that keeps a generation counter
+// for each table, and includes the generations of the tables that a query
+// reads in its cache key. Statements that modify a table increment its
+// generation, and therefore, entries of previous generations are no longer
+// reachable and age out of the cache according to their TTL. Unlike the
+// other strategies, it does not require the cache to support tagging.
+//
+// Note that generations are kept in-process. Hence, processes that share a
+// remote level do not observe the writes of each other.
+func InvalidateGenerations() Invalidator {
+	return &genInvalidator{gens: make(map[string]uint64)}
+}
+
+// GenKey is the cache key of a query, that is
+// scoped by the generations of the tables it reads.
+type GenKey struct {
+	Key Key
+	Gen uint64
+}
+
+// MarshalKey implements the KeyMarshaler interface.
+func (k GenKey) MarshalKey() (string, error) {
+	key, err := MarshalKey(k.Key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:g%d", key, k.Gen), nil
+}
+
+// genInvalidator implements the InvalidateGenerations strategy.
+type genInvalidator struct {
+	mu   sync.RWMutex
+	gens map[string]uint64
+	// next is the last generation that was assigned. Generations are
+	// unique across tables, and therefore, a table never returns to a
+	// generation it had before.
+	next uint64
+}
+
+func (*genInvalidator) Tags(string) []string { return nil }
+
+func (g *genInvalidator) Key(query string, k Key) Key {
+	tables := queryTables(query)
+	if len(tables) == 0 {
+		return k
+	}
+	sort.Strings(tables)
+	h := newHasher()
+	g.mu.RLock()
+	for _, t := range tables {
+		h.string(t)
+		h.uint(0, g.gens[t])
+	}
+	g.mu.RUnlock()
+	return GenKey{Key: k, Gen: uint64(h)}
+}
+
+func (g *genInvalidator) Invalidate(_ context.Context, _ AddGetDeleter, query string) error {
+	tables := queryTables(query)
+	if len(tables) == 0 {
+		return nil
+	}
+	g.mu.Lock()
+	for _, t := range tables {
+		g.next++
+		g.gens[t] = g.next
+	}
+	g.mu.Unlock()
+	return nil
+}
+
+// invalidate runs the invalidation strategies of the driver for the given statement.
+func (d *Driver) invalidate(ctx context.Context, query string) {
+	for _, inv := range d.Invalidators {
+		if err := inv.Invalidate(ctx, d.Cache, query); err != nil {
+			atomic.AddUint64(&d.stats.EvictErrors, 1)
+			if d.Log != nil {
+				d.Log(fmt.Sprintf("entcache: failed invalidating entries of statement %q: %v", query, err))
+			}
+		}
+	}
+}
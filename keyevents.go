@@ -0,0 +1,160 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyEvents configures the driver to subscribe to the keyspace notifications of the
+// given Redis, and evict entries from the in-process levels (e.g. LRU and ProcLRU) when
+// their keys are deleted, expired or evicted in Redis. It keeps the levels of a
+// multi-level cache consistent, even when entries are removed only on the Redis side
+// (e.g. evicted by another process, or by the Redis maxmemory policy).
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(entcache.NewLRU(256), entcache.NewRedis(rdb)),
+//		entcache.KeyEvents(rdb),
+//	)
+//
+// Redis must be configured to publish the events, for example:
+//
+//	CONFIG SET notify-keyspace-events Egxe
+//
+// Note that only entries that were added to Redis by the driver after it was created
+// are evicted, and that the subscription is closed when the driver is closed. The
+// driver tracks the names of the most recently added keys (up to 64K), until they
+// are deleted or expire.
+func KeyEvents(rdb redis.UniversalClient) Option {
+	return func(o *Options) {
+		o.KeyEvents = rdb
+	}
+}
+
+// keyEventsPatterns match the channels of the key events that remove keys.
+var keyEventsPatterns = []string{
+	"__keyevent@*__:del",
+	"__keyevent@*__:expired",
+	"__keyevent@*__:evicted",
+}
+
+// listenKeyEvents evicts entries from the in-process levels of the given
+// cache on key events, until ctx is canceled.
+func (d *Driver) listenKeyEvents(ctx context.Context, m *multiLevel, sub *redis.PubSub) {
+	defer sub.Close()
+	var local []AddGetDeleter
+	for _, l := range m.levels {
+		if isLocal(l) {
+			local = append(local, l)
+		}
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			k, ok := m.names.take(stripHashTag(msg.Payload))
+			if !ok {
+				continue
+			}
+			for _, l := range local {
+				if err := l.Del(ctx, k); err != nil && d.Log != nil {
+					d.Log(fmt.Sprintf("entcache: failed evicting entry %v on key event %q: %v", k, msg.Channel, err))
+				}
+			}
+		}
+	}
+}
+
+// isLocal reports if the level is an in-process level, that is evicted on key events.
+func isLocal(l AddGetDeleter) bool {
+	switch l.(type) {
+	case *LRU, *ProcLRU, *ShardedLRU, *ARC, *TinyLFU:
+		return true
+	}
+	return false
+}
+
+// maxKeyNames is the maximum number of key names that are tracked for key events.
+const maxKeyNames = 1 << 16
+
+// keyNames maps the Redis names of keys to the keys that were added to the cache.
+// Names are kept until their entries expire, and the least recently added names
+// are dropped when the limit is reached.
+type keyNames struct {
+	mu  sync.Mutex
+	max int
+	c   *lruCache[string, Key]
+}
+
+// newKeyNames returns a new keyNames that holds at most max names.
+func newKeyNames(max int) *keyNames {
+	return &keyNames{max: max, c: newLRUCache[string, Key](nil)}
+}
+
+// added records the name of the given key, if it was added to a remote
+// level. Entries of the in-process levels do not trigger key events.
+func (n *keyNames) added(l AddGetDeleter, k Key, e *Entry) {
+	if n == nil || isLocal(l) {
+		return
+	}
+	name, err := MarshalKey(k)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.c.sweep(time.Now())
+	n.c.add(name, k, e.Expiry)
+	n.c.trim(n.max)
+}
+
+// take removes the name from the map and returns its key, if it exists.
+func (n *keyNames) take(name string) (Key, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	k, ok := n.c.get(name, time.Now())
+	if ok {
+		n.c.remove(name)
+	}
+	return k, ok
+}
+
+// del deletes the name of the given key.
+func (n *keyNames) del(k Key) {
+	if n == nil {
+		return
+	}
+	if name, err := MarshalKey(k); err == nil {
+		n.mu.Lock()
+		n.c.remove(name)
+		n.mu.Unlock()
+	}
+}
+
+// len returns the number of tracked names.
+func (n *keyNames) len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.c.len()
+}
+
+// startKeyEvents starts listening to key events, if configured.
+func (d *Driver) startKeyEvents() {
+	m, ok := d.Cache.(*multiLevel)
+	if d.KeyEvents == nil || !ok {
+		return
+	}
+	m.names = newKeyNames(maxKeyNames)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stopEvents = cancel
+	go d.listenKeyEvents(ctx, m, d.KeyEvents.PSubscribe(ctx, keyEventsPatterns...))
+}
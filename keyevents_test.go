@@ -0,0 +1,82 @@
+package entcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// remoteLevel is a non-local level that fails its adds, if err is set.
+type remoteLevel struct {
+	*LRU
+	err error
+}
+
+func (r *remoteLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.LRU.Add(ctx, k, e, ttl)
+}
+
+func TestKeyNames(t *testing.T) {
+	ctx := context.Background()
+	remote := &remoteLevel{LRU: NewLRU(0)}
+	m := newMultiLevel(NewLRU(0), remote)
+	m.names = newKeyNames(2)
+
+	// Failed remote adds are not tracked.
+	remote.err = errors.New("unavailable")
+	if err := m.Add(ctx, "a", &Entry{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.names.len(); n != 0 {
+		t.Fatalf("names = %d, want 0", n)
+	}
+	remote.err = nil
+
+	// Names are bounded.
+	for _, k := range []string{"a", "b", "c"} {
+		if err := m.Add(ctx, k, &Entry{}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.names.len(); n != 2 {
+		t.Fatalf("names = %d, want 2", n)
+	}
+	if _, ok := m.names.take("a"); ok {
+		t.Fatal("expected the oldest name to be dropped")
+	}
+	if k, ok := m.names.take("c"); !ok || k != "c" {
+		t.Fatalf("take = %v, %v, want c", k, ok)
+	}
+
+	// Deleted and expired entries release their names.
+	if err := m.Del(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.names.len(); n != 0 {
+		t.Fatalf("names = %d, want 0", n)
+	}
+	if err := m.Add(ctx, "d", &Entry{}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := m.Add(ctx, "e", &Entry{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.names.len(); n != 1 {
+		t.Fatalf("names = %d, want 1", n)
+	}
+
+	// Entries of local levels only are not tracked.
+	l := newMultiLevel(NewLRU(0), NewLRU(0))
+	l.names = newKeyNames(2)
+	if err := l.Add(ctx, "a", &Entry{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.names.len(); n != 0 {
+		t.Fatalf("names = %d, want 0", n)
+	}
+}
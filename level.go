@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"database/sql/driver"
+	"encoding"
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/groupcache/lru"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -19,6 +22,13 @@ type (
 	Entry struct {
 		Columns []string
 		Values  [][]driver.Value
+		// Expiry holds the logical expiration time of the entry. If set,
+		// levels that support it treat the entry as missing after this time,
+		// regardless of the expiration time of the underlying storage.
+		Expiry time.Time
+		// Provenance describes the origin of the entry, if
+		// recorded by the driver (see RecordProvenance).
+		Provenance *Provenance
 	}
 
 	// A Key defines a comparable Go value.
@@ -27,6 +37,11 @@ type (
 
 	// AddGetDeleter defines the interface for getting,
 	// adding and deleting entries from the cache.
+	//
+	// Get returns ErrNotFound if the entry does not exist in the cache (or
+	// expired). Del is idempotent, and deleting a key that does not exist in
+	// the cache is a no-op that does not return an error. The leveltest package
+	// provides a test suite for verifying custom implementations.
 	AddGetDeleter interface {
 		Del(context.Context, Key) error
 		Add(context.Context, Key, *Entry, time.Duration) error
@@ -34,6 +49,39 @@ type (
 	}
 )
 
+// KeyMarshaler is an optional interface implemented by keys that control their
+// serialization in remote levels (e.g. Redis). For example, structured keys that
+// are composed of a tenant and a query fingerprint:
+//
+//	func (k TenantKey) MarshalKey() (string, error) {
+//		return fmt.Sprintf("%s:%d", k.Tenant, k.Hash), nil
+//	}
+//
+// Keys that implement the encoding.TextMarshaler interface are also supported.
+type KeyMarshaler interface {
+	MarshalKey() (string, error)
+}
+
+// MarshalKey returns the string representation of the key that is used by
+// remote levels. Keys are serialized using their MarshalKey or MarshalText
+// methods if implemented, and using fmt.Sprint otherwise.
+func MarshalKey(k Key) (string, error) {
+	switch k := k.(type) {
+	case string:
+		return k, nil
+	case KeyMarshaler:
+		return k.MarshalKey()
+	case encoding.TextMarshaler:
+		b, err := k.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return fmt.Sprint(k), nil
+	}
+}
+
 func init() {
 	// Register non builtin driver.Values.
 	gob.Register(time.Time{})
@@ -44,9 +92,13 @@ func (e Entry) MarshalBinary() ([]byte, error) {
 	entry := struct {
 		C []string
 		V [][]driver.Value
+		E time.Time
+		P *Provenance
 	}{
 		C: e.Columns,
 		V: e.Values,
+		E: e.Expiry,
+		P: e.Provenance,
 	}
 	var buf bytes.Buffer
 	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
@@ -56,100 +108,273 @@ func (e Entry) MarshalBinary() ([]byte, error) {
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// Compressed entries (see Compression) are decompressed, regardless of
+// the configuration of the reader.
 func (e *Entry) UnmarshalBinary(buf []byte) error {
 	var entry struct {
 		C []string
 		V [][]driver.Value
+		E time.Time
+		P *Provenance
+	}
+	buf, err := unwrap(buf)
+	if err != nil {
+		return err
 	}
 	if err := gob.NewDecoder(bytes.NewBuffer(buf)).Decode(&entry); err != nil {
 		return err
 	}
 	e.Values = entry.V
 	e.Columns = entry.C
+	e.Expiry = entry.E
+	e.Provenance = entry.P
 	return nil
 }
 
-// ErrNotFound is returned by Get when and Entry does not exist in the cache.
-var ErrNotFound = errors.New("entcache: entry was not found")
+// Expired reports if the logical expiration time of the entry has passed.
+// Levels should treat expired entries as missing.
+func (e *Entry) Expired() bool {
+	return !e.Expiry.IsZero() && !time.Now().Before(e.Expiry)
+}
 
-type (
-	// LRU provides an LRU cache that implements the AddGetter interface.
-	LRU struct {
-		mu sync.Mutex
-		*lru.Cache
+// Remaining returns the given TTL, capped by the remaining lifetime of the
+// entry, if it carries an expiration time. Levels use it for setting the
+// expiration of the stored items.
+func (e *Entry) Remaining(ttl time.Duration) time.Duration {
+	if e.Expiry.IsZero() {
+		return ttl
 	}
-	// entry wraps the Entry with additional expiry information.
-	entry struct {
-		*Entry
-		expiry time.Time
+	rem := time.Until(e.Expiry)
+	if rem < time.Millisecond {
+		// Zero means no expiration. Expired entries are
+		// not returned by the levels regardless.
+		rem = time.Millisecond
 	}
-)
+	if ttl <= 0 || rem < ttl {
+		return rem
+	}
+	return ttl
+}
+
+// WithExpiry returns the entry with its absolute expiration time set according
+// to the given TTL. Hence, all levels that store the entry (or copy it between
+// them) share the same deadline, instead of restarting its TTL.
+func (e *Entry) WithExpiry(ttl time.Duration) *Entry {
+	if ttl <= 0 {
+		return e
+	}
+	exp := time.Now().Add(ttl)
+	if !e.Expiry.IsZero() && !exp.Before(e.Expiry) {
+		return e
+	}
+	ne := *e
+	ne.Expiry = exp
+	return &ne
+}
+
+// ErrNotFound is returned by Get when and Entry does not exist in the cache.
+var ErrNotFound = errors.New("entcache: entry was not found")
+
+// LRU provides an LRU cache that implements the AddGetter interface.
+// The column names of its entries are interned, and entries with the
+// same columns (e.g. of the same query) share an immutable slice.
+type LRU struct {
+	// MaxEntries is the maximum number of entries before
+	// an entry is evicted. Zero means no limit.
+	MaxEntries int
+
+	// PreserveRecency indicates if adding an entry with the same content (i.e.
+	// columns and values) as the stored one keeps its position in the eviction
+	// order, instead of marking it as recently used. Hence, background refreshes
+	// (see Refresh) and warm-up loops do not evict the keys that are actually hot.
+	// Its TTL is renewed regardless. Note that, it costs an additional encoding of
+	// the entry for computing its checksum on every add.
+	PreserveRecency bool
+
+	mu   sync.Mutex
+	c    *lruCache[Key, *Entry]
+	tags map[string]map[Key]struct{}
+	// keyTags is the reverse index of tags, for dropping the
+	// references of evicted keys from the tags index.
+	keyTags map[Key][]string
+	// sums holds the content checksums of the entries,
+	// if PreserveRecency is enabled.
+	sums map[Key]uint64
+	// disabled indicates that entries are not added
+	// to the cache (e.g. under memory pressure).
+	disabled bool
+}
 
 // NewLRU creates a new Cache.
 // If maxEntries is zero, the cache has no limit.
 func NewLRU(maxEntries int) *LRU {
-	return &LRU{
-		Cache: lru.New(maxEntries),
-	}
+	l := &LRU{MaxEntries: maxEntries}
+	l.c = newLRUCache[Key, *Entry](l.evicted)
+	return l
 }
 
 // Add adds the entry to the cache.
 func (l *LRU) Add(_ context.Context, k Key, e *Entry, ttl time.Duration) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	buf, err := e.MarshalBinary()
+	return l.add(k, e, ttl)
+}
+
+// add adds a copy of the entry to the cache. The caller must hold the lock.
+func (l *LRU) add(k Key, e *Entry, ttl time.Duration) error {
+	if l.disabled {
+		return nil
+	}
+	ne, err := copyEntry(e)
 	if err != nil {
 		return err
 	}
+	if l.PreserveRecency {
+		sum, err := contentSum(e)
+		if err != nil {
+			return err
+		}
+		if old, ok := l.sums[k]; ok && old == sum && l.c.replace(k, ne, ne.deadline(ttl)) {
+			return nil
+		}
+		if l.sums == nil {
+			l.sums = make(map[Key]uint64)
+		}
+		l.sums[k] = sum
+	}
+	l.c.add(k, ne, ne.deadline(ttl))
+	l.c.trim(l.MaxEntries)
+	return nil
+}
+
+// contentSum returns the checksum of the columns and the values of the entry.
+func contentSum(e *Entry) (uint64, error) {
+	buf, err := Entry{Columns: e.Columns, Values: e.Values}.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(buf)
+	return h.Sum64(), nil
+}
+
+// copyEntry returns a deep copy of the entry, with interned column names,
+// for storing it in an in-process cache.
+func copyEntry(e *Entry) (*Entry, error) {
+	buf, err := e.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
 	ne := &Entry{}
 	if err := ne.UnmarshalBinary(buf); err != nil {
-		return err
+		return nil, err
 	}
-	if ttl == 0 {
-		l.Cache.Add(k, ne)
-	} else {
-		l.Cache.Add(k, &entry{Entry: ne, expiry: time.Now().Add(ttl)})
+	ne.Columns = columns.intern(ne.Columns)
+	return ne, nil
+}
+
+// deadline returns the time the entry expires, which is the earliest of the given
+// TTL and its logical expiration time. A negative TTL expires it immediately, and
+// a zero time means the entry does not expire.
+func (e *Entry) deadline(ttl time.Duration) time.Time {
+	expiry := e.Expiry
+	if exp := time.Now().Add(ttl); ttl != 0 && (expiry.IsZero() || exp.Before(expiry)) {
+		expiry = exp
 	}
-	return nil
+	return expiry
 }
 
 // Get gets an entry from the cache.
 func (l *LRU) Get(_ context.Context, k Key) (*Entry, error) {
 	l.mu.Lock()
-	e, ok := l.Cache.Get(k)
-	l.mu.Unlock()
+	defer l.mu.Unlock()
+	e, ok := l.c.get(k, time.Now())
 	if !ok {
 		return nil, ErrNotFound
 	}
-	switch e := e.(type) {
-	case *Entry:
-		return e, nil
-	case *entry:
-		if time.Now().Before(e.expiry) {
-			return e.Entry, nil
-		}
-		l.mu.Lock()
-		l.Cache.Remove(k)
-		l.mu.Unlock()
-		return nil, ErrNotFound
-	default:
-		return nil, fmt.Errorf("entcache: unexpected entry type: %T", e)
+	return e, nil
+}
+
+// peek returns the stored entry of the key and its expiration time as-is.
+func (l *LRU) peek(k Key) (*Entry, time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.c.peek(k)
+}
+
+// put stores an entry that was returned by peek, if valid reports
+// true while the lock is held. It reports if the entry was stored.
+func (l *LRU) put(k Key, e *Entry, expiry time.Time, valid func() bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !valid() {
+		return false
 	}
+	l.c.add(k, e, expiry)
+	l.c.trim(l.MaxEntries)
+	return true
+}
+
+// Len returns the number of entries in the cache.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.c.len()
+}
+
+// Evictions returns the number of entries that were evicted
+// from the cache due to its limit (see MaxEntries).
+func (l *LRU) Evictions() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.c.evictions
+}
+
+// Clear purges all entries from the cache.
+func (l *LRU) Clear() {
+	l.mu.Lock()
+	l.clear()
+	l.mu.Unlock()
+}
+
+// clear purges all entries and indexes of the cache. The caller must hold the lock.
+func (l *LRU) clear() {
+	l.c.clear()
+	l.tags, l.keyTags, l.sums = nil, nil, nil
 }
 
 // Del deletes an entry from the cache.
 func (l *LRU) Del(_ context.Context, k Key) error {
 	l.mu.Lock()
-	l.Cache.Remove(k)
+	l.c.remove(k)
 	l.mu.Unlock()
 	return nil
 }
 
-// Redis provides a remote cache backed by Redis
-// and implements the SetGetter interface.
-type Redis struct {
-	c redis.Cmdable
-}
+type (
+	// Redis provides a remote cache backed by Redis
+	// and implements the SetGetter interface.
+	Redis struct {
+		c        redis.Cmdable
+		ttl      time.Duration
+		expiry   ExpiryPolicy
+		sliding  bool
+		noGetEx  uint32 // GETEX is not supported.
+		compress Compression
+		// Adds configuration.
+		w       redis.Cmdable
+		timeout time.Duration
+		drop    bool
+		dropped uint64
+		hashTag string
+		// Outage detection (see RedisDegraded).
+		degrader *degrader
+	}
+
+	// RedisOption allows configuring the Redis
+	// cache level using functional options.
+	RedisOption func(*Redis)
+)
 
 // NewRedis returns a new Redis cache level from the given Redis connection.
 //
@@ -160,65 +385,474 @@ type Redis struct {
 //	entcache.NewRedis(redis.NewClusterClient(&redis.ClusterOptions{
 //		Addrs: []string{":7000", ":7001", ":7002"},
 //	}))
-func NewRedis(c redis.Cmdable) *Redis {
-	return &Redis{c: c}
+func NewRedis(c redis.Cmdable, opts ...RedisOption) *Redis {
+	r := &Redis{c: c}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// RedisTTL configures the TTL of the Redis keys. The interaction between
+// this TTL and the TTL configured on the driver (or the context) is defined
+// by the ExpiryPolicy of the level. See RedisExpiry for more info.
+func RedisTTL(ttl time.Duration) RedisOption {
+	return func(r *Redis) {
+		r.ttl = ttl
+	}
+}
+
+// RedisExpiry configures the policy for resolving the expiration time of
+// entries when both the level TTL (see RedisTTL) and the driver TTL are set.
+//
+//	entcache.NewRedis(rdb, entcache.RedisTTL(time.Hour), entcache.RedisExpiry(entcache.ExpiryStrictest))
+func RedisExpiry(p ExpiryPolicy) RedisOption {
+	return func(r *Redis) {
+		r.expiry = p
+	}
+}
+
+// RedisSliding configures the Redis level to extend the TTL of keys (configured
+// by RedisTTL) on every hit. The key is read and extended in a single round trip
+// using GETEX, and falls back to GET and EXPIRE on Redis versions prior to 6.2.
+//
+//	entcache.NewRedis(rdb, entcache.RedisTTL(time.Hour), entcache.RedisSliding())
+//
+// Note that the logical expiration time of entries (see ExpiryStrictest) is not
+// extended.
+func RedisSliding() RedisOption {
+	return func(r *Redis) {
+		r.sliding = true
+	}
+}
+
+// RedisCompression configures the Redis level to compress the entries it adds
+// with the given algorithm. Compressed entries carry their codec and algorithm,
+// and readers decode all the bundled algorithms, regardless of their own
+// configuration. Hence, services that share the same Redis can switch between
+// algorithms independently.
+//
+//	entcache.NewRedis(rdb, entcache.RedisCompression(entcache.CompressionZstd))
+//
+// Note that readers of versions that do not support compression fail decoding
+// compressed entries. Therefore, they should be upgraded first.
+func RedisCompression(c Compression) RedisOption {
+	return func(r *Redis) {
+		r.compress = c
+	}
+}
+
+// RedisWriteClient configures the Redis level to add entries using a separate
+// client (i.e. a separate connection pool). Under load, it prevents cache
+// population from competing with lookups on the connections of the hit path.
+//
+//	entcache.NewRedis(rdb, entcache.RedisWriteClient(redis.NewClient(&redis.Options{
+//		Addr:     ":6379",
+//		PoolSize: 4,
+//	})))
+func RedisWriteClient(c redis.Cmdable) RedisOption {
+	return func(r *Redis) {
+		r.w = c
+	}
+}
+
+// RedisWriteTimeout configures a timeout for adding entries to Redis, that is
+// usually lower than the timeout of lookups. Adds that exceed it are abandoned,
+// instead of holding their connections.
+func RedisWriteTimeout(d time.Duration) RedisOption {
+	return func(r *Redis) {
+		r.timeout = d
+	}
+}
+
+// RedisDropAdds configures the Redis level to drop adds when the connection pool
+// of its client is saturated (i.e. all connections are in use), to keep the latency
+// of lookups predictable. The number of dropped adds is reported by DroppedAdds.
+//
+// Note that saturation is detected only for clients that expose their pool options
+// and statistics (e.g. redis.Client), and is checked on the pool of lookups, even if
+// entries are added using a separate client (see RedisWriteClient).
+func RedisDropAdds() RedisOption {
+	return func(r *Redis) {
+		r.drop = true
+	}
+}
+
+// DroppedAdds returns the number of adds that were dropped due to saturation of
+// the connection pool. See RedisDropAdds for more info.
+func (r *Redis) DroppedAdds() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// writer returns the client and the context for adding an entry, and
+// reports if the add should be dropped. The returned cancel function
+// must be called when the add is done.
+func (r *Redis) writer(ctx context.Context) (redis.Cmdable, context.Context, context.CancelFunc, bool) {
+	if r.drop && r.saturated() {
+		atomic.AddUint64(&r.dropped, 1)
+		return nil, ctx, func() {}, false
+	}
+	c, cancel := r.c, context.CancelFunc(func() {})
+	if r.w != nil {
+		c = r.w
+	}
+	if r.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+	}
+	return c, ctx, cancel, true
+}
+
+// saturated reports if all connections in the pool of the client are in use.
+func (r *Redis) saturated() bool {
+	p, ok := r.c.(interface {
+		Options() *redis.Options
+		PoolStats() *redis.PoolStats
+	})
+	if !ok {
+		return false
+	}
+	s := p.PoolStats()
+	return s.IdleConns == 0 && int(s.TotalConns) >= p.Options().PoolSize
+}
+
+// ExpiryPolicy defines which TTL determines the expiration time of a cache
+// entry, when both the level and the driver (or the context) TTLs are set.
+type ExpiryPolicy uint
+
+// List of expiry policies.
+const (
+	// ExpiryDriver indicates that the driver (or the context) TTL owns the expiration
+	// of entries. The level TTL is used only for entries that were added without TTL.
+	// This is the default policy.
+	ExpiryDriver ExpiryPolicy = iota
+	// ExpiryLevel indicates that the level TTL owns the expiration
+	// of entries, and the driver TTL is used only if it is not set.
+	// Note that entries that were added through multiple levels carry
+	// the expiration time of the driver TTL, and keys are capped by it.
+	ExpiryLevel
+	// ExpiryStrictest indicates that the shorter TTL is used. In addition,
+	// the logical expiration time is stored inside the entry, and is honored
+	// by all readers, regardless of their configuration.
+	ExpiryStrictest
+)
+
+// resolve returns the TTL to be used by a level with the given
+// TTL, and reports if it should be stored inside the entry.
+func (p ExpiryPolicy) resolve(level, ttl time.Duration) (time.Duration, bool) {
+	switch {
+	case level == 0:
+		return ttl, p == ExpiryStrictest
+	case ttl == 0:
+		return level, p == ExpiryStrictest
+	case p == ExpiryLevel:
+		return level, false
+	case p == ExpiryStrictest:
+		if level < ttl {
+			return level, true
+		}
+		return ttl, true
+	default:
+		return ttl, false
+	}
 }
 
 // Add adds the entry to the cache.
 func (r *Redis) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
-	key := fmt.Sprint(k)
-	if key == "" {
-		return nil
+	key, err := r.key(k)
+	if err != nil || key == "" {
+		return err
 	}
-	buf, err := e.MarshalBinary()
+	buf, ttl, err := r.encode(e, ttl)
 	if err != nil {
 		return err
 	}
-	if err := r.c.Set(ctx, key, buf, ttl).Err(); err != nil {
-		return err
+	if !r.degrader.allow() {
+		return nil
 	}
-	return nil
+	c, wctx, cancel, ok := r.writer(ctx)
+	defer cancel()
+	if !ok {
+		return nil
+	}
+	err = c.Set(wctx, key, buf, ttl).Err()
+	r.degrader.done(ctx, err)
+	return err
+}
+
+// encode encodes the entry, and returns the TTL of its key
+// according to the expiry policy of the level.
+func (r *Redis) encode(e *Entry, ttl time.Duration) ([]byte, time.Duration, error) {
+	ttl, logical := r.expiry.resolve(r.ttl, ttl)
+	// Keys do not outlive the entries they hold.
+	keyTTL := e.Remaining(ttl)
+	if exp := time.Now().Add(ttl); logical && ttl > 0 && (e.Expiry.IsZero() || exp.Before(e.Expiry)) {
+		ne := *e
+		ne.Expiry = exp
+		e = &ne
+	}
+	buf, err := e.Encode(r.compress)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf, keyTTL, nil
 }
 
 // Get gets an entry from the cache.
 func (r *Redis) Get(ctx context.Context, k Key) (*Entry, error) {
-	key := fmt.Sprint(k)
+	key, err := r.key(k)
+	if err != nil {
+		return nil, err
+	}
 	if key == "" {
 		return nil, ErrNotFound
 	}
-	buf, err := r.c.Get(ctx, key).Bytes()
-	if err != nil || len(buf) == 0 {
+	if !r.degrader.allow() {
+		return nil, ErrDegraded
+	}
+	buf, err := r.get(ctx, key)
+	if r.degrader.done(ctx, err); err != nil || len(buf) == 0 {
 		return nil, ErrNotFound
 	}
 	e := &Entry{}
 	if err := e.UnmarshalBinary(buf); err != nil {
 		return nil, err
 	}
+	if e.Expired() {
+		return nil, ErrNotFound
+	}
 	return e, nil
 }
 
+// get reads the value of the given key, and extends its TTL in sliding mode.
+func (r *Redis) get(ctx context.Context, key string) ([]byte, error) {
+	if !r.sliding || r.ttl <= 0 {
+		return r.c.Get(ctx, key).Bytes()
+	}
+	if atomic.LoadUint32(&r.noGetEx) == 0 {
+		buf, err := r.c.GetEx(ctx, key, r.ttl).Bytes()
+		if err == nil || !strings.HasPrefix(err.Error(), "ERR unknown command") {
+			return buf, err
+		}
+		atomic.StoreUint32(&r.noGetEx, 1)
+	}
+	buf, err := r.c.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	// Failing to extend the TTL does not fail the lookup.
+	r.c.Expire(ctx, key, r.ttl)
+	return buf, nil
+}
+
 // Del deletes an entry from the cache.
 func (r *Redis) Del(ctx context.Context, k Key) error {
-	key := fmt.Sprint(k)
-	if key == "" {
+	key, err := r.key(k)
+	if err != nil || key == "" {
+		return err
+	}
+	if !r.degrader.allow() {
+		return ErrDegraded
+	}
+	err = r.c.Del(ctx, key).Err()
+	r.degrader.done(ctx, err)
+	return err
+}
+
+// budgetLevel wraps a cache level with a latency budget.
+type budgetLevel struct {
+	AddGetDeleter
+	budget time.Duration
+}
+
+// Required marks a level of a multi-level cache as required. Adding entries to a
+// multi-level cache is best-effort, and failures are only counted (see LevelStats),
+// so a flaky remote level does not prevent the other levels from being populated.
+// However, failures of required levels are returned to the caller, and reported by
+// the driver (e.g. logged).
+//
+//	entcache.Levels(
+//		entcache.NewLRU(256),
+//		entcache.Required(entcache.NewRedis(rdb)),
+//	)
+func Required(level AddGetDeleter) AddGetDeleter {
+	return &required{AddGetDeleter: level}
+}
+
+// required marks a level as required in a multi-level cache.
+type required struct {
+	AddGetDeleter
+}
+
+// LatencyBudget wraps the given cache level (usually, a remote one) with a latency
+// budget. Lookups and additions are skipped if the context carries a deadline, and
+// the remaining time is shorter than the budget. This allows requests with tight
+// deadlines to fall back to the lower levels (or the database) without spending
+// their time on the cache.
+//
+//	entcache.Levels(
+//		entcache.NewLRU(256),
+//		entcache.LatencyBudget(entcache.NewRedis(rdb), 10*time.Millisecond),
+//	)
+func LatencyBudget(level AddGetDeleter, budget time.Duration) AddGetDeleter {
+	return &budgetLevel{AddGetDeleter: level, budget: budget}
+}
+
+// Add adds the entry to the cache.
+func (b *budgetLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	if b.exceeded(ctx) {
 		return nil
 	}
-	return r.c.Del(ctx, key).Err()
+	return b.AddGetDeleter.Add(ctx, k, e, ttl)
+}
+
+// Get gets an entry from the cache.
+func (b *budgetLevel) Get(ctx context.Context, k Key) (*Entry, error) {
+	if b.exceeded(ctx) {
+		return nil, ErrNotFound
+	}
+	return b.AddGetDeleter.Get(ctx, k)
+}
+
+// exceeded reports if the remaining time of the context is shorter than the budget.
+// Note that deletions are never skipped, as they are required for keeping the cache
+// consistent.
+func (b *budgetLevel) exceeded(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < b.budget
+}
+
+// readOnly wraps a cache level and ignores all writes to it.
+type readOnly struct {
+	AddGetDeleter
+}
+
+// ReadOnly wraps the given cache level, and ignores all writes (adds and deletes)
+// to it. It is useful for services that consume a shared cache (e.g. Redis) that
+// is warmed up by another "writer" service, without churning its entries.
+//
+//	entcache.Levels(
+//		entcache.NewLRU(256),
+//		entcache.ReadOnly(entcache.NewRedis(rdb)),
+//	)
+//
+// Note that, since deletes are ignored as well, the writer service
+// is responsible for evicting the stale entries of the shared level.
+func ReadOnly(level AddGetDeleter) AddGetDeleter {
+	return &readOnly{AddGetDeleter: level}
+}
+
+// Add implements the AddGetDeleter interface.
+func (*readOnly) Add(context.Context, Key, *Entry, time.Duration) error {
+	return nil
+}
+
+// Del implements the AddGetDeleter interface.
+func (*readOnly) Del(context.Context, Key) error {
+	return nil
+}
+
+// snapshotLevel wraps the driver cache, and pins the
+// entries it reads or adds in the context-level cache.
+type snapshotLevel struct {
+	AddGetDeleter
+}
+
+// Get returns the pinned entry of the key (if any), or
+// pins the entry that is returned by the driver cache.
+func (s *snapshotLevel) Get(ctx context.Context, k Key) (*Entry, error) {
+	c := &contextLevel{}
+	if e, err := c.Get(ctx, k); err == nil {
+		return e, nil
+	}
+	e, err := s.AddGetDeleter.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	// Failing to pin the entry does not fail the lookup.
+	c.Add(ctx, k, e, 0)
+	return e, nil
+}
+
+// Add adds the entry to the driver cache, and pins it.
+func (s *snapshotLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	if err := (&contextLevel{}).Add(ctx, k, e, 0); err != nil {
+		return err
+	}
+	return s.AddGetDeleter.Add(ctx, k, e, ttl)
+}
+
+// Del deletes the entry from the driver cache, and unpins it.
+func (s *snapshotLevel) Del(ctx context.Context, k Key) error {
+	if err := (&contextLevel{}).Del(ctx, k); err != nil {
+		return err
+	}
+	return s.AddGetDeleter.Del(ctx, k)
 }
 
 // multiLevel provides a multi-level cache implementation.
 type multiLevel struct {
-	levels []AddGetDeleter
+	levels  []AddGetDeleter
+	errs    []uint64 // Add failures, indexed by level.
+	getErrs []uint64 // Get failures, indexed by level.
+	// names holds the names of added keys, if
+	// key events are enabled (see KeyEvents).
+	names *keyNames
+	// promote indicates if entries are added to the
+	// levels above the one they were found in.
+	promote bool
+	// tolerant indicates if failing levels are skipped by lookups,
+	// and report is called with their failures (see TolerateLevelErrors).
+	tolerant bool
+	report   func(level int, err error)
 }
 
-// Add adds the entry to the cache.
+// newMultiLevel returns a multi-level cache of the given levels.
+func newMultiLevel(levels ...AddGetDeleter) *multiLevel {
+	return &multiLevel{levels: levels, errs: make([]uint64, len(levels)), getErrs: make([]uint64, len(levels))}
+}
+
+// Add adds the entry to the cache. The entry carries its absolute expiration
+// time to all levels, and therefore, entries that are copied between levels
+// do not outlive the TTL they were added with.
 func (m *multiLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	e = e.WithExpiry(ttl)
+	var errs levelErrors
 	for i := range m.levels {
-		if err := m.levels[i].Add(ctx, k, e, ttl); err != nil {
-			return err
+		err := m.levels[i].Add(ctx, k, e, ttl)
+		if err == nil {
+			m.names.added(m.levels[i], k, e)
 		}
+		errs = m.failed(errs, i, err)
 	}
-	return nil
+	return errs.err()
+}
+
+// failed accounts the Add failure of the given level (if any). Adding an entry
+// is best-effort, and only failures of required levels are returned to the caller.
+func (m *multiLevel) failed(errs levelErrors, i int, err error) levelErrors {
+	if err == nil {
+		return errs
+	}
+	atomic.AddUint64(&m.errs[i], 1)
+	if _, ok := m.levels[i].(*required); ok {
+		errs = append(errs, &LevelError{Level: i, Err: err})
+	} else if m.tolerant && m.report != nil {
+		m.report(i, err)
+	}
+	return errs
+}
+
+// tolerate reports if the lookup failure of the given level is skipped,
+// and accounts it. Failures of required levels are never skipped.
+func (m *multiLevel) tolerate(i int, err error) bool {
+	if _, ok := m.levels[i].(*required); ok || !m.tolerant {
+		return false
+	}
+	atomic.AddUint64(&m.getErrs[i], 1)
+	if m.report != nil {
+		m.report(i, err)
+	}
+	return true
 }
 
 // Get gets an entry from the cache.
@@ -226,50 +860,126 @@ func (m *multiLevel) Get(ctx context.Context, k Key) (*Entry, error) {
 	for i := range m.levels {
 		switch e, err := m.levels[i].Get(ctx, k); {
 		case err == nil:
+			if dec := decisionFromContext(ctx); dec != nil {
+				dec.Level = i
+			}
+			if m.promote && i > 0 {
+				m.backfill(ctx, k, e, i)
+			}
 			return e, nil
-		case err != ErrNotFound:
+		case err != ErrNotFound && !m.tolerate(i, err):
 			return nil, err
 		}
 	}
 	return nil, ErrNotFound
 }
 
-// Del deletes an entry from the cache.
+// promoteTagsKey is the context key of the function that returns the
+// tags of the entry that is looked up (see PromoteOnHit and AlsoShared).
+type promoteTagsKey struct{}
+
+// backfill adds the entry that was found in the level at the given index to the
+// levels above it, with its remaining TTL and the tags of its query (if known).
+func (m *multiLevel) backfill(ctx context.Context, k Key, e *Entry, i int) {
+	var tags []string
+	if f, ok := ctx.Value(promoteTagsKey{}).(func() []string); ok {
+		tags = f()
+	}
+	ttl := e.Remaining(0)
+	m.names.added(m.levels[i], k, e)
+	for j := 0; j < i; j++ {
+		m.failed(nil, j, addTagged(ctx, m.levels[j], k, e, ttl, tags))
+	}
+}
+
+// Del deletes an entry from all levels, even if some of them fail.
 func (m *multiLevel) Del(ctx context.Context, k Key) error {
+	m.names.del(k)
+	var errs levelErrors
 	for i := range m.levels {
-		if err := m.levels[i].Del(ctx, k); err != nil {
-			return err
+		if err := m.levels[i].Del(ctx, k); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, &LevelError{Level: i, Err: err})
 		}
 	}
-	return nil
+	return errs.err()
 }
 
 // contextLevel provides a context/request level cache implementation.
-type contextLevel struct{}
+// If a shared level is configured, entries are also added to it, and
+// entries that are missing in the context are looked up in it.
+type contextLevel struct {
+	shared AddGetDeleter
+	ttl    time.Duration // shared entries TTL.
+}
 
 // Get gets an entry from the cache.
-func (*contextLevel) Get(ctx context.Context, k Key) (*Entry, error) {
+func (l *contextLevel) Get(ctx context.Context, k Key) (*Entry, error) {
 	c, ok := FromContext(ctx)
-	if !ok {
+	if ok {
+		e, err := c.Get(ctx, k)
+		if err == nil {
+			indexFromContext(ctx).hit(k)
+		}
+		if err != ErrNotFound || l.shared == nil {
+			return e, err
+		}
+	}
+	if l.shared == nil {
 		return nil, ErrNotFound
 	}
-	return c.Get(ctx, k)
+	e, err := l.shared.Get(ctx, k)
+	if err == nil && ok && !isInFlight(e) {
+		l.backfill(ctx, c, k, e)
+	}
+	return e, err
+}
+
+// backfill adds the entry that was found in the shared level to the context cache,
+// with its remaining TTL and the tags of its query (if known). Hence, the next
+// lookups of the entry in the same context are not sent to the shared level.
+func (l *contextLevel) backfill(ctx context.Context, c AddGetDeleter, k Key, e *Entry) {
+	var tags []string
+	if f, ok := ctx.Value(promoteTagsKey{}).(func() []string); ok {
+		tags = f()
+	}
+	ttl := e.Remaining(0)
+	if err := addTagged(ctx, c, k, e, ttl, tags); err == nil {
+		indexFromContext(ctx).add(k, e, ttl)
+	}
 }
 
 // Add adds the entry to the cache.
-func (*contextLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
-	c, ok := FromContext(ctx)
-	if !ok {
-		return nil
+func (l *contextLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	if c, ok := FromContext(ctx); ok {
+		if err := c.Add(ctx, k, e, ttl); err != nil {
+			return err
+		}
+		indexFromContext(ctx).add(k, e, ttl)
 	}
-	return c.Add(ctx, k, e, ttl)
+	if l.shared != nil {
+		return l.shared.Add(ctx, k, e, l.sharedTTL(ttl))
+	}
+	return nil
 }
 
 // Del deletes an entry from the cache.
-func (*contextLevel) Del(ctx context.Context, k Key) error {
-	c, ok := FromContext(ctx)
-	if !ok {
-		return nil
+func (l *contextLevel) Del(ctx context.Context, k Key) error {
+	if c, ok := FromContext(ctx); ok {
+		if err := c.Del(ctx, k); err != nil {
+			return err
+		}
+		indexFromContext(ctx).del(k)
+	}
+	if l.shared != nil {
+		return l.shared.Del(ctx, k)
+	}
+	return nil
+}
+
+// sharedTTL returns the TTL of entries that are added to the shared level.
+func (l *contextLevel) sharedTTL(ttl time.Duration) time.Duration {
+	if l.ttl != 0 {
+		return l.ttl
 	}
-	return c.Del(ctx, k)
+	return ttl
 }
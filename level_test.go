@@ -0,0 +1,1340 @@
+package entcache_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redismock/v9"
+	"github.com/golang/groupcache"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedis_Expiry(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	buf, _ := e.MarshalBinary()
+	tests := []struct {
+		name   string
+		opts   []entcache.RedisOption
+		ttl    time.Duration
+		expect time.Duration
+	}{
+		{name: "Driver", opts: []entcache.RedisOption{entcache.RedisTTL(time.Hour)}, ttl: time.Minute, expect: time.Minute},
+		{name: "DriverNoTTL", opts: []entcache.RedisOption{entcache.RedisTTL(time.Hour)}, expect: time.Hour},
+		{name: "Level", opts: []entcache.RedisOption{entcache.RedisTTL(time.Hour), entcache.RedisExpiry(entcache.ExpiryLevel)}, ttl: time.Minute, expect: time.Hour},
+		{name: "LevelNoTTL", opts: []entcache.RedisOption{entcache.RedisExpiry(entcache.ExpiryLevel)}, ttl: time.Minute, expect: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb, mock := redismock.NewClientMock()
+			mock.ExpectSet("1", buf, tt.expect).SetVal("OK")
+			if err := entcache.NewRedis(rdb, tt.opts...).Add(ctx, 1, e, tt.ttl); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("Strictest", func(t *testing.T) {
+		rdb, mock := redismock.NewClientMock()
+		r := entcache.NewRedis(rdb, entcache.RedisTTL(time.Hour), entcache.RedisExpiry(entcache.ExpiryStrictest))
+		mock.Regexp().ExpectSet("1", ".+", time.Minute).SetVal("OK")
+		if err := r.Add(ctx, 1, e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		// Logical expiration is honored by readers.
+		expired, _ := entcache.Entry{Values: e.Values, Expiry: time.Now().Add(-time.Second)}.MarshalBinary()
+		mock.ExpectGet("1").SetVal(string(expired))
+		if _, err := r.Get(ctx, 1); err != entcache.ErrNotFound {
+			t.Fatalf("expected expired entry to be missing, got: %v", err)
+		}
+		valid, _ := entcache.Entry{Values: e.Values, Expiry: time.Now().Add(time.Minute)}.MarshalBinary()
+		mock.ExpectGet("1").SetVal(string(valid))
+		if _, err := r.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestRedis_Sliding(t *testing.T) {
+	ctx := context.Background()
+	buf, _ := entcache.Entry{Values: [][]driver.Value{{"a8m"}}}.MarshalBinary()
+	rdb, mock := redismock.NewClientMock()
+	r := entcache.NewRedis(rdb, entcache.RedisTTL(time.Hour), entcache.RedisSliding())
+	mock.ExpectGetEx("1", time.Hour).SetVal(string(buf))
+	if _, err := r.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Fallback for Redis versions without GETEX.
+	mock.ExpectGetEx("1", time.Hour).SetErr(errors.New("ERR unknown command 'getex', with args beginning with: '1'"))
+	mock.ExpectGet("1").SetVal(string(buf))
+	mock.ExpectExpire("1", time.Hour).SetVal(true)
+	if _, err := r.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectGet("1").SetVal(string(buf))
+	mock.ExpectExpire("1", time.Hour).SetVal(true)
+	if _, err := r.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedis_Compression(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{strings.Repeat("a8m", 100)}}}
+	plain, _ := e.MarshalBinary()
+	for _, c := range []entcache.Compression{entcache.CompressionNone, entcache.CompressionGzip, entcache.CompressionSnappy, entcache.CompressionZstd} {
+		w := entcache.NewRedis(rdb, entcache.RedisCompression(c))
+		if err := w.Add(ctx, c.String(), e, 0); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := rdb.Get(ctx, c.String()).Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != entcache.CompressionNone && len(buf) >= len(plain) {
+			t.Errorf("%s: expected entry to be compressed: %d >= %d", c, len(buf), len(plain))
+		}
+		// Readers decode entries regardless of their own compression.
+		for _, rc := range []entcache.Compression{entcache.CompressionNone, entcache.CompressionSnappy} {
+			got, err := entcache.NewRedis(rdb, entcache.RedisCompression(rc)).Get(ctx, c.String())
+			if err != nil {
+				t.Fatalf("%s: reading with %s: %v", c, rc, err)
+			}
+			if got.Values[0][0] != e.Values[0][0] {
+				t.Fatalf("%s: unexpected value: %v", c, got.Values[0][0])
+			}
+		}
+	}
+	if err := rdb.Set(ctx, "unknown", []byte{0, 1, 99, 1, 2, 3}, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entcache.NewRedis(rdb).Get(ctx, "unknown"); err == nil || !strings.Contains(err.Error(), "unsupported compression") {
+		t.Fatal("expected error for unsupported compression", err)
+	}
+}
+
+func TestRedis_DecompressionLimit(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	// Payloads that decompress to more than 64MB are rejected.
+	bomb := make([]byte, 64<<20+1)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(bomb); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c, payload := range map[entcache.Compression][]byte{
+		entcache.CompressionGzip:   gz.Bytes(),
+		entcache.CompressionSnappy: snappy.Encode(nil, bomb),
+		entcache.CompressionZstd:   enc.EncodeAll(bomb, nil),
+	} {
+		if err := rdb.Set(ctx, c.String(), append([]byte{0, 1, byte(c)}, payload...), 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entcache.NewRedis(rdb).Get(ctx, c.String()); err == nil || !strings.Contains(err.Error(), "decompressed entry exceeds") {
+			t.Fatalf("%s: expected size error, got: %v", c, err)
+		}
+	}
+}
+
+func TestRedis_HashTag(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	r := entcache.NewRedis(rdb, entcache.RedisHashTag("entcache"))
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	if err := r.Add(ctx, "a", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddTagged(ctx, "b", e, time.Minute, []string{"users"}); err != nil {
+		t.Fatal(err)
+	}
+	keys := m.Keys()
+	if len(keys) != 3 || keys[0] != "{entcache}:a" || keys[1] != "{entcache}:b" || keys[2] != "{entcache}:entcache:tag:users" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	for _, k := range keys {
+		if s := entcache.RedisSlot(k); s != entcache.RedisSlot("entcache") {
+			t.Fatalf("unexpected slot of key %q: %d", k, s)
+		}
+	}
+	if _, err := r.Get(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.EvictTag(ctx, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, "b"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected tagged entry to be evicted, got: %v", err)
+	}
+	if err := r.DelMulti(ctx, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := m.Keys(); len(keys) != 0 {
+		t.Fatalf("expected all keys to be deleted: %v", keys)
+	}
+}
+
+func TestRedis_Degraded(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+	r := entcache.NewRedis(rdb, entcache.RedisDegraded(2, 200*time.Millisecond))
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	if err := r.Add(ctx, "k", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Missing keys are not failures.
+	for i := 0; i < 3; i++ {
+		if _, err := r.Get(ctx, "missing"); !errors.Is(err, entcache.ErrNotFound) || r.Degraded() {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+	}
+	// Deadlines of the caller are not failures.
+	dctx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := r.Get(dctx, "k"); !errors.Is(err, entcache.ErrNotFound) || r.Degraded() {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+	}
+	m.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := r.Get(ctx, "k"); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected lookup to fail with ErrNotFound during outage: %v", err)
+		}
+	}
+	if !r.Degraded() || r.Degradations() != 1 {
+		t.Fatalf("expected level to be degraded after 2 failures")
+	}
+	if _, err := r.Get(ctx, "k"); !errors.Is(err, entcache.ErrDegraded) {
+		t.Fatalf("expected ErrDegraded, got: %v", err)
+	}
+	if err := r.Add(ctx, "k", e, 0); err != nil {
+		t.Fatalf("expected adds to be dropped: %v", err)
+	}
+	if err := r.Del(ctx, "k"); !errors.Is(err, entcache.ErrDegraded) {
+		t.Fatalf("expected ErrDegraded, got: %v", err)
+	}
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, err := r.Get(ctx, "k"); err != nil {
+		t.Fatalf("expected level to recover after cooldown: %v", err)
+	}
+	if r.Degraded() {
+		t.Fatal("expected level to recover after a successful probe")
+	}
+
+	// Failover errors degrade the level immediately.
+	m.SetError("READONLY You can't write against a read only replica.")
+	if err := r.Add(ctx, "k", e, 0); err == nil {
+		t.Fatal("expected add to fail")
+	}
+	if !r.Degraded() || r.Degradations() != 2 {
+		t.Fatal("expected level to be degraded on failover")
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(r))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Skips[entcache.SkipDegraded] != 1 {
+		t.Fatalf("expected query to pass through the degraded level: %+v", s)
+	}
+}
+
+func TestRedisSlot(t *testing.T) {
+	for key, slot := range map[string]int{
+		"foo":                  12182,
+		"123456789":            12739,
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"foo{}{bar}":           8363,
+	} {
+		if s := entcache.RedisSlot(key); s != slot {
+			t.Errorf("RedisSlot(%q) = %d, want %d", key, s, slot)
+		}
+	}
+}
+
+func TestRedis_Writes(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr(), PoolSize: 1})
+	t.Cleanup(func() { rdb.Close() })
+	wdb, mock := redismock.NewClientMock()
+	var (
+		e   = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+		buf = func() []byte { b, _ := e.MarshalBinary(); return b }()
+		r   = entcache.NewRedis(rdb, entcache.RedisWriteClient(wdb), entcache.RedisDropAdds(), entcache.RedisWriteTimeout(time.Second))
+	)
+	mock.ExpectSet("1", buf, 0).SetVal("OK")
+	if err := r.Add(ctx, 1, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// Hold the only connection of the pool, and expect adds to be dropped.
+	conn := rdb.Conn()
+	if err := conn.Ping(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(ctx, 2, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.DroppedAdds(); n != 1 {
+		t.Fatalf("unexpected dropped adds: %d != 1", n)
+	}
+	conn.Close()
+	mock.ExpectSet("3", buf, 0).SetVal("OK")
+	if err := r.Add(ctx, 3, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedis_Tags(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	buf, _ := e.MarshalBinary()
+	rdb, mock := redismock.NewClientMock()
+	r := entcache.NewRedis(rdb)
+	mock.Regexp().ExpectEvalSha(".+", []string{"1", "entcache:tag:users"}, buf, time.Minute.Milliseconds()).SetVal(int64(1))
+	if err := r.AddTagged(ctx, 1, e, time.Minute, []string{"users"}); err != nil {
+		t.Fatal(err)
+	}
+	mock.Regexp().ExpectEvalSha(".+", []string{"entcache:tag:users"}).SetVal(int64(1))
+	if err := r.EvictTag(ctx, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_Sweep(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	var (
+		l   = entcache.NewLRU(0)
+		r   = entcache.NewRedis(rdb)
+		e   = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+		drv = entcache.NewDriver(nil, entcache.Levels(l, r))
+	)
+	if err := l.AddTagged(ctx, "expiring", e, time.Millisecond, []string{"users"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"deleted", "forever"} {
+		if err := drv.Cache.(entcache.Tagger).AddTagged(ctx, k, e, 0, []string{"users"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := l.IndexSize(); n != 3 {
+		t.Fatalf("unexpected index size: %d != 3", n)
+	}
+	// References of deleted entries are dropped on eviction.
+	if err := drv.Cache.Del(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.IndexSize(); n != 2 {
+		t.Fatalf("unexpected index size: %d != 2", n)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := drv.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.IndexSize(); n != 1 || l.Len() != 1 {
+		t.Fatalf("unexpected index size: %d, or entries: %d", n, l.Len())
+	}
+	members, err := rdb.SMembers(ctx, "entcache:tag:users").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != "forever" {
+		t.Fatalf("unexpected tag members: %v", members)
+	}
+	// An expired entry in the LRU, and a deleted key in Redis.
+	if n := drv.Stats().Swept; n != 2 {
+		t.Fatalf("unexpected swept count: %d != 2", n)
+	}
+}
+
+func TestDriver_KeyEvents(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		l   = entcache.NewLRU(0)
+		e   = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+		drv = entcache.NewDriver(sql.OpenDB(dialect.Postgres, db), entcache.Levels(l, entcache.NewRedis(rdb)), entcache.KeyEvents(rdb))
+	)
+	for _, k := range []entcache.Key{"deleted", "kept"} {
+		if err := drv.Cache.Add(ctx, k, e, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Miniredis does not publish keyspace notifications. Hence, the event
+	// is published manually until the subscription of the driver is ready.
+	for i := 0; ; i++ {
+		m.Publish("__keyevent@0__:del", "deleted")
+		if _, err := l.Get(ctx, "deleted"); errors.Is(err, entcache.ErrNotFound) {
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected entry to be evicted from the LRU on key event")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := l.Get(ctx, "kept"); err != nil {
+		t.Fatal("expected entry to be kept in the LRU", err)
+	}
+	mock.ExpectClose()
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedis_Miniredis(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	t.Run("Expiry", func(t *testing.T) {
+		r := entcache.NewRedis(rdb)
+		e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+		if err := r.Add(ctx, "expiry", e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.Get(ctx, "expiry")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Columns[0] != "name" || got.Values[0][0] != "a8m" {
+			t.Fatalf("unexpected entry: %v", got)
+		}
+		m.FastForward(time.Minute)
+		if _, err := r.Get(ctx, "expiry"); err != entcache.ErrNotFound {
+			t.Fatalf("expected entry to expire, got: %v", err)
+		}
+	})
+
+	t.Run("Sliding", func(t *testing.T) {
+		r := entcache.NewRedis(rdb, entcache.RedisTTL(time.Minute), entcache.RedisSliding())
+		if err := r.Add(ctx, "sliding", &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		m.FastForward(40 * time.Second)
+		if _, err := r.Get(ctx, "sliding"); err != nil {
+			t.Fatal(err)
+		}
+		m.FastForward(40 * time.Second)
+		if _, err := r.Get(ctx, "sliding"); err != nil {
+			t.Fatalf("expected entry TTL to be extended on read: %v", err)
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		r := entcache.NewRedis(rdb, entcache.RedisTTL(time.Hour), entcache.RedisExpiry(entcache.ExpiryLevel))
+		e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}, Expiry: time.Now().Add(time.Minute)}
+		if err := r.Add(ctx, "deadline", e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := m.TTL("deadline"); ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("expected key TTL to be capped by the entry expiry, got: %v", ttl)
+		}
+		// Entries added through multiple levels carry their deadline.
+		var o entcache.Options
+		entcache.Levels(entcache.NewLRU(0), entcache.NewRedis(rdb))(&o)
+		if err := o.Cache.Add(ctx, "levels", &entcache.Entry{Values: e.Values}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.Get(ctx, "levels")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(got.Expiry); d <= 0 || d > time.Minute {
+			t.Fatalf("unexpected entry expiry: %v", got.Expiry)
+		}
+	})
+
+	t.Run("Large", func(t *testing.T) {
+		r := entcache.NewRedis(rdb)
+		blob := make([]byte, 4<<20)
+		for i := range blob {
+			blob[i] = byte(i)
+		}
+		values := make([][]driver.Value, 1000)
+		for i := range values {
+			values[i] = []driver.Value{int64(i), "a8m", 1.5, true, nil, time.Unix(int64(i), 0).UTC()}
+		}
+		values[0][4] = blob
+		if err := r.Add(ctx, "large", &entcache.Entry{Values: values}, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.Get(ctx, "large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Values) != len(values) || !bytes.Equal(got.Values[0][4].([]byte), blob) {
+			t.Fatal("unexpected large entry")
+		}
+		for i := range values {
+			for j := range values[i] {
+				if j == 4 {
+					continue
+				}
+				if tv, ok := values[i][j].(time.Time); ok && !tv.Equal(got.Values[i][j].(time.Time)) || !ok && values[i][j] != got.Values[i][j] {
+					t.Fatalf("unexpected value at %d:%d: %v != %v", i, j, got.Values[i][j], values[i][j])
+				}
+			}
+		}
+	})
+
+	t.Run("HashTags", func(t *testing.T) {
+		// Keys with Redis Cluster hash tags are stored as is.
+		r := entcache.NewRedis(rdb)
+		if err := r.Add(ctx, "{users}:1", &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !m.Exists("{users}:1") {
+			t.Fatal("expected key to be stored with its hash tag")
+		}
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		r := entcache.NewRedis(rdb)
+		e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+		if err := r.AddTagged(ctx, "tagged", e, time.Minute, []string{"users"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Add(ctx, "untagged", e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.EvictTag(ctx, "users"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Get(ctx, "tagged"); err != entcache.ErrNotFound {
+			t.Fatalf("expected tagged entry to be evicted, got: %v", err)
+		}
+		if _, err := r.Get(ctx, "untagged"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		r := entcache.NewRedis(rdb)
+		if _, err := r.Get(ctx, "missing"); err != entcache.ErrNotFound {
+			t.Fatalf("expected missing entry to be mapped to ErrNotFound, got: %v", err)
+		}
+		if err := m.Set("corrupted", "entcache"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Get(ctx, "corrupted"); err == nil || err == entcache.ErrNotFound {
+			t.Fatalf("expected decoding error, got: %v", err)
+		}
+		m.SetError("LOADING Redis is loading the dataset in memory")
+		defer m.SetError("")
+		// Lookup failures are reported as cache misses.
+		if _, err := r.Get(ctx, "expiry"); err != entcache.ErrNotFound {
+			t.Fatalf("expected server error to be mapped to ErrNotFound, got: %v", err)
+		}
+		if err := r.Add(ctx, "expiry", &entcache.Entry{}, 0); err == nil {
+			t.Fatal("expected server error")
+		}
+	})
+}
+
+type tenantKey struct {
+	Tenant string
+	Hash   uint64
+}
+
+func (k tenantKey) MarshalKey() (string, error) {
+	return fmt.Sprintf("%s:%d", k.Tenant, k.Hash), nil
+}
+
+type textKey struct{ ID int }
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprint("text:", k.ID)), nil
+}
+
+func TestRedis_KeyMarshaler(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	r := entcache.NewRedis(rdb)
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	for k, expected := range map[entcache.Key]string{
+		tenantKey{Tenant: "ariga", Hash: 1}: "ariga:1",
+		textKey{ID: 1}:                      "text:1",
+		uint64(1):                           "1",
+	} {
+		if err := r.Add(ctx, k, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !m.Exists(expected) {
+			t.Fatalf("expected key %v to be stored as %q, got: %v", k, expected, m.Keys())
+		}
+		if _, err := r.Get(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Del(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+		if m.Exists(expected) {
+			t.Fatalf("expected key %q to be deleted", expected)
+		}
+	}
+}
+
+func TestLRU_InternColumns(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := entcache.NewLRU(0), entcache.NewLRU(0)
+	for i, l := range []*entcache.LRU{l1, l2} {
+		e := &entcache.Entry{Columns: []string{"id", "name"}, Values: [][]driver.Value{{int64(i), "a8m"}}}
+		if err := l.Add(ctx, i, e, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e1, err := l1.Get(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := l2.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &e1.Columns[0] != &e2.Columns[0] {
+		t.Fatal("expected entries to share their columns")
+	}
+	if cap(e1.Columns) != len(e1.Columns) {
+		t.Fatalf("expected shared columns to be trimmed: %d != %d", cap(e1.Columns), len(e1.Columns))
+	}
+}
+
+// lossyLevel is a level that does not preserve the types of values.
+type lossyLevel struct{ *entcache.LRU }
+
+func (l lossyLevel) Get(ctx context.Context, k entcache.Key) (*entcache.Entry, error) {
+	e, err := l.LRU.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range e.Values {
+		for i, v := range row {
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	return e, nil
+}
+
+// blobs is an in-memory BlobStore.
+type blobs map[string][]byte
+
+func (b blobs) Get(_ context.Context, name string) ([]byte, error) {
+	if v, ok := b[name]; ok {
+		return v, nil
+	}
+	return nil, entcache.ErrNotFound
+}
+
+func (b blobs) Put(_ context.Context, name string, data []byte) error {
+	b[name] = data
+	return nil
+}
+
+func (b blobs) Delete(_ context.Context, name string) error {
+	delete(b, name)
+	return nil
+}
+
+func TestBlob_MinSize(t *testing.T) {
+	ctx := context.Background()
+	s := blobs{}
+	l := entcache.NewBlob(s, entcache.BlobMinSize(1<<10), entcache.BlobPrefix("cache/"))
+	small := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	if err := l.Add(ctx, "small", small, 0); err != nil {
+		t.Fatal(err)
+	}
+	large := &entcache.Entry{Values: [][]driver.Value{{strings.Repeat("a", 1<<10)}}}
+	if err := l.Add(ctx, "large", large, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 1 || s["cache/large"] == nil {
+		t.Fatalf("expected only the large entry to be stored: %d", len(s))
+	}
+	if _, err := l.Get(ctx, "small"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected small entry to be missing", err)
+	}
+	if _, err := l.Get(ctx, "large"); err != nil {
+		t.Fatal(err)
+	}
+	// Overwriting a large entry with a small one deletes its object.
+	if err := l.Add(ctx, "large", small, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, "large"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected shrunk entry to be missing", err)
+	}
+	if len(s) != 0 {
+		t.Fatalf("expected the large object to be deleted: %d", len(s))
+	}
+	// Expired entries are filtered out on reads.
+	if err := l.Add(ctx, "large", large, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := l.Get(ctx, "large"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected entry to expire", err)
+	}
+}
+
+func TestContextMap(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	m := entcache.NewContextMap()
+	if err := m.Add(ctx, "k1", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(ctx, "k2", e, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// Entries are stored as they are.
+	if got, err := m.Get(ctx, "k1"); err != nil || got != e {
+		t.Fatal("expected the added entry", got, err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := m.Get(ctx, "k2"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected entry to expire", err)
+	}
+	m.Clear()
+	if _, err := m.Get(ctx, "k1"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected entry to be cleared", err)
+	}
+}
+
+func TestLRU_Evictions(t *testing.T) {
+	ctx := context.Background()
+	l := entcache.NewLRU(2)
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	for i := 0; i < 3; i++ {
+		if err := l.Add(ctx, i, e, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, ev := l.Len(), l.Evictions(); n != 2 || ev != 1 {
+		t.Fatalf("unexpected length or evictions: %d, %d", n, ev)
+	}
+	if _, err := l.Get(ctx, 0); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected least recently used entry to be evicted", err)
+	}
+	// Expired and deleted entries are not counted as evictions.
+	if err := l.Add(ctx, 1, e, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Del(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := l.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected entry to expire", err)
+	}
+	if n, ev := l.Len(), l.Evictions(); n != 0 || ev != 1 {
+		t.Fatalf("unexpected length or evictions: %d, %d", n, ev)
+	}
+}
+
+func TestARC(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	arc, lru := entcache.NewARC(4), entcache.NewLRU(4)
+	for _, l := range []entcache.AddGetDeleter{arc, lru} {
+		// Hot entries are used more than once.
+		for _, k := range []string{"hot1", "hot2"} {
+			if err := l.Add(ctx, k, e, 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.Get(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// A scan (e.g. pagination) adds entries that are used once.
+		for i := 0; i < 20; i++ {
+			if err := l.Add(ctx, fmt.Sprint("page", i), e, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, k := range []string{"hot1", "hot2"} {
+		if _, err := arc.Get(ctx, k); err != nil {
+			t.Fatalf("expected hot entry %q to survive the scan: %v", k, err)
+		}
+		if _, err := lru.Get(ctx, k); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected hot entry %q to be flushed from the LRU: %v", k, err)
+		}
+	}
+	if n := arc.Len(); n != 4 {
+		t.Fatalf("unexpected number of entries: %d != 4", n)
+	}
+	if err := arc.Add(ctx, "expiring", e, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := arc.Get(ctx, "expiring"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected entry to expire", err)
+	}
+	if err := arc.Del(ctx, "hot1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := arc.Get(ctx, "hot1"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected ErrNotFound after deletion", err)
+	}
+}
+
+func TestTinyLFU(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	l := entcache.NewTinyLFU(2, time.Minute)
+	// A lookup precedes the addition of an entry,
+	// and is counted even if the entry is missing.
+	query := func(k string) {
+		if _, err := l.Get(ctx, k); errors.Is(err, entcache.ErrNotFound) {
+			if err := l.Add(ctx, k, e, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		query("hot1")
+		query("hot2")
+	}
+	for i := 0; i < 20; i++ {
+		query(fmt.Sprint("page", i))
+	}
+	for _, k := range []string{"hot1", "hot2"} {
+		if _, err := l.Get(ctx, k); err != nil {
+			t.Fatalf("expected hot entry %q to survive the scan: %v", k, err)
+		}
+	}
+	if n := l.Rejected(); n != 20 {
+		t.Fatalf("unexpected number of rejected entries: %d != 20", n)
+	}
+	// Keys that become frequent are admitted.
+	for i := 0; i < 10; i++ {
+		query("hot3")
+	}
+	if _, err := l.Get(ctx, "hot3"); err != nil {
+		t.Fatal("expected frequent entry to be admitted", err)
+	}
+	if n := l.Len(); n != 2 {
+		t.Fatalf("unexpected number of entries: %d != 2", n)
+	}
+	// Entries expire after the TTL of the level, if shorter.
+	l = entcache.NewTinyLFU(2, time.Millisecond)
+	if err := l.Add(ctx, "k", e, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := l.Get(ctx, "k"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected entry to expire", err)
+	}
+}
+
+func TestShardedLRU(t *testing.T) {
+	ctx := context.Background()
+	l := entcache.NewShardedLRU(4, 0)
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	for i := 0; i < 100; i++ {
+		if err := l.AddTagged(ctx, uint64(i), e, 0, []string{"users"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Add(ctx, "k", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	stats := l.Stats()
+	if stats.Entries != 101 || l.Len() != 101 || len(stats.Shards) != 4 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	for i, n := range stats.Shards {
+		if n == 0 {
+			t.Fatalf("expected keys to be hashed across all shards, shard %d is empty", i)
+		}
+	}
+	if err := l.EvictTag(ctx, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.Len(); n != 1 {
+		t.Fatalf("unexpected number of entries after tag eviction: %d != 1", n)
+	}
+	if err := l.Del(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, "k"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected ErrNotFound after deletion", err)
+	}
+}
+
+func TestDriver_Verify(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	var logs []string
+	drv := entcache.NewDriver(nil, entcache.Levels(entcache.NewLRU(0), entcache.NewRedis(rdb)))
+	drv.Log = func(v ...any) { logs = append(logs, fmt.Sprint(v...)) }
+	if err := drv.Verify(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "no TTL is configured") {
+		t.Fatalf("expected a zero TTL warning: %q", logs)
+	}
+	if keys := m.Keys(); len(keys) != 0 {
+		t.Fatalf("expected sentinel entry to be deleted: %v", keys)
+	}
+	m.Close()
+	if err := drv.Verify(ctx); err == nil || !strings.Contains(err.Error(), "level 1 (*entcache.Redis)") {
+		t.Fatalf("expected unreachable Redis to fail verification, got: %v", err)
+	}
+	drv = entcache.NewDriver(nil, entcache.Levels(lossyLevel{entcache.NewLRU(0)}))
+	if err := drv.Verify(ctx); err == nil || !strings.Contains(err.Error(), `unexpected value of column "int"`) {
+		t.Fatalf("expected lossy level to fail verification, got: %v", err)
+	}
+	// Wrapped levels that do not store the sentinel synchronously.
+	s := blobs{}
+	for name, l := range map[string]entcache.AddGetDeleter{
+		"ReadOnly":   entcache.ReadOnly(entcache.NewLRU(0)),
+		"AsyncLevel": entcache.AsyncLevel(entcache.NewLRU(0), 16),
+		"Blob":       entcache.NewBlob(s, entcache.BlobCompression(entcache.CompressionGzip)),
+		"AsyncBlob":  entcache.AsyncLevel(entcache.NewBlob(s), 16),
+	} {
+		drv := entcache.NewDriver(nil, entcache.Levels(entcache.NewLRU(0), l))
+		if err := drv.Verify(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(s) != 0 {
+		t.Fatalf("expected sentinel blobs to be deleted: %d", len(s))
+	}
+}
+
+func TestWatchMemory(t *testing.T) {
+	var used atomic.Uint64
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := entcache.NewLRU(0)
+	for i := 0; i < 8; i++ {
+		if err := l.Add(ctx, i, &entcache.Entry{Values: [][]driver.Value{{int64(i)}}}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go entcache.WatchMemory(ctx, l,
+		entcache.MemoryInterval(time.Millisecond),
+		entcache.MemoryUsage(func() (uint64, uint64) { return used.Load(), 100 }),
+	)
+	// eventually waits for the cache to reach the given state.
+	eventually := func(cached bool) {
+		t.Helper()
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+			if err := l.Add(ctx, "k", &entcache.Entry{}, 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.Get(ctx, "k"); (err == nil) == cached {
+				return
+			}
+		}
+		t.Fatalf("expected cached to be %v", cached)
+	}
+	used.Store(95)
+	eventually(false)
+	if n := l.Len(); n != 0 {
+		t.Fatalf("expected cache to be purged under pressure, got %d entries", n)
+	}
+	// Caching is not restored until the usage drops below the low watermark.
+	used.Store(85)
+	time.Sleep(10 * time.Millisecond)
+	eventually(false)
+	used.Store(50)
+	eventually(true)
+	if l.MaxEntries != 0 {
+		t.Fatalf("expected original budget to be restored, got: %d", l.MaxEntries)
+	}
+}
+
+func TestGroupcache(t *testing.T) {
+	ctx := context.Background()
+	// The owner of all keys is unavailable, and therefore,
+	// entries are loaded from this peer and cached by groupcache.
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(owner.Close)
+	pool := groupcache.NewHTTPPool("http://localhost")
+	pool.Set(owner.URL)
+	var (
+		g = entcache.NewGroupcache(pool, "entcache-test", 1<<20, entcache.GroupcacheWindow(100*time.Millisecond))
+		e = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	)
+	if _, err := g.Get(ctx, "k"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected ErrNotFound for a missing key", err)
+	}
+	if err := g.Add(ctx, "k", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Add(ctx, "expiring", e, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	got, err := g.Get(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Values[0][0] != "a8m" {
+		t.Fatalf("unexpected entry: %v", got.Values)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := g.Get(ctx, "expiring"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatal("expected ErrNotFound for an expired entry", err)
+	}
+	if err := g.Del(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted entries are dropped from groupcache at the end of the window.
+	for i := 0; ; i++ {
+		if _, err := g.Get(ctx, "k"); errors.Is(err, entcache.ErrNotFound) {
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected deleted entry to be dropped at the end of the window")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// blockingLevel blocks its adds until released.
+type blockingLevel struct {
+	*entcache.LRU
+	started, release chan struct{}
+}
+
+func (b *blockingLevel) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.LRU.Add(ctx, k, e, ttl)
+}
+
+func (b *blockingLevel) AddTagged(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration, tags []string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.LRU.AddTagged(ctx, k, e, ttl, tags)
+}
+
+func TestAsyncLevel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ctx  = context.Background()
+		logs []string
+		bl   = &blockingLevel{LRU: entcache.NewLRU(0), started: make(chan struct{}, 2), release: make(chan struct{})}
+		drv  = entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(entcache.NewLRU(0), entcache.AsyncLevel(bl, 4)),
+		)
+		e = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	)
+	drv.Log = func(v ...any) { logs = append(logs, fmt.Sprint(v...)) }
+	// Adds are not blocked by the slow level.
+	if err := drv.Cache.Add(ctx, "k", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-bl.started
+	// The worker of the key is busy, and therefore, the next
+	// add is queued, and the one after it is dropped.
+	for i := 0; i < 2; i++ {
+		if err := drv.Cache.Add(ctx, "k", e, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := drv.Stats(); s.AsyncErrors != 1 {
+		t.Fatalf("expected a dropped add: %+v", s)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "queue is full") {
+		t.Fatalf("unexpected logs: %q", logs)
+	}
+	if _, err := drv.Cache.Get(ctx, "k"); err != nil {
+		t.Fatalf("expected entry to be served by the first level: %v", err)
+	}
+	close(bl.release)
+	mock.ExpectClose()
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// Queued adds are flushed on close.
+	if len(bl.started) != 1 || bl.Len() != 1 {
+		t.Fatalf("expected the queued add to be flushed: %d", bl.Len())
+	}
+}
+
+func TestAsyncLevel_EvictTag(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ctx = context.Background()
+		bl  = &blockingLevel{LRU: entcache.NewLRU(0), started: make(chan struct{}, 2), release: make(chan struct{})}
+		drv = entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(entcache.NewLRU(0), entcache.AsyncLevel(bl, 4)),
+		)
+		e = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	)
+	tagger := drv.Cache.(entcache.Tagger)
+	// The first add is in progress, and the second one is queued behind it.
+	if err := tagger.AddTagged(ctx, "k", e, 0, []string{"users"}); err != nil {
+		t.Fatal(err)
+	}
+	<-bl.started
+	if err := tagger.AddTagged(ctx, "k", e, 0, []string{"users"}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- drv.EvictTags(ctx, "users") }()
+	select {
+	case err := <-done:
+		t.Fatalf("expected eviction to wait for the add in progress: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(bl.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectClose()
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The add in progress was evicted, and the queued one was dropped.
+	if len(bl.started) != 0 || bl.Len() != 0 {
+		t.Fatalf("expected stale entries to be evicted: %d", bl.Len())
+	}
+	if s := drv.Stats(); s.AsyncErrors != 0 {
+		t.Fatalf("unexpected async errors: %+v", s)
+	}
+}
+
+type flakyLevel struct {
+	*entcache.LRU
+	fail atomic.Bool
+}
+
+func (f *flakyLevel) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	if f.fail.Load() {
+		return errors.New("connection refused")
+	}
+	return f.LRU.Add(ctx, k, e, ttl)
+}
+
+func TestRetryAdds(t *testing.T) {
+	var (
+		ctx = context.Background()
+		fl  = &flakyLevel{LRU: entcache.NewLRU(0)}
+		c   = entcache.RetryAdds(fl, 2, 10*time.Millisecond)
+		e   = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	)
+	fl.fail.Store(true)
+	for _, k := range []entcache.Key{"a", "b", "c"} {
+		if err := c.Add(ctx, k, e, time.Minute); err == nil {
+			t.Fatal("expected add to fail")
+		}
+	}
+	// Deleting an entry cancels its retry, and "a" is
+	// dropped from the queue, as it holds up to 2 entries.
+	if err := c.Del(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	fl.fail.Store(false)
+	for i := 0; fl.Len() < 1 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for k, ok := range map[entcache.Key]bool{"a": false, "b": true, "c": false} {
+		if _, err := fl.Get(ctx, k); (err == nil) != ok {
+			t.Errorf("unexpected result for key %v: %v", k, err)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ctx    = context.Background()
+		shared = entcache.NewLRU(0)
+		c      = entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(entcache.NewLRU(0), entcache.ReadOnly(shared)),
+		).Cache
+		e = &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	)
+	if err := shared.Add(ctx, "k1", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "k1"); err != nil {
+		t.Fatalf("expected entry to be served by the read-only level: %v", err)
+	}
+	if err := c.Add(ctx, "k2", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.Get(ctx, "k2"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected add to be ignored: %v", err)
+	}
+	if _, err := shared.Get(ctx, "k1"); err != nil {
+		t.Fatalf("expected delete to be ignored: %v", err)
+	}
+}
+
+func TestPromoteOnHit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		l1, l2 = entcache.NewLRU(0), entcache.NewLRU(0)
+		drv    = entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(l1, l2),
+			entcache.TTL(time.Minute),
+			entcache.PromoteOnHit(true),
+		)
+		ctx = entcache.WithTags(entcache.WithKey(context.Background(), "k"), "users")
+	)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	// The entry is served by the second level, and promoted to the first one.
+	if err := l1.Del(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := l1.Get(ctx, "k"); err != nil || e.Expiry.IsZero() {
+		t.Fatalf("expected entry to be promoted with its expiration time: %v", err)
+	}
+	// Promoted entries are evicted by the tags of their query.
+	if err := drv.EvictTags(ctx, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l1.Get(ctx, "k"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected promoted entry to be evicted: %v", err)
+	}
+}
+
+func TestLRU_PreserveRecency(t *testing.T) {
+	ctx := context.Background()
+	for _, preserve := range []bool{false, true} {
+		l := entcache.NewLRU(2)
+		l.PreserveRecency = preserve
+		for _, k := range []entcache.Key{"a", "b", "a"} {
+			if err := l.Add(ctx, k, &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Re-adding "a" with the same content does not mark it as recently used.
+		if err := l.Add(ctx, "c", &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.Get(ctx, "a"); errors.Is(err, entcache.ErrNotFound) != preserve {
+			t.Fatalf("unexpected eviction of a (preserve=%t): %v", preserve, err)
+		}
+	}
+	// Entries with a different content are marked as recently used.
+	l := entcache.NewLRU(2)
+	l.PreserveRecency = true
+	for i, k := range []entcache.Key{"a", "b", "a", "c"} {
+		if err := l.Add(ctx, k, &entcache.Entry{Values: [][]driver.Value{{int64(i)}}}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Get(ctx, "b"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected b to be evicted: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package leveltest_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"ariga.io/entcache"
+)
+
+// objects is a minimal in-memory object storage.
+type objects struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newObjects() *objects {
+	return &objects{data: make(map[string][]byte)}
+}
+
+// gcs exposes the objects using the GCSBucket interface.
+type gcs struct{ *objects }
+
+func (g gcs) NewReader(_ context.Context, name string) (io.ReadCloser, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.data[name]
+	if !ok {
+		return nil, entcache.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (g gcs) NewWriter(_ context.Context, name string) io.WriteCloser {
+	return &gcsWriter{objects: g.objects, name: name}
+}
+
+func (g gcs) Delete(_ context.Context, name string) error {
+	g.mu.Lock()
+	delete(g.data, name)
+	g.mu.Unlock()
+	return nil
+}
+
+// gcsWriter stores the object on Close.
+type gcsWriter struct {
+	bytes.Buffer
+	*objects
+	name string
+}
+
+func (w *gcsWriter) Close() error {
+	w.mu.Lock()
+	w.data[w.name] = w.Bytes()
+	w.mu.Unlock()
+	return nil
+}
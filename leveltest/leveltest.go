@@ -0,0 +1,294 @@
+// Package leveltest provides a conformance test suite for entcache levels.
+// Authors of custom levels can use it to verify that their implementation
+// follows the semantics expected by the entcache.Driver.
+//
+//	func TestLevel(t *testing.T) {
+//		leveltest.Run(t, func() entcache.AddGetDeleter {
+//			return NewLevel(client)
+//		})
+//	}
+package leveltest
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+)
+
+// Run runs the conformance test suite against the level that is returned by
+// newLevel. newLevel is called for each test, and should return an empty level.
+// The suite validates not-found semantics, binary round-trips, TTL behavior,
+// concurrency safety and large entries.
+func Run(t *testing.T, newLevel func() entcache.AddGetDeleter) {
+	for _, tt := range []struct {
+		name string
+		test func(*testing.T, entcache.AddGetDeleter)
+	}{
+		{name: "NotFound", test: testNotFound},
+		{name: "RoundTrip", test: testRoundTrip},
+		{name: "Overwrite", test: testOverwrite},
+		{name: "TTL", test: testTTL},
+		{name: "Del", test: testDel},
+		{name: "Concurrency", test: testConcurrency},
+		{name: "Large", test: testLarge},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newLevel())
+		})
+	}
+}
+
+// testNotFound verifies that missing keys are reported as ErrNotFound.
+func testNotFound(t *testing.T, l entcache.AddGetDeleter) {
+	for _, k := range []entcache.Key{key(t, "missing"), uint64(time.Now().UnixNano())} {
+		e, err := l.Get(context.Background(), k)
+		if !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for a missing key, got: %v", err)
+		}
+		if e != nil {
+			t.Fatalf("expected nil entry for a missing key, got: %v", e)
+		}
+	}
+}
+
+// testRoundTrip verifies that entries are returned as they were added.
+func testRoundTrip(t *testing.T, l entcache.AddGetDeleter) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Microsecond).UTC()
+	e := &entcache.Entry{
+		Columns: []string{"id", "name", "active", "score", "data", "created_at", "deleted_at"},
+		Values: [][]driver.Value{
+			{int64(1), "a8m", true, 1.5, []byte("a8m"), now, nil},
+			{int64(2), "", false, 0.0, []byte("b"), now.Add(time.Hour), now},
+		},
+	}
+	k := key(t, "round-trip")
+	if err := l.Add(ctx, k, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, err := l.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEntry(t, e, got)
+	// Stored entries must not be affected by
+	// modifications of the added entry.
+	e.Values[0][1] = "modified"
+	if got, err = l.Get(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+	if got.Values[0][1] != "a8m" {
+		t.Fatalf("stored entry was modified by its caller: %v", got.Values[0][1])
+	}
+}
+
+// testOverwrite verifies that adding an existing key replaces its entry.
+func testOverwrite(t *testing.T, l entcache.AddGetDeleter) {
+	ctx := context.Background()
+	k := key(t, "overwrite")
+	for _, v := range []string{"a8m", "Ariel"} {
+		e := &entcache.Entry{Values: [][]driver.Value{{v}}}
+		if err := l.Add(ctx, k, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, err := l.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectEntry(t, e, got)
+	}
+}
+
+// testTTL verifies that entries expire after their TTL, and that entries
+// without TTL (or with their logical expiration time in the future) do not.
+func testTTL(t *testing.T, l entcache.AddGetDeleter) {
+	ctx := context.Background()
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	expiring, forever := key(t, "expiring"), key(t, "forever")
+	if err := l.Add(ctx, expiring, e, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add(ctx, forever, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, expiring); err != nil {
+		t.Fatalf("expected entry to exist before its TTL, got: %v", err)
+	}
+	expired, valid := key(t, "expired"), key(t, "valid")
+	if err := l.Add(ctx, expired, &entcache.Entry{Values: e.Values, Expiry: time.Now().Add(-time.Second)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add(ctx, valid, &entcache.Entry{Values: e.Values, Expiry: time.Now().Add(time.Hour)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, expired); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an entry with a past expiry, got: %v", err)
+	}
+	if _, err := l.Get(ctx, valid); err != nil {
+		t.Fatalf("expected entry with a future expiry to exist, got: %v", err)
+	}
+	sleep(t, l, 1100*time.Millisecond)
+	if _, err := l.Get(ctx, expiring); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after the entry TTL, got: %v", err)
+	}
+	if _, err := l.Get(ctx, forever); err != nil {
+		t.Fatalf("expected entry without TTL to exist, got: %v", err)
+	}
+}
+
+// testDel verifies that Del is idempotent, and that
+// deleting missing keys does not return an error.
+func testDel(t *testing.T, l entcache.AddGetDeleter) {
+	ctx := context.Background()
+	k := key(t, "del")
+	if err := l.Del(ctx, k); err != nil {
+		t.Fatalf("deleting a missing key should be a no-op, got: %v", err)
+	}
+	if err := l.Add(ctx, k, &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Del(ctx, k); err != nil {
+			t.Fatalf("unexpected error on Del #%d: %v", i+1, err)
+		}
+		if _, err := l.Get(ctx, k); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after Del #%d, got: %v", i+1, err)
+		}
+	}
+}
+
+// testConcurrency verifies that the level can be used concurrently.
+// It is mostly useful when running with the race detector enabled.
+func testConcurrency(t *testing.T, l entcache.AddGetDeleter) {
+	var (
+		wg   sync.WaitGroup
+		ctx  = context.Background()
+		keys = make([]string, 8)
+	)
+	for i := range keys {
+		keys[i] = key(t, fmt.Sprint("concurrency-", i))
+	}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				k := keys[(w+i)%len(keys)]
+				var err error
+				switch i % 3 {
+				case 0:
+					err = l.Add(ctx, k, &entcache.Entry{Values: [][]driver.Value{{k}}}, time.Minute)
+				case 1:
+					var e *entcache.Entry
+					switch e, err = l.Get(ctx, k); {
+					case errors.Is(err, entcache.ErrNotFound):
+						err = nil
+					case err == nil && (len(e.Values) != 1 || e.Values[0][0] != k):
+						err = fmt.Errorf("unexpected entry for key %v: %v", k, e.Values)
+					}
+				case 2:
+					err = l.Del(ctx, k)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// testLarge verifies that large entries are stored and returned intact.
+func testLarge(t *testing.T, l entcache.AddGetDeleter) {
+	ctx := context.Background()
+	blob := make([]byte, 1<<20)
+	for i := range blob {
+		blob[i] = byte(i % 251)
+	}
+	e := &entcache.Entry{Columns: []string{"id", "data"}}
+	for i := 0; i < 1000; i++ {
+		e.Values = append(e.Values, []driver.Value{int64(i), fmt.Sprint("row-", i)})
+	}
+	e.Values[0][1] = blob
+	k := key(t, "large")
+	if err := l.Add(ctx, k, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, err := l.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEntry(t, e, got)
+}
+
+// FastForwarder is an optional interface implemented by levels that are backed
+// by a fake clock (e.g. miniredis). If implemented, the TTL tests move the clock
+// forward, in addition to waiting for the duration.
+type FastForwarder interface {
+	FastForward(time.Duration)
+}
+
+// sleep waits for the given duration, and moves
+// the clock of the level forward, if supported.
+func sleep(t *testing.T, l entcache.AddGetDeleter, d time.Duration) {
+	t.Helper()
+	time.Sleep(d)
+	if f, ok := l.(FastForwarder); ok {
+		f.FastForward(d)
+	}
+}
+
+// expectEntry fails the test if the entries are not equal.
+func expectEntry(t *testing.T, expected, actual *entcache.Entry) {
+	t.Helper()
+	if len(actual.Columns) != len(expected.Columns) {
+		t.Fatalf("mismatch columns: %v != %v", actual.Columns, expected.Columns)
+	}
+	for i := range expected.Columns {
+		if actual.Columns[i] != expected.Columns[i] {
+			t.Fatalf("mismatch columns: %v != %v", actual.Columns, expected.Columns)
+		}
+	}
+	if len(actual.Values) != len(expected.Values) {
+		t.Fatalf("mismatch rows length: %d != %d", len(actual.Values), len(expected.Values))
+	}
+	for i := range expected.Values {
+		if len(actual.Values[i]) != len(expected.Values[i]) {
+			t.Fatalf("mismatch row %d length: %d != %d", i, len(actual.Values[i]), len(expected.Values[i]))
+		}
+		for j := range expected.Values[i] {
+			if !equal(expected.Values[i][j], actual.Values[i][j]) {
+				t.Fatalf("mismatch value at %d:%d: %v (%[3]T) != %v (%[4]T)", i, j, actual.Values[i][j], expected.Values[i][j])
+			}
+		}
+	}
+}
+
+// equal reports if the two driver values are equal.
+func equal(v1, v2 driver.Value) bool {
+	switch v1 := v1.(type) {
+	case []byte:
+		v2, ok := v2.([]byte)
+		return ok && bytes.Equal(v1, v2)
+	case time.Time:
+		v2, ok := v2.(time.Time)
+		return ok && v1.Equal(v2)
+	default:
+		return v1 == v2
+	}
+}
+
+// key returns a unique key for the test, as levels
+// may be backed by a storage that is shared between tests.
+func key(t *testing.T, name string) string {
+	return fmt.Sprintf("leveltest:%s:%s:%d", t.Name(), name, time.Now().UnixNano())
+}
@@ -0,0 +1,73 @@
+package leveltest_test
+
+import (
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/leveltest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLRU(t *testing.T) {
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return entcache.NewLRU(0)
+	})
+}
+
+func TestProcLRU(t *testing.T) {
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return entcache.NewProcLRU(0)
+	})
+}
+
+func TestARC(t *testing.T) {
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return entcache.NewARC(1024)
+	})
+}
+
+func TestTinyLFU(t *testing.T) {
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return entcache.NewTinyLFU(1024, 0)
+	})
+}
+
+func TestRedis(t *testing.T) {
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return fastForward{entcache.NewRedis(rdb), m}
+	})
+}
+
+func TestGCS(t *testing.T) {
+	b := gcs{newObjects()}
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return entcache.NewGCS(b, entcache.BlobMinSize(0), entcache.BlobCompression(entcache.CompressionZstd))
+	})
+}
+
+func TestLevels(t *testing.T) {
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		var o entcache.Options
+		entcache.Levels(entcache.NewLRU(0), entcache.NewRedis(rdb))(&o)
+		return fastForward{o.Cache, m}
+	})
+}
+
+// fastForward moves the miniredis clock forward on TTL tests.
+type fastForward struct {
+	entcache.AddGetDeleter
+	m *miniredis.Miniredis
+}
+
+func (f fastForward) FastForward(d time.Duration) {
+	f.m.FastForward(d)
+}
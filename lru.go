@@ -0,0 +1,209 @@
+package entcache
+
+import (
+	"container/heap"
+	"container/list"
+	"time"
+)
+
+type (
+	// lruCache is a generic LRU cache with a native expiration time per
+	// item. It is not safe for concurrent use, and its owner guards all
+	// of its operations, including lookups that promote items, with a
+	// single (exclusive) lock.
+	lruCache[K comparable, V any] struct {
+		ll    *list.List
+		items map[K]*list.Element
+		// expiries orders the expiration times of
+		// items for sweeping (see lruCache.sweep).
+		expiries  expiryHeap[K]
+		evictions uint64
+		// onEvict is called when an item is removed from the cache.
+		onEvict func(K, V)
+	}
+	// lruItem is an item in the cache. A zero expiry means no expiration.
+	lruItem[K comparable, V any] struct {
+		key    K
+		value  V
+		expiry time.Time
+	}
+)
+
+// newLRUCache returns a new LRU cache with the given eviction callback.
+func newLRUCache[K comparable, V any](onEvict func(K, V)) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		ll:      list.New(),
+		items:   make(map[K]*list.Element),
+		onEvict: onEvict,
+	}
+}
+
+// add adds the value to the cache, or replaces the value of an existing key.
+func (c *lruCache[K, V]) add(k K, v V, expiry time.Time) {
+	if el, ok := c.items[k]; ok {
+		c.ll.MoveToFront(el)
+		it := el.Value.(*lruItem[K, V])
+		it.value, it.expiry = v, expiry
+	} else {
+		c.items[k] = c.ll.PushFront(&lruItem[K, V]{key: k, value: v, expiry: expiry})
+	}
+	if !expiry.IsZero() {
+		heap.Push(&c.expiries, expiryItem[K]{key: k, at: expiry})
+		c.compact()
+	}
+}
+
+// replace replaces the value and the expiration time of an existing
+// key, without promoting it. It reports if the key exists.
+func (c *lruCache[K, V]) replace(k K, v V, expiry time.Time) bool {
+	el, ok := c.items[k]
+	if !ok {
+		return false
+	}
+	it := el.Value.(*lruItem[K, V])
+	it.value, it.expiry = v, expiry
+	if !expiry.IsZero() {
+		heap.Push(&c.expiries, expiryItem[K]{key: k, at: expiry})
+		c.compact()
+	}
+	return true
+}
+
+// get returns the value of the key and promotes it, if it exists and
+// it has not expired. Expired items are removed from the cache.
+func (c *lruCache[K, V]) get(k K, now time.Time) (v V, ok bool) {
+	el, ok := c.items[k]
+	if !ok {
+		return v, false
+	}
+	it := el.Value.(*lruItem[K, V])
+	if !it.expiry.IsZero() && !now.Before(it.expiry) {
+		c.removeElement(el)
+		return v, false
+	}
+	c.ll.MoveToFront(el)
+	return it.value, true
+}
+
+// peek returns the value of the key and its expiration time, without promoting it.
+func (c *lruCache[K, V]) peek(k K) (v V, expiry time.Time, ok bool) {
+	el, ok := c.items[k]
+	if !ok {
+		return v, expiry, false
+	}
+	it := el.Value.(*lruItem[K, V])
+	return it.value, it.expiry, true
+}
+
+// contains reports if the key exists in the cache, without promoting it.
+func (c *lruCache[K, V]) contains(k K) bool {
+	_, ok := c.items[k]
+	return ok
+}
+
+// oldest returns the least recently used item, without removing it.
+func (c *lruCache[K, V]) oldest() (k K, v V, ok bool) {
+	el := c.ll.Back()
+	if el == nil {
+		return k, v, false
+	}
+	it := el.Value.(*lruItem[K, V])
+	return it.key, it.value, true
+}
+
+// remove removes the key from the cache.
+func (c *lruCache[K, V]) remove(k K) {
+	if el, ok := c.items[k]; ok {
+		c.removeElement(el)
+	}
+}
+
+// trim evicts the least recently used items, until the cache holds at
+// most max items. Zero means no limit, and a negative max holds nothing.
+func (c *lruCache[K, V]) trim(max int) {
+	for max != 0 && c.ll.Len() > 0 && c.ll.Len() > max {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// removeOldest evicts the least recently used item.
+func (c *lruCache[K, V]) removeOldest() {
+	if el := c.ll.Back(); el != nil {
+		c.removeElement(el)
+		c.evictions++
+	}
+}
+
+// sweep removes the items that their expiration time has passed,
+// and returns their number.
+func (c *lruCache[K, V]) sweep(now time.Time) int {
+	var n int
+	for len(c.expiries) > 0 && !c.expiries[0].at.After(now) {
+		it := heap.Pop(&c.expiries).(expiryItem[K])
+		// Skip keys that were overridden or removed since they were tracked.
+		if el, ok := c.items[it.key]; ok && el.Value.(*lruItem[K, V]).expiry.Equal(it.at) {
+			c.removeElement(el)
+			n++
+		}
+	}
+	return n
+}
+
+// clear removes all items from the cache.
+func (c *lruCache[K, V]) clear() {
+	for el := c.ll.Back(); el != nil; el = c.ll.Back() {
+		c.removeElement(el)
+	}
+	c.expiries = nil
+}
+
+// len returns the number of items in the cache.
+func (c *lruCache[K, V]) len() int {
+	return c.ll.Len()
+}
+
+func (c *lruCache[K, V]) removeElement(el *list.Element) {
+	it := c.ll.Remove(el).(*lruItem[K, V])
+	delete(c.items, it.key)
+	if c.onEvict != nil {
+		c.onEvict(it.key, it.value)
+	}
+}
+
+// compact drops the stale expiration times of overridden or removed
+// items from the heap, when they outnumber the items in the cache.
+func (c *lruCache[K, V]) compact() {
+	if len(c.expiries) <= 2*len(c.items)+64 {
+		return
+	}
+	valid := c.expiries[:0]
+	for _, it := range c.expiries {
+		if el, ok := c.items[it.key]; ok && el.Value.(*lruItem[K, V]).expiry.Equal(it.at) {
+			valid = append(valid, it)
+		}
+	}
+	c.expiries = valid
+	heap.Init(&c.expiries)
+}
+
+type (
+	// expiryItem is an item in the expiry heap.
+	expiryItem[K comparable] struct {
+		key K
+		at  time.Time
+	}
+	// expiryHeap is a min-heap of expiration times.
+	expiryHeap[K comparable] []expiryItem[K]
+)
+
+func (h expiryHeap[K]) Len() int           { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap[K]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap[K]) Push(x any)        { *h = append(*h, x.(expiryItem[K])) }
+func (h *expiryHeap[K]) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
@@ -0,0 +1,392 @@
+// Package memcache provides a remote cache level for entcache,
+// that is backed by Memcached.
+package memcache
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ariga.io/entcache"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+type (
+	// Level provides a remote cache backed by Memcached
+	// and implements the entcache.AddGetDeleter interface.
+	Level struct {
+		c        *memcache.Client
+		itemSize int
+	}
+
+	// Option allows configuring the Memcache
+	// cache level using functional options.
+	Option func(*Level)
+)
+
+// New returns a new Memcache cache level from the given Memcached client.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(
+//			entcache.NewLRU(256),
+//			memcache.New(gomemcache.New("10.0.0.1:11211", "10.0.0.2:11211")),
+//		),
+//	)
+//
+// Memcached limits the size of items (1MB by default). Entries that exceed the
+// limit are split into chunks that are stored in separate items, and are read
+// back together. Entries that one of their chunks was evicted are treated as
+// missing.
+func New(c *memcache.Client, opts ...Option) *Level {
+	m := &Level{c: c, itemSize: 1 << 20}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// ItemSize configures the maximum item size of the Memcached servers
+// (i.e. the -I flag of memcached). The default is 1MB.
+func ItemSize(size int) Option {
+	return func(m *Level) {
+		m.itemSize = size
+	}
+}
+
+// List of item flags that describe the
+// content of the items stored by the level.
+const (
+	memcacheEntry  uint32 = iota // item holds an encoded entry.
+	memcacheChunks               // item holds the header of a chunked entry.
+)
+
+// memcacheOverhead is the space reserved for the item header
+// (key, flags and CAS) within the item size limit.
+const memcacheOverhead = 1024
+
+// Add adds the entry to the cache.
+func (m *Level) Add(_ context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	key, err := m.key(k)
+	if err != nil || key == "" {
+		return err
+	}
+	buf, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	exp := memcacheExpiration(e.Remaining(ttl))
+	size := m.itemSize - memcacheOverhead
+	if len(buf) <= size {
+		return m.c.Set(&memcache.Item{Key: key, Value: buf, Flags: memcacheEntry, Expiration: exp})
+	}
+	// Chunks are stored under a random prefix, to avoid mixing
+	// them with the chunks of entries that are added concurrently.
+	prefix := fmt.Sprintf("%s:%x", key, rand.Uint64())
+	n := (len(buf) + size - 1) / size
+	for i := 0; i < n; i++ {
+		chunk := buf[i*size:]
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		if err := m.c.Set(&memcache.Item{Key: chunkKey(prefix, i), Value: chunk, Expiration: exp}); err != nil {
+			return err
+		}
+	}
+	return m.c.Set(&memcache.Item{Key: key, Value: []byte(fmt.Sprintf("%s %d", prefix, n)), Flags: memcacheChunks, Expiration: exp})
+}
+
+// Get gets an entry from the cache.
+func (m *Level) Get(_ context.Context, k entcache.Key) (*entcache.Entry, error) {
+	key, err := m.key(k)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, entcache.ErrNotFound
+	}
+	it, err := m.c.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return nil, entcache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := it.Value
+	if it.Flags == memcacheChunks {
+		if buf, err = m.chunks(it.Value); err != nil {
+			return nil, err
+		}
+	}
+	e := &entcache.Entry{}
+	if err := e.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	if e.Expired() {
+		return nil, entcache.ErrNotFound
+	}
+	return e, nil
+}
+
+// Del deletes an entry from the cache. The chunks of large
+// entries are not deleted, and expire with the entry TTL.
+func (m *Level) Del(_ context.Context, k entcache.Key) error {
+	key, err := m.key(k)
+	if err != nil || key == "" {
+		return err
+	}
+	if err := m.c.Delete(key); err != nil && err != memcache.ErrCacheMiss {
+		return err
+	}
+	return nil
+}
+
+// chunks reads the chunks of an entry using its header.
+func (m *Level) chunks(header []byte) ([]byte, error) {
+	prefix, count, ok := strings.Cut(string(header), " ")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n <= 0 {
+		return nil, fmt.Errorf("entcache: invalid memcache chunks header: %q", header)
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = chunkKey(prefix, i)
+	}
+	items, err := m.c.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for _, k := range keys {
+		it, ok := items[k]
+		if !ok {
+			// One of the chunks was evicted.
+			return nil, entcache.ErrNotFound
+		}
+		buf = append(buf, it.Value...)
+	}
+	return buf, nil
+}
+
+// memcacheKeyLimit is the maximum length of keys that are used as is.
+// Longer keys are hashed, leaving room for the suffix of chunk keys.
+const memcacheKeyLimit = 200
+
+// key returns the Memcached key of the given Key. Keys that are too long,
+// or contain characters that are not allowed by Memcached, are hashed.
+func (m *Level) key(k entcache.Key) (string, error) {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return "", err
+	}
+	if len(key) <= memcacheKeyLimit && strings.IndexFunc(key, func(r rune) bool { return r <= ' ' || r == 0x7f }) == -1 {
+		return key, nil
+	}
+	h := sha1.Sum([]byte(key))
+	return "entcache:" + hex.EncodeToString(h[:]), nil
+}
+
+// chunkKey returns the key of the i-th chunk of an entry.
+func chunkKey(prefix string, i int) string {
+	return prefix + ":" + strconv.Itoa(i)
+}
+
+// memcacheMaxRelative is the maximum expiration time that is
+// interpreted by Memcached as relative to the current time.
+const memcacheMaxRelative = 30 * 24 * time.Hour
+
+// memcacheExpiration returns the Memcached expiration time of the given TTL.
+// TTLs are rounded up to seconds, as Memcached does not support less, and
+// negative TTLs expire the item immediately.
+func memcacheExpiration(ttl time.Duration) int32 {
+	switch {
+	case ttl < 0:
+		return -1
+	case ttl == 0:
+		return 0
+	case ttl > memcacheMaxRelative:
+		return int32(time.Now().Add(ttl).Unix())
+	default:
+		return int32((ttl + time.Second - 1) / time.Second)
+	}
+}
+
+// Servers implements the memcache.ServerSelector interface, and distributes
+// keys between Memcached servers using ketama consistent hashing. Hence, adding or
+// removing a server remaps only the keys of that server.
+//
+//	ss, err := memcache.NewServers("10.0.0.1:11211", "10.0.0.2:11211", "10.0.0.3:11211")
+//	if err != nil {
+//		return err
+//	}
+//	entcache.NewDriver(drv, entcache.Levels(entcache.NewLRU(256), memcache.New(ss.Client())))
+//
+// Servers that fail to accept connections FailureLimit consecutive times are ejected
+// for RetryTimeout, and their keys are served by the next servers on the ring in the
+// meantime. Therefore, the loss of a server degrades the hit rate, instead of failing
+// the operations on its keys. Failures are tracked only by clients that are created
+// using the Client method.
+type Servers struct {
+	// FailureLimit is the number of consecutive connection failures that
+	// eject a server. The default is 2. It should be set before first use.
+	FailureLimit int
+	// RetryTimeout is the duration that ejected servers are skipped
+	// for. The default is 30s. It should be set before first use.
+	RetryTimeout time.Duration
+
+	mu     sync.RWMutex
+	ring   []ketamaPoint
+	byAddr map[string]*memcacheNode
+}
+
+type (
+	// memcacheNode is a server of the ring.
+	memcacheNode struct {
+		addr     net.Addr
+		failures int
+		// ejected holds the time until the server is ejected.
+		ejected time.Time
+	}
+
+	// ketamaPoint is a point of a server on the ring.
+	ketamaPoint struct {
+		hash uint32
+		node *memcacheNode
+	}
+)
+
+// ketamaPoints is the number of md5 digests that are computed per server.
+// Each digest is split into 4 points on the ring, like libmemcached does.
+const ketamaPoints = 40
+
+// NewServers returns a Servers ring with the given servers,
+// using the same address format as gomemcache.New.
+func NewServers(servers ...string) (*Servers, error) {
+	s := &Servers{FailureLimit: 2, RetryTimeout: 30 * time.Second}
+	if err := s.SetServers(servers...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetServers replaces the servers of the ring. The failures of
+// servers that are kept in the ring are preserved.
+func (s *Servers) SetServers(servers ...string) error {
+	var (
+		ring   = make([]ketamaPoint, 0, len(servers)*ketamaPoints*4)
+		byAddr = make(map[string]*memcacheNode, len(servers))
+	)
+	s.mu.RLock()
+	prev := s.byAddr
+	s.mu.RUnlock()
+	for _, server := range servers {
+		addr, err := memcacheAddr(server)
+		if err != nil {
+			return err
+		}
+		if _, ok := byAddr[addr.String()]; ok {
+			return fmt.Errorf("entcache: duplicate memcache server: %q", server)
+		}
+		n := &memcacheNode{addr: addr}
+		if p, ok := prev[addr.String()]; ok {
+			n = p
+		}
+		byAddr[addr.String()] = n
+		for i := 0; i < ketamaPoints; i++ {
+			d := md5.Sum([]byte(server + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				ring = append(ring, ketamaPoint{hash: binary.LittleEndian.Uint32(d[j*4:]), node: n})
+			}
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	s.mu.Lock()
+	s.ring, s.byAddr = ring, byAddr
+	s.mu.Unlock()
+	return nil
+}
+
+// memcacheAddr resolves the address of a server, like memcache.ServerList does.
+func memcacheAddr(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		return net.ResolveUnixAddr("unix", server)
+	}
+	return net.ResolveTCPAddr("tcp", server)
+}
+
+// PickServer implements the memcache.ServerSelector interface. It returns the
+// first server on the ring at or after the hash of the key that is not ejected.
+func (s *Servers) PickServer(key string) (net.Addr, error) {
+	d := md5.Sum([]byte(key))
+	h, now := binary.LittleEndian.Uint32(d[:4]), time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	for j := range s.ring {
+		if n := s.ring[(i+j)%len(s.ring)].node; !now.Before(n.ejected) {
+			return n.addr, nil
+		}
+	}
+	return nil, memcache.ErrNoServers
+}
+
+// Each implements the memcache.ServerSelector interface.
+// Servers that are currently ejected are skipped.
+func (s *Servers) Each(f func(net.Addr) error) error {
+	now := time.Now()
+	s.mu.RLock()
+	addrs := make([]net.Addr, 0, len(s.byAddr))
+	for _, n := range s.byAddr {
+		if !now.Before(n.ejected) {
+			addrs = append(addrs, n.addr)
+		}
+	}
+	s.mu.RUnlock()
+	for _, a := range addrs {
+		if err := f(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Client returns a Memcached client that uses the servers, and reports
+// its connection failures to them.
+func (s *Servers) Client() *memcache.Client {
+	c := memcache.NewFromSelector(s)
+	c.DialContext = s.dial
+	return c
+}
+
+// dial connects to a server, and ejects it if it fails too many times.
+func (s *Servers) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, address)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.byAddr[address]
+	switch {
+	case !ok:
+	case err == nil:
+		n.failures = 0
+	default:
+		if n.failures++; n.failures >= s.FailureLimit {
+			n.ejected = time.Now().Add(s.RetryTimeout)
+			// A single failure re-ejects the
+			// server once the timeout is over.
+			n.failures = s.FailureLimit - 1
+		}
+	}
+	return c, err
+}
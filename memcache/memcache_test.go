@@ -0,0 +1,114 @@
+package memcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"testing"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/leveltest"
+	"ariga.io/entcache/memcache"
+
+	gomemcache "github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestLevel(t *testing.T) {
+	m := runMemcached(t)
+	c := gomemcache.New(m.Addr())
+	t.Cleanup(func() { c.Close() })
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return memcache.New(c)
+	})
+}
+
+func TestServers(t *testing.T) {
+	ss, err := memcache.NewServers(runMemcached(t).Addr(), runMemcached(t).Addr(), runMemcached(t).Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ss.Client()
+	t.Cleanup(func() { c.Close() })
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return memcache.New(c)
+	})
+}
+
+func TestServers_Ketama(t *testing.T) {
+	servers := []string{"127.0.0.1:11211", "127.0.0.1:11212", "127.0.0.1:11213"}
+	all, err := memcache.NewServers(servers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	some, err := memcache.NewServers(servers[:2]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Removing a server remaps only its keys.
+	picks := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key-", i)
+		a1, err := all.PickServer(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks[a1.String()]++
+		a2, err := some.PickServer(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a1.String() != servers[2] && a1.String() != a2.String() {
+			t.Fatalf("key %q was moved from %s to %s", key, a1, a2)
+		}
+	}
+	for _, s := range servers {
+		if picks[s] < 200 {
+			t.Fatalf("unexpected distribution of keys: %v", picks)
+		}
+	}
+	if _, err := memcache.NewServers(servers[0], servers[0]); err == nil {
+		t.Fatal("expected error for duplicate servers")
+	}
+}
+
+func TestServers_Ejection(t *testing.T) {
+	ctx := context.Background()
+	// A server that refuses connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln.Addr().String()
+	ln.Close()
+	ss, err := memcache.NewServers(runMemcached(t).Addr(), down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ss.Client()
+	t.Cleanup(func() { c.Close() })
+	m := memcache.New(c)
+	e := &entcache.Entry{Values: [][]driver.Value{{"a8m"}}}
+	var failures int
+	for i := 0; i < 100; i++ {
+		if err := m.Add(ctx, fmt.Sprint("key-", i), e, 0); err != nil {
+			failures++
+		}
+	}
+	// The server is ejected after 2 failures, and its keys
+	// are stored on the other server of the ring.
+	if failures != 2 {
+		t.Fatalf("expected 2 failures, got: %d", failures)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := m.Get(ctx, fmt.Sprint("key-", i)); err != nil && err != entcache.ErrNotFound {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Add(ctx, "key-0", e, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(ctx, "key-0"); err != nil {
+		t.Fatal(err)
+	}
+}
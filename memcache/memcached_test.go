@@ -0,0 +1,121 @@
+package memcache_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memcached is a minimal in-memory Memcached server that supports the
+// commands used by the Memcache level (gets, set and delete).
+type memcached struct {
+	ln       net.Listener
+	itemSize int
+	mu       sync.Mutex
+	items    map[string]memcachedItem
+}
+
+type memcachedItem struct {
+	flags  string
+	value  []byte
+	expiry time.Time
+}
+
+// runMemcached starts a memcached server that is closed with the test.
+func runMemcached(t *testing.T) *memcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &memcached{ln: ln, itemSize: 1 << 20, items: make(map[string]memcachedItem)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(c)
+		}
+	}()
+	return m
+}
+
+func (m *memcached) Addr() string {
+	return m.ln.Addr().String()
+}
+
+func (m *memcached) serve(c net.Conn) {
+	defer c.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "gets", "get":
+			m.mu.Lock()
+			for _, k := range args[1:] {
+				if it, ok := m.items[k]; ok && (it.expiry.IsZero() || time.Now().Before(it.expiry)) {
+					fmt.Fprintf(rw, "VALUE %s %s %d 0\r\n%s\r\n", k, it.flags, len(it.value), it.value)
+				}
+			}
+			m.mu.Unlock()
+			rw.WriteString("END\r\n")
+		case "set":
+			if len(args) != 5 {
+				rw.WriteString("ERROR\r\n")
+				break
+			}
+			exp, _ := strconv.Atoi(args[3])
+			n, _ := strconv.Atoi(args[4])
+			value := make([]byte, n+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				return
+			}
+			if n > m.itemSize {
+				rw.WriteString("SERVER_ERROR object too large for cache\r\n")
+				break
+			}
+			it := memcachedItem{flags: args[2], value: value[:n]}
+			switch {
+			case exp < 0:
+				it.expiry = time.Now()
+			case exp > 30*24*60*60:
+				it.expiry = time.Unix(int64(exp), 0)
+			case exp > 0:
+				it.expiry = time.Now().Add(time.Duration(exp) * time.Second)
+			}
+			m.mu.Lock()
+			m.items[args[1]] = it
+			m.mu.Unlock()
+			rw.WriteString("STORED\r\n")
+		case "delete":
+			m.mu.Lock()
+			_, ok := m.items[args[1]]
+			delete(m.items, args[1])
+			m.mu.Unlock()
+			if ok {
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
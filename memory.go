@@ -0,0 +1,164 @@
+package entcache
+
+import (
+	"context"
+	"math"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// MemoryOption allows configuring the memory watcher
+	// (see WatchMemory) using functional options.
+	MemoryOption func(*memoryWatcher)
+
+	// memoryWatcher shrinks an LRU cache under memory pressure.
+	memoryWatcher struct {
+		l         *LRU
+		interval  time.Duration
+		high, low float64
+		usage     func() (used, limit uint64)
+		// State of the watcher. Original holds the budget
+		// of the cache before the pressure started.
+		pressured bool
+		original  int
+	}
+)
+
+// WatchMemory starts a watcher that monitors the memory usage of the process,
+// and shrinks the budget of the given LRU cache when the usage approaches the
+// memory limit of the process. If the usage stays high, local caching is
+// disabled until the pressure subsides, and the original budget is restored
+// once the usage drops below the low watermark. The watcher stops when ctx is
+// done.
+//
+//	lru := entcache.NewLRU(10000)
+//	go entcache.WatchMemory(ctx, lru)
+//	entcache.NewDriver(drv, entcache.Levels(lru, entcache.NewRedis(rdb)))
+//
+// By default, the memory limit is the soft limit of the Go runtime (GOMEMLIMIT),
+// or the cgroup limit of the container if not set. The watcher is a no-op for
+// processes without a limit.
+func WatchMemory(ctx context.Context, l *LRU, opts ...MemoryOption) {
+	w := &memoryWatcher{
+		l:        l,
+		interval: time.Second,
+		high:     0.9,
+		low:      0.8,
+		usage:    memoryUsage,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if w.pressured {
+				w.restore()
+			}
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// MemoryInterval configures the polling interval of the memory watcher. The default is 1s.
+func MemoryInterval(d time.Duration) MemoryOption {
+	return func(w *memoryWatcher) {
+		w.interval = d
+	}
+}
+
+// MemoryWatermarks configures the fractions of the memory limit that start
+// and end the memory pressure. The defaults are 0.9 and 0.8 respectively.
+func MemoryWatermarks(high, low float64) MemoryOption {
+	return func(w *memoryWatcher) {
+		w.high, w.low = high, low
+	}
+}
+
+// MemoryUsage configures the function that reports the memory usage and the
+// memory limit of the process, in bytes. A zero limit means no limit.
+func MemoryUsage(f func() (used, limit uint64)) MemoryOption {
+	return func(w *memoryWatcher) {
+		w.usage = f
+	}
+}
+
+// check checks the memory usage, and resizes the cache accordingly.
+func (w *memoryWatcher) check() {
+	used, limit := w.usage()
+	if limit == 0 {
+		return
+	}
+	switch ratio := float64(used) / float64(limit); {
+	case ratio >= w.high:
+		w.shrink()
+	case ratio < w.low && w.pressured:
+		w.restore()
+	}
+}
+
+// shrink halves the budget of the cache, or disables it if it is already empty.
+func (w *memoryWatcher) shrink() {
+	w.l.mu.Lock()
+	defer w.l.mu.Unlock()
+	if !w.pressured {
+		w.pressured, w.original = true, w.l.MaxEntries
+	}
+	n := w.l.c.len() / 2
+	if n == 0 {
+		w.l.clear()
+		w.l.disabled = true
+		return
+	}
+	w.l.MaxEntries = n
+	w.l.c.trim(n)
+}
+
+// restore restores the original budget of the cache.
+func (w *memoryWatcher) restore() {
+	w.l.mu.Lock()
+	w.l.MaxEntries, w.l.disabled = w.original, false
+	w.l.mu.Unlock()
+	w.pressured = false
+}
+
+// memoryUsage returns the memory usage of the process, as accounted
+// by the Go runtime for its memory limit, and the memory limit.
+func memoryUsage() (used, limit uint64) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	used = samples[0].Value.Uint64() - samples[1].Value.Uint64()
+	if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+		return used, uint64(l)
+	}
+	return used, cgroupLimit()
+}
+
+// cgroupLimit returns the memory limit of the cgroup of the process (v2 or v1), or zero if there is no limit.
+func cgroupLimit() uint64 {
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		l, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+		// cgroup v1 reports a huge number (page-aligned MaxInt64) for no limit.
+		if err != nil || l >= math.MaxInt64/2 {
+			return 0
+		}
+		return l
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package entcache
+
+import (
+	stdsql "database/sql"
+	"slices"
+	"sync"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+)
+
+// ColumnMetadata configures the driver to cache the column metadata (i.e. the names
+// and the types) of up to size query fingerprints (see Fingerprint), separately from
+// their rows. Hence, rows that are served from the cache support ColumnTypes, and the
+// entries of the same query share the column names that were recorded before.
+//
+//	entcache.NewDriver(drv, entcache.ColumnMetadata(1024))
+//
+// The metadata is captured from the database on the first miss of each fingerprint,
+// and therefore, hits of queries that were not executed by the process yet (e.g. their
+// entries were added to Redis by other processes) do not support ColumnTypes.
+func ColumnMetadata(size int) Option {
+	return func(o *Options) {
+		o.ColumnMetadata = size
+	}
+}
+
+type (
+	// columnMeta holds the column metadata of a query.
+	columnMeta struct {
+		columns []string
+		types   []*stdsql.ColumnType
+	}
+
+	// metadataCache caches the column metadata of queries by their fingerprints.
+	// A nil metadataCache holds nothing.
+	metadataCache struct {
+		mu   sync.Mutex
+		size int
+		c    *lruCache[string, *columnMeta]
+	}
+)
+
+// newMetadataCache returns a metadata cache of the given size.
+func newMetadataCache(size int) *metadataCache {
+	return &metadataCache{size: size, c: newLRUCache[string, *columnMeta](nil)}
+}
+
+// types returns the column types of the query, if they were
+// captured for the same columns.
+func (m *metadataCache) types(query string, columns []string) ([]*stdsql.ColumnType, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.Lock()
+	meta, ok := m.c.get(Fingerprint(query), time.Time{})
+	m.mu.Unlock()
+	if !ok || meta.types == nil || !slices.Equal(meta.columns, columns) {
+		return nil, false
+	}
+	return meta.types, true
+}
+
+// record returns the columns of the query, as they were recorded before, and captures
+// the metadata of the given rows if it was not captured yet, or if the columns changed.
+func (m *metadataCache) record(query string, cols []string, rows sql.ColumnScanner) []string {
+	fp := Fingerprint(query)
+	m.mu.Lock()
+	meta, ok := m.c.get(fp, time.Time{})
+	m.mu.Unlock()
+	if ok && slices.Equal(meta.columns, cols) {
+		return meta.columns
+	}
+	meta = &columnMeta{columns: columns.intern(cols)}
+	// Drivers that do not support column types store only the names.
+	if types, err := rows.ColumnTypes(); err == nil && len(types) == len(cols) {
+		meta.types = types
+	}
+	m.mu.Lock()
+	m.c.add(fp, meta, time.Time{})
+	m.c.trim(m.size)
+	m.mu.Unlock()
+	return meta.columns
+}
@@ -0,0 +1,54 @@
+package entcache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// WithName returns a new Context that carries a logical name for the operation
+// of its queries. Entries that are recorded by named operations are tagged with
+// their name, and can be evicted using Driver.EvictName. In addition, the driver
+// collects cache statistics per name (see Driver.NameStats).
+//
+//	client.Todo.Query().All(entcache.WithName(ctx, "dashboard.todos"))
+func WithName(ctx context.Context, name string) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.name = name
+		c.tags = append(c.tags[:len(c.tags):len(c.tags)], nameTag(name))
+	})
+}
+
+// EvictName deletes all cache entries that were recorded by operations with
+// the given name. It fails if the configured cache does not support tagging.
+func (d *Driver) EvictName(ctx context.Context, name string) error {
+	return d.EvictTags(ctx, nameTag(name))
+}
+
+// NameStats returns the cache statistics of named operations. Note that
+// only the Gets and Hits counters are collected per name.
+func (d *Driver) NameStats() map[string]Stats {
+	stats := make(map[string]Stats)
+	d.names.Range(func(k, v any) bool {
+		s := v.(*Stats)
+		stats[k.(string)] = Stats{
+			Gets: atomic.LoadUint64(&s.Gets),
+			Hits: atomic.LoadUint64(&s.Hits),
+		}
+		return true
+	})
+	return stats
+}
+
+// nameStats returns the statistics of the named operation.
+func (d *Driver) nameStats(name string) *Stats {
+	s, ok := d.names.Load(name)
+	if !ok {
+		s, _ = d.names.LoadOrStore(name, &Stats{})
+	}
+	return s.(*Stats)
+}
+
+// nameTag returns the tag of entries that were recorded by the named operation.
+func nameTag(name string) string {
+	return "entcache:name:" + name
+}
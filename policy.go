@@ -0,0 +1,105 @@
+package entcache
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type (
+	// Policy defines the caching policy of a query.
+	Policy struct {
+		// Disable indicates that the query bypasses the cache.
+		Disable bool
+		// TTL overrides the driver TTL of the query entry, if set.
+		// TTLs that are set on the context (see WithTTL) take precedence.
+		TTL time.Duration
+		// Rollout defines the percentage of executions of the query
+		// that use the cache. Zero (or 100) means all executions.
+		Rollout int
+	}
+
+	// QueryInfo describes a query for resolving its policy.
+	QueryInfo struct {
+		// Query is the executed statement.
+		Query string
+		// Fingerprint is the fingerprint of the query (see Fingerprint).
+		Fingerprint string
+		// Tables holds the tables that are read by the query.
+		Tables []string
+		// Name is the operation name of the query, if set (see WithName).
+		Name string
+	}
+
+	// PolicyProvider provides the caching policies of queries. It is consulted
+	// on every query, and therefore, can be backed by a feature-flag system for
+	// changing policies in real time. Implementations should be fast, as they
+	// are on the query path, and reporting false falls back to the driver options.
+	PolicyProvider interface {
+		Policy(context.Context, QueryInfo) (Policy, bool)
+	}
+
+	// The PolicyFunc type is an adapter to allow the use of
+	// ordinary functions as PolicyProvider.
+	PolicyFunc func(context.Context, QueryInfo) (Policy, bool)
+)
+
+// Policy calls f(ctx, q).
+func (f PolicyFunc) Policy(ctx context.Context, q QueryInfo) (Policy, bool) {
+	return f(ctx, q)
+}
+
+// Policies configures the driver to consult the given provider for the
+// caching policy of each query. For example, backed by feature flags:
+//
+//	entcache.NewDriver(drv, entcache.Policies(entcache.PolicyFunc(func(ctx context.Context, q entcache.QueryInfo) (entcache.Policy, bool) {
+//		for _, t := range q.Tables {
+//			if flags.Bool(ctx, "cache.disable."+t) {
+//				return entcache.Policy{Disable: true}, true
+//			}
+//		}
+//		return entcache.Policy{Rollout: flags.Int(ctx, "cache.rollout")}, true
+//	})))
+func Policies(p PolicyProvider) Option {
+	return func(o *Options) {
+		o.Policy = p
+	}
+}
+
+// TTLPolicy configures the driver to resolve the TTL of queries by their arguments
+// using the given function. If the function reports false, the driver TTL is used.
+// TTLs that are set on the context (see WithTTL) or by the query policy (see Policies)
+// take precedence, and the resolved TTLs are clamped to the MinTTL and MaxTTL bounds.
+// For example, queries of today are cached briefly, and historical ranges for a day:
+//
+//	entcache.NewDriver(drv, entcache.TTLPolicy(func(query string, args []any) (time.Duration, bool) {
+//		for _, arg := range args {
+//			if t, ok := arg.(time.Time); ok && time.Since(t) < 24*time.Hour {
+//				return 30 * time.Second, true
+//			}
+//		}
+//		return 24 * time.Hour, true
+//	}))
+func TTLPolicy(f func(query string, args []any) (time.Duration, bool)) Option {
+	return func(o *Options) {
+		o.TTLFunc = f
+	}
+}
+
+// policy resolves the policy of the query, if a provider is configured.
+func (d *Driver) policy(ctx context.Context, query string, opts ctxOptions) (Policy, bool) {
+	if d.Policy == nil {
+		return Policy{}, false
+	}
+	return d.Policy.Policy(ctx, QueryInfo{
+		Query:       query,
+		Fingerprint: Fingerprint(query),
+		Tables:      queryTables(query),
+		Name:        opts.name,
+	})
+}
+
+// bypass reports if the query execution bypasses the cache according to the policy.
+func (p Policy) bypass() bool {
+	return p.Disable || p.Rollout > 0 && p.Rollout < 100 && rand.Intn(100) >= p.Rollout
+}
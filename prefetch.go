@@ -0,0 +1,95 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Prefetch configures the driver to prefetch the next pages of paginated queries
+// (i.e. queries that end with a LIMIT/OFFSET clause) in the background, when their
+// current page missed the cache. This improves the hit ratio of sequential pagination
+// patterns, like admin tables and infinite scrolls.
+//
+//	entcache.NewDriver(drv, entcache.Prefetch(2))
+//
+// Note that prefetched pages are stored in the cache with the TTL of the query that
+// triggered them, and that pages that are already cached are not fetched again.
+func Prefetch(pages int) Option {
+	return func(o *Options) {
+		o.Prefetch = pages
+	}
+}
+
+// pageRegex matches the LIMIT/OFFSET clause at the end of a paginated query.
+var pageRegex = regexp.MustCompile(`(?i)\sLIMIT\s+(\d+)(?:\s+OFFSET\s+(\d+))?\s*$`)
+
+// nextPages returns the queries of the n pages that follow the
+// given paginated query, or nil if the query is not paginated.
+func nextPages(query string, n int) []string {
+	loc := pageRegex.FindStringSubmatchIndex(query)
+	if loc == nil {
+		return nil
+	}
+	limit, err := strconv.Atoi(query[loc[2]:loc[3]])
+	if err != nil || limit <= 0 {
+		return nil
+	}
+	var offset int
+	if loc[4] != -1 {
+		if offset, err = strconv.Atoi(query[loc[4]:loc[5]]); err != nil {
+			return nil
+		}
+	}
+	pages := make([]string, n)
+	for i := range pages {
+		pages[i] = fmt.Sprintf("%s LIMIT %d OFFSET %d", query[:loc[0]], limit, offset+(i+1)*limit)
+	}
+	return pages
+}
+
+// prefetchKey marks contexts of prefetch queries, to
+// prevent prefetched pages from prefetching others.
+type prefetchKey struct{}
+
+// prefetch fetches the next pages of the query in the background.
+func (d *Driver) prefetch(ctx context.Context, opts ctxOptions, query string, args any) {
+	if d.Prefetch <= 0 || ctx.Value(prefetchKey{}) != nil {
+		return
+	}
+	argv, ok := args.([]any)
+	if !ok {
+		return
+	}
+	pages := nextPages(query, d.Prefetch)
+	if len(pages) == 0 {
+		return
+	}
+	// Prefetching outlives the query, and therefore, it does not inherit its
+	// context. The context-level cache (if any) and the TTL are carried over.
+	pctx := context.WithValue(context.Background(), prefetchKey{}, true)
+	pctx = Reuse(pctx, ctx)
+	if opts.ttl != 0 {
+		pctx = WithTTL(pctx, opts.ttl)
+	}
+	go func() {
+		for _, page := range pages {
+			key, err := d.Hash(page, argv)
+			if err != nil {
+				return
+			}
+			if _, loaded := d.prefetching.LoadOrStore(key, struct{}{}); loaded {
+				continue
+			}
+			err = d.warm(pctx, page, argv)
+			d.prefetching.Delete(key)
+			if err != nil {
+				if d.Log != nil {
+					d.Log(fmt.Sprintf("entcache: failed prefetching page %q: %v", page, err))
+				}
+				return
+			}
+		}
+	}()
+}
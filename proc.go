@@ -0,0 +1,160 @@
+package entcache
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ProcLRU is an experimental in-process cache that is partitioned by the
+// processors (Ps) of the Go scheduler. Each processor owns an LRU cache, and
+// lookups are served from the cache of the processor that runs the calling
+// goroutine. Hence, lookups that run on different processors do not contend
+// on the same lock.
+//
+// The trade-off is duplication. Entries that were added by one processor are
+// copied to the caches of other processors on their first lookup there, and
+// adding or deleting an entry removes it from the caches of all processors.
+// Therefore, ProcLRU fits read-heavy workloads with a small set of hot keys.
+//
+// ProcLRU reads the processor id using the runtime.procPin function, which
+// is linked with go:linkname. Builds with the entcache_noprocpin tag do not
+// link it, and spread the lookups between the caches in round-robin instead.
+type ProcLRU struct {
+	// started and done count the writes (i.e. adds and deletes). Writes lock
+	// only the caches they modify, and copies of entries between processors
+	// are skipped if a write was running before or during the copy.
+	started, done atomic.Uint64
+	procs         []*LRU
+}
+
+// NewProcLRU creates a new ProcLRU with a cache for each of the GOMAXPROCS
+// processors. maxEntries is the limit of each processor cache, and if it is
+// zero, the caches have no limit.
+//
+//	entcache.NewDriver(drv, entcache.Levels(entcache.NewProcLRU(1024)))
+func NewProcLRU(maxEntries int) *ProcLRU {
+	p := &ProcLRU{procs: make([]*LRU, runtime.GOMAXPROCS(0))}
+	for i := range p.procs {
+		p.procs[i] = NewLRU(maxEntries)
+	}
+	return p
+}
+
+// Add adds the entry to the cache of the current processor,
+// and removes stale copies of it from the other processors.
+func (p *ProcLRU) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	p.started.Add(1)
+	defer p.done.Add(1)
+	l := p.proc()
+	if err := l.Add(ctx, k, e, ttl); err != nil {
+		return err
+	}
+	p.delOthers(l, k)
+	return nil
+}
+
+// Get gets an entry from the cache of the current processor. On miss, the entry
+// is copied from the cache of another processor, if one of them holds it. Copies
+// are skipped while entries are added or deleted, as the entry that is copied may
+// be stale by the time it is stored.
+func (p *ProcLRU) Get(ctx context.Context, k Key) (*Entry, error) {
+	l := p.proc()
+	e, err := l.Get(ctx, k)
+	if err != ErrNotFound {
+		return e, err
+	}
+	s := p.started.Load()
+	if p.done.Load() != s {
+		return nil, ErrNotFound
+	}
+	for _, o := range p.procs {
+		if o == l {
+			continue
+		}
+		if e, exp, ok := o.peek(k); ok {
+			if !l.put(k, e, exp, func() bool { return p.started.Load() == s }) {
+				return nil, ErrNotFound
+			}
+			return l.Get(ctx, k)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Del deletes an entry from the caches of all processors.
+func (p *ProcLRU) Del(ctx context.Context, k Key) error {
+	p.started.Add(1)
+	defer p.done.Add(1)
+	for _, l := range p.procs {
+		if err := l.Del(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTagged implements the Tagger interface.
+func (p *ProcLRU) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	p.started.Add(1)
+	defer p.done.Add(1)
+	l := p.proc()
+	if err := l.AddTagged(ctx, k, e, ttl, tags); err != nil {
+		return err
+	}
+	p.delOthers(l, k)
+	return nil
+}
+
+// EvictTag implements the Tagger interface. Tags are recorded only by the
+// processor that added the entry, and therefore, the tagged keys are deleted
+// from the caches of all processors. The keys are collected and deleted while
+// the caches of all processors are locked, and hence, entries that are added
+// concurrently are either evicted or added after the eviction.
+func (p *ProcLRU) EvictTag(_ context.Context, tag string) error {
+	p.started.Add(1)
+	defer p.done.Add(1)
+	for _, l := range p.procs {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+	var keys []Key
+	for _, l := range p.procs {
+		for k := range l.tags[tag] {
+			keys = append(keys, k)
+		}
+		delete(l.tags, tag)
+	}
+	for _, l := range p.procs {
+		for _, k := range keys {
+			l.c.remove(k)
+		}
+	}
+	return nil
+}
+
+// Clear purges all entries from the caches of all processors.
+func (p *ProcLRU) Clear() {
+	p.started.Add(1)
+	defer p.done.Add(1)
+	for _, l := range p.procs {
+		l.Clear()
+	}
+}
+
+// delOthers deletes the key from the caches of all processors except l.
+func (p *ProcLRU) delOthers(l *LRU, k Key) {
+	for _, o := range p.procs {
+		if o != l {
+			o.Del(context.Background(), k)
+		}
+	}
+}
+
+// proc returns the cache of the processor that runs the calling goroutine.
+// The goroutine may be moved to another processor after the call returns,
+// in which case, the lock of the returned cache may be contended.
+func (p *ProcLRU) proc() *LRU {
+	return p.procs[procID()%len(p.procs)]
+}
@@ -0,0 +1,14 @@
+//go:build entcache_noprocpin
+
+package entcache
+
+import "sync/atomic"
+
+// next is the round-robin counter of procID.
+var next uint32
+
+// procID returns the next processor id in round-robin,
+// as the runtime.procPin function is not linked.
+func procID() int {
+	return int(atomic.AddUint32(&next, 1) & (1<<31 - 1))
+}
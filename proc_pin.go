@@ -0,0 +1,23 @@
+//go:build !entcache_noprocpin
+
+package entcache
+
+import _ "unsafe"
+
+// procID returns the id of the processor that runs the calling goroutine.
+//
+// runtime.procPin is an internal function of the runtime. Go 1.23 restricts
+// go:linkname references to the runtime, but keeps procPin linkable as it
+// is widely used by other packages (see go.dev/issue/67401). If a future
+// release removes it, build with the entcache_noprocpin tag.
+func procID() int {
+	id := procPin()
+	procUnpin()
+	return id
+}
+
+//go:linkname procPin runtime.procPin
+func procPin() int
+
+//go:linkname procUnpin runtime.procUnpin
+func procUnpin()
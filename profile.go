@@ -0,0 +1,51 @@
+package entcache
+
+import (
+	"context"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+
+	"entgo.io/ent/dialect"
+)
+
+// ProfileLabels configures the driver to label the goroutines that execute
+// queries on the database after a cache miss (or skip) with pprof labels. The
+// labels are "entcache.fingerprint", holding the fingerprint of the query (see
+// Fingerprint), and "entcache.tables", holding the tables it references. Hence,
+// CPU and goroutine profiles that are taken during incidents show which uncached
+// queries dominate. With SQLComments, the sqlcommenter tags of the query are
+// added as labels as well, prefixed with "sqlcomment." (e.g. "sqlcomment.route").
+//
+//	entcache.NewDriver(drv, entcache.ProfileLabels())
+//
+// Note that the number of in-flight queries is reported by DBStats.InFlight,
+// regardless of this option.
+func ProfileLabels() Option {
+	return func(o *Options) {
+		o.ProfileLabels = true
+	}
+}
+
+// execQuery executes the query on the database, and tracks it as in-flight.
+func (d *Driver) execQuery(ctx context.Context, drv dialect.ExecQuerier, query string, args, v any) (err error) {
+	atomic.AddInt64(&d.db.InFlight, 1)
+	defer atomic.AddInt64(&d.db.InFlight, -1)
+	if !d.ProfileLabels {
+		return drv.Query(ctx, query, args, v)
+	}
+	labels := []string{
+		"entcache.fingerprint", Fingerprint(query),
+		"entcache.tables", strings.Join(queryTables(query), ","),
+	}
+	if d.SQLComments {
+		_, tags := splitComment(query)
+		for k, v := range tags {
+			labels = append(labels, "sqlcomment."+k, v)
+		}
+	}
+	pprof.Do(ctx, pprof.Labels(labels...), func(ctx context.Context) {
+		err = drv.Query(ctx, query, args, v)
+	})
+	return err
+}
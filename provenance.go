@@ -0,0 +1,52 @@
+package entcache
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Provenance describes the origin of a cache entry.
+type Provenance struct {
+	// Time is the time the entry was recorded.
+	Time time.Time
+	// Node identifies the process that recorded the entry.
+	Node string
+	// Query is the query that produced the entry.
+	Query string
+}
+
+// RecordProvenance configures the driver to store the provenance of entries
+// alongside them: the time they were recorded, the node that recorded them
+// and their query. It is useful for investigating which instance cached a
+// stale row and when, using Driver.Peek or the entcachectl command. If node
+// is empty, the host name is used.
+//
+//	entcache.NewDriver(drv, entcache.RecordProvenance(os.Getenv("POD_NAME")))
+//
+// Note that recording provenance increases the size of entries.
+func RecordProvenance(node string) Option {
+	return func(o *Options) {
+		if node == "" {
+			node, _ = os.Hostname()
+		}
+		o.Provenance, o.Node = true, node
+	}
+}
+
+// provenance returns the provenance of an entry that is
+// recorded for the given query, if provenance is enabled.
+func (d *Driver) provenance(query string) *Provenance {
+	if !d.Provenance {
+		return nil
+	}
+	return &Provenance{Time: time.Now(), Node: d.Node, Query: query}
+}
+
+// Peek returns the entry that is stored in the driver cache under the given
+// key, including its provenance if recorded. Unlike queries, peeking does not
+// affect the statistics of the driver. Keys can be computed using the Hash
+// function of the driver, or obtained from the Decision of a query.
+func (d *Driver) Peek(ctx context.Context, k Key) (*Entry, error) {
+	return d.Cache.Get(ctx, k)
+}
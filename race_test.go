@@ -0,0 +1,220 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+)
+
+func TestLevels_Race(t *testing.T) {
+	levels := func(opt entcache.Option) entcache.AddGetDeleter {
+		var o entcache.Options
+		opt(&o)
+		return o.Cache
+	}
+	tests := []struct {
+		name  string
+		ctx   func() context.Context
+		level func() entcache.AddGetDeleter
+	}{
+		{
+			name:  "LRU",
+			level: func() entcache.AddGetDeleter { return entcache.NewLRU(0) },
+		},
+		{
+			name:  "LimitedLRU",
+			level: func() entcache.AddGetDeleter { return entcache.NewLRU(8) },
+		},
+		{
+			name:  "ProcLRU",
+			level: func() entcache.AddGetDeleter { return entcache.NewProcLRU(8) },
+		},
+		{
+			name:  "ARC",
+			level: func() entcache.AddGetDeleter { return entcache.NewARC(8) },
+		},
+		{
+			name:  "TinyLFU",
+			level: func() entcache.AddGetDeleter { return entcache.NewTinyLFU(8, 0) },
+		},
+		{
+			name:  "ShardedLRU",
+			level: func() entcache.AddGetDeleter { return entcache.NewShardedLRU(4, 2) },
+		},
+		{
+			name: "MultiLevel",
+			level: func() entcache.AddGetDeleter {
+				return levels(entcache.Levels(entcache.NewLRU(8), entcache.NewLRU(0)))
+			},
+		},
+		{
+			name:  "ContextLevel",
+			ctx:   func() context.Context { return entcache.NewContext(context.Background()) },
+			level: func() entcache.AddGetDeleter { return levels(entcache.ContextLevel()) },
+		},
+		{
+			name: "LatencyBudget",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				t.Cleanup(cancel)
+				return ctx
+			},
+			level: func() entcache.AddGetDeleter { return entcache.LatencyBudget(entcache.NewLRU(0), time.Millisecond) },
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			if tt.ctx != nil {
+				ctx = tt.ctx()
+			}
+			stressLevel(ctx, t, tt.level())
+		})
+	}
+}
+
+// stressLevel runs concurrent operations on the given level, and checks that
+// entries are never corrupted. Some entries are added with a short TTL, in
+// order to exercise expiration concurrently with the other operations.
+func stressLevel(ctx context.Context, t *testing.T, l entcache.AddGetDeleter) {
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for i := 0; i < 500; i++ {
+				k := rnd.Intn(32)
+				v := fmt.Sprint("value-", k)
+				var err error
+				switch op := rnd.Intn(10); {
+				case op < 4:
+					var e *entcache.Entry
+					e, err = l.Get(ctx, k)
+					if errors.Is(err, entcache.ErrNotFound) {
+						err = nil
+					} else if err == nil && (len(e.Values) != 1 || e.Values[0][0] != v) {
+						err = fmt.Errorf("unexpected entry for key %d: %v", k, e.Values)
+					}
+				case op < 8:
+					var ttl time.Duration
+					if op == 7 {
+						ttl = time.Millisecond
+					}
+					err = l.Add(ctx, k, &entcache.Entry{Values: [][]driver.Value{{v}}}, ttl)
+				case op == 8:
+					err = l.Del(ctx, k)
+				default:
+					if tl, ok := l.(entcache.Tagger); ok {
+						if rnd.Intn(2) == 0 {
+							err = tl.AddTagged(ctx, k, &entcache.Entry{Values: [][]driver.Value{{v}}}, 0, []string{fmt.Sprint("tag-", k%4)})
+						} else {
+							err = tl.EvictTag(ctx, fmt.Sprint("tag-", k%4))
+						}
+					}
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+}
+
+func TestProcLRU_Stale(t *testing.T) {
+	var (
+		wg  sync.WaitGroup
+		ctx = context.Background()
+		l   = entcache.NewProcLRU(0)
+	)
+	// Readers copy entries between processors, while the entry is replaced.
+	stop := make(chan struct{})
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					l.Get(ctx, "k")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		if err := l.Add(ctx, "k", &entcache.Entry{Values: [][]driver.Value{{int64(i)}}}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	// Copies of replaced entries must not outlive the last Add.
+	for w := 0; w < 32; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if e, err := l.Get(ctx, "k"); err != nil || e.Values[0][0] != int64(999) {
+					t.Errorf("unexpected entry: %v, %v", e, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProcLRU_EvictTag(t *testing.T) {
+	var (
+		wg    sync.WaitGroup
+		added sync.Map
+		ctx   = context.Background()
+		l     = entcache.NewProcLRU(0)
+	)
+	// Entries are added concurrently with the eviction of their tag, and
+	// read from other processors for copying them between the caches.
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				k := fmt.Sprint(w, "-", i)
+				if err := l.AddTagged(ctx, k, &entcache.Entry{Values: [][]driver.Value{{int64(i)}}}, 0, []string{"t"}); err != nil {
+					t.Error(err)
+					return
+				}
+				added.Store(k, struct{}{})
+				l.Get(ctx, fmt.Sprint((w+1)%8, "-", i))
+			}
+		}(w)
+	}
+	time.Sleep(time.Millisecond)
+	// Entries that were added before the eviction must be evicted.
+	var before []string
+	added.Range(func(k, _ any) bool {
+		before = append(before, k.(string))
+		return true
+	})
+	if err := l.EvictTag(ctx, "t"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range before {
+		if _, err := l.Get(ctx, k); !errors.Is(err, entcache.ErrNotFound) {
+			t.Errorf("expected entry %q to be evicted, got: %v", k, err)
+		}
+	}
+	wg.Wait()
+}
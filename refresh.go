@@ -0,0 +1,176 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// minRefreshInterval is the minimum interval of refreshers. Shorter (or
+// non-positive) intervals are clamped, to avoid re-executing the query in
+// a tight loop.
+const minRefreshInterval = 10 * time.Millisecond
+
+// refresher re-executes a registered query on a schedule. Since each refresher is
+// executed by a single goroutine, its executions never overlap.
+type refresher struct {
+	query    string
+	args     []any
+	tables   []string
+	interval time.Duration
+	trigger  chan struct{}
+	stop     context.CancelFunc
+}
+
+// RegisterRefresher registers a query to be re-executed by the driver every interval
+// (with a small random jitter), and stores its results in the cache. This allows
+// serving expensive queries (e.g. dashboards) from the cache without paying their
+// cost on user requests. The returned function unregisters the refresher.
+//
+//	stop := drv.RegisterRefresher("SELECT COUNT(*) FROM `todos`", nil, time.Minute)
+//	defer stop()
+//
+// Refreshers are grouped by the tables referenced by their query, and can be triggered
+// proactively using RefreshTables (e.g. when the tables are modified).
+//
+// Note that a refresh is never started while the previous one is still running,
+// and that all refreshers are stopped when the driver is closed. Intervals that are
+// shorter than 10ms (including non-positive ones) are clamped to 10ms.
+func (d *Driver) RegisterRefresher(query string, args []any, interval time.Duration) func() {
+	if interval < minRefreshInterval {
+		interval = minRefreshInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &refresher{
+		query:    query,
+		args:     args,
+		tables:   queryTables(query),
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+		stop:     cancel,
+	}
+	d.mu.Lock()
+	if d.refreshers == nil {
+		d.refreshers = make(map[*refresher]struct{})
+	}
+	d.refreshers[r] = struct{}{}
+	d.mu.Unlock()
+	go d.runRefresher(ctx, r)
+	return func() {
+		d.mu.Lock()
+		delete(d.refreshers, r)
+		d.mu.Unlock()
+		r.stop()
+	}
+}
+
+// runRefresher runs the refresher until its context is canceled.
+func (d *Driver) runRefresher(ctx context.Context, r *refresher) {
+	timer := time.NewTimer(jitter(r.interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			d.refresh(ctx, r)
+			timer.Reset(jitter(r.interval))
+		case <-r.trigger:
+			d.refresh(ctx, r)
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(jitter(r.interval))
+		}
+	}
+}
+
+// RefreshTables triggers a background refresh of all registered refreshers
+// whose query references at least one of the given tables. Refreshers that
+// are already running, or were already triggered, are not triggered again.
+func (d *Driver) RefreshTables(tables ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for r := range d.refreshers {
+		if r.references(tables) {
+			select {
+			case r.trigger <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// references reports if the refresher query references any of the given tables.
+func (r *refresher) references(tables []string) bool {
+	for _, t := range tables {
+		for _, rt := range r.tables {
+			if t == rt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// refresh executes the refresher query.
+func (d *Driver) refresh(ctx context.Context, r *refresher) {
+	if err := d.warm(Refresh(ctx), r.query, r.args); err != nil && d.Log != nil {
+		d.Log(fmt.Sprintf("entcache: failed refreshing query %q: %v", r.query, err))
+	}
+}
+
+// Close stops all registered refreshers and the background tasks of
+// the driver (e.g. sweeping), flushes the queues of async levels, persists
+// the statistics of the driver if a StatsStore was configured, and closes
+// the underlying driver.
+func (d *Driver) Close() error {
+	d.mu.Lock()
+	for r := range d.refreshers {
+		r.stop()
+	}
+	d.refreshers = nil
+	if d.stopSweep != nil {
+		d.stopSweep()
+	}
+	if d.stopEvents != nil {
+		d.stopEvents()
+	}
+	if d.stopStats != nil {
+		d.stopStats()
+	}
+	d.mu.Unlock()
+	// Flush the async levels first, as they may wrap retry levels.
+	walkLevels(d.Cache, func(l AddGetDeleter) {
+		if a, ok := l.(*asyncLevel); ok {
+			a.close()
+		}
+	})
+	walkLevels(d.Cache, func(l AddGetDeleter) {
+		if r, ok := l.(*retryLevel); ok {
+			r.close()
+		}
+	})
+	if d.StatsStore != nil {
+		if err := d.persistStats(context.Background()); err != nil && d.Log != nil {
+			d.Log(fmt.Sprintf("entcache: failed persisting stats: %v", err))
+		}
+	}
+	return d.Driver.Close()
+}
+
+// jitter returns the given duration shifted randomly by up to 10%.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	j := int64(d) / 10
+	if j == 0 {
+		return d
+	}
+	return d - time.Duration(j) + time.Duration(rand.Int63n(2*j))
+}
@@ -0,0 +1,246 @@
+package entcache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// retryAddAttempts is the maximum number of times a failed add is retried.
+const retryAddAttempts = 5
+
+type (
+	// retryLevel wraps a cache level, and retries its failed adds
+	// in the background (see RetryAdds).
+	retryLevel struct {
+		AddGetDeleter
+		size    int
+		backoff time.Duration
+		mu      sync.Mutex
+		ll      *list.List // of *retryItem, oldest first.
+		items   map[Key]*list.Element
+		// inflight holds the items that are being retried.
+		inflight map[Key]*retryItem
+		wake     chan struct{}
+		stop     chan struct{}
+		done     chan struct{}
+		once     sync.Once
+	}
+
+	// retryItem is a failed add that is waiting for a retry.
+	retryItem struct {
+		ctx      context.Context
+		k        Key
+		e        *Entry
+		tags     []string
+		deadline time.Time // zero means no expiration.
+		attempts int
+		next     time.Time
+		// canceled indicates that the entry was deleted while it was retried.
+		canceled bool
+	}
+)
+
+// RetryAdds wraps the given cache level (usually, a remote one like Redis), and
+// retries its failed adds in the background with exponential backoff, starting
+// from the given delay. Hence, transient failures (e.g. a Redis blip) do not lose
+// the entries of rarely executed queries until they are executed again.
+//
+//	entcache.Levels(
+//		entcache.NewLRU(256),
+//		entcache.RetryAdds(entcache.NewRedis(rdb), 1024, 100*time.Millisecond),
+//	)
+//
+// The failure is still returned to the caller (and counted by LevelStats). The queue
+// holds up to size entries, and the oldest ones are dropped on overflow. Entries are
+// dropped after 5 failed retries, or if they expire in the meantime, and deleting an
+// entry cancels its pending retry. The queue is dropped when the driver is closed.
+func RetryAdds(level AddGetDeleter, size int, backoff time.Duration) AddGetDeleter {
+	r := &retryLevel{
+		AddGetDeleter: level,
+		size:          size,
+		backoff:       backoff,
+		ll:            list.New(),
+		items:         make(map[Key]*list.Element),
+		inflight:      make(map[Key]*retryItem),
+		wake:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Add adds the entry to the wrapped level, and queues it for retry on failure.
+func (r *retryLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	err := r.AddGetDeleter.Add(ctx, k, e, ttl)
+	if err != nil {
+		r.push(ctx, k, e, ttl, nil)
+	} else {
+		r.remove(k)
+	}
+	return err
+}
+
+// AddTagged implements the Tagger interface.
+func (r *retryLevel) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	err := addTagged(ctx, r.AddGetDeleter, k, e, ttl, tags)
+	if err != nil {
+		r.push(ctx, k, e, ttl, tags)
+	} else {
+		r.remove(k)
+	}
+	return err
+}
+
+// EvictTag implements the Tagger interface. Pending retries of entries that
+// may hold the tag are not tracked, and therefore, all of them are dropped.
+func (r *retryLevel) EvictTag(ctx context.Context, tag string) error {
+	r.mu.Lock()
+	r.ll.Init()
+	clear(r.items)
+	for _, it := range r.inflight {
+		it.canceled = true
+	}
+	r.mu.Unlock()
+	return evictTag(ctx, r.AddGetDeleter, tag)
+}
+
+// Del deletes the entry from the wrapped level, and cancels its pending retry.
+func (r *retryLevel) Del(ctx context.Context, k Key) error {
+	r.remove(k)
+	return r.AddGetDeleter.Del(ctx, k)
+}
+
+// push queues the entry for retry. If the queue is full, the oldest entry is dropped.
+func (r *retryLevel) push(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) {
+	if r.size <= 0 {
+		return
+	}
+	it := &retryItem{
+		ctx:  context.WithoutCancel(ctx),
+		k:    k,
+		e:    e,
+		tags: tags,
+		next: time.Now().Add(r.backoff),
+	}
+	if ttl > 0 {
+		it.deadline = time.Now().Add(ttl)
+	}
+	r.mu.Lock()
+	if el, ok := r.items[k]; ok {
+		r.ll.Remove(el)
+	}
+	r.items[k] = r.ll.PushBack(it)
+	for r.ll.Len() > r.size {
+		oldest := r.ll.Front()
+		r.ll.Remove(oldest)
+		delete(r.items, oldest.Value.(*retryItem).k)
+	}
+	r.mu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove cancels the pending retry of the key, if any.
+func (r *retryLevel) remove(k Key) {
+	r.mu.Lock()
+	if el, ok := r.items[k]; ok {
+		r.ll.Remove(el)
+		delete(r.items, k)
+	}
+	if it, ok := r.inflight[k]; ok {
+		it.canceled = true
+	}
+	r.mu.Unlock()
+}
+
+// run retries the queued adds until the level is closed.
+func (r *retryLevel) run() {
+	defer close(r.done)
+	t := time.NewTimer(r.backoff)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.wake:
+		case <-t.C:
+		}
+		next := r.retry(time.Now())
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		if next.IsZero() {
+			// Nothing to retry. Wait for the next push.
+			next = time.Now().Add(time.Hour)
+		}
+		t.Reset(time.Until(next))
+	}
+}
+
+// retry retries the adds that are due, and returns the time of the next retry.
+func (r *retryLevel) retry(now time.Time) time.Time {
+	var due []*retryItem
+	r.mu.Lock()
+	for el := r.ll.Front(); el != nil; {
+		it, nextEl := el.Value.(*retryItem), el.Next()
+		if !it.next.After(now) {
+			r.ll.Remove(el)
+			delete(r.items, it.k)
+			r.inflight[it.k] = it
+			due = append(due, it)
+		}
+		el = nextEl
+	}
+	r.mu.Unlock()
+	for _, it := range due {
+		var ttl time.Duration
+		if !it.deadline.IsZero() {
+			if ttl = it.deadline.Sub(now); ttl <= 0 {
+				r.mu.Lock()
+				delete(r.inflight, it.k)
+				r.mu.Unlock()
+				continue
+			}
+		}
+		err := addTagged(it.ctx, r.AddGetDeleter, it.k, it.e, ttl, it.tags)
+		r.mu.Lock()
+		delete(r.inflight, it.k)
+		canceled := it.canceled
+		it.attempts++
+		// Do not override a newer add (or retry) of the key.
+		if _, ok := r.items[it.k]; !ok && !canceled && err != nil && it.attempts < retryAddAttempts {
+			it.next = now.Add(r.backoff << it.attempts)
+			r.items[it.k] = r.ll.PushBack(it)
+		}
+		r.mu.Unlock()
+		// The entry was deleted while it was added.
+		if canceled && err == nil {
+			r.AddGetDeleter.Del(it.ctx, it.k)
+		}
+	}
+	var next time.Time
+	r.mu.Lock()
+	for el := r.ll.Front(); el != nil; el = el.Next() {
+		if it := el.Value.(*retryItem); next.IsZero() || it.next.Before(next) {
+			next = it.next
+		}
+	}
+	r.mu.Unlock()
+	return next
+}
+
+// close stops retrying, and drops the queued adds.
+func (r *retryLevel) close() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
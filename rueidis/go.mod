@@ -0,0 +1,27 @@
+module ariga.io/entcache/rueidis
+
+go 1.22
+
+require (
+	ariga.io/entcache v0.0.0-00010101000000-000000000000
+	github.com/alicebob/miniredis/v2 v2.30.4
+	github.com/redis/go-redis/v9 v9.0.5
+	github.com/redis/rueidis v1.0.19
+)
+
+require (
+	entgo.io/ent v0.11.2-0.20220805114204-0066eb986dd3 // indirect
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.3 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/mitchellh/hashstructure/v2 v2.0.2 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
+)
+
+replace ariga.io/entcache => ../
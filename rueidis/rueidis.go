@@ -0,0 +1,125 @@
+// Package rueidis provides a remote cache level for entcache, that is backed
+// by Redis using the rueidis client and its client-side caching.
+package rueidis
+
+import (
+	"context"
+	"time"
+
+	"ariga.io/entcache"
+
+	"github.com/redis/rueidis"
+)
+
+type (
+	// Level provides a remote cache backed by Redis using the rueidis client,
+	// and implements the entcache.AddGetDeleter interface. Lookups use the client-side
+	// caching of RESP3 (server-assisted invalidation), and therefore, hot entries
+	// are served from the local memory of the client, and are invalidated by Redis
+	// once their key is modified or deleted (e.g. evicted by another instance).
+	Level struct {
+		c        rueidis.Client
+		localTTL time.Duration
+		compress entcache.Compression
+	}
+
+	// Option allows configuring the Rueidis
+	// cache level using functional options.
+	Option func(*Level)
+)
+
+// New returns a new Rueidis cache level from the given rueidis client.
+//
+//	c, err := rueidis.NewClient(rueidis.ClientOption{
+//		InitAddress: []string{":6379"},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	entcache.NewDriver(drv, entcache.Levels(entrueidis.New(c)))
+//
+// Client-side caching requires Redis 6 or above. For servers that do not support
+// it, the client should be created with the DisableCache option, and lookups are
+// sent to Redis as regular GET commands.
+func New(c rueidis.Client, opts ...Option) *Level {
+	r := &Level{c: c, localTTL: time.Minute}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// LocalTTL configures the maximum duration that entries are served from
+// the local memory of the client. Entries are invalidated by Redis when their
+// key is modified, and therefore, it only bounds the memory usage and staleness
+// on a lost connection. The default is 1 minute. Note that, local entries never
+// outlive the TTL of their Redis key.
+func LocalTTL(d time.Duration) Option {
+	return func(r *Level) {
+		r.localTTL = d
+	}
+}
+
+// Compression configures the compression of the stored entries.
+func Compression(c entcache.Compression) Option {
+	return func(r *Level) {
+		r.compress = c
+	}
+}
+
+// Add adds the entry to the cache.
+func (r *Level) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	key, err := entcache.MarshalKey(k)
+	if err != nil || key == "" {
+		return err
+	}
+	buf, err := e.WithExpiry(ttl).Encode(r.compress)
+	if err != nil {
+		return err
+	}
+	set := r.c.B().Set().Key(key).Value(rueidis.BinaryString(buf))
+	if ttl = e.Remaining(ttl); ttl > 0 {
+		// Expirations are set in milliseconds.
+		if ttl < time.Millisecond {
+			ttl = time.Millisecond
+		}
+		return r.c.Do(ctx, set.Px(ttl).Build()).Error()
+	}
+	return r.c.Do(ctx, set.Build()).Error()
+}
+
+// Get gets an entry from the cache.
+func (r *Level) Get(ctx context.Context, k entcache.Key) (*entcache.Entry, error) {
+	key, err := entcache.MarshalKey(k)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, entcache.ErrNotFound
+	}
+	buf, err := r.c.DoCache(ctx, r.c.B().Get().Key(key).Cache(), r.localTTL).AsBytes()
+	if rueidis.IsRedisNil(err) || err == nil && len(buf) == 0 {
+		return nil, entcache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := &entcache.Entry{}
+	if err := e.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	if e.Expired() {
+		return nil, entcache.ErrNotFound
+	}
+	return e, nil
+}
+
+// Del deletes an entry from the cache. Clients that cached
+// the entry locally are notified by Redis to invalidate it.
+func (r *Level) Del(ctx context.Context, k entcache.Key) error {
+	key, err := entcache.MarshalKey(k)
+	if err != nil || key == "" {
+		return err
+	}
+	return r.c.Do(ctx, r.c.B().Del().Key(key).Build()).Error()
+}
@@ -0,0 +1,77 @@
+package rueidis_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/leveltest"
+	entrueidis "ariga.io/entcache/rueidis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/rueidis"
+)
+
+func TestLevel(t *testing.T) {
+	m := miniredis.RunT(t)
+	// miniredis does not support client-side caching.
+	c, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{m.Addr()}, DisableCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return fastForward{entrueidis.New(c), m}
+	})
+}
+
+func TestLevel_Redis(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	c, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{m.Addr()}, DisableCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	r := entrueidis.New(c, entrueidis.Compression(entcache.CompressionSnappy))
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	if err := r.Add(ctx, "k", e, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := m.TTL("k"); ttl != time.Minute {
+		t.Fatalf("unexpected key TTL: %v", ttl)
+	}
+	// Entries are shared with the go-redis based level.
+	got, err := entcache.NewRedis(rdb).Get(ctx, "k")
+	if err != nil || got.Values[0][0] != "a8m" {
+		t.Fatalf("unexpected entry: %v, %v", got, err)
+	}
+	if err := entcache.NewRedis(rdb).Add(ctx, "k", &entcache.Entry{Values: [][]driver.Value{{"ent"}}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = r.Get(ctx, "k"); err != nil || got.Values[0][0] != "ent" {
+		t.Fatalf("unexpected entry: %v, %v", got, err)
+	}
+	if err := r.Del(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, "k"); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+// fastForward moves the miniredis clock forward on TTL tests.
+type fastForward struct {
+	entcache.AddGetDeleter
+	m *miniredis.Miniredis
+}
+
+func (f fastForward) FastForward(d time.Duration) {
+	f.m.FastForward(d)
+}
@@ -0,0 +1,50 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// objects is a minimal in-memory object storage,
+// that is exposed using the API of the S3 client.
+type objects struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newObjects() *objects {
+	return &objects{data: make(map[string][]byte)}
+}
+
+func (o *objects) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b, ok := o.data[*in.Key]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (o *objects) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	o.data[*in.Key] = b
+	o.mu.Unlock()
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (o *objects) DeleteObject(_ context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	o.mu.Lock()
+	delete(o.data, *in.Key)
+	o.mu.Unlock()
+	return &s3.DeleteObjectOutput{}, nil
+}
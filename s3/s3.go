@@ -0,0 +1,83 @@
+// Package s3 provides an object storage cache level for entcache,
+// that stores large entries in an S3 bucket.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"ariga.io/entcache"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type (
+	// Client is the subset of the S3 client API that is used by
+	// the S3 cache level. It is implemented by *s3.Client.
+	Client interface {
+		GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+		PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+		DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	}
+
+	// store implements the entcache.BlobStore interface for an S3 bucket.
+	store struct {
+		c      Client
+		bucket *string
+	}
+)
+
+// New returns a new entcache.Blob cache level that stores its entries in the given
+// S3 bucket. Only entries that are larger than the minimum size (1MB by default)
+// are stored, and therefore, it should be the last level of the cache.
+//
+//	cfg, err := config.LoadDefaultConfig(ctx)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(
+//			entcache.NewLRU(256),
+//			entcache.NewRedis(rdb),
+//			s3.New(awss3.NewFromConfig(cfg), "entcache", entcache.BlobMinSize(512<<10)),
+//		),
+//	)
+func New(c Client, bucket string, opts ...entcache.BlobOption) *entcache.Blob {
+	return entcache.NewBlob(&store{c: c, bucket: aws.String(bucket)}, opts...)
+}
+
+// Get implements the entcache.BlobStore interface.
+func (s *store) Get(ctx context.Context, name string) ([]byte, error) {
+	out, err := s.c.GetObject(ctx, &s3.GetObjectInput{Bucket: s.bucket, Key: aws.String(name)})
+	var nerr *types.NoSuchKey
+	if errors.As(err, &nerr) {
+		return nil, entcache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// Put implements the entcache.BlobStore interface.
+func (s *store) Put(ctx context.Context, name string, data []byte) error {
+	_, err := s.c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        s.bucket,
+		Key:           aws.String(name),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	return err
+}
+
+// Delete implements the entcache.BlobStore interface.
+func (s *store) Delete(ctx context.Context, name string) error {
+	_, err := s.c.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: s.bucket, Key: aws.String(name)})
+	return err
+}
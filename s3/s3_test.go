@@ -0,0 +1,16 @@
+package s3_test
+
+import (
+	"testing"
+
+	"ariga.io/entcache"
+	"ariga.io/entcache/leveltest"
+	"ariga.io/entcache/s3"
+)
+
+func TestLevel(t *testing.T) {
+	c := newObjects()
+	leveltest.Run(t, func() entcache.AddGetDeleter {
+		return s3.New(c, "entcache", entcache.BlobMinSize(0))
+	})
+}
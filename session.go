@@ -0,0 +1,128 @@
+package entcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReadYourWrites configures the driver to provide read-your-writes consistency
+// for sessions (see Session). After a session modified a table, its queries that
+// read the table bypass the cache for the given window, while other sessions are
+// still served from the cache. For example:
+//
+//	entcache.NewDriver(drv, entcache.ReadYourWrites(5*time.Second))
+//
+// Note that writes are tracked in-process. Hence, sessions should be routed to
+// the same process (e.g. using sticky sessions) for the guarantee to hold.
+func ReadYourWrites(window time.Duration) Option {
+	return func(o *Options) {
+		o.SessionWindow = window
+	}
+}
+
+// Session returns a new Context that carries the identifier of the session that
+// executes its statements (e.g. the authenticated user). See ReadYourWrites for
+// more info.
+//
+//	ctx = entcache.Session(r.Context(), userID)
+func Session(ctx context.Context, id string) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.session = id
+	})
+}
+
+// allTables is the table of session writes that their tables were not detected.
+const allTables = "*"
+
+// sessionWrites tracks the tables that were modified by sessions within a window.
+type sessionWrites struct {
+	window time.Duration
+	mu     sync.Mutex
+	sweep  time.Time
+	// writes holds the write deadline of tables by session.
+	writes map[string]map[string]time.Time
+}
+
+func newSessionWrites(window time.Duration) *sessionWrites {
+	return &sessionWrites{window: window, writes: make(map[string]map[string]time.Time)}
+}
+
+// wrote records that the session modified the tables of the given statement.
+func (s *sessionWrites) wrote(session, query string) {
+	tables := queryTables(query)
+	if len(tables) == 0 {
+		tables = []string{allTables}
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gc(now)
+	w, ok := s.writes[session]
+	if !ok {
+		w = make(map[string]time.Time)
+		s.writes[session] = w
+	}
+	for _, t := range tables {
+		w[t] = now.Add(s.window)
+	}
+}
+
+// reads reports if the given query reads a table
+// that was recently modified by the session.
+func (s *sessionWrites) reads(session, query string) bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.writes[session]
+	if !ok {
+		return false
+	}
+	if now.Before(w[allTables]) {
+		return true
+	}
+	tables := queryTables(query)
+	if len(tables) == 0 {
+		// Tables were not detected, and the session has pending writes.
+		for _, deadline := range w {
+			if now.Before(deadline) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, t := range tables {
+		if now.Before(w[t]) {
+			return true
+		}
+	}
+	return false
+}
+
+// gc drops expired writes, at most once per window. The caller must hold the lock.
+func (s *sessionWrites) gc(now time.Time) {
+	if now.Sub(s.sweep) < s.window {
+		return
+	}
+	for session, w := range s.writes {
+		for t, deadline := range w {
+			if !now.Before(deadline) {
+				delete(w, t)
+			}
+		}
+		if len(w) == 0 {
+			delete(s.writes, session)
+		}
+	}
+	s.sweep = now
+}
+
+// sessionWrite records the write of the session that is carried by ctx, if any.
+func (d *Driver) sessionWrite(ctx context.Context, query string) {
+	if d.sessions == nil {
+		return
+	}
+	if c, ok := ctx.Value(ctxOptionsKey{}).(*ctxOptions); ok && c.session != "" {
+		d.sessions.wrote(c.session, query)
+	}
+}
@@ -0,0 +1,139 @@
+package entcache
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// ShardedLRU is an in-process cache that is partitioned into independently
+	// locked LRU caches (shards). Keys are hashed across the shards, and therefore,
+	// operations on different keys rarely contend on the same lock. Unlike ProcLRU,
+	// entries are not duplicated, and each key is stored in a single shard.
+	ShardedLRU struct {
+		shards []*LRU
+	}
+
+	// ShardedLRUStats holds the statistics of a ShardedLRU.
+	ShardedLRUStats struct {
+		// Entries is the total number of entries in the cache.
+		Entries int
+		// Shards holds the number of entries in each shard.
+		// A skewed distribution may indicate hot keys.
+		Shards []int
+		// Evictions is the total number of entries that
+		// were evicted due to the limit of their shards.
+		Evictions uint64
+	}
+)
+
+// NewShardedLRU creates a new ShardedLRU with the given number of shards.
+// maxEntriesPerShard is the limit of each shard, and if it is zero, the
+// shards have no limit.
+//
+//	entcache.NewDriver(drv, entcache.Levels(entcache.NewShardedLRU(32, 1024)))
+func NewShardedLRU(shards, maxEntriesPerShard int) *ShardedLRU {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &ShardedLRU{shards: make([]*LRU, shards)}
+	for i := range s.shards {
+		s.shards[i] = NewLRU(maxEntriesPerShard)
+	}
+	return s
+}
+
+// Add adds the entry to the shard of the key.
+func (s *ShardedLRU) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	return s.shard(k).Add(ctx, k, e, ttl)
+}
+
+// Get gets an entry from the shard of the key.
+func (s *ShardedLRU) Get(ctx context.Context, k Key) (*Entry, error) {
+	return s.shard(k).Get(ctx, k)
+}
+
+// Del deletes an entry from the shard of the key.
+func (s *ShardedLRU) Del(ctx context.Context, k Key) error {
+	return s.shard(k).Del(ctx, k)
+}
+
+// AddTagged implements the Tagger interface.
+func (s *ShardedLRU) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	return s.shard(k).AddTagged(ctx, k, e, ttl, tags)
+}
+
+// EvictTag implements the Tagger interface. Keys of the same tag
+// may be stored in different shards, and the tag is evicted from
+// all of them.
+func (s *ShardedLRU) EvictTag(ctx context.Context, tag string) error {
+	for _, l := range s.shards {
+		if err := l.EvictTag(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sweep implements the Sweeper interface.
+func (s *ShardedLRU) Sweep(ctx context.Context) (int, error) {
+	var n int
+	for _, l := range s.shards {
+		m, _ := l.Sweep(ctx)
+		n += m
+	}
+	return n, nil
+}
+
+// Clear purges all entries from all shards.
+func (s *ShardedLRU) Clear() {
+	for _, l := range s.shards {
+		l.Clear()
+	}
+}
+
+// Len returns the total number of entries in the cache.
+func (s *ShardedLRU) Len() int {
+	var n int
+	for _, l := range s.shards {
+		n += l.Len()
+	}
+	return n
+}
+
+// Stats returns the statistics of the cache.
+func (s *ShardedLRU) Stats() ShardedLRUStats {
+	st := ShardedLRUStats{Shards: make([]int, len(s.shards))}
+	for i, l := range s.shards {
+		st.Shards[i] = l.Len()
+		st.Entries += st.Shards[i]
+		st.Evictions += l.Evictions()
+	}
+	return st
+}
+
+// shard returns the shard of the given key.
+func (s *ShardedLRU) shard(k Key) *LRU {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	return s.shards[keyHash(k)%uint64(len(s.shards))]
+}
+
+// keyHash returns the hash of the given key. Keys
+// that are hashes (e.g. by DefaultHash) are returned as-is.
+func keyHash(k Key) uint64 {
+	switch k := k.(type) {
+	case uint64:
+		return k
+	case string:
+		h := newHasher()
+		h.string(k)
+		return uint64(h)
+	default:
+		name, _ := MarshalKey(k)
+		h := newHasher()
+		h.string(name)
+		return uint64(h)
+	}
+}
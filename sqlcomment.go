@@ -0,0 +1,94 @@
+package entcache
+
+import (
+	"net/url"
+	"strings"
+)
+
+// SQLComments configures the driver to support queries that carry sqlcommenter
+// tags (e.g. added by ariga.io/sqlcomment). The trailing tags comment is stripped
+// from queries before they are hashed, and therefore, the same query that was
+// executed by different routes or controllers is cached using a single entry.
+// The comment is not stripped from the queries that are sent to the database.
+//
+// The given keys define the tags that are used as the operation name of queries
+// that were not named using WithName. Hence, cache statistics are collected per
+// route or controller (see Driver.NameStats), and are attributed in policies.
+//
+//	drv := entcache.NewDriver(drv, entcache.SQLComments("route", "controller"))
+//	client := ent.NewClient(ent.Driver(sqlcomment.NewDriver(drv, sqlcomment.WithTagger(...))))
+//
+// In debug mode, the tags are recorded in the Comments field of the decision
+// (see WithTrace), and with ProfileLabels, goroutines are labeled with them.
+func SQLComments(nameKeys ...string) Option {
+	return func(o *Options) {
+		o.SQLComments, o.CommentNames = true, nameKeys
+	}
+}
+
+// splitComment splits the query into the statement and the tags of its trailing
+// sqlcommenter comment (e.g. /*route='%2Fusers',db_driver='ent'*/). Queries that
+// do not end with a valid tags comment are returned as-is, with nil tags.
+func splitComment(query string) (string, map[string]string) {
+	q := strings.TrimRight(query, " \t\n;")
+	if !strings.HasSuffix(q, "*/") {
+		return query, nil
+	}
+	start := strings.LastIndex(q, "/*")
+	if start == -1 {
+		return query, nil
+	}
+	tags, ok := parseComment(q[start+2 : len(q)-2])
+	if !ok {
+		return query, nil
+	}
+	return strings.TrimRight(q[:start], " \t\n"), tags
+}
+
+// parseComment parses the comma-separated key='value' pairs of
+// an sqlcommenter comment. Keys and values are URL-encoded.
+func parseComment(s string) (map[string]string, bool) {
+	tags := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; {
+		eq := strings.Index(s, "='")
+		if eq <= 0 {
+			return nil, false
+		}
+		k, err := url.QueryUnescape(s[:eq])
+		if err != nil {
+			return nil, false
+		}
+		// Find the closing quote, skipping escaped ones.
+		end := eq + 2
+		for ; end < len(s) && s[end] != '\''; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) {
+			return nil, false
+		}
+		v, err := url.QueryUnescape(strings.ReplaceAll(s[eq+2:end], `\'`, "'"))
+		if err != nil {
+			return nil, false
+		}
+		tags[k] = v
+		if s = s[end+1:]; s != "" {
+			if s[0] != ',' {
+				return nil, false
+			}
+			s = s[1:]
+		}
+	}
+	return tags, len(tags) > 0
+}
+
+// commentName returns the operation name of the query from its tags.
+func (d *Driver) commentName(tags map[string]string) string {
+	for _, k := range d.CommentNames {
+		if v := tags[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
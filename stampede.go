@@ -0,0 +1,145 @@
+package entcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StampedeGuard configures the detection of cache stampedes. That is, many
+// misses for the same key within a short interval, that cause the same query
+// to be executed and its result to be added to the cache by all callers.
+type StampedeGuard struct {
+	// Misses defines the number of misses for the same key
+	// within the Window that triggers the guard.
+	Misses int
+	// Window defines the interval in which misses are counted.
+	Window time.Duration
+	// Hold defines the TTL of the in-flight placeholder. If
+	// zero, the Window is used.
+	Hold time.Duration
+}
+
+// GuardStampede configures the driver to guard the cache from stampedes. Once
+// a key missed the cache g.Misses times within g.Window, the driver inserts a
+// short-lived in-flight placeholder for it before executing the query. Callers
+// that find the placeholder (or that miss the key while it is held by another
+// caller of the process) execute the query on the database, but do not add its
+// result to the cache. The placeholder is replaced by the caller that inserted
+// it once its rows are recorded, or expires after g.Hold. For example:
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.GuardStampede(entcache.StampedeGuard{
+//			Misses: 5,
+//			Window: 100 * time.Millisecond,
+//		}),
+//	)
+//
+// Note that placeholders are stored in the cache levels. Hence, processes that
+// share a remote level (e.g. Redis) bound their duplicate Adds together.
+func GuardStampede(g StampedeGuard) Option {
+	return func(o *Options) {
+		o.Stampede = &g
+	}
+}
+
+// inFlightColumn is the column name of the in-flight placeholder entry.
+const inFlightColumn = "entcache:in-flight"
+
+// errInFlight is returned when the cache holds an in-flight placeholder for a key.
+var errInFlight = errors.New("entcache: entry is in-flight")
+
+// isInFlight reports if the given entry is an in-flight placeholder.
+func isInFlight(e *Entry) bool {
+	return len(e.Values) == 0 && len(e.Columns) == 1 && e.Columns[0] == inFlightColumn
+}
+
+// get looks up the key in the cache of the query, and
+// reports in-flight placeholders as errInFlight.
+func (d *Driver) get(ctx context.Context, opts ctxOptions) (*Entry, error) {
+	e, err := opts.cache.Get(ctx, opts.key)
+	if err == nil && isInFlight(e) {
+		return nil, errInFlight
+	}
+	return e, err
+}
+
+// guard counts a cache miss for the key of the query. If the guard was triggered,
+// it inserts an in-flight placeholder to the cache, and the caller is expected to
+// record the query result, which replaces the placeholder. It reports if the key
+// is held by another caller, and therefore, the caller should skip adding the
+// query result to the cache.
+func (d *Driver) guard(ctx context.Context, opts ctxOptions) bool {
+	if d.misses == nil {
+		return false
+	}
+	trip, held := d.misses.miss(opts.key)
+	if trip {
+		if err := opts.cache.Add(ctx, opts.key, &Entry{Columns: []string{inFlightColumn}}, d.misses.hold); err != nil && d.Log != nil {
+			d.Log("entcache: failed storing in-flight placeholder for", opts.key, err)
+		}
+	}
+	return held
+}
+
+// missCounter counts the cache misses of keys within a time window.
+type missCounter struct {
+	limit  int
+	window time.Duration
+	hold   time.Duration
+	mu     sync.Mutex
+	sweep  time.Time
+	keys   map[Key]*missWindow
+}
+
+// missWindow holds the misses of a key since the window start,
+// and the time until which the key is held by the caller that
+// triggered the guard.
+type missWindow struct {
+	start time.Time
+	n     int
+	held  time.Time
+}
+
+func newMissCounter(g *StampedeGuard) *missCounter {
+	hold := g.Hold
+	if hold == 0 {
+		hold = g.Window
+	}
+	return &missCounter{limit: g.Misses, window: g.Window, hold: hold, keys: make(map[Key]*missWindow)}
+}
+
+// miss records a miss for the given key. It reports if the number of misses
+// within the window reached the limit (and the caller now holds the key), or
+// if the key is already held by another caller.
+func (c *missCounter) miss(k Key) (trip, held bool) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop expired windows, at most once per window.
+	if now.Sub(c.sweep) > c.window {
+		for k, w := range c.keys {
+			if now.Sub(w.start) > c.window && !now.Before(w.held) {
+				delete(c.keys, k)
+			}
+		}
+		c.sweep = now
+	}
+	w, ok := c.keys[k]
+	switch {
+	case ok && now.Before(w.held):
+		return false, true
+	case !ok || now.Sub(w.start) > c.window:
+		w = &missWindow{start: now}
+		c.keys[k] = w
+	}
+	w.n++
+	if w.n < c.limit {
+		return false, false
+	}
+	// Start a new window, as the key is guarded by the placeholder.
+	w.start, w.n, w.held = now, 0, now.Add(c.hold)
+	return true, false
+}
@@ -0,0 +1,242 @@
+package entcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type (
+	// StatsStore persists the cumulative statistics of drivers (see PersistStats).
+	// Drivers add the counts of each interval to the store, and therefore, a store
+	// can be shared by the processes (and the restarts) of the same service.
+	StatsStore interface {
+		// Add adds the given counts to the persisted statistics.
+		Add(context.Context, Stats) error
+		// Load returns the persisted statistics.
+		Load(context.Context) (Stats, error)
+	}
+
+	// redisStats is a StatsStore that keeps the statistics in a Redis hash.
+	redisStats struct {
+		c   redis.Cmdable
+		key string
+	}
+
+	// fileStats is a StatsStore that keeps the statistics in a JSON file.
+	fileStats struct {
+		mu   sync.Mutex
+		path string
+	}
+)
+
+// PersistStats configures the driver to add its statistics to the given store
+// every interval, and when it is closed. Hence, the history of the hit rate
+// survives restarts, and is returned by Driver.TotalStats.
+//
+//	entcache.NewDriver(drv, entcache.PersistStats(entcache.RedisStats(rdb, "entcache:stats"), time.Minute))
+//
+// Counts of the last interval are lost if the process exits without closing
+// the driver.
+func PersistStats(s StatsStore, interval time.Duration) Option {
+	return func(o *Options) {
+		o.StatsStore, o.StatsInterval = s, interval
+	}
+}
+
+// RedisStats returns a StatsStore that keeps the statistics in the given
+// Redis hash. Counts are added atomically, and therefore, the hash can be
+// shared by multiple processes.
+func RedisStats(c redis.Cmdable, key string) StatsStore {
+	return &redisStats{c: c, key: key}
+}
+
+// Add implements the StatsStore interface.
+func (r *redisStats) Add(ctx context.Context, s Stats) error {
+	_, err := r.c.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for f, n := range s.fields() {
+			if n > 0 {
+				pipe.HIncrBy(ctx, r.key, f, int64(n))
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Load implements the StatsStore interface.
+func (r *redisStats) Load(ctx context.Context) (Stats, error) {
+	vs, err := r.c.HGetAll(ctx, r.key).Result()
+	if err != nil {
+		return Stats{}, err
+	}
+	fields := make(map[string]uint64, len(vs))
+	for f, v := range vs {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return Stats{}, fmt.Errorf("entcache: invalid stats field %q: %w", f, err)
+		}
+		fields[f] = n
+	}
+	return statsFromFields(fields), nil
+}
+
+// FileStats returns a StatsStore that keeps the statistics in the given
+// JSON file. The file should not be shared by multiple processes.
+func FileStats(path string) StatsStore {
+	return &fileStats{path: path}
+}
+
+// Add implements the StatsStore interface.
+func (f *fileStats) Add(ctx context.Context, s Stats) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cur, err := f.load()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(cur.add(s))
+	if err != nil {
+		return err
+	}
+	// Replace the file atomically, to avoid losing
+	// the history if the process crashes mid-write.
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Load implements the StatsStore interface.
+func (f *fileStats) Load(context.Context) (Stats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.load()
+}
+
+func (f *fileStats) load() (Stats, error) {
+	var s Stats
+	buf, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(buf, &s)
+	return s, err
+}
+
+// TotalStats returns the statistics that were persisted by the configured
+// StatsStore (see PersistStats), including the counts that were not added
+// to it yet.
+func (d *Driver) TotalStats(ctx context.Context) (Stats, error) {
+	if d.StatsStore == nil {
+		return Stats{}, errors.New("entcache: stats store was not configured")
+	}
+	d.persistMu.Lock()
+	defer d.persistMu.Unlock()
+	s, err := d.StatsStore.Load(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	return s.add(d.Stats().Delta(d.persisted)), nil
+}
+
+// persistStats adds the counts since the last call to the stats store.
+func (d *Driver) persistStats(ctx context.Context) error {
+	d.persistMu.Lock()
+	defer d.persistMu.Unlock()
+	curr := d.Stats()
+	if err := d.StatsStore.Add(ctx, curr.Delta(d.persisted)); err != nil {
+		return err
+	}
+	d.persisted = curr
+	return nil
+}
+
+// runStatsPersister persists the driver stats periodically until ctx is canceled.
+func (d *Driver) runStatsPersister(ctx context.Context) {
+	ticker := time.NewTicker(d.StatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.persistStats(ctx); err != nil && d.Log != nil {
+				d.Log(fmt.Sprintf("entcache: failed persisting stats: %v", err))
+			}
+		}
+	}
+}
+
+// fields returns the counters of the statistics, keyed by their JSON names.
+// Skips are keyed by the label of their SkipReason, prefixed with "skips.".
+func (s Stats) fields() map[string]uint64 {
+	fields := map[string]uint64{
+		"gets":          s.Gets,
+		"hits":          s.Hits,
+		"errors":        s.Errors,
+		"hedges":        s.Hedges,
+		"evict_errors":  s.EvictErrors,
+		"rows_limited":  s.RowsLimited,
+		"bytes_limited": s.BytesLimited,
+		"encode_errors": s.EncodeErrors,
+		"clamped_ttls":  s.ClampedTTLs,
+		"swept":         s.Swept,
+		"async_errors":  s.AsyncErrors,
+	}
+	for r, n := range s.Skips {
+		fields["skips."+SkipReason(r).String()] = n
+	}
+	return fields
+}
+
+// statsFromFields returns the statistics of the given fields. Unknown fields are ignored.
+func statsFromFields(fields map[string]uint64) Stats {
+	s := Stats{
+		Gets:         fields["gets"],
+		Hits:         fields["hits"],
+		Errors:       fields["errors"],
+		Hedges:       fields["hedges"],
+		EvictErrors:  fields["evict_errors"],
+		RowsLimited:  fields["rows_limited"],
+		BytesLimited: fields["bytes_limited"],
+		EncodeErrors: fields["encode_errors"],
+		ClampedTTLs:  fields["clamped_ttls"],
+		Swept:        fields["swept"],
+		AsyncErrors:  fields["async_errors"],
+	}
+	for r := range s.Skips {
+		s.Skips[r] = fields["skips."+SkipReason(r).String()]
+	}
+	return s
+}
+
+// add returns the sum of the statistics.
+func (s Stats) add(o Stats) Stats {
+	f1, f2 := s.fields(), o.fields()
+	for k, n := range f2 {
+		f1[k] += n
+	}
+	return statsFromFields(f1)
+}
@@ -0,0 +1,94 @@
+package entcache_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ariga.io/entcache"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestPersistStats(t *testing.T) {
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	stores := map[string]entcache.StatsStore{
+		"Redis": entcache.RedisStats(rdb, "entcache:stats"),
+		"File":  entcache.FileStats(filepath.Join(t.TempDir(), "stats.json")),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			// First run: a miss and a hit, persisted on close.
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.PersistStats(store, time.Hour))
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+			mock.ExpectClose()
+			if err := drv.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+			s, err := store.Load(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Gets != 2 || s.Hits != 1 {
+				t.Fatalf("unexpected persisted stats: %+v", s)
+			}
+
+			// Second run: totals include the unsaved counts.
+			db, mock, err = sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			drv = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.PersistStats(store, time.Hour))
+			mock.ExpectQuery("SELECT name FROM users").
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []interface{}{"a8m"})
+			if s := drv.Stats(); s.Gets != 1 || s.Hits != 0 {
+				t.Fatalf("unexpected stats since boot: %+v", s)
+			}
+			total, err := drv.TotalStats(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total.Gets != 3 || total.Hits != 1 {
+				t.Fatalf("unexpected total stats: %+v", total)
+			}
+			rec := httptest.NewRecorder()
+			entcache.StatsHandler(drv).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats?total", nil))
+			var got entcache.Stats
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != total {
+				t.Fatalf("unexpected handler stats: %+v != %+v", got, total)
+			}
+			mock.ExpectClose()
+			if err := drv.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if s, err = store.Load(ctx); err != nil || s != total {
+				t.Fatalf("unexpected persisted stats: %+v != %+v (%v)", s, total, err)
+			}
+		})
+	}
+}
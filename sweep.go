@@ -0,0 +1,154 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sweeper is an optional interface implemented by cache levels that can drop
+// their expired entries, and the references of evicted or expired entries from
+// their tag indexes. Sweep returns the number of dropped entries and references.
+type Sweeper interface {
+	Sweep(context.Context) (int, error)
+}
+
+// SweepInterval configures the driver to sweep its cache levels in the background
+// every interval (see Sweeper). Without sweeping, the tag indexes of the levels
+// (e.g. tables or fingerprints) may grow with the references of expired entries.
+// The number of dropped entries and references is counted by Stats.Swept.
+//
+//	entcache.NewDriver(drv, entcache.EvictWrites(entcache.EvictAfterWrite), entcache.SweepInterval(time.Minute))
+//
+// Note that the background sweeping is stopped when the driver is closed.
+func SweepInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.SweepInterval = d
+	}
+}
+
+// Sweep sweeps the levels of the driver cache that implement the Sweeper interface.
+func (d *Driver) Sweep(ctx context.Context) error {
+	var errs []string
+	for _, l := range d.levels() {
+		s, ok := l.(Sweeper)
+		if !ok {
+			continue
+		}
+		n, err := s.Sweep(ctx)
+		atomic.AddUint64(&d.stats.Swept, uint64(n))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%T: %v", l, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("entcache: sweeping levels: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// runSweeper sweeps the driver levels periodically until ctx is canceled.
+func (d *Driver) runSweeper(ctx context.Context) {
+	ticker := time.NewTicker(d.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.Sweep(ctx); err != nil && d.Log != nil {
+				d.Log(err.Error())
+			}
+		}
+	}
+}
+
+// Sweep implements the Sweeper interface. It removes the entries that their
+// TTL (or logical expiration time) has passed. References of evicted entries
+// are dropped from the tags index on eviction, and therefore, are not counted.
+func (l *LRU) Sweep(context.Context) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.c.sweep(time.Now()), nil
+}
+
+// IndexSize returns the number of references (tag and key pairs) in the tags index of the cache.
+func (l *LRU) IndexSize() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var n int
+	for _, keys := range l.tags {
+		n += len(keys)
+	}
+	return n
+}
+
+// evicted drops the evicted key from the tags index and the checksums
+// of the cache. It is called by the cache, while the lock is held.
+func (l *LRU) evicted(k Key, _ *Entry) {
+	for _, t := range l.keyTags[k] {
+		delete(l.tags[t], k)
+		if len(l.tags[t]) == 0 {
+			delete(l.tags, t)
+		}
+	}
+	delete(l.keyTags, k)
+	delete(l.sums, k)
+}
+
+// Sweep implements the Sweeper interface.
+func (p *ProcLRU) Sweep(ctx context.Context) (int, error) {
+	var n int
+	for _, l := range p.procs {
+		m, _ := l.Sweep(ctx)
+		n += m
+	}
+	return n, nil
+}
+
+// Sweep implements the Sweeper interface. Expired keys are deleted by Redis,
+// and therefore, it only drops the members of tag sets whose keys no longer
+// exist (e.g. were deleted or expired before the set itself).
+func (r *Redis) Sweep(ctx context.Context) (int, error) {
+	var (
+		n    int
+		iter = r.c.Scan(ctx, 0, r.tagKey("*"), 100).Iterator()
+	)
+	for iter.Next(ctx) {
+		m, err := r.sweepTag(ctx, iter.Val())
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, iter.Err()
+}
+
+// sweepTagScript removes the member (KEYS[2]) from the tag set (KEYS[1]) if its key
+// does not exist. The check is atomic, to not drop members that are added concurrently.
+var sweepTagScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	return redis.call('SREM', KEYS[1], KEYS[2])
+end
+return 0
+`)
+
+// sweepTag drops the members of the given tag set whose keys no longer exist.
+func (r *Redis) sweepTag(ctx context.Context, tag string) (int, error) {
+	var (
+		n    int
+		iter = r.c.SScan(ctx, tag, 0, "", 100).Iterator()
+	)
+	for iter.Next(ctx) {
+		removed, err := sweepTagScript.Run(ctx, r.c, []string{tag, iter.Val()}).Int()
+		if err != nil {
+			return n, err
+		}
+		n += removed
+	}
+	return n, iter.Err()
+}
@@ -0,0 +1,88 @@
+package entcache
+
+import "strings"
+
+// queryTables returns the tables referenced by the given statement. It supports the
+// statements generated by Ent (i.e. SELECT, INSERT, UPDATE and DELETE), and detects
+// tables that follow the FROM, JOIN, INTO and UPDATE keywords. Subqueries are skipped
+// and table names are returned without their schema qualifier.
+func queryTables(query string) []string {
+	var (
+		tables []string
+		expect bool
+	)
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == '\'':
+			// Skip string literals.
+			for i++; i < len(query) && query[i] != '\''; i++ {
+			}
+			i++
+		case c == '"' || c == '`' || isIdent(c):
+			var name string
+			name, i = scanName(query, i)
+			switch {
+			case expect:
+				tables, expect = appendTable(tables, name), false
+			case !isQuoted(c):
+				switch strings.ToUpper(name) {
+				case "FROM", "JOIN", "INTO", "UPDATE":
+					expect = true
+				}
+			}
+		case c == '(':
+			// Subquery or function call.
+			expect = false
+			i++
+		default:
+			i++
+		}
+	}
+	return tables
+}
+
+// scanName scans a (possibly qualified and quoted) name starting at position i,
+// and returns its last part and the position after it.
+func scanName(s string, i int) (string, int) {
+	var name string
+	for i < len(s) {
+		switch c := s[i]; {
+		case isQuoted(c):
+			j := strings.IndexByte(s[i+1:], c)
+			if j == -1 {
+				return s[i+1:], len(s)
+			}
+			name, i = s[i+1:i+1+j], i+j+2
+		case isIdent(c):
+			j := i
+			for j < len(s) && isIdent(s[j]) {
+				j++
+			}
+			name, i = s[i:j], j
+		default:
+			return name, i
+		}
+		if i >= len(s) || s[i] != '.' {
+			return name, i
+		}
+		i++
+	}
+	return name, i
+}
+
+func appendTable(tables []string, t string) []string {
+	for i := range tables {
+		if tables[i] == t {
+			return tables
+		}
+	}
+	return append(tables, t)
+}
+
+func isQuoted(c byte) bool {
+	return c == '"' || c == '`'
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
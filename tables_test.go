@@ -0,0 +1,76 @@
+package entcache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryTables(t *testing.T) {
+	tests := []struct {
+		query  string
+		tables []string
+	}{
+		{"SELECT `users`.`id` FROM `users` WHERE `users`.`name` = ?", []string{"users"}},
+		{`SELECT "t1"."id" FROM "todos" AS "t1" JOIN "users" AS "t2" ON "t1"."owner_id" = "t2"."id"`, []string{"todos", "users"}},
+		{`SELECT * FROM "public"."users" WHERE "name" = 'from x'`, []string{"users"}},
+		{"SELECT COUNT(*) FROM (SELECT id FROM users) AS t", []string{"users"}},
+		{`INSERT INTO "users" ("name") VALUES ($1) RETURNING "id"`, []string{"users"}},
+		{"UPDATE `users` SET `name` = ? WHERE `id` = ?", []string{"users"}},
+		{"DELETE FROM users WHERE id IN (SELECT user_id FROM todos)", []string{"users", "todos"}},
+		{"SELECT 1", nil},
+	}
+	for _, tt := range tests {
+		if tables := queryTables(tt.query); !reflect.DeepEqual(tables, tt.tables) {
+			t.Errorf("queryTables(%q) = %q, want %q", tt.query, tables, tt.tables)
+		}
+	}
+}
+
+func TestHasFilter(t *testing.T) {
+	tests := []struct {
+		query  string
+		filter bool
+	}{
+		{"SELECT `users`.`id` FROM `users` WHERE `users`.`name` = ?", true},
+		{`SELECT "id" FROM "users" ORDER BY "id" LIMIT 10`, true},
+		{"select id from users where id = 1", true},
+		{"SELECT `id` FROM `users`", false},
+		{"SELECT `where` FROM `limit`", false},
+		{"SELECT id FROM users AS t WHERE name = 'where'", true},
+		{"SELECT COUNT(*) FROM (SELECT id FROM users WHERE id > 1 LIMIT 1) AS t", false},
+		{`SELECT "t1"."id" FROM "todos" AS "t1" JOIN "users" AS "t2" ON "t1"."owner_id" = "t2"."id"`, false},
+	}
+	for _, tt := range tests {
+		if filter := hasFilter(tt.query); filter != tt.filter {
+			t.Errorf("hasFilter(%q) = %t, want %t", tt.query, filter, tt.filter)
+		}
+	}
+}
+
+func TestSplitComment(t *testing.T) {
+	tests := []struct {
+		query string
+		stmt  string
+		tags  map[string]string
+	}{
+		{
+			query: "SELECT `id` FROM `users` /*db_driver='ent',route='%2Fusers%2F%3Aid'*/",
+			stmt:  "SELECT `id` FROM `users`",
+			tags:  map[string]string{"db_driver": "ent", "route": "/users/:id"},
+		},
+		{
+			query: `SELECT "id" FROM "users" WHERE "name" = $1 /*action='it\'s'*/;`,
+			stmt:  `SELECT "id" FROM "users" WHERE "name" = $1`,
+			tags:  map[string]string{"action": "it's"},
+		},
+		{query: "SELECT `id` FROM `users`", stmt: "SELECT `id` FROM `users`"},
+		{query: "SELECT `id` FROM `users` /* not tags */", stmt: "SELECT `id` FROM `users` /* not tags */"},
+		{query: "SELECT `id` FROM `users` /*route='unterminated*/", stmt: "SELECT `id` FROM `users` /*route='unterminated*/"},
+	}
+	for _, tt := range tests {
+		stmt, tags := splitComment(tt.query)
+		if stmt != tt.stmt || !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("splitComment(%q) = %q, %v, want %q, %v", tt.query, stmt, tags, tt.stmt, tt.tags)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Tagger is an optional interface implemented by cache levels that support
+// associating entries with tags, and evicting them as a group.
+type Tagger interface {
+	// AddTagged adds the entry to the cache, and associates its key with the given tags.
+	AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error
+	// EvictTag deletes all entries that are associated with the given tag.
+	EvictTag(ctx context.Context, tag string) error
+}
+
+// WithTags returns a new Context that carries tags for the cache entries
+// stored by its queries. Tagged entries can be evicted as a group using
+// Driver.EvictTags.
+//
+//	client.T.Query().All(entcache.WithTags(ctx, "dashboard"))
+func WithTags(ctx context.Context, tags ...string) context.Context {
+	return withOptions(ctx, func(c *ctxOptions) {
+		c.tags = append(c.tags[:len(c.tags):len(c.tags)], tags...)
+	})
+}
+
+// NewScope returns a new Context that stores the entries of its queries in a named
+// scope of the context-level cache. Entries of a scope can be dropped as a group
+// using DropScope, without discarding the rest of the context cache. For example,
+// a resolver that mutates data in the middle of a request:
+//
+//	ctx = entcache.NewScope(ctx, "todos")
+//	todos, err := client.Todo.Query().All(ctx)
+//	// ...
+//	entcache.DropScope(ctx, "todos")
+//
+// Note that scopes are supported only by caches that implement the Tagger
+// interface (e.g. LRU), and only when the Driver works in ContextLevel mode.
+func NewScope(ctx context.Context, name string) context.Context {
+	return WithTags(ctx, scopeTag(name))
+}
+
+// DropScope deletes all entries that were stored in the named
+// scope from the cache that is attached to ctx (see NewScope).
+func DropScope(ctx context.Context, name string) error {
+	c, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	t, ok := c.(Tagger)
+	if !ok {
+		return fmt.Errorf("entcache: context cache %T does not support scopes", c)
+	}
+	return t.EvictTag(ctx, scopeTag(name))
+}
+
+// scopeTag returns the tag of the named scope.
+func scopeTag(name string) string {
+	return "entcache:scope:" + name
+}
+
+// EvictTags deletes all cache entries that are associated with the given tags.
+// It fails if the configured cache does not support tagging.
+func (d *Driver) EvictTags(ctx context.Context, tags ...string) error {
+	t, ok := d.Cache.(Tagger)
+	if !ok {
+		return fmt.Errorf("entcache: cache %T does not support tags", d.Cache)
+	}
+	for _, tag := range tags {
+		if err := t.EvictTag(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTagged adds the entry to the given cache level, and associates
+// it with the given tags, if the level supports tagging.
+func addTagged(ctx context.Context, c AddGetDeleter, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	if t, ok := c.(Tagger); ok && len(tags) > 0 {
+		return t.AddTagged(ctx, k, e, ttl, tags)
+	}
+	return c.Add(ctx, k, e, ttl)
+}
+
+// evictTag evicts the entries that are associated with the tag from the given level.
+// Levels that do not support tags are cleared if they are in-process (e.g. ARC), and
+// otherwise, an error is returned, as their stale entries cannot be evicted.
+func evictTag(ctx context.Context, c AddGetDeleter, tag string) error {
+	switch c := c.(type) {
+	case Tagger:
+		return c.EvictTag(ctx, tag)
+	case interface{ Clear() }:
+		c.Clear()
+		return nil
+	default:
+		return fmt.Errorf("entcache: cache level %T does not support evicting tags", c)
+	}
+}
+
+// AddTagged implements the Tagger interface.
+func (l *LRU) AddTagged(_ context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.add(k, e, ttl); err != nil {
+		return err
+	}
+	if l.disabled {
+		return nil
+	}
+	if l.tags == nil {
+		l.tags = make(map[string]map[Key]struct{})
+		l.keyTags = make(map[Key][]string)
+	}
+	for _, t := range tags {
+		if l.tags[t] == nil {
+			l.tags[t] = make(map[Key]struct{})
+		}
+		if _, ok := l.tags[t][k]; !ok {
+			l.tags[t][k] = struct{}{}
+			l.keyTags[k] = append(l.keyTags[k], t)
+		}
+	}
+	return nil
+}
+
+// EvictTag implements the Tagger interface.
+func (l *LRU) EvictTag(_ context.Context, tag string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k := range l.tags[tag] {
+		l.c.remove(k)
+	}
+	delete(l.tags, tag)
+	return nil
+}
+
+var (
+	// addTaggedScript sets the entry key (KEYS[1]) to the value (ARGV[1]) with the given
+	// TTL in milliseconds (ARGV[2]), and adds it to the tag sets (KEYS[2:]). Tag sets
+	// expire with their longest-living member.
+	addTaggedScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+for i = 2, #KEYS do
+	local pttl = redis.call('PTTL', KEYS[i])
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if ttl <= 0 then
+		redis.call('PERSIST', KEYS[i])
+	elseif pttl == -2 or (pttl >= 0 and pttl < ttl) then
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return 1
+`)
+	// evictTagScript deletes the tag set (KEYS[1]) and all its members atomically.
+	evictTagScript = redis.NewScript(`
+local keys = redis.call('SMEMBERS', KEYS[1])
+for i = 1, #keys, 1000 do
+	redis.call('DEL', unpack(keys, i, math.min(i + 999, #keys)))
+end
+redis.call('DEL', KEYS[1])
+return #keys
+`)
+)
+
+// AddTagged implements the Tagger interface. The entry and its tags are stored
+// atomically using a Lua script. Note that, since tags and their members may be
+// stored on different Redis Cluster slots, tagging is supported in cluster mode only
+// if the keys are wrapped with a hash tag (see RedisHashTag).
+func (r *Redis) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	key, err := r.key(k)
+	if err != nil || key == "" {
+		return err
+	}
+	buf, ttl, err := r.encode(e, ttl)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(tags)+1)
+	keys = append(keys, key)
+	for _, t := range tags {
+		keys = append(keys, r.tagKey(t))
+	}
+	if !r.degrader.allow() {
+		return nil
+	}
+	c, wctx, cancel, ok := r.writer(ctx)
+	defer cancel()
+	if !ok {
+		return nil
+	}
+	err = addTaggedScript.Run(wctx, c, keys, buf, ttl.Milliseconds()).Err()
+	r.degrader.done(ctx, err)
+	return err
+}
+
+// EvictTag implements the Tagger interface. The tag and all its
+// members are deleted atomically using a Lua script.
+func (r *Redis) EvictTag(ctx context.Context, tag string) error {
+	if !r.degrader.allow() {
+		return ErrDegraded
+	}
+	err := evictTagScript.Run(ctx, r.c, []string{r.tagKey(tag)}).Err()
+	r.degrader.done(ctx, err)
+	return err
+}
+
+// redisTagKey returns the Redis key of the set holding the members of the tag.
+func redisTagKey(tag string) string {
+	return "entcache:tag:" + tag
+}
+
+// AddTagged implements the Tagger interface.
+func (m *multiLevel) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	e = e.WithExpiry(ttl)
+	var errs levelErrors
+	for i := range m.levels {
+		err := addTagged(ctx, m.levels[i], k, e, ttl, tags)
+		if err == nil {
+			m.names.added(m.levels[i], k, e)
+		}
+		errs = m.failed(errs, i, err)
+	}
+	return errs.err()
+}
+
+// EvictTag implements the Tagger interface.
+func (m *multiLevel) EvictTag(ctx context.Context, tag string) error {
+	var errs levelErrors
+	for i := range m.levels {
+		if err := evictTag(ctx, m.levels[i], tag); err != nil {
+			errs = append(errs, &LevelError{Level: i, Err: err})
+		}
+	}
+	return errs.err()
+}
+
+// AddTagged implements the Tagger interface.
+func (l *contextLevel) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	if c, ok := FromContext(ctx); ok {
+		if err := addTagged(ctx, c, k, e, ttl, tags); err != nil {
+			return err
+		}
+		indexFromContext(ctx).add(k, e, ttl)
+	}
+	if l.shared != nil {
+		return addTagged(ctx, l.shared, k, e, l.sharedTTL(ttl), tags)
+	}
+	return nil
+}
+
+// EvictTag implements the Tagger interface.
+func (l *contextLevel) EvictTag(ctx context.Context, tag string) error {
+	if c, ok := FromContext(ctx); ok {
+		if t, ok := c.(Tagger); ok {
+			if err := t.EvictTag(ctx, tag); err != nil {
+				return err
+			}
+		}
+	}
+	if l.shared != nil {
+		return evictTag(ctx, l.shared, tag)
+	}
+	return nil
+}
+
+// AddTagged implements the Tagger interface.
+func (b *budgetLevel) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	if b.exceeded(ctx) {
+		return nil
+	}
+	return addTagged(ctx, b.AddGetDeleter, k, e, ttl, tags)
+}
+
+// EvictTag implements the Tagger interface.
+func (b *budgetLevel) EvictTag(ctx context.Context, tag string) error {
+	return evictTag(ctx, b.AddGetDeleter, tag)
+}
+
+// AddTagged implements the Tagger interface. Writes are ignored, including tags.
+func (*readOnly) AddTagged(context.Context, Key, *Entry, time.Duration, []string) error {
+	return nil
+}
+
+// EvictTag implements the Tagger interface. Read-only levels are not evicted by the
+// process, and the writer service is responsible for evicting their entries.
+func (*readOnly) EvictTag(context.Context, string) error {
+	return nil
+}
+
+// AddTagged implements the Tagger interface.
+func (r *required) AddTagged(ctx context.Context, k Key, e *Entry, ttl time.Duration, tags []string) error {
+	return addTagged(ctx, r.AddGetDeleter, k, e, ttl, tags)
+}
+
+// EvictTag implements the Tagger interface.
+func (r *required) EvictTag(ctx context.Context, tag string) error {
+	return evictTag(ctx, r.AddGetDeleter, tag)
+}
@@ -0,0 +1,182 @@
+package entcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TinyLFU provides an in-process LRU cache with the TinyLFU admission policy,
+// and implements the AddGetDeleter interface.
+//
+// The cache estimates the access frequency of keys (including keys that are not
+// cached) using a count-min sketch. When the cache is full, a new entry is admitted
+// only if its key is accessed more frequently than the key of the entry that would
+// be evicted for it. Hence, one-off queries, like paginating over a table, do not
+// evict the entries of frequently-repeated queries.
+type TinyLFU struct {
+	mu       sync.Mutex
+	size     int
+	ttl      time.Duration
+	c        *lruCache[Key, *Entry]
+	sketch   *sketch
+	rejected uint64
+}
+
+// NewTinyLFU creates a new TinyLFU cache that holds up to size entries. If ttl
+// is not zero, entries expire after ttl, or after the TTL they were added with,
+// if it is shorter.
+//
+//	entcache.NewDriver(drv, entcache.Levels(entcache.NewTinyLFU(1024, time.Minute)))
+func NewTinyLFU(size int, ttl time.Duration) *TinyLFU {
+	if size < 1 {
+		size = 1
+	}
+	return &TinyLFU{
+		size:   size,
+		ttl:    ttl,
+		c:      newLRUCache[Key, *Entry](nil),
+		sketch: newSketch(size),
+	}
+}
+
+// Add adds the entry to the cache, if it is admitted.
+func (t *TinyLFU) Add(_ context.Context, k Key, e *Entry, ttl time.Duration) error {
+	ne, err := copyEntry(e)
+	if err != nil {
+		return err
+	}
+	if t.ttl > 0 && (ttl == 0 || t.ttl < ttl) {
+		ttl = t.ttl
+	}
+	h := keyHash(k)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.c.contains(k) && t.c.len() >= t.size {
+		victim, _, _ := t.c.oldest()
+		if t.sketch.estimate(h) <= t.sketch.estimate(keyHash(victim)) {
+			t.rejected++
+			return nil
+		}
+		t.c.removeOldest()
+	}
+	t.c.add(k, ne, ne.deadline(ttl))
+	return nil
+}
+
+// Get gets an entry from the cache. Lookups are counted by the
+// frequency sketch, regardless of whether the entry is cached.
+func (t *TinyLFU) Get(_ context.Context, k Key) (*Entry, error) {
+	h := keyHash(k)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sketch.increment(h)
+	e, ok := t.c.get(k, time.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// Del deletes an entry from the cache.
+func (t *TinyLFU) Del(_ context.Context, k Key) error {
+	t.mu.Lock()
+	t.c.remove(k)
+	t.mu.Unlock()
+	return nil
+}
+
+// Sweep implements the Sweeper interface.
+func (t *TinyLFU) Sweep(context.Context) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.c.sweep(time.Now()), nil
+}
+
+// Len returns the number of entries in the cache.
+func (t *TinyLFU) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.c.len()
+}
+
+// Rejected returns the number of entries that were not
+// admitted to the cache, as their keys were not frequent
+// enough.
+func (t *TinyLFU) Rejected() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rejected
+}
+
+// Clear purges all entries from the cache, and resets the frequency sketch.
+func (t *TinyLFU) Clear() {
+	t.mu.Lock()
+	t.c.clear()
+	t.sketch = newSketch(t.size)
+	t.mu.Unlock()
+}
+
+// sketchDepth is the number of rows in the count-min sketch.
+const sketchDepth = 4
+
+// sketch is a count-min sketch that estimates the access frequency of keys.
+// Its counters are halved periodically, so old accesses fade out.
+type sketch struct {
+	rows    [sketchDepth][]uint8
+	mask    uint64
+	samples int
+	reset   int
+}
+
+// newSketch returns a sketch for a cache of the given size.
+func newSketch(size int) *sketch {
+	w := 16
+	for w < size {
+		w <<= 1
+	}
+	s := &sketch{mask: uint64(w - 1), reset: 10 * size}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// increment increments the counters of the hash, and ages the sketch
+// when the number of samples reaches the reset threshold.
+func (s *sketch) increment(h uint64) {
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(h, i)]; *c < 15 {
+			*c++
+		}
+	}
+	if s.samples++; s.samples >= s.reset {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.samples /= 2
+	}
+}
+
+// estimate returns the estimated frequency of the hash.
+func (s *sketch) estimate(h uint64) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// index returns the index of the hash in the given row.
+func (s *sketch) index(h uint64, row int) uint64 {
+	// Each row uses a different mix of the hash.
+	h ^= uint64(row+1) * 0x9e3779b97f4a7c15
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h & s.mask
+}
@@ -0,0 +1,126 @@
+package entcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"entgo.io/ent/dialect"
+)
+
+// A Decision describes the caching decision that was made
+// by a Driver in Debug mode for a single query.
+type Decision struct {
+	// Query is the executed statement.
+	Query string
+	// Select indicates if the statement was classified
+	// as a SELECT query that can be cached.
+	Select bool
+	// Key is the cache key computed for the query, if any.
+	Key Key
+	// Comments holds the sqlcommenter tags of the query (see SQLComments).
+	Comments map[string]string
+	// Hit indicates if the query was served from the cache. In case
+	// of multi-level cache, Level holds the index of the level that
+	// served the entry. Level is -1 if the query was not served
+	// from the cache.
+	Hit   bool
+	Level int
+	// Skipped indicates if the query bypassed the cache,
+	// and Skip holds the reason for it.
+	Skipped bool
+	Skip    SkipReason
+	// Lookup holds the time the cache lookup took, and DB holds the time
+	// the database query took after the cache was missed (if executed).
+	Lookup time.Duration
+	DB     time.Duration
+	// Err holds the error returned by the query, if any.
+	Err error
+}
+
+// String implements the fmt.Stringer interface.
+func (d Decision) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "entcache: %q", d.Query)
+	switch {
+	case !d.Select:
+		b.WriteString(" is not a SELECT query")
+	case d.Skipped:
+		fmt.Fprintf(&b, " skipped cache (%s)", d.Skip)
+	case d.Hit:
+		fmt.Fprintf(&b, " hit key %v at level %d", d.Key, d.Level)
+	default:
+		fmt.Fprintf(&b, " missed key %v", d.Key)
+	}
+	if d.DB > 0 {
+		fmt.Fprintf(&b, " (db %s)", d.DB)
+	}
+	if d.Err != nil {
+		fmt.Fprintf(&b, ": %v", d.Err)
+	}
+	return b.String()
+}
+
+// hit marks the decision as a cache hit. Multi-level
+// caches record the level index before returning.
+func (d *Decision) hit() {
+	d.Hit = true
+	if d.Level == -1 {
+		d.Level = 0
+	}
+}
+
+type (
+	// trace collects the decisions made for a context.
+	trace struct {
+		mu        sync.Mutex
+		decisions []Decision
+	}
+	traceKey    struct{}
+	decisionKey struct{}
+)
+
+// WithTrace returns a new Context that collects the caching decisions
+// made by a Driver in Debug mode for the queries executed with it.
+//
+//	ctx = entcache.WithTrace(ctx)
+//	client.T.Query().All(ctx)
+//	decisions := entcache.TraceFromContext(ctx)
+func WithTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceKey{}, &trace{})
+}
+
+// TraceFromContext returns the decisions collected by ctx, if any.
+func TraceFromContext(ctx context.Context) []Decision {
+	t, ok := ctx.Value(traceKey{}).(*trace)
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Decision(nil), t.decisions...)
+}
+
+// decisionFromContext returns the decision that is recorded for the current query.
+func decisionFromContext(ctx context.Context) *Decision {
+	d, _ := ctx.Value(decisionKey{}).(*Decision)
+	return d
+}
+
+// debugQuery executes the query and records its caching decision.
+func (d *Driver) debugQuery(ctx context.Context, drv dialect.ExecQuerier, query string, args, v any) error {
+	dec := &Decision{Query: query, Level: -1}
+	err := d.query(context.WithValue(ctx, decisionKey{}, dec), drv, query, args, v)
+	dec.Err = err
+	if t, ok := ctx.Value(traceKey{}).(*trace); ok {
+		t.mu.Lock()
+		t.decisions = append(t.decisions, *dec)
+		t.mu.Unlock()
+	}
+	if d.Log != nil {
+		d.Log(dec.String())
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package entcache
+
+import (
+	"context"
+	stdsql "database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"entgo.io/ent/dialect"
+)
+
+// Tx starts a transaction using the underlying driver, and returns a cache-aware
+// transaction. Queries that are executed by the transaction use the cache, and
+// respect the options that were set on their context (e.g. Skip and Evict).
+//
+// Since a transaction may read the data that it modified before it was committed,
+// queries that follow a write in the same transaction bypass the cache. Entries
+// of the modified tables are evicted according to the EvictWrites option, where
+// the "after write" eviction is executed once the transaction was committed.
+func (d *Driver) Tx(ctx context.Context) (dialect.Tx, error) {
+	tx, err := d.Driver.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &txDriver{Tx: tx, drv: d}, nil
+}
+
+// BeginTx calls BeginTx of the underlying driver, and returns a cache-aware
+// transaction (see Tx), or fails if the underlying driver does not support it.
+func (d *Driver) BeginTx(ctx context.Context, opts *stdsql.TxOptions) (dialect.Tx, error) {
+	drv, ok := d.Driver.(interface {
+		BeginTx(context.Context, *stdsql.TxOptions) (dialect.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("Driver.BeginTx is not supported")
+	}
+	tx, err := drv.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &txDriver{Tx: tx, drv: d}, nil
+}
+
+// txDriver wraps a transaction with the cache layer of its driver.
+type txDriver struct {
+	dialect.Tx
+	drv *Driver
+	// mu guards the fields below.
+	mu      sync.Mutex
+	writes  []string // statements to evict their tables on commit.
+	session string   // session that executed the writes, if any.
+}
+
+// Query implements the dialect.Querier interface.
+func (t *txDriver) Query(ctx context.Context, query string, args, v any) error {
+	if !strings.HasPrefix(query, "SELECT") && !strings.HasPrefix(query, "select") {
+		t.drv.skip(ctx, SkipNotSelect)
+		if !isWrite(query) {
+			return t.Tx.Query(ctx, query, args, v)
+		}
+		return t.write(ctx, query, func() error {
+			return t.Tx.Query(ctx, query, args, v)
+		})
+	}
+	if t.wrote() {
+		t.drv.skip(ctx, SkipTxWrite)
+		return t.Tx.Query(ctx, query, args, v)
+	}
+	if t.drv.Debug {
+		return t.drv.debugQuery(ctx, t.Tx, query, args, v)
+	}
+	return t.drv.query(ctx, t.Tx, query, args, v)
+}
+
+// Exec implements the dialect.Execer interface.
+func (t *txDriver) Exec(ctx context.Context, query string, args, v any) error {
+	return t.write(ctx, query, func() error {
+		return t.Tx.Exec(ctx, query, args, v)
+	})
+}
+
+// QueryContext calls QueryContext of the underlying transaction, or fails if it is not supported.
+// Note, this method is not part of the caching layer since Ent does not use it by default.
+func (t *txDriver) QueryContext(ctx context.Context, query string, args ...any) (*stdsql.Rows, error) {
+	tx, ok := t.Tx.(interface {
+		QueryContext(context.Context, string, ...any) (*stdsql.Rows, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("Tx.QueryContext is not supported")
+	}
+	return tx.QueryContext(ctx, query, args...)
+}
+
+// ExecContext calls ExecContext of the underlying transaction, or fails if it is not supported.
+// Note, statements that are executed using this method do not evict the cache (see EvictWrites).
+func (t *txDriver) ExecContext(ctx context.Context, query string, args ...any) (stdsql.Result, error) {
+	tx, ok := t.Tx.(interface {
+		ExecContext(context.Context, string, ...any) (stdsql.Result, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("Tx.ExecContext is not supported")
+	}
+	return tx.ExecContext(ctx, query, args...)
+}
+
+// Commit commits the transaction, and evicts the entries of the tables
+// that were modified by it, if the driver was configured to do so. The
+// writes of sessions (see ReadYourWrites) are recorded on commit as well.
+func (t *txDriver) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	writes, session := t.writes, t.session
+	t.writes = nil
+	t.mu.Unlock()
+	for _, query := range writes {
+		if t.drv.evictAfterWrite() {
+			t.drv.evictTables(context.Background(), query)
+		}
+		t.drv.invalidate(context.Background(), query)
+		if session != "" {
+			t.drv.sessionWrite(Session(context.Background(), session), query)
+		}
+	}
+	return nil
+}
+
+// write executes a statement that modifies data in the transaction. Eviction
+// after the write is deferred to commit, as the data is not visible to other
+// connections before it.
+func (t *txDriver) write(ctx context.Context, query string, exec func() error) error {
+	if t.drv.Writes&EvictBeforeWrite != 0 {
+		t.drv.evictTables(ctx, query)
+	}
+	t.mu.Lock()
+	t.writes = append(t.writes, query)
+	if c, ok := ctx.Value(ctxOptionsKey{}).(*ctxOptions); ok && c.session != "" {
+		t.session = c.session
+	}
+	t.mu.Unlock()
+	err := exec()
+	t.drv.invalidateContext(ctx, query)
+	return err
+}
+
+// wrote reports if the transaction executed a statement that modifies data.
+func (t *txDriver) wrote() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.writes) > 0
+}
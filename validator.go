@@ -0,0 +1,192 @@
+package entcache
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Validators holds the HTTP validators of the data that was
+	// consumed by a request from the cache (see Validator).
+	Validators struct {
+		// ETag is a strong entity tag that is derived from the
+		// content of the consumed entries, including its quotes.
+		ETag string
+		// LastModified is the time the most recent entry was recorded.
+		// It is zero if the recording time of one of the entries is
+		// unknown (see RecordProvenance).
+		LastModified time.Time
+	}
+
+	// validator collects the entries that were consumed by a context.
+	validator struct {
+		mu       sync.Mutex
+		sum      uint64
+		n        int
+		modified time.Time
+		unknown  bool // an entry with unknown recording time was consumed.
+		partial  bool // a query bypassed the cache.
+	}
+	validatorKey struct{}
+)
+
+// WithValidator returns a new Context that collects the cache entries consumed
+// by the queries executed with it, for computing the HTTP validators of the
+// response using Validator. It allows serving conditional requests based on
+// the query cache, instead of maintaining a separate HTTP cache.
+//
+//	ctx := entcache.WithValidator(r.Context())
+//	todos, err := client.Todo.Query().All(ctx)
+//	if err != nil {
+//		// ...
+//	}
+//	if v, ok := entcache.Validator(ctx); ok && v.NotModified(w, r) {
+//		return
+//	}
+//	json.NewEncoder(w).Encode(todos)
+func WithValidator(ctx context.Context) context.Context {
+	return context.WithValue(ctx, validatorKey{}, &validator{})
+}
+
+// Validator returns the HTTP validators of the entries that were consumed by ctx.
+// It returns false if ctx was not created by WithValidator, if no entries were
+// consumed, or if one of the queries bypassed the cache, as the validators do
+// not cover the data it returned.
+func Validator(ctx context.Context) (Validators, bool) {
+	v, ok := ctx.Value(validatorKey{}).(*validator)
+	if !ok {
+		return Validators{}, false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.partial || v.n == 0 {
+		return Validators{}, false
+	}
+	vs := Validators{ETag: fmt.Sprintf("%q", strconv.FormatUint(v.sum, 36)+"-"+strconv.Itoa(v.n))}
+	if !v.unknown {
+		vs.LastModified = v.modified
+	}
+	return vs, true
+}
+
+// NotModified sets the validators on the response headers, and reports if
+// the response was answered with 304 (Not Modified) according to the
+// conditional headers of the request (If-None-Match or If-Modified-Since).
+func (vs Validators) NotModified(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("ETag", vs.ETag)
+	if !vs.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", vs.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	var match bool
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			// If-None-Match uses the weak comparison.
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == vs.ETag {
+				match = true
+				break
+			}
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !vs.LastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		match = err == nil && !vs.LastModified.Truncate(time.Second).After(t)
+	}
+	if match {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return match
+}
+
+// validatorFromContext returns the validator of the context, if any.
+func validatorFromContext(ctx context.Context) *validator {
+	v, _ := ctx.Value(validatorKey{}).(*validator)
+	return v
+}
+
+// add adds the given entry, that was recorded at the given time, to the
+// validator. A zero time means the recording time of the entry is unknown.
+func (v *validator) add(e *Entry, recorded time.Time) {
+	h := entryHash(e)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// Entries are combined in an order-independent way, as
+	// queries may be executed concurrently (e.g. eager-loading).
+	v.sum += h
+	v.n++
+	switch {
+	case recorded.IsZero():
+		v.unknown = true
+	case recorded.After(v.modified):
+		v.modified = recorded
+	}
+}
+
+// bypass marks the validator as partial.
+func (v *validator) bypass() {
+	v.mu.Lock()
+	v.partial = true
+	v.mu.Unlock()
+}
+
+// validate adds the entry that was consumed by the query to the validator of the context, if any.
+func validate(ctx context.Context, e *Entry, recorded time.Time) {
+	if v := validatorFromContext(ctx); v != nil {
+		v.add(e, recorded)
+	}
+}
+
+// entryHash returns the hash of the content of the entry.
+func entryHash(e *Entry) uint64 {
+	h := newHasher()
+	for _, c := range e.Columns {
+		h.string(c)
+		h.byte(0)
+	}
+	for _, row := range e.Values {
+		for _, v := range row {
+			h.value(v)
+		}
+		h.byte(1)
+	}
+	return uint64(h)
+}
+
+// value hashes the given driver value.
+func (h *hasher) value(v driver.Value) {
+	switch v := v.(type) {
+	case nil:
+		h.byte(0)
+	case int64:
+		h.int(1, v)
+	case float64:
+		h.uint(2, math.Float64bits(v))
+	case bool:
+		if v {
+			h.byte(3)
+		} else {
+			h.byte(4)
+		}
+	case []byte:
+		h.byte(5)
+		h.string(string(v))
+	case string:
+		h.byte(6)
+		h.string(v)
+	case time.Time:
+		h.int(7, v.UnixNano())
+	default:
+		h.byte(8)
+		h.string(fmt.Sprint(v))
+	}
+	h.byte(0xff)
+}
@@ -0,0 +1,119 @@
+package entcache
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/gob"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Query returns the value stored in the cache of the driver under the given key,
+// or computes it using fn and stores it in the cache. It allows caching derived
+// data (e.g. aggregations) alongside query entries, using the same cache levels.
+// Values are encoded using encoding/gob, and a zero ttl means the driver TTL.
+//
+//	stats, err := entcache.Query(ctx, drv, "dashboard.stats", time.Minute, func(ctx context.Context) (*Stats, error) {
+//		return computeStats(ctx, client)
+//	})
+//
+// The Skip and Evict context options are honored.
+func Query[T any](ctx context.Context, d *Driver, key Key, ttl time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	var opts ctxOptions
+	if c, ok := ctx.Value(ctxOptionsKey{}).(*ctxOptions); ok {
+		opts = *c
+	}
+	if opts.skip {
+		d.skip(ctx, SkipOption)
+		return fn(ctx)
+	}
+	k, c := d.valueKey(ctx, key), d.Cache
+	if opts.level != nil {
+		c = opts.level
+	}
+	if opts.evict {
+		if err := d.evict(ctx, c, k); err != nil {
+			d.skip(ctx, SkipCacheError)
+			return fn(ctx)
+		}
+	}
+	if ttl = d.entryTTL(ttl); ttl == NoCache {
+		d.skip(ctx, SkipNoCache)
+		return fn(ctx)
+	}
+	atomic.AddUint64(&d.stats.Gets, 1)
+	if e, err := c.Get(ctx, k); err == nil {
+		if v, err := decodeValue[T](e); err == nil {
+			atomic.AddUint64(&d.stats.Hits, 1)
+			return v, nil
+		}
+	}
+	v, err := fn(ctx)
+	if err != nil {
+		return v, err
+	}
+	e, err := encodeValue(v)
+	if err == nil {
+		err = c.Add(ctx, k, e, ttl)
+	}
+	if err != nil && d.Log != nil {
+		atomic.AddUint64(&d.stats.Errors, 1)
+		d.Log(fmt.Sprintf("entcache: failed storing value %v in cache: %v", key, err))
+	}
+	return v, nil
+}
+
+// valueKey is the cache key of a computed value. In remote levels, it is
+// stored as "entcache:value:<Key>", to avoid collisions with query entries.
+type valueKey struct {
+	Key Key
+}
+
+// MarshalKey implements the KeyMarshaler interface.
+func (k valueKey) MarshalKey() (string, error) {
+	key, err := MarshalKey(k.Key)
+	if err != nil {
+		return "", err
+	}
+	return "entcache:value:" + key, nil
+}
+
+// valueKey returns the cache key of a computed value. Like the keys
+// of query entries, it is scoped by the database of the context.
+func (d *Driver) valueKey(ctx context.Context, key Key) Key {
+	k := Key(valueKey{Key: key})
+	if d.Database != nil {
+		if db := d.Database(ctx); db != "" {
+			k = DBKey{DB: db, Key: k}
+		}
+	}
+	return k
+}
+
+// valueColumn is the column name of entries that hold computed values.
+const valueColumn = "entcache:value"
+
+// encodeValue encodes the value to a cache entry.
+func encodeValue[T any](v T) (*Entry, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return &Entry{Columns: []string{valueColumn}, Values: [][]driver.Value{{buf.Bytes()}}}, nil
+}
+
+// decodeValue decodes a value from a cache entry.
+func decodeValue[T any](e *Entry) (T, error) {
+	var v T
+	if len(e.Columns) != 1 || e.Columns[0] != valueColumn || len(e.Values) != 1 || len(e.Values[0]) != 1 {
+		return v, fmt.Errorf("entcache: unexpected value entry")
+	}
+	buf, ok := e.Values[0][0].([]byte)
+	if !ok {
+		return v, fmt.Errorf("entcache: unexpected value type %T", e.Values[0][0])
+	}
+	err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&v)
+	return v, err
+}
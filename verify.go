@@ -0,0 +1,142 @@
+package entcache
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Verify writes, reads and deletes a sentinel entry through every level of the
+// driver cache, and validates that the entry survives the round trip, including
+// the encoding of its values and its key. It is intended to run at startup, to
+// catch misconfigured levels (e.g. an unreachable Redis) before traffic arrives.
+//
+//	if err := drv.Verify(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// In ContextLevel mode, only the shared level (see AlsoShared) is verified. Ambiguous
+// configurations (e.g. a zero TTL, see ZeroTTL) are passed as warnings to Log.
+func (d *Driver) Verify(ctx context.Context) error {
+	if d.TTL == 0 && d.ZeroTTL == 0 && d.MaxTTL == 0 && d.Log != nil {
+		d.Log("entcache: no TTL is configured, and entries are stored without expiration. Use ZeroTTL(NoExpiry) or ZeroTTL(NoCache) to configure it explicitly")
+	}
+	query := "SELECT 1 /* entcache:verify */"
+	key, err := d.Hash(query, []any{rand.Int63()})
+	if err != nil {
+		return fmt.Errorf("entcache: computing sentinel key: %w", err)
+	}
+	if d.Database != nil {
+		if db := d.Database(ctx); db != "" {
+			key = DBKey{DB: db, Key: key}
+		}
+	}
+	for i, l := range d.levels() {
+		if err := verifyLevel(ctx, l, key); err != nil {
+			return fmt.Errorf("entcache: verifying level %d (%T): %w", i, l, err)
+		}
+	}
+	return nil
+}
+
+// levels returns the levels of the driver cache.
+func (d *Driver) levels() []AddGetDeleter {
+	switch c := d.Cache.(type) {
+	case *multiLevel:
+		return c.levels
+	case *contextLevel:
+		if c.shared != nil {
+			return []AddGetDeleter{c.shared}
+		}
+		return nil
+	default:
+		return []AddGetDeleter{c}
+	}
+}
+
+// verifyLevel verifies the round trip of a sentinel entry through the given level.
+func verifyLevel(ctx context.Context, l AddGetDeleter, k Key) error {
+	switch l := l.(type) {
+	case *readOnly:
+		// Writes are ignored by the level, and only lookups are verified.
+		if _, err := l.Get(ctx, k); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("get: %w", err)
+		}
+		return nil
+	case *asyncLevel:
+		// Writes are queued, and therefore, the wrapped level is verified.
+		return verifyLevel(ctx, l.AddGetDeleter, k)
+	}
+	now := time.Now().Truncate(time.Microsecond)
+	data := []byte("entcache")
+	if b, ok := l.(*Blob); ok && b.minSize > 0 {
+		// Smaller entries are not stored by the level. Random
+		// bytes are used, as they are not shrunk by compression.
+		data = make([]byte, b.minSize)
+		rand.New(rand.NewSource(now.UnixNano())).Read(data)
+	}
+	e := &Entry{
+		Columns: []string{"int", "float", "bool", "string", "bytes", "time", "null"},
+		Values: [][]driver.Value{
+			{int64(-1), 1.5, true, "entcache", data, now, nil},
+		},
+	}
+	if err := l.Add(ctx, k, e, time.Minute); err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+	got, err := l.Get(ctx, k)
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	if err := verifyEntry(e, got); err != nil {
+		return err
+	}
+	if err := l.Del(ctx, k); err != nil {
+		return fmt.Errorf("del: %w", err)
+	}
+	if _, err := l.Get(ctx, k); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("expected sentinel entry to be deleted, got: %v", err)
+	}
+	return nil
+}
+
+// verifyEntry reports if the entry that was read from a level does not match the expected one.
+func verifyEntry(expected, actual *Entry) error {
+	if len(actual.Columns) != len(expected.Columns) || len(actual.Values) != len(expected.Values) {
+		return fmt.Errorf("unexpected sentinel entry: %v", actual)
+	}
+	for i, c := range expected.Columns {
+		if actual.Columns[i] != c {
+			return fmt.Errorf("unexpected column %d: %q != %q", i, actual.Columns[i], c)
+		}
+	}
+	for i, row := range expected.Values {
+		if len(actual.Values[i]) != len(row) {
+			return fmt.Errorf("unexpected sentinel row: %v", actual.Values[i])
+		}
+		for j, v := range row {
+			if !equalValues(v, actual.Values[i][j]) {
+				return fmt.Errorf("unexpected value of column %q: %v (%T) != %v (%T)", expected.Columns[j], actual.Values[i][j], actual.Values[i][j], v, v)
+			}
+		}
+	}
+	return nil
+}
+
+// equalValues reports if the given driver values are equal.
+func equalValues(v1, v2 driver.Value) bool {
+	switch v1 := v1.(type) {
+	case []byte:
+		v2, ok := v2.([]byte)
+		return ok && bytes.Equal(v1, v2)
+	case time.Time:
+		v2, ok := v2.(time.Time)
+		return ok && v1.Equal(v2)
+	default:
+		return v1 == v2
+	}
+}
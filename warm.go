@@ -0,0 +1,148 @@
+package entcache
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"entgo.io/ent/dialect/sql"
+)
+
+// HotKey describes a frequently used cache key and the query it originates from.
+type HotKey struct {
+	Key   Key
+	Query string
+	Args  []any
+	Hits  uint64
+}
+
+// HotKeys configures the driver to track the n most frequently used cache keys
+// and their originating queries. The tracked keys can be exported using the
+// Driver.ExportHotKeys method, and used for warming the cache of a new deployment.
+//
+// Note that tracking is approximate, and is based on the Space-Saving algorithm.
+func HotKeys(n int) Option {
+	return func(o *Options) {
+		o.HotKeys = n
+	}
+}
+
+// hotKeys tracks the top-k cache keys using the Space-Saving algorithm.
+type hotKeys struct {
+	mu   sync.Mutex
+	size int
+	keys map[Key]*HotKey
+}
+
+func newHotKeys(size int) *hotKeys {
+	return &hotKeys{size: size, keys: make(map[Key]*HotKey, size)}
+}
+
+// touch records a usage of the given key.
+func (h *hotKeys) touch(k Key, query string, args []any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hk, ok := h.keys[k]; ok {
+		hk.Hits++
+		return
+	}
+	var hits uint64
+	if len(h.keys) >= h.size {
+		// Replace the least used key, and inherit its counter.
+		var min *HotKey
+		for _, hk := range h.keys {
+			if min == nil || hk.Hits < min.Hits {
+				min = hk
+			}
+		}
+		delete(h.keys, min.Key)
+		hits = min.Hits
+	}
+	h.keys[k] = &HotKey{Key: k, Query: query, Args: append([]any(nil), args...), Hits: hits + 1}
+}
+
+// top returns the tracked keys sorted by their usage.
+func (h *hotKeys) top() []HotKey {
+	h.mu.Lock()
+	keys := make([]HotKey, 0, len(h.keys))
+	for _, hk := range h.keys {
+		keys = append(keys, *hk)
+	}
+	h.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Hits > keys[j].Hits
+	})
+	return keys
+}
+
+// HotKeys returns the most frequently used cache keys, sorted by their usage.
+// It returns nil if the driver was not configured with the HotKeys option.
+func (d *Driver) HotKeys() []HotKey {
+	if d.hot == nil {
+		return nil
+	}
+	return d.hot.top()
+}
+
+// ExportHotKeys writes the most frequently used cache keys and their originating
+// queries to w, to be loaded later by WarmFromExport. Keys and arguments are gob
+// encoded, and therefore, custom types must be registered using gob.Register.
+func (d *Driver) ExportHotKeys(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(d.HotKeys())
+}
+
+// WarmFromExport reads the hot keys written by ExportHotKeys, and executes their
+// queries for populating the cache. Queries that fail are reported to the Log
+// function, and do not stop the warming process.
+func (d *Driver) WarmFromExport(ctx context.Context, r io.Reader) error {
+	var keys []HotKey
+	if err := gob.NewDecoder(r).Decode(&keys); err != nil {
+		return fmt.Errorf("entcache: decoding hot keys: %w", err)
+	}
+	for _, hk := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.warm(WithKey(ctx, hk.Key), hk.Query, hk.Args); err != nil && d.Log != nil {
+			d.Log(fmt.Sprintf("entcache: failed warming entry %v: %v", hk.Key, err))
+		}
+	}
+	return nil
+}
+
+// warm executes the query through the cache layer, and reads all its rows.
+func (d *Driver) warm(ctx context.Context, query string, args []any) error {
+	rows := &sql.Rows{}
+	if err := d.Query(ctx, query, args, rows); err != nil {
+		return err
+	}
+	return scanAll(rows)
+}
+
+// scanAll scans all rows and closes them. It is used for
+// populating the cache when the results are not needed.
+func scanAll(rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return err
+	}
+	values := make([]any, len(columns))
+	for i := range values {
+		values[i] = new(any)
+	}
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	return rows.Close()
+}